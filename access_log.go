@@ -0,0 +1,117 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// splunk-access-log-format values
+const (
+	accessLogFormatNone     = "none"
+	accessLogFormatCommon   = "common"
+	accessLogFormatCombined = "combined"
+	accessLogFormatNginx    = "nginx"
+	accessLogFormatApache   = "apache"
+)
+
+// Indexed fields extracted from the access log lines
+const (
+	accessLogStatusField  = "status"
+	accessLogMethodField  = "method"
+	accessLogPathField    = "path"
+	accessLogLatencyField = "latency_ms"
+)
+
+const (
+	// host ident user [time] "method path protocol" status bytes
+	accessLogCommonPattern = `^\S+ \S+ \S+ \[[^\]]+\] "([A-Z]+) (\S+)[^"]*" (\d{3}) (?:\d+|-)`
+	// followed by "referer" "user agent"
+	accessLogCombinedPattern = accessLogCommonPattern + ` "(?:[^"\\]|\\.)*" "(?:[^"\\]|\\.)*"`
+	// followed by the request time as the last value of the line, optional
+	accessLogLatencyPattern = `(?:.* (\d+(?:\.\d+)?))?\s*$`
+)
+
+// accessLogFormat extracts the fields of the lines of an access log
+type accessLogFormat struct {
+	pattern *regexp.Regexp
+	// unit of the request time, 0 when the format has none
+	latencyUnit time.Duration
+}
+
+var accessLogFormats = map[string]*accessLogFormat{
+	accessLogFormatCommon:   {pattern: regexp.MustCompile(accessLogCommonPattern)},
+	accessLogFormatCombined: {pattern: regexp.MustCompile(accessLogCombinedPattern)},
+	// combined with $request_time, in seconds with a milliseconds resolution
+	accessLogFormatNginx: {pattern: regexp.MustCompile(accessLogCombinedPattern + accessLogLatencyPattern), latencyUnit: time.Second},
+	// combined with %D, in microseconds
+	accessLogFormatApache: {pattern: regexp.MustCompile(accessLogCombinedPattern + accessLogLatencyPattern), latencyUnit: time.Microsecond},
+}
+
+// parseAccessLogFormat() returns the format of the access log lines, nil
+// when the lines are not parsed
+func parseAccessLogFormat(config map[string]string) (*accessLogFormat, error) {
+	name, ok := config[splunkAccessLogFormatKey]
+	if !ok || name == accessLogFormatNone {
+		return nil, nil
+	}
+	format, ok := accessLogFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown %s %s, supported formats are none, common, combined, nginx and apache", driverName, splunkAccessLogFormatKey, name)
+	}
+	return format, nil
+}
+
+// parse() returns the fields of an access log line, false when the line is
+// not in the format
+func (f *accessLogFormat) parse(line []byte) (map[string]string, bool) {
+	match := f.pattern.FindSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+	fields := map[string]string{
+		accessLogMethodField: string(match[1]),
+		accessLogPathField:   string(match[2]),
+		accessLogStatusField: string(match[3]),
+	}
+	if f.latencyUnit > 0 && len(match[4]) > 0 {
+		if latency, err := strconv.ParseFloat(string(match[4]), 64); err == nil {
+			ms := latency * float64(f.latencyUnit) / float64(time.Millisecond)
+			fields[accessLogLatencyField] = strconv.FormatFloat(ms, 'f', -1, 64)
+		}
+	}
+	return fields, true
+}
+
+// addAccessLogFields() sets the fields of an access log line to the message.
+// The lines which are not in the format, like the startup messages of the
+// server, are sent without them.
+func (l *splunkLogger) addAccessLogFields(message *splunkMessage, line []byte) {
+	if l.accessLog == nil || len(line) == 0 {
+		return
+	}
+	fields, ok := l.accessLog.parse(line)
+	if !ok {
+		return
+	}
+	for key, value := range fields {
+		setField(message, key, value)
+	}
+}
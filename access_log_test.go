@@ -0,0 +1,109 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestAccessLogFormat(t *testing.T) {
+	const combined = `172.17.0.1 - alice [16/Oct/2026:10:00:00 +0000] "GET /api/orders?id=7 HTTP/1.1" 404 153 "https://example.com/" "curl/8.4.0"`
+	tests := []struct {
+		format   string
+		line     string
+		expected map[string]string
+	}{
+		{accessLogFormatCombined, combined, map[string]string{"method": "GET", "path": "/api/orders?id=7", "status": "404"}},
+		{accessLogFormatCommon, `10.0.0.2 - - [16/Oct/2026:10:00:00 +0000] "POST /login HTTP/1.0" 302 -`, map[string]string{"method": "POST", "path": "/login", "status": "302"}},
+		{accessLogFormatNginx, combined + " 0.250", map[string]string{"method": "GET", "path": "/api/orders?id=7", "status": "404", "latency_ms": "250"}},
+		{accessLogFormatNginx, combined, map[string]string{"method": "GET", "path": "/api/orders?id=7", "status": "404"}},
+		{accessLogFormatApache, combined + " 1500", map[string]string{"method": "GET", "path": "/api/orders?id=7", "status": "404", "latency_ms": "1.5"}},
+		{accessLogFormatCombined, `2026/10/16 10:00:00 [notice] 1#1: start worker processes`, nil},
+		{accessLogFormatCombined, `10.0.0.2 - - [16/Oct/2026:10:00:00 +0000] "POST /login HTTP/1.0" 302 -`, nil},
+	}
+	for _, test := range tests {
+		format, err := parseAccessLogFormat(map[string]string{splunkAccessLogFormatKey: test.format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields, ok := format.parse([]byte(test.line))
+		if ok != (test.expected != nil) || !reflect.DeepEqual(fields, test.expected) {
+			t.Fatalf("%s: expected %v for %q, got %v", test.format, test.expected, test.line, fields)
+		}
+	}
+
+	if format, err := parseAccessLogFormat(map[string]string{splunkAccessLogFormatKey: accessLogFormatNone}); err != nil || format != nil {
+		t.Fatalf("expected no format, got %v, %v", format, err)
+	}
+	if _, err := parseAccessLogFormat(map[string]string{splunkAccessLogFormatKey: "iis"}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestAccessLogFields(t *testing.T) {
+	stub := &stubTransport{}
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:             "https://splunk.example.com:8088",
+			splunkTokenKey:           "00000000-0000-0000-0000-000000000000",
+			splunkAccessLogFormatKey: accessLogFormatCombined,
+		},
+		ContainerID: "containeriid",
+	}
+	l, err := NewWithClient(info, &http.Client{Transport: stub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		`172.17.0.1 - - [16/Oct/2026:10:00:00 +0000] "DELETE /api/orders/7 HTTP/1.1" 204 0 "-" "Go-http-client/1.1"`,
+		`nginx: the configuration file /etc/nginx/nginx.conf syntax is ok`,
+	}
+	for _, line := range lines {
+		if err := l.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.messages) != len(lines) {
+		t.Fatalf("expected %d messages, got %d", len(lines), len(stub.messages))
+	}
+	expected := map[string]string{"method": "DELETE", "path": "/api/orders/7", "status": "204", logDriverField: defaultLogDriver}
+	if fields := stub.messages[0].Fields; !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected the fields %v, got %v", expected, fields)
+	}
+	// the line which is not an access log line is sent as it is
+	if fields := stub.messages[1].Fields; len(fields) != 1 {
+		t.Fatalf("expected no access log fields, got %v", fields)
+	}
+	event, err := stub.messages[1].EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["line"] != lines[1] {
+		t.Fatalf("expected the line %q, got %v", lines[1], event["line"])
+	}
+}
@@ -0,0 +1,426 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// adminServer serves the plugin's debugging and control endpoints on a unix
+// socket next to the plugin socket. It is independent from the docker daemon.
+type adminServer struct {
+	mux    *http.ServeMux
+	driver *driver
+	logs   *logRingBuffer
+	token  string
+	health *healthProber
+	levels *logLevelController
+	// nil when the self log file is disabled
+	selfLog *selfLogFile
+}
+
+func newAdminServer(d *driver, logs *logRingBuffer, token string) *adminServer {
+	a := &adminServer{
+		mux:    http.NewServeMux(),
+		driver: d,
+		logs:   logs,
+		token:  token,
+		health: health,
+		levels: logLevel,
+	}
+	a.mux.HandleFunc("/debug/log", a.requireToken(a.handleDebugLog))
+	a.mux.HandleFunc("/containers", a.handleContainers)
+	a.mux.HandleFunc("/containers/", a.requireToken(a.handleContainerPause))
+	a.mux.HandleFunc("/healthz", a.handleHealthz)
+	a.mux.HandleFunc("/loglevel", a.requireToken(a.handleLogLevel))
+	a.mux.HandleFunc("/debug/selflog", a.requireToken(a.handleSelfLog))
+	a.mux.HandleFunc("/pause", a.requireToken(a.handlePause))
+	a.mux.HandleFunc("/resume", a.requireToken(a.handlePause))
+	a.mux.HandleFunc("/validate", a.requireToken(a.handleValidate))
+	a.mux.HandleFunc("/selftest", a.requireToken(a.handleSelfTest))
+	return a
+}
+
+func (a *adminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *adminServer) serveUnix(addr string) error {
+	os.Remove(addr)
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return err
+	}
+	driverLog.WithField("socket", addr).Info("Admin socket is listening")
+	return http.Serve(l, a)
+}
+
+// requireToken() rejects requests that don't carry the admin token as a bearer
+// token. Endpoints protected by it are disabled when no token is configured.
+func (a *adminServer) requireToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.token == "" {
+			http.Error(w, "endpoint is disabled, set "+envVarAdminToken+" to enable it", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleDebugLog() returns the recent entries of the plugin's own log
+func (a *adminServer) handleDebugLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range a.logs.recent() {
+		if _, err := w.Write([]byte(line)); err != nil {
+			return
+		}
+	}
+}
+
+// handleSelfLog() lists the files of the self log, or returns the one given
+// by the file parameter
+func (a *adminServer) handleSelfLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.selfLog == nil {
+		http.Error(w, "self log file is disabled, set "+envVarSelfLogFile+" to enable it", http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("file")
+	if name == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.selfLog.files())
+		return
+	}
+	f, err := a.selfLog.openFile(name)
+	if err != nil {
+		http.Error(w, "unknown file "+name, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, f)
+}
+
+// containerState describes the logging pipeline of a container
+type containerState struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	File    string            `json:"file"`
+	Options map[string]string `json:"options"`
+	// effective options with where each value comes from
+	EffectiveOptions map[string]resolvedOption `json:"effective_options"`
+	Forwarding       bool                      `json:"forwarding"`
+	// why the container is only logged locally
+	LocalOnly       string     `json:"local_only,omitempty"`
+	QueueDepth      int        `json:"queue_depth"`
+	LastReceived    *time.Time `json:"last_received,omitempty"`
+	LastSend        *time.Time `json:"last_send,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	EventsForwarded uint64     `json:"events_forwarded"`
+	BytesForwarded  uint64     `json:"bytes_forwarded"`
+	Degraded        bool       `json:"degraded"`
+	// why the local log serving docker logs could not be created, with
+	// splunk-cache-required=false
+	CacheUnavailable string `json:"cache_unavailable,omitempty"`
+	// why the local log is not written while its filesystem is full or
+	// read-only, the container is only forwarded meanwhile
+	CacheDegraded string `json:"cache_degraded,omitempty"`
+	// why the splunk logger could not be created, with
+	// splunk-forwarding-required=false
+	ForwardingUnavailable string `json:"forwarding_unavailable,omitempty"`
+	// when the forwarding of the container was paused from the admin
+	// socket, and whether its lines are discarded or spooled meanwhile
+	PausedSince *time.Time `json:"paused_since,omitempty"`
+	PauseMode   string     `json:"pause_mode,omitempty"`
+}
+
+type metricsProvider interface {
+	containerMetrics() *containerMetrics
+}
+
+// containerStates() returns the state of every logged container sorted by id
+func (d *driver) containerStates() []containerState {
+	d.mu.Lock()
+	states := make([]containerState, 0, len(d.logs))
+	for file, lf := range d.logs {
+		state := containerState{
+			ID:               lf.info.ContainerID,
+			Name:             lf.info.Name(),
+			File:             file,
+			Options:          redactedConfig(lf.info.Config),
+			EffectiveOptions: lf.options,
+			LocalOnly:        lf.localOnly,
+		}
+		if cache, ok := lf.jsonl.(*cacheLogger); ok {
+			if err := cache.unavailable(); err != nil {
+				state.CacheUnavailable = err.Error()
+			}
+		}
+		if fault := lf.cacheFault(); fault != nil {
+			if err := fault.degraded(); err != nil {
+				state.CacheDegraded = err.Error()
+			}
+		}
+		if degraded, ok := lf.splunkl.(*degradedSplunkLogger); ok {
+			if err := degraded.unavailable(); err != nil {
+				state.ForwardingUnavailable = err.Error()
+			}
+		}
+		if p := lf.pausable(); p != nil {
+			if since, mode := p.paused(); !since.IsZero() {
+				state.PausedSince, state.PauseMode = &since, mode
+			}
+		}
+		if provider, ok := lf.splunkl.(metricsProvider); ok && provider.containerMetrics() != nil {
+			m := provider.containerMetrics()
+			state.Forwarding = true
+			state.QueueDepth = m.queueDepth()
+			if lastReceived := atomic.LoadInt64(&m.lastReceived); lastReceived != 0 {
+				t := time.Unix(0, lastReceived)
+				state.LastReceived = &t
+			}
+			if lastSend := atomic.LoadInt64(&m.lastSend); lastSend != 0 {
+				t := time.Unix(0, lastSend)
+				state.LastSend = &t
+			}
+			state.LastError = m.getLastError()
+			state.EventsForwarded = atomic.LoadUint64(&m.sent)
+			state.BytesForwarded = atomic.LoadUint64(&m.bytesSent)
+			state.Degraded = m.isDegraded()
+		}
+		states = append(states, state)
+	}
+	d.mu.Unlock()
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ID < states[j].ID
+	})
+	return states
+}
+
+// lookupContainer() returns the logged container whose id is or starts
+// with id
+func (d *driver) lookupContainer(id string) (*logPair, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if lf, ok := d.idx[id]; ok {
+		return lf, nil
+	}
+	var found *logPair
+	for containerID, lf := range d.idx {
+		if strings.HasPrefix(containerID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("container id %s is ambiguous", id)
+			}
+			found = lf
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("logger does not exist for %s", id)
+	}
+	return found, nil
+}
+
+// handleContainers() returns the state of every logged container
+func (a *adminServer) handleContainers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.driver.containerStates())
+}
+
+// handleHealthz() returns 200 when logs are being delivered, 503 otherwise,
+// from the state cached by the health prober
+func (a *adminServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	report := a.health.report()
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleLogLevel() returns the plugin log level on GET and changes it on
+// POST, with the level and ttl form values
+func (a *adminServer) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level, err := parseLogLevel(r.FormValue("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl := a.levels.debugTTL
+		if ttlStr := r.FormValue("ttl"); ttlStr != "" {
+			ttl, err = time.ParseDuration(ttlStr)
+			if err != nil {
+				http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		a.levels.set(level, ttl)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": a.levels.level().String()})
+}
+
+// handlePause() pauses the forwarding to HEC on /pause and resumes it on
+// /resume
+func (a *adminServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/pause" {
+		forwarding.pause()
+	} else {
+		forwarding.resume()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"paused": forwarding.paused()})
+}
+
+// handleContainerPause() pauses the forwarding of a container on
+// /containers/{id}/pause, with the mode query parameter discard or spool,
+// and resumes it on /containers/{id}/resume. The id may be a unique prefix.
+func (a *adminServer) handleContainerPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+	if len(parts) != 2 || parts[0] == "" || (parts[1] != "pause" && parts[1] != "resume") {
+		http.NotFound(w, r)
+		return
+	}
+	lf, err := a.driver.lookupContainer(parts[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	p := lf.pausable()
+	if p == nil {
+		http.Error(w, "container "+lf.info.ContainerID+" is not forwarded", http.StatusConflict)
+		return
+	}
+	if parts[1] == "pause" {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = pauseModeDiscard
+		}
+		if mode != pauseModeDiscard && mode != pauseModeSpool {
+			http.Error(w, "unknown mode "+mode+", supported modes are "+pauseModeDiscard+" and "+pauseModeSpool, http.StatusBadRequest)
+			return
+		}
+		if _, err := p.pause(mode); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	} else {
+		p.resume()
+	}
+	since, mode := p.paused()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": lf.info.ContainerID, "paused": !since.IsZero(), "mode": mode})
+}
+
+// handleValidate() validates the JSON object of log-opts in the request
+// body, and the connection to HEC with connect=true
+func (a *adminServer) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var cfg map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "invalid options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	connect, _ := strconv.ParseBool(r.URL.Query().Get("connect"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(validateOptions(cfg, connect))
+}
+
+// handleSelfTest() runs the self-test of the plug-in defaults on POST, with
+// the correlation ID of the test event in the id query parameter
+func (a *adminServer) handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report := runSelfTest(r.URL.Query().Get("id"), defaultSelfTestTimeout)
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Passed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	report.writeTo(w)
+}
+
+// httpServer is a TCP listener serving plug-in internals, such as metrics or
+// profiles, which is shut down with the plug-in
+type httpServer struct {
+	name   string
+	server *http.Server
+}
+
+func startHTTPServer(name string, addr string, handler http.Handler) *httpServer {
+	s := &httpServer{name: name, server: &http.Server{Addr: addr, Handler: handler}}
+	go func() {
+		driverLog.WithField("server", name).WithField("addr", addr).Info("Serving")
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			driverLog.WithField("server", name).WithError(err).Error("Failed to serve")
+		}
+	}()
+	return s
+}
+
+func (s *httpServer) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		driverLog.WithField("server", s.name).WithError(err).Warn("Failed to shut down server")
+	}
+}
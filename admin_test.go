@@ -0,0 +1,201 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestDebugLog(t *testing.T) {
+	debugLog := newLogRingBuffer(3)
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(debugLog)
+	for _, line := range []string{"line one", "line two", "line three", "line four"} {
+		log.Info(line)
+	}
+
+	admin := newAdminServer(newDriver(), debugLog, "secret")
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/log", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected request without token to be rejected, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/log", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", w.Code)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %v", lines)
+	}
+	for i, expected := range []string{"line two", "line three", "line four"} {
+		if !strings.Contains(lines[i], expected) {
+			t.Fatalf("Expected line %d to contain %q, got %q", i, expected, lines[i])
+		}
+	}
+
+	admin = newAdminServer(newDriver(), debugLog, "")
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected endpoint to be disabled without token, got %d", w.Code)
+	}
+}
+
+func TestContainers(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newDriver()
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: "00000000-0000-0000-0000-000000001234",
+		},
+		ContainerID:   "containeriid",
+		ContainerName: "/container_name",
+	}
+	file := startTestLogging(t, d, dir, info)
+	defer d.StopLogging(file)
+
+	admin := newAdminServer(d, newLogRingBuffer(1), "")
+	req := httptest.NewRequest(http.MethodGet, "/containers", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", w.Code)
+	}
+
+	var states []containerState
+	if err := json.Unmarshal(w.Body.Bytes(), &states); err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 {
+		t.Fatalf("Expected 1 container, got %v", states)
+	}
+	state := states[0]
+	if state.ID != "containeriid" || state.Name != "container_name" || state.File != file {
+		t.Fatalf("Unexpected container %+v", state)
+	}
+	if !state.Forwarding {
+		t.Fatal("Expected container to be forwarding")
+	}
+	if state.Options[splunkTokenKey] != "****1234" {
+		t.Fatalf("Expected token to be redacted, got %s", state.Options[splunkTokenKey])
+	}
+	if state.Options[splunkURLKey] != hec.URL() {
+		t.Fatalf("Unexpected url %s", state.Options[splunkURLKey])
+	}
+	if option := state.EffectiveOptions[splunkTokenKey]; option.Value != "****1234" || option.Source != optionSourceLogOpt {
+		t.Fatalf("Expected the effective token to be redacted, got %+v", option)
+	}
+	if option := state.EffectiveOptions[splunkFormatKey]; option.Value != splunkFormatInline || option.Source != optionSourceDefault {
+		t.Fatalf("Unexpected effective format %+v", option)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/containers", nil)
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected POST to be rejected, got %d", w.Code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	m := newPluginMetrics()
+	c := m.register("container1", func() int { return 10 })
+	admin := newAdminServer(newDriver(), newLogRingBuffer(1), "")
+	admin.health = newHealthProber(m, 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", w.Code)
+	}
+
+	c.queueCapacity = 10
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected unhealthy status, got %d", w.Code)
+	}
+	var report healthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy || len(report.Failures) != 1 || report.Failures[0].Condition != "buffers_full" {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	admin := newAdminServer(newDriver(), newLogRingBuffer(1), "secret")
+	levels, current := newTestLogLevelController()
+	admin.levels = levels
+
+	req := httptest.NewRequest(http.MethodPost, "/loglevel?level=debug&ttl=1h", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected request without token to be rejected, got %d", w.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", w.Code)
+	}
+	if current.get() != logrus.DebugLevel || !strings.Contains(w.Body.String(), `"debug"`) {
+		t.Fatalf("Expected debug level, got %s", w.Body.String())
+	}
+
+	for _, query := range []string{"level=verbose", "level=info&ttl=soon"} {
+		req = httptest.NewRequest(http.MethodPost, "/loglevel?"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w = httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected %s to be rejected, got %d", query, w.Code)
+		}
+	}
+}
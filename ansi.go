@@ -0,0 +1,46 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+// ANSI escape sequences: CSI sequences such as colors and cursor moves, OSC
+// sequences such as window titles and hyperlinks, ended by BEL or ST, and
+// the other escapes such as charset selections
+var ansiEscapePattern = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[ -/]*[0-Z\\^-~])`)
+
+// parseStripANSI() returns whether ANSI escape sequences are removed from
+// the lines
+func parseStripANSI(config map[string]string) (bool, error) {
+	stripStr, ok := config[splunkStripANSIKey]
+	if !ok {
+		return false, nil
+	}
+	return strconv.ParseBool(stripStr)
+}
+
+// stripANSI() returns the line without its ANSI escape sequences
+func stripANSI(line []byte) []byte {
+	if bytes.IndexByte(line, 0x1b) < 0 {
+		return line
+	}
+	return ansiEscapePattern.ReplaceAll(line, nil)
+}
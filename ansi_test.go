@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+func TestProcessStripsANSI(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:       hec.URL(),
+			splunkTokenKey:     hec.token,
+			splunkStripANSIKey: "true",
+		},
+		ContainerID: "containeriid",
+	}
+	stripANSI, err := parseStripANSI(info.Config)
+	if err != nil || !stripANSI {
+		t.Fatalf("Expected %s to be enabled, got %v", splunkStripANSIKey, err)
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	lf := &logPair{sinks: []logger.Logger{splunkl}, splunkl: splunkl, stream: r, info: info}
+	done := make(chan struct{})
+	go func() {
+		messageProcessor{stripANSI: stripANSI}.process(lf)
+		close(done)
+	}()
+
+	enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+	for _, line := range []string{
+		"\x1b[32mINFO\x1b[0m server started",
+		"\x1b[1;31mERROR\x1b[m \x1b[38;5;208mdisk\x1b[39m almost full",
+		"\x1b]0;window title\x07\x1b[2K\x1b[1Gprogress 100%",
+		"\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\ and \x1b(Bcharset",
+		// only escape sequences, not sent
+		"\x1b[0m",
+		"plain line",
+	} {
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte(line)}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	<-done
+
+	expected := []string{
+		"INFO server started",
+		"ERROR disk almost full",
+		"progress 100%",
+		"link and charset",
+		"plain line",
+	}
+	if len(hec.messages) != len(expected) {
+		t.Fatalf("Expected %d messages, got %d", len(expected), len(hec.messages))
+	}
+	for i, line := range expected {
+		event, err := hec.messages[i].EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event["line"] != line {
+			t.Fatalf("Expected event %d to be %q, got %q", i, line, event["line"])
+		}
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	line := []byte("no escape sequence")
+	if stripped := stripANSI(line); &stripped[0] != &line[0] {
+		t.Fatal("Expected a line without escape sequence to be returned as is")
+	}
+	// an escape sequence cut at the end of the line is kept
+	if stripped := string(stripANSI([]byte("cut \x1b[3"))); stripped != "cut \x1b[3" {
+		t.Fatalf("Unexpected line %q", stripped)
+	}
+}
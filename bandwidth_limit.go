@@ -0,0 +1,176 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// over-limit events are dropped
+	bandwidthPolicyDrop = "drop"
+	// over-limit events wait for the bucket to refill, which slows down the
+	// container's log stream
+	bandwidthPolicyBlock = "block"
+)
+
+// Units of splunk-bandwidth-limit, per second
+var bandwidthUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"kb": 1024,
+	"mb": 1024 * 1024,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// parseBandwidthLimit() returns the bytes per second of
+// splunk-bandwidth-limit, such as 512kb or 1mb, 0 means no limit
+func parseBandwidthLimit(config map[string]string) (int64, error) {
+	limitStr, ok := config[splunkBandwidthLimitKey]
+	if !ok {
+		return 0, nil
+	}
+	value := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(limitStr)), "/s")
+	number := strings.TrimRight(value, "abcdefghijklmnopqrstuvwxyz")
+	unit, ok := bandwidthUnits[value[len(number):]]
+	if !ok {
+		return 0, fmt.Errorf("%s: unknown unit in %s=%s, supported units are b, kb, mb and gb", driverName, splunkBandwidthLimitKey, limitStr)
+	}
+	limit, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid %s=%s", driverName, splunkBandwidthLimitKey, limitStr)
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("%s: %s must not be negative", driverName, splunkBandwidthLimitKey)
+	}
+	return int64(limit * float64(unit)), nil
+}
+
+// parseBandwidthPolicy() returns what happens to the events over
+// splunk-bandwidth-limit
+func parseBandwidthPolicy(config map[string]string) (string, error) {
+	policy, ok := config[splunkBandwidthPolicyKey]
+	if !ok {
+		return bandwidthPolicyDrop, nil
+	}
+	switch policy {
+	case bandwidthPolicyDrop, bandwidthPolicyBlock:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%s: unknown %s=%s, supported policies are drop and block", driverName, splunkBandwidthPolicyKey, policy)
+}
+
+// bandwidthLimiter is a token bucket of bytes refilled at the limit per
+// second and holding up to one second of it. An event larger than the
+// bucket passes once the bucket is full, the bucket then goes into debt so
+// the average stays within the limit. A nil limiter allows everything.
+type bandwidthLimiter struct {
+	rate  float64
+	burst float64
+	block bool
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newBandwidthLimiter() returns nil when limit is not positive
+func newBandwidthLimiter(limit int64, policy string) *bandwidthLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{
+		rate:   float64(limit),
+		burst:  float64(limit),
+		block:  policy == bandwidthPolicyBlock,
+		tokens: float64(limit),
+	}
+}
+
+// reserve() takes size bytes from the bucket. It returns false when the
+// event is over the limit with the drop policy, or else how long to wait
+// before sending it.
+func (b *bandwidthLimiter) reserve(size int, now time.Time) (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		if b.tokens += now.Sub(b.last).Seconds() * b.rate; b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	cost := float64(size)
+	needed := cost
+	if needed > b.burst {
+		needed = b.burst
+	}
+	if b.tokens >= needed {
+		b.tokens -= cost
+		return true, 0
+	}
+	if !b.block {
+		return false, 0
+	}
+	wait := time.Duration((needed - b.tokens) / b.rate * float64(time.Second))
+	b.tokens -= cost
+	return true, wait
+}
+
+// setBandwidthLimit() replaces the bandwidth limiter of a running logger,
+// which starts with a full bucket
+func (l *splunkLogger) setBandwidthLimit(limit int64, policy string) {
+	l.bandwidth.Store(newBandwidthLimiter(limit, policy))
+}
+
+// limitBandwidth() takes the size of the message, as it is sent after the
+// enrichment, from the bandwidth of the container. It returns false when the
+// message is dropped, or else waits with the block policy.
+func (l *splunkLogger) limitBandwidth(message *splunkMessage) bool {
+	bandwidth, _ := l.bandwidth.Load().(*bandwidthLimiter)
+	if bandwidth == nil {
+		return true
+	}
+	encoded, err := message.encode()
+	if err != nil {
+		// reported when the message is posted
+		return true
+	}
+	allowed, wait := bandwidth.reserve(len(encoded), time.Now())
+	if !allowed {
+		l.hec.metrics.addReceived(1)
+		l.hec.metrics.addDropped(dropReasonBandwidthLimited, 1)
+		atomic.AddUint64(&metrics.bandwidthDropped, 1)
+		atomic.AddUint64(&metrics.bandwidthDroppedBytes, uint64(len(encoded)))
+		// not written to the dead-letter file nor logged, which would
+		// defeat the limit
+		l.hec.sampleDropped(dropReasonBandwidthLimited, []*splunkMessage{message})
+		return false
+	}
+	if wait > 0 {
+		atomic.AddUint64(&metrics.bandwidthWaitNanos, uint64(wait))
+		time.Sleep(wait)
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestParseBandwidthLimit(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"512kb": 512 * 1024,
+		"1MB":   1024 * 1024,
+		"1.5kb": 1536,
+		"100":   100,
+		"2mb/s": 2 * 1024 * 1024,
+		"0":     0,
+		" 10b ": 10,
+	} {
+		limit, err := parseBandwidthLimit(map[string]string{splunkBandwidthLimitKey: value})
+		if err != nil {
+			t.Fatalf("%s: %v", value, err)
+		}
+		if limit != expected {
+			t.Fatalf("Expected %s to be %d bytes per second, got %d", value, expected, limit)
+		}
+	}
+	for _, value := range []string{"", "kb", "10tb", "-1kb", "fast"} {
+		if _, err := parseBandwidthLimit(map[string]string{splunkBandwidthLimitKey: value}); err == nil {
+			t.Fatalf("Expected %q to be rejected", value)
+		}
+	}
+	if _, err := parseBandwidthPolicy(map[string]string{splunkBandwidthPolicyKey: "queue"}); err == nil {
+		t.Fatal("Expected an unknown policy to be rejected")
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	now := time.Now()
+	b := newBandwidthLimiter(1000, bandwidthPolicyDrop)
+	if allowed, _ := b.reserve(600, now); !allowed {
+		t.Fatal("Expected the first event to fit in the bucket")
+	}
+	if allowed, _ := b.reserve(600, now); allowed {
+		t.Fatal("Expected the event over the limit to be dropped")
+	}
+	if allowed, _ := b.reserve(600, now.Add(200*time.Millisecond)); !allowed {
+		t.Fatal("Expected the bucket to refill")
+	}
+	// larger than the bucket, passes once it is full and leaves a debt
+	if allowed, _ := b.reserve(3000, now.Add(time.Second)); allowed {
+		t.Fatal("Expected the large event to wait for a full bucket")
+	}
+	if allowed, _ := b.reserve(3000, now.Add(2*time.Second)); !allowed {
+		t.Fatal("Expected the large event to pass with a full bucket")
+	}
+	if allowed, _ := b.reserve(10, now.Add(3*time.Second)); allowed {
+		t.Fatal("Expected the debt of the large event to be paid first")
+	}
+
+	b = newBandwidthLimiter(1000, bandwidthPolicyBlock)
+	if _, wait := b.reserve(1000, now); wait != 0 {
+		t.Fatalf("Expected no wait with a full bucket, got %v", wait)
+	}
+	if allowed, wait := b.reserve(500, now); !allowed || wait != 500*time.Millisecond {
+		t.Fatalf("Expected to wait 500ms, got %v %v", allowed, wait)
+	}
+	if _, wait := b.reserve(500, now); wait != time.Second {
+		t.Fatalf("Expected to wait after the previous reservation, got %v", wait)
+	}
+
+	var disabled *bandwidthLimiter
+	if allowed, wait := disabled.reserve(1<<30, now); !allowed || wait != 0 {
+		t.Fatal("Expected a nil limiter to allow everything")
+	}
+}
+
+func TestBandwidthLimitEnrichedSize(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	labels := map[string]string{}
+	for _, key := range []string{"team", "service", "environment", "region", "cluster"} {
+		labels[key] = strings.Repeat(key, 10)
+	}
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:            hec.URL(),
+			splunkTokenKey:          hec.token,
+			splunkBandwidthLimitKey: "1kb",
+			labelsKey:               "team,service,environment,region,cluster",
+		},
+		ContainerID:     "containeriid",
+		ContainerName:   "/container_name",
+		ContainerLabels: labels,
+	}
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the lines are only 100 bytes, but every event carries the labels
+	line := []byte(strings.Repeat("x", 100))
+	for i := 0; i < 4; i++ {
+		if err := loggerDriver.Log(&logger.Message{Line: line, Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := loggerDriver.(*splunkLoggerInline).containerMetrics()
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 1 {
+		t.Fatalf("Expected the enriched events to exceed the limit after the first one, got %d events", len(hec.messages))
+	}
+	if dropped := c.droppedBy[dropReasonBandwidthLimited]; dropped != 3 {
+		t.Fatalf("Expected 3 events dropped over the bandwidth limit, got %d", dropped)
+	}
+	if dropped := c.droppedBy[dropReasonBufferFull]; dropped != 0 {
+		t.Fatalf("Expected the bandwidth drops to be counted apart, got %d buffer drops", dropped)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// How often the size of the local json files of all the containers is
+// checked against SPLUNK_CACHE_TOTAL_MAX_BYTES
+const cacheBudgetInterval = 30 * time.Second
+
+// Rotated files the accountant may remove, <path>.<n> from the json logger
+// and the files picked up or compressed by splunk-local-compress. Files
+// being compressed are left alone.
+var cacheRotatedPattern = regexp.MustCompile(`^(\d+|\d{8}T\d{6}\.\d{9}(\.gz)?)$`)
+
+// cacheBudget removes the oldest rotated local json files of any container
+// while they all take more than SPLUNK_CACHE_TOTAL_MAX_BYTES, nil when there
+// is no limit
+var cacheBudget *cacheAccountant
+
+// cacheAccountant sums the sizes of the local json files of the logged
+// containers, their active file and the rotated ones. It only stats the
+// files, once per interval. The active files are never removed.
+type cacheAccountant struct {
+	maxBytes int64
+
+	mu     sync.Mutex
+	caches map[*cacheBudgetLogger]struct{}
+}
+
+func newCacheAccountant(maxBytes int64) *cacheAccountant {
+	return &cacheAccountant{maxBytes: maxBytes, caches: make(map[*cacheBudgetLogger]struct{})}
+}
+
+// start() checks the sizes every interval
+func (a *cacheAccountant) start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			a.enforce()
+		}
+	}()
+}
+
+func (a *cacheAccountant) add(c *cacheBudgetLogger) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.caches[c] = struct{}{}
+}
+
+func (a *cacheAccountant) remove(c *cacheBudgetLogger) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.caches, c)
+}
+
+// cacheFile is a local json file found by the accountant
+type cacheFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+	cache   *cacheBudgetLogger
+}
+
+// enforce() removes the oldest rotated files until the local json files
+// take at most maxBytes, it returns the number of bytes reclaimed
+func (a *cacheAccountant) enforce() int64 {
+	a.mu.Lock()
+	caches := make([]*cacheBudgetLogger, 0, len(a.caches))
+	for c := range a.caches {
+		caches = append(caches, c)
+	}
+	a.mu.Unlock()
+
+	var total int64
+	var rotated []cacheFile
+	for _, c := range caches {
+		active, files := c.files()
+		total += active
+		for _, f := range files {
+			total += f.size
+		}
+		rotated = append(rotated, files...)
+	}
+	if total <= a.maxBytes {
+		return 0
+	}
+
+	sort.Slice(rotated, func(i, j int) bool {
+		return rotated[i].modTime.Before(rotated[j].modTime)
+	})
+	var reclaimed int64
+	removed := 0
+	for _, f := range rotated {
+		if total-reclaimed <= a.maxBytes {
+			break
+		}
+		if !f.cache.removeRotated(f) {
+			continue
+		}
+		reclaimed += f.size
+		removed++
+		processorLog.WithField("path", f.name).WithField("size", f.size).WithField("modified", f.modTime).Debug("Removed rotated log file over the cache budget")
+	}
+	atomic.AddUint64(&metrics.cacheBudgetReclaimed, uint64(reclaimed))
+	entry := processorLog.WithField("total", total).WithField("maxBytes", a.maxBytes).WithField("files", removed).WithField("reclaimed", reclaimed)
+	if total-reclaimed > a.maxBytes {
+		entry.Warn("Local json files are over SPLUNK_CACHE_TOTAL_MAX_BYTES and no more rotated file can be removed")
+	} else {
+		entry.Info("Removed the oldest rotated log files over SPLUNK_CACHE_TOTAL_MAX_BYTES")
+	}
+	return reclaimed
+}
+
+// cacheBudgetLogger wraps the json logger of a container to account its
+// files in cacheBudget. The json logger renames the rotated files while it
+// writes, so the accountant only removes them between writes.
+type cacheBudgetLogger struct {
+	logger.Logger
+
+	path string
+	// records the files removed, may be nil
+	retention *localRetention
+	budget    *cacheAccountant
+
+	// held while the json logger writes, and while the accountant removes
+	// a file
+	mu sync.Mutex
+	// also held while a file is removed, the locks of the wrappers which
+	// rename the rotated files too
+	locks []sync.Locker
+}
+
+func newCacheBudgetLogger(l logger.Logger, path string, retention *localRetention, budget *cacheAccountant) *cacheBudgetLogger {
+	c := &cacheBudgetLogger{Logger: l, path: path, retention: retention, budget: budget}
+	budget.add(c)
+	return c
+}
+
+func (c *cacheBudgetLogger) Log(msg *logger.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Logger.Log(msg)
+}
+
+func (c *cacheBudgetLogger) Close() error {
+	c.budget.remove(c)
+	return c.Logger.Close()
+}
+
+func (c *cacheBudgetLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	return c.Logger.(logger.LogReader).ReadLogs(config)
+}
+
+// files() returns the size of the active file and the rotated files
+func (c *cacheBudgetLogger) files() (int64, []cacheFile) {
+	var active int64
+	if info, err := os.Stat(c.path); err == nil {
+		active = info.Size()
+	}
+	matches, err := filepath.Glob(c.path + ".*")
+	if err != nil {
+		processorLog.WithField("path", c.path).WithError(err).Warn("Cannot list rotated log files")
+		return active, nil
+	}
+	var files []cacheFile
+	for _, match := range matches {
+		if !cacheRotatedPattern.MatchString(strings.TrimPrefix(match, c.path+".")) {
+			continue
+		}
+		if info, err := os.Stat(match); err == nil {
+			files = append(files, cacheFile{name: match, size: info.Size(), modTime: info.ModTime(), cache: c})
+		}
+	}
+	return active, files
+}
+
+// removeRotated() removes a rotated file unless it changed since it was
+// found: a rotation may have given its name to a newer file
+func (c *cacheBudgetLogger) removeRotated(f cacheFile) bool {
+	for _, l := range c.locks {
+		l.Lock()
+		defer l.Unlock()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, err := os.Stat(f.name)
+	if err != nil || info.Size() != f.size || !info.ModTime().Equal(f.modTime) {
+		return false
+	}
+	if err := os.Remove(f.name); err != nil {
+		processorLog.WithField("path", f.name).WithError(err).Warn("Cannot remove rotated log file")
+		return false
+	}
+	c.retention.removed(f.modTime)
+	return true
+}
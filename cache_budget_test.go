@@ -0,0 +1,124 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheAccountant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachebudget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0640); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(-age)
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the oldest files are the active file of a and the rotated files of b
+	write("a.json", 100, 5*time.Hour)
+	write("a.json.1", 100, 2*time.Hour)
+	write("b.json", 100, 0)
+	write("b.json.1", 100, time.Hour)
+	write("b.json.20060102T150405.000000000.gz", 100, 3*time.Hour)
+	write("b.json.20060102T150405.000000000.gz.tmp", 100, 4*time.Hour)
+
+	budget := newCacheAccountant(350)
+	retentionA, retentionB := &localRetention{}, &localRetention{}
+	a := newCacheBudgetLogger(&countingLogger{}, filepath.Join(dir, "a.json"), retentionA, budget)
+	b := newCacheBudgetLogger(&countingLogger{}, filepath.Join(dir, "b.json"), retentionB, budget)
+
+	// 500 bytes are accounted, the file being compressed is not
+	if reclaimed := budget.enforce(); reclaimed != 200 {
+		t.Fatalf("Expected 200 bytes to be reclaimed, got %d", reclaimed)
+	}
+	for name, exists := range map[string]bool{
+		"a.json":                              true,
+		"a.json.1":                            false,
+		"b.json":                              true,
+		"b.json.1":                            true,
+		"b.json.20060102T150405.000000000.gz": false,
+		"b.json.20060102T150405.000000000.gz.tmp": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Fatalf("Expected %s to exist: %v", name, exists)
+		}
+	}
+	if !retentionA.since().Equal(now.Add(-2*time.Hour)) || !retentionB.since().Equal(now.Add(-3*time.Hour)) {
+		t.Fatal("Expected the removed files to be recorded for docker logs")
+	}
+
+	// under the limit
+	if reclaimed := budget.enforce(); reclaimed != 0 {
+		t.Fatalf("Expected nothing to be reclaimed, got %d", reclaimed)
+	}
+
+	// the active files are never removed
+	budget.maxBytes = 0
+	if reclaimed := budget.enforce(); reclaimed != 100 {
+		t.Fatalf("Expected the last rotated file to be reclaimed, got %d", reclaimed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.json")); err != nil {
+		t.Fatal("Expected the active file to be kept")
+	}
+
+	// closed loggers are not accounted
+	a.Close()
+	b.Close()
+	if len(budget.caches) != 0 {
+		t.Fatal("Expected the closed loggers to be removed from the accountant")
+	}
+}
+
+func TestCacheBudgetSkipsRenamedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachebudget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newCacheBudgetLogger(&countingLogger{}, filepath.Join(dir, "a.json"), nil, newCacheAccountant(0))
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.json.1"), []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	_, files := c.files()
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 rotated file, got %d", len(files))
+	}
+	// a rotation gives the name to a newer file
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.json.1"), []byte("newer"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if c.removeRotated(files[0]) {
+		t.Fatal("Expected a file renamed since it was found not to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.json.1")); err != nil {
+		t.Fatal("Expected the newer file to be kept")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	stderrors "errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/pkg/errors"
+)
+
+// Number of consecutive writes of the local log failing as its filesystem is
+// full or read-only after which the container is only forwarded
+const cacheFaultThreshold = 3
+
+// Number of containers whose local log is not written as its filesystem is
+// full or read-only
+var cacheDegradedContainers int64
+
+// isCacheFault() returns whether err is a write to a full or read-only
+// filesystem
+func isCacheFault(err error) bool {
+	for _, e := range []error{err, errors.Cause(err)} {
+		if stderrors.Is(e, syscall.ENOSPC) || stderrors.Is(e, syscall.EROFS) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheFaultLogger wraps the local json logger and stops writing to it once
+// its writes keep failing as the filesystem is full or read-only, without
+// a warning per line. A small test write is tried every probe interval and
+// writing resumes once it succeeds. The lines written meanwhile are only
+// forwarded.
+type cacheFaultLogger struct {
+	logger.Logger
+
+	containerID   string
+	dir           string
+	probeInterval time.Duration
+	probe         func(dir string) error
+	// called with a lifecycle action and reason when writing stops and
+	// resumes
+	onChange func(action string, reason string)
+
+	mu       sync.Mutex
+	failures int
+	// why the local log is not written, nil unless degraded
+	err       error
+	nextProbe time.Time
+}
+
+func newCacheFaultLogger(l logger.Logger, containerID string, dir string, probeInterval time.Duration) *cacheFaultLogger {
+	return &cacheFaultLogger{
+		Logger:        l,
+		containerID:   containerID,
+		dir:           dir,
+		probeInterval: probeInterval,
+		probe:         probeWrite,
+	}
+}
+
+// probeWrite() writes and removes a small file in dir
+func probeWrite(dir string) error {
+	f, err := ioutil.TempFile(dir, ".splunk-probe")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write([]byte("probe\n")); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *cacheFaultLogger) Log(msg *logger.Message) error {
+	if c.skip(time.Now()) {
+		return nil
+	}
+	err := c.Logger.Log(msg)
+	if !isCacheFault(err) {
+		c.mu.Lock()
+		c.failures = 0
+		c.mu.Unlock()
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil
+	}
+	if c.failures++; c.failures < cacheFaultThreshold {
+		processorLog.WithField("id", c.containerID).WithError(err).Debug("Cannot write the local log")
+		return nil
+	}
+	c.err = err
+	c.nextProbe = time.Now().Add(c.probeInterval)
+	atomic.AddInt64(&cacheDegradedContainers, 1)
+	atomic.AddUint64(&metrics.cacheDegraded, 1)
+	processorLog.WithField("id", c.containerID).WithField("path", c.dir).WithError(err).Warn("Cannot write the local log, forwarding to Splunk only until the filesystem is writable again")
+	if c.onChange != nil {
+		c.onChange(lifecycleDegraded, lifecycleReasonCacheWriteFailed)
+	}
+	return nil
+}
+
+// skip() returns whether the local log is not written, trying a test write
+// once per probe interval
+func (c *cacheFaultLogger) skip(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return false
+	}
+	if now.Before(c.nextProbe) {
+		return true
+	}
+	c.nextProbe = now.Add(c.probeInterval)
+	if err := c.probe(c.dir); err != nil {
+		processorLog.WithField("id", c.containerID).WithError(err).Debug("Local log is still not writable")
+		return true
+	}
+	c.err, c.failures = nil, 0
+	atomic.AddInt64(&cacheDegradedContainers, -1)
+	processorLog.WithField("id", c.containerID).WithField("path", c.dir).Info("Filesystem is writable again, resuming local logging")
+	if c.onChange != nil {
+		c.onChange(lifecycleRecovered, lifecycleReasonCacheWritable)
+	}
+	return false
+}
+
+// degraded() returns why the local log is not written, nil when it is
+func (c *cacheFaultLogger) degraded() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *cacheFaultLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	return c.Logger.(logger.LogReader).ReadLogs(config)
+}
+
+func (c *cacheFaultLogger) Close() error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.err = nil
+		atomic.AddInt64(&cacheDegradedContainers, -1)
+	}
+	c.mu.Unlock()
+	return c.Logger.Close()
+}
+
+// cacheFault() returns the fault detection of the local log of the
+// container, nil when it has none
+func (lf *logPair) cacheFault() *cacheFaultLogger {
+	jsonl := lf.jsonl
+	if cache, ok := jsonl.(*cacheLogger); ok {
+		cache.mu.Lock()
+		jsonl = cache.cache
+		cache.mu.Unlock()
+	}
+	fault, _ := jsonl.(*cacheFaultLogger)
+	return fault
+}
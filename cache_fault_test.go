@@ -0,0 +1,139 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// failingLogger fails its writes with err while it is set
+type failingLogger struct {
+	countingLogger
+	err error
+}
+
+func (l *failingLogger) Log(msg *logger.Message) error {
+	if l.err != nil {
+		return l.err
+	}
+	return l.countingLogger.Log(msg)
+}
+
+func TestCacheFaultLogger(t *testing.T) {
+	local := &failingLogger{}
+	fault := newCacheFaultLogger(local, "containeriid", "/var/log/docker", time.Hour)
+	var probeErr error
+	probes := 0
+	fault.probe = func(dir string) error {
+		probes++
+		return probeErr
+	}
+	var events []string
+	fault.onChange = func(action string, reason string) {
+		events = append(events, action+":"+reason)
+	}
+	log := func() error {
+		return fault.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()})
+	}
+
+	// other errors are returned and do not degrade the local log
+	local.err = errors.New("other")
+	for i := 0; i < cacheFaultThreshold; i++ {
+		if err := log(); err == nil {
+			t.Fatal("Expected the error of the local log")
+		}
+	}
+	if fault.degraded() != nil {
+		t.Fatal("Expected only a full or read-only filesystem to degrade the local log")
+	}
+
+	degraded := atomic.LoadInt64(&cacheDegradedContainers)
+	local.err = &os.PathError{Op: "write", Path: "/var/log/docker/containeriid.json", Err: syscall.ENOSPC}
+	for i := 0; i < cacheFaultThreshold; i++ {
+		if err := log(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fault.degraded() == nil {
+		t.Fatalf("Expected the local log to be degraded after %d failures", cacheFaultThreshold)
+	}
+	if atomic.LoadInt64(&cacheDegradedContainers) != degraded+1 {
+		t.Fatal("Expected the degraded container to be counted")
+	}
+
+	// the filesystem is still full
+	probeErr = syscall.ENOSPC
+	fault.nextProbe = time.Time{}
+	local.err = nil
+	if err := log(); err != nil {
+		t.Fatal(err)
+	}
+	if probes != 1 || local.logged != 0 || fault.degraded() == nil {
+		t.Fatal("Expected the local log not to be written while the test write fails")
+	}
+	// between probes the local log is not tried
+	if err := log(); err != nil {
+		t.Fatal(err)
+	}
+	if probes != 1 || local.logged != 0 {
+		t.Fatal("Expected no test write before the interval")
+	}
+
+	probeErr = nil
+	fault.nextProbe = time.Time{}
+	if err := log(); err != nil {
+		t.Fatal(err)
+	}
+	if local.logged != 1 || fault.degraded() != nil {
+		t.Fatal("Expected the local log to be written once the test write succeeds")
+	}
+	if atomic.LoadInt64(&cacheDegradedContainers) != degraded {
+		t.Fatal("Expected the recovered container not to be counted")
+	}
+	if len(events) != 2 || events[0] != "degraded:cache_write_failed" || events[1] != "recovered:cache_writable" {
+		t.Fatalf("Unexpected lifecycle events %v", events)
+	}
+}
+
+func TestProbeWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := probeWrite(dir); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Expected the test file to be removed, found %d files", len(files))
+	}
+	if err := probeWrite(dir + "/missing"); err == nil {
+		t.Fatal("Expected the test write to fail in a missing directory")
+	}
+}
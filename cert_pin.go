@@ -0,0 +1,111 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// What the fingerprints of splunk-pinned-cert-sha256 are computed on
+const (
+	// the DER encoding of the certificate
+	pinTypeCert = "cert"
+	// the DER encoding of the public key of the certificate, which is kept
+	// when the certificate is renewed with the same key
+	pinTypeSPKI = "spki"
+)
+
+// parsePinType() returns the pin type of splunk-pin-type
+func parsePinType(value string, key string) (string, error) {
+	switch value {
+	case pinTypeCert, pinTypeSPKI:
+		return value, nil
+	}
+	return "", fmt.Errorf("%s: unknown pin type %s in %s, supported types are cert and spki", driverName, value, key)
+}
+
+// parsePins() returns the SHA-256 fingerprints of a comma separated list,
+// each in hex, with or without colons, or in base64
+func parsePins(value string, key string) ([][]byte, error) {
+	var pins [][]byte
+	for _, pin := range strings.Split(value, ",") {
+		pin = strings.TrimSpace(pin)
+		if pin == "" {
+			continue
+		}
+		fingerprint, err := hex.DecodeString(strings.Replace(pin, ":", "", -1))
+		if err != nil {
+			fingerprint, err = base64.StdEncoding.DecodeString(pin)
+		}
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, fmt.Errorf("%s: %s in %s is not a SHA-256 fingerprint in hex or base64", driverName, pin, key)
+		}
+		pins = append(pins, fingerprint)
+	}
+	if len(pins) == 0 {
+		return nil, fmt.Errorf("%s: %s has no fingerprint", driverName, key)
+	}
+	return pins, nil
+}
+
+// pinFingerprint() returns the SHA-256 fingerprint of the certificate or of
+// its public key
+func pinFingerprint(cert *x509.Certificate, pinType string) []byte {
+	der := cert.Raw
+	if pinType == pinTypeSPKI {
+		der = cert.RawSubjectPublicKeyInfo
+	}
+	fingerprint := sha256.Sum256(der)
+	return fingerprint[:]
+}
+
+// verifyPins() returns the callback checking, after the usual verification,
+// that the server certificate matches one of the pins. A public key pin may
+// also match a certificate of the verified chain, such as an intermediate
+// CA. The certificates presented by the server which the verification did
+// not chain are never trusted. Several pins allow rotating the certificate.
+func verifyPins(pins [][]byte, pinType string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 {
+			return fmt.Errorf("%s: server certificate is not verified, it cannot be checked against the pinned fingerprints", driverName)
+		}
+		leaf := verifiedChains[0][0]
+		for _, chain := range verifiedChains {
+			certs := chain[:1]
+			if pinType == pinTypeSPKI {
+				certs = chain
+			}
+			for _, cert := range certs {
+				fingerprint := pinFingerprint(cert, pinType)
+				for _, pin := range pins {
+					if bytes.Equal(fingerprint, pin) {
+						return nil
+					}
+				}
+			}
+		}
+		observed := hex.EncodeToString(pinFingerprint(leaf, pinType))
+		senderLog.WithField("subject", leaf.Subject.String()).WithField("pinType", pinType).WithField("fingerprint", observed).Error("Server certificate does not match the pinned fingerprints")
+		return fmt.Errorf("%s: server certificate does not match the pinned fingerprints, its %s SHA-256 fingerprint is %s", driverName, pinType, observed)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinnedCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	// the refused handshakes are expected
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "pinning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certSum := sha256.Sum256(server.Certificate().Raw)
+	spkiSum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	certHex := hex.EncodeToString(certSum[:])
+	other := strings.Repeat("ab", sha256.Size)
+
+	// hex with colons, as printed by openssl
+	var colons []string
+	for i := 0; i < len(certHex); i += 2 {
+		colons = append(colons, strings.ToUpper(certHex[i:i+2]))
+	}
+
+	tests := []struct {
+		pins    string
+		pinType string
+		refused bool
+	}{
+		{certHex, "", false},
+		{strings.Join(colons, ":"), pinTypeCert, false},
+		{base64.StdEncoding.EncodeToString(certSum[:]), "", false},
+		{base64.StdEncoding.EncodeToString(spkiSum[:]), pinTypeSPKI, false},
+		// a pin of the certificate does not match its public key
+		{certHex, pinTypeSPKI, true},
+		{other, "", true},
+		// rotation
+		{other + ", " + certHex, "", false},
+	}
+	for _, test := range tests {
+		config := map[string]string{
+			splunkCAPathKey:           caPath,
+			splunkPinnedCertSHA256Key: test.pins,
+		}
+		if test.pinType != "" {
+			config[splunkPinTypeKey] = test.pinType
+		}
+		if err := ValidateLogOpt(config); err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig, err := newTLSConfig(config, hecTLSOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := &http.Transport{TLSClientConfig: tlsConfig}
+		res, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		transport.CloseIdleConnections()
+		if refused := err != nil; refused != test.refused {
+			t.Fatalf("Expected the connection with %v to be refused: %v, got %v", config, test.refused, err)
+		}
+		if test.refused && !strings.Contains(err.Error(), "fingerprint is ") {
+			t.Fatalf("Expected the error to show the observed fingerprint, got %v", err)
+		}
+	}
+}
+
+func TestValidatePinnedCertificate(t *testing.T) {
+	pin := strings.Repeat("ab", sha256.Size)
+	for _, config := range []map[string]string{
+		{splunkPinnedCertSHA256Key: pin, splunkInsecureSkipVerifyKey: "true"},
+		{splunkPinnedCertSHA256Key: "abcd"},
+		{splunkPinnedCertSHA256Key: ","},
+		{splunkPinnedCertSHA256Key: pin, splunkPinTypeKey: "key"},
+	} {
+		if err := ValidateLogOpt(config); err == nil {
+			t.Fatalf("Expected %v to be invalid", config)
+		}
+	}
+	if err := ValidateLogOpt(map[string]string{splunkPinnedCertSHA256Key: pin, splunkInsecureSkipVerifyKey: "false"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newSelfSignedCert() returns a certificate of 127.0.0.1 signed by itself
+func newSelfSignedCert(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestPinnedCertificateNotChained(t *testing.T) {
+	// the server presents its own certificate followed by the pinned one,
+	// which does not sign it
+	served, key := newSelfSignedCert(t, "served")
+	pinned, _ := newSelfSignedCert(t, "pinned")
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{served.Raw, pinned.Raw}, PrivateKey: key}}}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "pinning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: served.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pinType := range []string{pinTypeCert, pinTypeSPKI} {
+		fingerprint := pinFingerprint(pinned, pinType)
+		config := map[string]string{
+			splunkCAPathKey:           caPath,
+			splunkPinnedCertSHA256Key: hex.EncodeToString(fingerprint),
+			splunkPinTypeKey:          pinType,
+		}
+		tlsConfig, err := newTLSConfig(config, hecTLSOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := &http.Transport{TLSClientConfig: tlsConfig}
+		res, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		transport.CloseIdleConnections()
+		if err == nil {
+			t.Fatalf("Expected the connection to be refused with a %s pin of a certificate out of the verified chain", pinType)
+		}
+	}
+}
+
+func TestPinnedCertificateRequiresVerification(t *testing.T) {
+	config := map[string]string{
+		splunkPinnedCertSHA256Key:   strings.Repeat("ab", sha256.Size),
+		splunkInsecureSkipVerifyKey: "true",
+	}
+	if _, err := newTLSConfig(config, hecTLSOptions); err == nil {
+		t.Fatal("Expected pins without verification to be refused")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	channelFromSource      = "source"
+	channelFromLabelPrefix = "label:"
+)
+
+var guidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// channelDeriver computes the HEC request channel of a message from its
+// docker source (stdout/stderr) or from a container label
+type channelDeriver struct {
+	fromSource bool
+	// channel derived from the label, the same for every message
+	labelChannel string
+}
+
+func newChannelDeriver(info logger.Info) (*channelDeriver, error) {
+	from, ok := info.Config[splunkChannelFromKey]
+	if !ok || from == "" {
+		return nil, nil
+	}
+	if from == channelFromSource {
+		return &channelDeriver{fromSource: true}, nil
+	}
+	if strings.HasPrefix(from, channelFromLabelPrefix) {
+		label := strings.TrimPrefix(from, channelFromLabelPrefix)
+		value, ok := info.ContainerLabels[label]
+		if !ok || value == "" {
+			return nil, nil
+		}
+		return &channelDeriver{labelChannel: channelGUID(value)}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown %s value %q, supported values are %s and %s<name>", driverName, splunkChannelFromKey, from, channelFromSource, channelFromLabelPrefix)
+}
+
+func (c *channelDeriver) channel(msg *logger.Message) string {
+	if c == nil {
+		return ""
+	}
+	if c.fromSource {
+		return channelGUID(msg.Source)
+	}
+	return c.labelChannel
+}
+
+// channelGUID() returns value when it's already a GUID, HEC requires channels
+// to be GUIDs. Other values are mapped to a name based (version 5) UUID.
+func channelGUID(value string) string {
+	if guidRegexp.MatchString(value) {
+		return value
+	}
+	sum := sha1.Sum([]byte(value))
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestChannelGUID(t *testing.T) {
+	guid := "0D2E4B8C-9B3A-4E5F-8A7B-1C2D3E4F5A6B"
+	if channelGUID(guid) != guid {
+		t.Fatal("Expected GUID values to be used as channel")
+	}
+	if channelGUID("stdout") != channelGUID("stdout") || channelGUID("stdout") == channelGUID("stderr") {
+		t.Fatal("Expected stable and distinct channels")
+	}
+	if !guidRegexp.MatchString(channelGUID("stdout")) {
+		t.Fatalf("Expected %s to be a GUID", channelGUID("stdout"))
+	}
+}
+
+func TestChannelFromSource(t *testing.T) {
+	if err := os.Setenv(envVarPostMessagesFrequency, "10h"); err != nil {
+		t.Fatal(err)
+	}
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:         hec.URL(),
+			splunkTokenKey:       hec.token,
+			splunkChannelFromKey: channelFromSource,
+		},
+		ContainerID: "containeriid",
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []string{"stdout", "stdout", "stderr", "stdout"} {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(source), Source: source, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{channelGUID("stdout"), channelGUID("stderr"), channelGUID("stdout")}
+	if len(hec.channels) != len(expected) {
+		t.Fatalf("Unexpected channels %v", hec.channels)
+	}
+	for i, channel := range expected {
+		if hec.channels[i] != channel {
+			t.Fatalf("Unexpected channels %v", hec.channels)
+		}
+	}
+	if len(hec.messages) != 4 {
+		t.Fatal("Expected four messages")
+	}
+
+	err = hec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Setenv(envVarPostMessagesFrequency, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannelFromLabel(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	guid := "0D2E4B8C-9B3A-4E5F-8A7B-1C2D3E4F5A6B"
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:         hec.URL(),
+			splunkTokenKey:       hec.token,
+			splunkChannelFromKey: "label:com.example.channel",
+		},
+		ContainerID:     "containeriid",
+		ContainerLabels: map[string]string{"com.example.channel": guid},
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loggerDriver.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.channels) != 1 || hec.channels[0] != guid {
+		t.Fatalf("Unexpected channels %v", hec.channels)
+	}
+
+	err = hec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.Config[splunkChannelFromKey] = "container"
+	if _, err := New(info); err == nil {
+		t.Fatal("Expecting error on unknown channel derivation")
+	}
+}
+
+func TestChannelRunsNotPostedTwice(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failed   bool
+		received []string
+	)
+	// fails the first request of channel b
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-Splunk-Request-Channel") == "b" && !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for dec := json.NewDecoder(r.Body); ; {
+			var message splunkMessage
+			if err := dec.Decode(&message); err != nil {
+				break
+			}
+			received = append(received, message.Event.(string))
+		}
+	}))
+	defer server.Close()
+
+	hec := &hecClient{
+		client:                server.Client(),
+		url:                   server.URL,
+		postMessagesBatchSize: 10,
+		bufferMaximum:         100,
+	}
+	messages := []*splunkMessage{
+		{Event: "a1", channel: "a"},
+		{Event: "a2", channel: "a"},
+		{Event: "b1", channel: "b"},
+		{Event: "c1", channel: "c"},
+	}
+	if remaining := hec.postMessages(messages, false); len(remaining) != len(messages) {
+		t.Fatalf("Expected the batch to be retried, %d messages remain", len(remaining))
+	}
+	if remaining := hec.postMessages(messages, false); len(remaining) != 0 {
+		t.Fatalf("Expected the batch to be sent, %d messages remain", len(remaining))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != "a1,a2,b1,c1" {
+		t.Fatalf("Expected every message to be posted once, got %v", received)
+	}
+}
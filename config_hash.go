@@ -0,0 +1,49 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// Field holding the hash of the options of the container
+const configHashField = "splunk_config_hash"
+
+// Number of hex characters of the config hash
+const configHashLength = 12
+
+// configHash() returns a short hash of the options of a container, the same
+// for identical options whatever their order. Secrets are hashed redacted,
+// so the hash doesn't help guessing them.
+func configHash(config map[string]string) string {
+	redacted := redactedConfig(config)
+	keys := make([]string, 0, len(redacted))
+	for key := range redacted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(redacted[key]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:configHashLength]
+}
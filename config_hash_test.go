@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestConfigHash(t *testing.T) {
+	config := func() map[string]string {
+		return map[string]string{
+			splunkURLKey:    "https://splunk.example.com:8088",
+			splunkTokenKey:  "00000000-0000-0000-0000-000000001234",
+			splunkIndexKey:  "main",
+			splunkFormatKey: splunkFormatJSON,
+		}
+	}
+	hash := configHash(config())
+	if len(hash) != configHashLength {
+		t.Fatalf("Expected a hash of %d characters, got %s", configHashLength, hash)
+	}
+	for i := 0; i < 10; i++ {
+		if other := configHash(config()); other != hash {
+			t.Fatalf("Expected the same hash for the same options, got %s and %s", hash, other)
+		}
+	}
+	changed := config()
+	changed[splunkIndexKey] = "other"
+	if other := configHash(changed); other == hash {
+		t.Fatal("Expected the hash to change with an option")
+	}
+	added := config()
+	added[splunkGzipCompressionKey] = "true"
+	if other := configHash(added); other == hash {
+		t.Fatal("Expected the hash to change with a new option")
+	}
+}
+
+func TestConfigHashField(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:        hec.URL(),
+			splunkTokenKey:      hec.token,
+			splunkConfigHashKey: "true",
+		},
+		ContainerID: "containeriid",
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loggerDriver.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(hec.messages))
+	}
+	if hash := hec.messages[0].Fields[configHashField]; hash != configHash(info.Config) {
+		t.Fatalf("Expected the config hash %s, got %v", configHash(info.Config), hec.messages[0].Fields)
+	}
+
+	if err := hec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
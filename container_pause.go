@@ -0,0 +1,163 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Modes of a container pause, for the lines read while it is paused
+const (
+	// the lines are not sent to Splunk
+	pauseModeDiscard = "discard"
+	// the lines are sent from the local log once the container resumes
+	pauseModeSpool = "spool"
+)
+
+// Number of containers whose forwarding is paused, for the statistics line
+var pausedContainers int64
+
+// pausableLogger is the splunk logger sink of a container, whose forwarding
+// can be paused from the admin socket while its local log goes on. The
+// pause is not persisted, restarting the plugin resumes every container.
+type pausableLogger struct {
+	logger.Logger
+	lf *logPair
+
+	// serializes the lines of the container and the replay of the spool
+	logMu sync.Mutex
+
+	mu          sync.Mutex
+	pausedSince time.Time
+	mode        string
+}
+
+func (p *pausableLogger) Log(msg *logger.Message) error {
+	p.logMu.Lock()
+	defer p.logMu.Unlock()
+	p.mu.Lock()
+	paused, mode := !p.pausedSince.IsZero(), p.mode
+	p.mu.Unlock()
+	if !paused {
+		return p.Logger.Log(msg)
+	}
+	if mode == pauseModeDiscard {
+		if provider, ok := p.Logger.(metricsProvider); ok && provider.containerMetrics() != nil {
+			provider.containerMetrics().addReceived(1)
+			provider.containerMetrics().addDropped(dropReasonPaused, 1)
+		}
+	}
+	logger.PutMessage(msg)
+	return nil
+}
+
+// pause() stops sending the lines of the container to Splunk, it returns
+// false when the container was already paused
+func (p *pausableLogger) pause(mode string) (bool, error) {
+	if mode == pauseModeSpool {
+		if _, ok := p.lf.localReader(); !ok {
+			return false, fmt.Errorf("%s: the container has no local log to spool to", driverName)
+		}
+	}
+	p.mu.Lock()
+	if !p.pausedSince.IsZero() {
+		p.mu.Unlock()
+		return false, nil
+	}
+	p.pausedSince, p.mode = time.Now(), mode
+	p.mu.Unlock()
+
+	atomic.AddInt64(&pausedContainers, 1)
+	driverLog.WithField("id", p.lf.info.ContainerID).WithField("mode", mode).Warn("Container forwarding paused")
+	p.lf.logLifecycle(lifecyclePaused, lifecycleReasonAdmin)
+	return true, nil
+}
+
+// resume() sends the lines of the container to Splunk again, after the
+// lines logged locally while it was paused with the spool mode. It returns
+// false when the container was not paused.
+func (p *pausableLogger) resume() bool {
+	p.logMu.Lock()
+	defer p.logMu.Unlock()
+	p.mu.Lock()
+	since, mode := p.pausedSince, p.mode
+	p.pausedSince, p.mode = time.Time{}, ""
+	p.mu.Unlock()
+	if since.IsZero() {
+		return false
+	}
+
+	atomic.AddInt64(&pausedContainers, -1)
+	p.lf.logLifecycle(lifecycleResumed, lifecycleReasonAdmin)
+	spooled := 0
+	if mode == pauseModeSpool {
+		spooled = p.lf.backfill(p.Logger, since, time.Time{}, math.MaxInt32)
+	}
+	driverLog.WithField("id", p.lf.info.ContainerID).WithField("paused", time.Since(since)).WithField("spooled", spooled).Info("Container forwarding resumed")
+	return true
+}
+
+// paused() returns when the container was paused and how, a zero time when
+// it is not
+func (p *pausableLogger) paused() (time.Time, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pausedSince, p.mode
+}
+
+func (p *pausableLogger) Close() error {
+	p.mu.Lock()
+	if !p.pausedSince.IsZero() {
+		p.pausedSince = time.Time{}
+		atomic.AddInt64(&pausedContainers, -1)
+	}
+	p.mu.Unlock()
+	return p.Logger.Close()
+}
+
+// flush() and queueDepth() flush the splunk logger, like when it is not
+// paused
+func (p *pausableLogger) flush(timeout time.Duration) bool {
+	if f, ok := p.Logger.(flusher); ok {
+		return f.flush(timeout)
+	}
+	return true
+}
+
+func (p *pausableLogger) queueDepth() int {
+	if f, ok := p.Logger.(flusher); ok {
+		return f.queueDepth()
+	}
+	return 0
+}
+
+// pausable() returns the sink pausing the forwarding of the container, nil
+// when it is not forwarded
+func (lf *logPair) pausable() *pausableLogger {
+	for _, sink := range lf.sinks {
+		if p, ok := sink.(*pausableLogger); ok {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// deadLetters receives the messages the plugin gives up on, nil unless
+// SPLUNK_DEAD_LETTER_FILE is set
+var deadLetters *deadLetterFile
+
+// deadLetterFile appends the messages which could not be delivered to a
+// file inside the plugin, one JSON record per line, so they can be replayed
+// once Splunk is back. Writes stop once the file reaches maxSize.
+type deadLetterFile struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// deadLetterRecord is a line of the dead-letter file
+type deadLetterRecord struct {
+	Time        time.Time       `json:"time"`
+	ContainerID string          `json:"container_id"`
+	Reason      string          `json:"reason"`
+	Event       json.RawMessage `json:"event"`
+}
+
+func newDeadLetterFile(path string, maxSize int64) (*deadLetterFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &deadLetterFile{path: path, maxSize: maxSize, f: f, size: info.Size()}, nil
+}
+
+// write() appends the messages, returns false if they were not all written
+func (d *deadLetterFile) write(containerID string, reason int, messages []*splunkMessage) bool {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, message := range messages {
+		line, err := deadLetterLine(now, containerID, reason, message)
+		if err != nil {
+			return false
+		}
+		if d.maxSize > 0 && d.size+int64(len(line)) > d.maxSize {
+			return false
+		}
+		n, err := d.f.Write(line)
+		d.size += int64(n)
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *deadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.f.Close()
+}
+
+// deadLetterLine() returns the record of a message, ending with a newline
+func deadLetterLine(now time.Time, containerID string, reason int, message *splunkMessage) ([]byte, error) {
+	event, err := message.encode()
+	if err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(&deadLetterRecord{now, containerID, dropReasonNames[reason], event})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// lastResort receives the messages which could not be written to the
+// dead-letter file, nil unless SPLUNK_LAST_RESORT_STDERR is enabled
+var lastResort *lastResortWriter
+
+// lastResortWriter writes the messages the plugin gives up on to its own
+// stderr, which the Docker daemon captures in its logs, as the records of
+// the dead-letter file. It is the last copy of the messages when Splunk and
+// the dead-letter file are both unavailable.
+type lastResortWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newLastResortWriter(w io.Writer) *lastResortWriter {
+	return &lastResortWriter{w: w}
+}
+
+// write() writes the messages, returns false if they were not all written
+func (l *lastResortWriter) write(containerID string, reason int, messages []*splunkMessage) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, message := range messages {
+		line, err := deadLetterLine(now, containerID, reason, message)
+		if err != nil {
+			return false
+		}
+		// a single write per record, so they are not interleaved with the
+		// plugin log
+		if _, err := l.w.Write(line); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// logRingBuffer is a logrus hook keeping the most recent formatted entries
+// of the plugin's own log, so they can be retrieved from the admin socket.
+type logRingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLogRingBuffer(size int) *logRingBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &logRingBuffer{lines: make([]string, size)}
+}
+
+func (b *logRingBuffer) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (b *logRingBuffer) Fire(entry *logrus.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// recent() returns the buffered entries from the oldest to the newest
+func (b *logRingBuffer) recent() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	lines := make([]string, 0, len(b.lines))
+	lines = append(lines, b.lines[b.next:]...)
+	return append(lines, b.lines[:b.next]...)
+}
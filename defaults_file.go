@@ -0,0 +1,291 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Label of a container selecting a destination of the defaults file
+const destinationLabel = "splunk.destination"
+
+// How often the defaults file is checked for changes
+const defaultsFilePollInterval = 5 * time.Second
+
+// reloadSafeOptions are applied to the running loggers when the defaults
+// file changes, the other options, such as the filters of the lines, only
+// apply to the containers started afterwards
+var reloadSafeOptions = map[string]bool{
+	splunkRoutingRulesKey:    true,
+	splunkBandwidthLimitKey:  true,
+	splunkBandwidthPolicyKey: true,
+}
+
+// hostDefaults, nil unless SPLUNK_DEFAULTS_FILE is set
+var hostDefaults *defaultsFile
+
+// defaultsConfig is the content of the defaults file
+type defaultsConfig struct {
+	// log-opt defaults of every container
+	Options map[string]string `json:"options"`
+	// named sets of options, selected with the splunk.destination label
+	Destinations map[string]map[string]string `json:"destinations"`
+	// HEC token of the containers with a label, by "<label>=<value>"
+	LabelTokens map[string]string `json:"label_tokens"`
+	// default of splunk-routing-rules
+	RoutingRules json.RawMessage `json:"routing_rules"`
+}
+
+// defaultsFile holds the host-wide option defaults of a JSON file mounted
+// into the plugin. The file is reloaded on SIGHUP or when it changes; a file
+// which doesn't parse leaves the previous defaults in place.
+type defaultsFile struct {
+	path string
+	// called with the new defaults after a reload
+	onReload func()
+
+	mu      sync.RWMutex
+	config  *defaultsConfig
+	content []byte
+	// the reload error last logged, logged again only once it changes
+	lastError string
+}
+
+func newDefaultsFile(path string) *defaultsFile {
+	return &defaultsFile{path: path, config: &defaultsConfig{}}
+}
+
+// parseDefaultsConfig() parses and validates the content of a defaults file
+func parseDefaultsConfig(content []byte) (*defaultsConfig, error) {
+	var config defaultsConfig
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return nil, err
+	}
+	check := func(where string, options map[string]string) error {
+		for key := range options {
+			if !isSupportedLogOpt(key) {
+				return fmt.Errorf("%s: %s", where, unknownLogOptError(key))
+			}
+		}
+		return nil
+	}
+	if err := check("options", config.Options); err != nil {
+		return nil, err
+	}
+	for name, options := range config.Destinations {
+		if err := check("destination "+name, options); err != nil {
+			return nil, err
+		}
+	}
+	for label := range config.LabelTokens {
+		if !strings.Contains(label, "=") {
+			return nil, fmt.Errorf("label_tokens: %q is not <label>=<value>", label)
+		}
+	}
+	if len(config.RoutingRules) > 0 {
+		if _, err := parseRoutingRules(string(config.RoutingRules)); err != nil {
+			return nil, err
+		}
+	}
+	return &config, nil
+}
+
+// reload() reads the file again if its content changed and returns true
+// if the defaults were replaced
+func (f *defaultsFile) reload() bool {
+	content, err := ioutil.ReadFile(f.path)
+	var config *defaultsConfig
+	if err == nil {
+		f.mu.RLock()
+		unchanged := bytes.Equal(content, f.content)
+		f.mu.RUnlock()
+		if unchanged {
+			return false
+		}
+		config, err = parseDefaultsConfig(content)
+	}
+	if err != nil {
+		f.mu.Lock()
+		logged := f.lastError == err.Error()
+		f.lastError = err.Error()
+		f.mu.Unlock()
+		if !logged {
+			driverLog.WithError(err).WithField("file", f.path).Error("Cannot load the defaults file, keeping the previous defaults")
+		}
+		return false
+	}
+	f.mu.Lock()
+	f.config = config
+	f.content = content
+	f.lastError = ""
+	f.mu.Unlock()
+	driverLog.WithField("file", f.path).Info("Loaded the defaults file")
+	if f.onReload != nil {
+		f.onReload()
+	}
+	return true
+}
+
+// watch() reloads the file every interval and on every value of signals
+func (f *defaultsFile) watch(interval time.Duration, signals <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-signals:
+		}
+		f.reload()
+	}
+}
+
+// containerOptions() returns the options the defaults file sets for a
+// container: the defaults, overridden by the destination the container
+// selects with a label, and the token of its labels
+func (f *defaultsFile) containerOptions(labels map[string]string) map[string]string {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	config := f.config
+	f.mu.RUnlock()
+
+	options := make(map[string]string)
+	if len(config.RoutingRules) > 0 {
+		options[splunkRoutingRulesKey] = string(config.RoutingRules)
+	}
+	for key, value := range config.Options {
+		options[key] = value
+	}
+	if name, ok := labels[destinationLabel]; ok {
+		destination, ok := config.Destinations[name]
+		if !ok {
+			driverLog.WithField("destination", name).Warn("Unknown destination in the defaults file")
+		}
+		for key, value := range destination {
+			options[key] = value
+		}
+	}
+	// the first matching label by name wins
+	matches := make([]string, 0, len(config.LabelTokens))
+	for label := range config.LabelTokens {
+		kv := strings.SplitN(label, "=", 2)
+		if value, ok := labels[kv[0]]; ok && value == kv[1] {
+			matches = append(matches, label)
+		}
+	}
+	if len(matches) > 0 {
+		sort.Strings(matches)
+		options[splunkTokenKey] = config.LabelTokens[matches[0]]
+	}
+	return options
+}
+
+// routingRulesUpdater is implemented by the splunk loggers
+type routingRulesUpdater interface {
+	setRoutingRules(rules []*routingRule)
+}
+
+// bandwidthLimitUpdater is implemented by the splunk loggers
+type bandwidthLimitUpdater interface {
+	setBandwidthLimit(limit int64, policy string)
+}
+
+// reloadDefaults() applies the reload-safe options of the defaults file to
+// the running loggers which took them from the file
+func (d *driver) reloadDefaults() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, lf := range d.logs {
+		fileOptions := hostDefaults.containerOptions(lf.info.ContainerLabels)
+		var options map[string]resolvedOption
+		bandwidthChanged := false
+		for key := range reloadSafeOptions {
+			if lf.options[key].Source != optionSourceFile {
+				continue
+			}
+			value := fileOptions[key]
+			if value == lf.options[key].Value {
+				continue
+			}
+			switch key {
+			case splunkRoutingRulesKey:
+				updater, ok := lf.splunkl.(routingRulesUpdater)
+				if !ok {
+					continue
+				}
+				rules, err := parseRoutingRules(value)
+				if err != nil {
+					continue
+				}
+				updater.setRoutingRules(rules)
+			case splunkBandwidthLimitKey, splunkBandwidthPolicyKey:
+				if _, ok := lf.splunkl.(bandwidthLimitUpdater); !ok {
+					continue
+				}
+				// the limit and the policy are applied together below
+				if check, ok := optionChecks[key]; ok && value != "" {
+					if _, err := check(value, fileOptions); err != nil {
+						continue
+					}
+				}
+				bandwidthChanged = true
+			}
+			if options == nil {
+				// the options are read by the admin endpoint, replaced by a copy
+				options = make(map[string]resolvedOption, len(lf.options))
+				for k, v := range lf.options {
+					options[k] = v
+				}
+			}
+			source := optionSourceFile
+			if value == "" {
+				source = optionSourceDefault
+			}
+			options[key] = resolvedOption{value, source}
+			driverLog.WithField("id", lf.info.ContainerID).WithField("option", key).Info("Applied the new default of a running logger")
+		}
+		if bandwidthChanged {
+			config := make(map[string]string, 2)
+			for _, key := range []string{splunkBandwidthLimitKey, splunkBandwidthPolicyKey} {
+				if value := options[key].Value; value != "" {
+					config[key] = value
+				}
+			}
+			limit, err := parseBandwidthLimit(config)
+			if err == nil {
+				var policy string
+				if policy, err = parseBandwidthPolicy(config); err == nil {
+					lf.splunkl.(bandwidthLimitUpdater).setBandwidthLimit(limit, policy)
+				}
+			}
+		}
+		if options != nil {
+			lf.options = options
+		}
+	}
+}
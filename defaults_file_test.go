@@ -0,0 +1,250 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/logger"
+)
+
+func writeDefaultsFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "defaults.json")
+	writeDefaultsFile(t, path, `{
+		"options": {"splunk-index": "file_index", "splunk-source": "file_source", "splunk-sourcetype": "file_sourcetype"},
+		"destinations": {"prod": {"splunk-url": "https://prod.example.com:8088"}},
+		"label_tokens": {"team=payments": "payments-token"}
+	}`)
+
+	hostDefaults = newDefaultsFile(path)
+	defer func() { hostDefaults = nil }()
+	if !hostDefaults.reload() {
+		t.Fatal("Expected the defaults file to be loaded")
+	}
+	os.Setenv(defaultOptionEnv(splunkSourceTypeKey), "env_sourcetype")
+	defer os.Unsetenv(defaultOptionEnv(splunkSourceTypeKey))
+	os.Setenv(defaultOptionEnv(splunkFormatKey), splunkFormatRaw)
+	defer os.Unsetenv(defaultOptionEnv(splunkFormatKey))
+
+	config, sources, err := applyOptionDefaults(map[string]string{
+		splunkIndexKey: "opt_index",
+	}, map[string]string{
+		optionLabel(splunkSourceKey): "label_source",
+		destinationLabel:             "prod",
+		"team":                       "payments",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := resolveOptions(config, sources)
+	tests := []struct {
+		name   string
+		value  string
+		source string
+	}{
+		{splunkIndexKey, "opt_index", optionSourceLogOpt},
+		{splunkSourceKey, "label_source", optionSourceLabel},
+		{splunkSourceTypeKey, "file_sourcetype", optionSourceFile},
+		{splunkURLKey, "https://prod.example.com:8088", optionSourceFile},
+		{splunkTokenKey, "****oken", optionSourceFile},
+		{splunkFormatKey, splunkFormatRaw, optionSourceEnv},
+	}
+	for _, test := range tests {
+		if option := options[test.name]; option.Value != test.value || option.Source != test.source {
+			t.Fatalf("Expected %s=%s from %s, got %+v", test.name, test.value, test.source, option)
+		}
+	}
+
+	// a broken file keeps the previous defaults and is reported once
+	debugLog := newLogRingBuffer(10)
+	hooks := logrus.StandardLogger().Hooks
+	logrus.StandardLogger().Hooks = make(logrus.LevelHooks)
+	logrus.StandardLogger().Hooks.Add(debugLog)
+	defer func() { logrus.StandardLogger().Hooks = hooks }()
+	writeDefaultsFile(t, path, `{"options": {"splunk-index": "broken"`)
+	for i := 0; i < 3; i++ {
+		if hostDefaults.reload() {
+			t.Fatal("Expected the broken file not to be loaded")
+		}
+	}
+	if index := hostDefaults.containerOptions(nil)[splunkIndexKey]; index != "file_index" {
+		t.Fatalf("Expected the previous defaults to be kept, got %s", index)
+	}
+	reported := 0
+	for _, line := range debugLog.recent() {
+		if strings.Contains(line, "Cannot load the defaults file") {
+			reported++
+		}
+	}
+	if reported != 1 {
+		t.Fatalf("Expected the error to be logged once, got %d times", reported)
+	}
+
+	writeDefaultsFile(t, path, `{"options": {"splunk-indx": "typo"}}`)
+	if hostDefaults.reload() {
+		t.Fatal("Expected a file with an unknown option not to be loaded")
+	}
+}
+
+func TestReloadDefaultsRunningLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "defaults.json")
+	writeDefaultsFile(t, path, `{
+		"options": {"splunk-index": "first"},
+		"routing_rules": [{"match": {"regex": "ERROR"}, "index": "errors"}]
+	}`)
+
+	d := newDriver()
+	hostDefaults = newDefaultsFile(path)
+	hostDefaults.onReload = d.reloadDefaults
+	defer func() { hostDefaults = nil }()
+	hostDefaults.reload()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	config, sources, err := applyOptionDefaults(map[string]string{
+		splunkURLKey:   hec.URL(),
+		splunkTokenKey: hec.token,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := logger.Info{Config: config, ContainerID: "containeriid"}
+	l, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.logs["file"] = &logPair{splunkl: l, info: info, options: resolveOptions(config, sources)}
+	if rules := l.(*splunkLoggerInline).rules(); len(rules) != 1 || rules[0].Index != "errors" {
+		t.Fatalf("Expected the routing rule of the defaults file, got %v", rules)
+	}
+
+	writeDefaultsFile(t, path, `{
+		"options": {"splunk-index": "second"},
+		"routing_rules": [{"match": {"regex": "WARN"}, "index": "warnings"}]
+	}`)
+	if !hostDefaults.reload() {
+		t.Fatal("Expected the defaults file to be reloaded")
+	}
+	// routing rules are reload-safe, the index only applies to new containers
+	if rules := l.(*splunkLoggerInline).rules(); len(rules) != 1 || rules[0].Index != "warnings" {
+		t.Fatalf("Expected the new routing rule, got %v", rules)
+	}
+	options := d.logs["file"].options
+	if options[splunkIndexKey].Value != "first" {
+		t.Fatalf("Expected the running logger to keep its index, got %+v", options[splunkIndexKey])
+	}
+	if !strings.Contains(options[splunkRoutingRulesKey].Value, "warnings") {
+		t.Fatalf("Expected the new routing rules in the options, got %+v", options[splunkRoutingRulesKey])
+	}
+	if config, _, _ := applyOptionDefaults(nil, nil); config[splunkIndexKey] != "second" {
+		t.Fatalf("Expected new containers to get the new index, got %s", config[splunkIndexKey])
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadDefaultsBandwidthLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "defaults.json")
+	writeDefaultsFile(t, path, `{"options": {"splunk-bandwidth-limit": "1mb"}}`)
+
+	d := newDriver()
+	hostDefaults = newDefaultsFile(path)
+	hostDefaults.onReload = d.reloadDefaults
+	defer func() { hostDefaults = nil }()
+	hostDefaults.reload()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	config, sources, err := applyOptionDefaults(map[string]string{
+		splunkURLKey:   hec.URL(),
+		splunkTokenKey: hec.token,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := logger.Info{Config: config, ContainerID: "containeriid"}
+	l, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.logs["file"] = &logPair{splunkl: l, info: info, options: resolveOptions(config, sources)}
+	limiter := func() *bandwidthLimiter {
+		b, _ := l.(*splunkLoggerInline).bandwidth.Load().(*bandwidthLimiter)
+		return b
+	}
+	if b := limiter(); b == nil || b.rate != 1024*1024 || b.block {
+		t.Fatalf("Expected the bandwidth limit of the defaults file, got %+v", b)
+	}
+
+	writeDefaultsFile(t, path, `{"options": {"splunk-bandwidth-limit": "2kb", "splunk-bandwidth-policy": "invalid"}}`)
+	if !hostDefaults.reload() {
+		t.Fatal("Expected the defaults file to be reloaded")
+	}
+	// the invalid policy is not applied, the policy came from the defaults
+	if b := limiter(); b == nil || b.rate != 2048 || b.block {
+		t.Fatalf("Expected the new bandwidth limit, got %+v", b)
+	}
+	if options := d.logs["file"].options; options[splunkBandwidthLimitKey].Value != "2kb" {
+		t.Fatalf("Expected the new bandwidth limit in the options, got %+v", options[splunkBandwidthLimitKey])
+	}
+
+	writeDefaultsFile(t, path, `{"options": {}}`)
+	if !hostDefaults.reload() {
+		t.Fatal("Expected the defaults file to be reloaded")
+	}
+	if b := limiter(); b != nil {
+		t.Fatalf("Expected no bandwidth limit once removed from the defaults file, got %+v", b)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// withDeliveryLatency() returns copies of the messages with the milliseconds
+// between their timestamp and now in the field, and the latencies to observe
+// once the messages are sent. The messages are stamped again when they are
+// retried. A timestamp after now, from a skewed container clock, is a
+// latency of 0.
+func withDeliveryLatency(messages []*splunkMessage, field string, now time.Time) ([]*splunkMessage, []time.Duration) {
+	stamped := make([]*splunkMessage, len(messages))
+	latencies := make([]time.Duration, 0, len(messages))
+	for i, message := range messages {
+		if message.timeNano == 0 {
+			// not read from the container, such as heartbeats
+			stamped[i] = message
+			continue
+		}
+		latency := now.Sub(time.Unix(0, message.timeNano))
+		latencies = append(latencies, latency)
+		if latency < 0 {
+			latency = 0
+		}
+		copied := *message
+		copied.encoded = nil
+		setField(&copied, field, strconv.FormatInt(latency.Nanoseconds()/int64(time.Millisecond), 10))
+		stamped[i] = &copied
+	}
+	return stamped, latencies
+}
+
+// observeDeliveryLatency() adds the latencies of sent messages to the
+// histogram, the negative ones are counted and observed as 0
+func observeDeliveryLatency(latencies []time.Duration) {
+	for _, latency := range latencies {
+		if latency < 0 {
+			atomic.AddUint64(&metrics.deliveryLatencyClamped, 1)
+			latency = 0
+		}
+		metrics.deliveryLatency.observe(latency.Seconds())
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// deliveryAlertEvent is sent when the delivery of a container becomes
+// degraded and when it recovers
+type deliveryAlertEvent struct {
+	EventType           string  `json:"event_type"`
+	ContainerID         string  `json:"container_id"`
+	ContainerName       string  `json:"container_name"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	FailurePercent      float64 `json:"failure_percent"`
+	LastError           string  `json:"last_error,omitempty"`
+}
+
+type deliverySample struct {
+	at     time.Time
+	failed bool
+}
+
+// deliveryMonitor tracks the posts of a container. Delivery is degraded
+// after maxConsecutive failed posts in a row, or when maxFailurePercent of
+// at least maxConsecutive posts failed over the window. Alerts are at least
+// minInterval apart, and a recovery is only reported for an alert sent.
+type deliveryMonitor struct {
+	containerID       string
+	containerName     string
+	maxConsecutive    int
+	maxFailurePercent float64
+	window            time.Duration
+	minInterval       time.Duration
+
+	mu          sync.Mutex
+	consecutive int
+	samples     []deliverySample
+	degraded    bool
+	alerted     bool
+	lastAlert   time.Time
+}
+
+// newDeliveryMonitor() returns nil when alerts are disabled
+func newDeliveryMonitor(info logger.Info) *deliveryMonitor {
+	maxConsecutive := getAdvancedOptionInt(envVarAlertConsecutiveFailures, defaultAlertConsecutiveFailures)
+	if maxConsecutive <= 0 {
+		return nil
+	}
+	return &deliveryMonitor{
+		containerID:       info.ContainerID,
+		containerName:     info.Name(),
+		maxConsecutive:    maxConsecutive,
+		maxFailurePercent: float64(getAdvancedOptionInt(envVarAlertFailurePercent, defaultAlertFailurePercent)),
+		window:            getAdvancedOptionDuration(envVarAlertWindow, defaultAlertWindow),
+		minInterval:       getAdvancedOptionDuration(envVarAlertMinInterval, defaultAlertMinInterval),
+	}
+}
+
+// record() adds the outcome of a post and returns the alert or recovery
+// event to send, if any, and whether delivery is degraded
+func (m *deliveryMonitor) record(failed bool, now time.Time) (*deliveryAlertEvent, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, deliverySample{now, failed})
+	for len(m.samples) > 0 && m.samples[0].at.Before(now.Add(-m.window)) {
+		m.samples = m.samples[1:]
+	}
+	failures := 0
+	for _, s := range m.samples {
+		if s.failed {
+			failures++
+		}
+	}
+	percent := 100 * float64(failures) / float64(len(m.samples))
+	if failed {
+		m.consecutive++
+	} else {
+		m.consecutive = 0
+	}
+
+	var eventType string
+	if !m.degraded && failed {
+		if m.consecutive >= m.maxConsecutive ||
+			(len(m.samples) >= m.maxConsecutive && percent >= m.maxFailurePercent) {
+			m.degraded = true
+			m.alerted = m.lastAlert.IsZero() || now.Sub(m.lastAlert) >= m.minInterval
+			if m.alerted {
+				m.lastAlert = now
+				eventType = "delivery_degraded"
+			}
+		}
+	} else if m.degraded && !failed {
+		m.degraded = false
+		if m.alerted {
+			m.alerted = false
+			eventType = "delivery_recovered"
+		}
+	}
+	if eventType == "" {
+		return nil, m.degraded
+	}
+	return &deliveryAlertEvent{
+		EventType:           eventType,
+		ContainerID:         m.containerID,
+		ContainerName:       m.containerName,
+		ConsecutiveFailures: m.consecutive,
+		FailurePercent:      percent,
+	}, m.degraded
+}
+
+// recordSend() updates the delivery state of the container after a post
+func (hec *hecClient) recordSend(err error) {
+	if hec.monitor == nil {
+		return
+	}
+	event, degraded := hec.monitor.record(err != nil, time.Now())
+	hec.metrics.setDegraded(degraded)
+	if event == nil {
+		return
+	}
+	if err != nil {
+		event.LastError = err.Error()
+	}
+	if hec.alert != nil {
+		hec.alert(event)
+	}
+}
+
+// sendDeliveryAlert() always logs the event, and makes a single attempt to
+// send it to Splunk
+func (l *splunkLogger) sendDeliveryAlert(event *deliveryAlertEvent) {
+	entry := senderLog.WithField("id", event.ContainerID).WithField("event", event.EventType).
+		WithField("consecutiveFailures", event.ConsecutiveFailures).WithField("failurePercent", event.FailurePercent)
+	if event.EventType == "delivery_degraded" {
+		entry.WithField("lastError", event.LastError).Warn("Delivery of the container logs is degraded")
+	} else {
+		entry.Info("Delivery of the container logs recovered")
+	}
+
+	message := l.createSplunkMessage(&logger.Message{Timestamp: time.Now()})
+	message.Event = event
+	tagPluginEvent(message, event.EventType)
+	if err := l.hec.tryPostMessages([]*splunkMessage{message}); err != nil {
+		entry.WithError(err).Debug("Failed to send the delivery alert")
+	}
+}
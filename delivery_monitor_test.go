@@ -0,0 +1,120 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestDeliveryMonitor(t *testing.T) {
+	m := &deliveryMonitor{
+		containerID:       "containeriid",
+		maxConsecutive:    3,
+		maxFailurePercent: 50,
+		window:            time.Minute,
+		minInterval:       10 * time.Minute,
+	}
+	now := time.Now()
+	record := func(failed bool) (*deliveryAlertEvent, bool) {
+		now = now.Add(time.Second)
+		return m.record(failed, now)
+	}
+
+	for i := 0; i < 2; i++ {
+		if event, degraded := record(true); event != nil || degraded {
+			t.Fatalf("Expected no alert before 3 failures in a row, got %v", event)
+		}
+	}
+	event, degraded := record(true)
+	if event == nil || event.EventType != "delivery_degraded" || !degraded ||
+		event.ContainerID != "containeriid" || event.ConsecutiveFailures != 3 || event.FailurePercent != 100 {
+		t.Fatalf("Expected a single alert after 3 failures in a row, got %+v", event)
+	}
+	if event, _ := record(true); event != nil {
+		t.Fatalf("Expected a single alert while degraded, got %+v", event)
+	}
+
+	event, degraded = record(false)
+	if event == nil || event.EventType != "delivery_recovered" || degraded {
+		t.Fatalf("Expected a recovery event, got %+v", event)
+	}
+
+	// 4 failures out of 6 posts, the previous alert is too recent
+	event, degraded = record(true)
+	if event != nil || !degraded {
+		t.Fatalf("Expected no alert within the minimum interval, got %+v", event)
+	}
+	if event, degraded := record(false); event != nil || degraded {
+		t.Fatalf("Expected no recovery event without an alert, got %+v", event)
+	}
+
+	// the failure percentage alone degrades the delivery once the alerts
+	// are far enough apart
+	now = now.Add(10 * time.Minute)
+	for _, failed := range []bool{false, true, false} {
+		record(failed)
+	}
+	event, degraded = record(true)
+	if event == nil || event.EventType != "delivery_degraded" || !degraded || event.ConsecutiveFailures != 1 {
+		t.Fatalf("Expected an alert on the failure percentage, got %+v", event)
+	}
+}
+
+func TestDeliveryAlertsDisabled(t *testing.T) {
+	if newDeliveryMonitor(logger.Info{}) != nil {
+		t.Fatal("Expected delivery alerts to be disabled by default")
+	}
+	os.Setenv(envVarAlertConsecutiveFailures, "3")
+	defer os.Setenv(envVarAlertConsecutiveFailures, "")
+	if m := newDeliveryMonitor(logger.Info{}); m == nil || m.maxConsecutive != 3 || m.minInterval != defaultAlertMinInterval {
+		t.Fatalf("Unexpected delivery monitor %+v", m)
+	}
+}
+
+func TestRecordSend(t *testing.T) {
+	m := newPluginMetrics()
+	var alerts []*deliveryAlertEvent
+	hec := &hecClient{
+		metrics: m.register("containeriid", func() int { return 0 }),
+		monitor: &deliveryMonitor{maxConsecutive: 1, maxFailurePercent: 100, window: time.Minute},
+		alert: func(event *deliveryAlertEvent) {
+			alerts = append(alerts, event)
+		},
+	}
+	p := newHealthProber(m, 1)
+
+	hec.recordSend(errors.New("connection refused"))
+	if len(alerts) != 1 || alerts[0].LastError != "connection refused" || !hec.metrics.isDegraded() {
+		t.Fatalf("Expected a degraded alert, got %v", alerts)
+	}
+	if r := p.report(); r.Healthy || r.Failures[0].Condition != "container_degraded" {
+		t.Fatalf("Expected the degraded container in the health report, got %+v", r)
+	}
+
+	hec.recordSend(nil)
+	if len(alerts) != 2 || alerts[1].EventType != "delivery_recovered" || hec.metrics.isDegraded() {
+		t.Fatalf("Expected a recovery event, got %v", alerts)
+	}
+	if r := p.report(); !r.Healthy {
+		t.Fatalf("Expected a healthy report after the recovery, got %+v", r)
+	}
+}
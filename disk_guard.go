@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// How often free space is checked while logging locally
+const diskGuardCheckInterval = 10 * time.Second
+
+// diskGuardedLogger wraps the local json logger and stops writing to it while
+// the filesystem holding the log file has less than minFree bytes available.
+// Forwarding to Splunk is not affected.
+type diskGuardedLogger struct {
+	logger.Logger
+
+	path      string
+	minFree   uint64
+	freeSpace func(path string) (uint64, error)
+
+	mu        sync.Mutex
+	nextCheck time.Time
+	low       bool
+}
+
+func newDiskGuardedLogger(l logger.Logger, path string, minFree uint64) *diskGuardedLogger {
+	return &diskGuardedLogger{
+		Logger:    l,
+		path:      path,
+		minFree:   minFree,
+		freeSpace: statfsFreeSpace,
+	}
+}
+
+func (g *diskGuardedLogger) Log(msg *logger.Message) error {
+	if g.isLow(time.Now()) {
+		return nil
+	}
+	return g.Logger.Log(msg)
+}
+
+// isLow() re-checks the free space once per check interval
+func (g *diskGuardedLogger) isLow(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Before(g.nextCheck) {
+		return g.low
+	}
+	g.nextCheck = now.Add(diskGuardCheckInterval)
+
+	free, err := g.freeSpace(g.path)
+	if err != nil {
+		processorLog.WithField("path", g.path).WithError(err).Warn("Cannot check free disk space")
+		return g.low
+	}
+	low := free < g.minFree
+	if low && !g.low {
+		processorLog.WithField("path", g.path).WithField("free", free).WithField("minFree", g.minFree).Warn("Disk space is low, pausing local logging")
+	} else if !low && g.low {
+		processorLog.WithField("path", g.path).WithField("free", free).Info("Disk space recovered, resuming local logging")
+	}
+	g.low = low
+	return low
+}
+
+func (g *diskGuardedLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	return g.Logger.(logger.LogReader).ReadLogs(config)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+type countingLogger struct {
+	logged int
+}
+
+func (l *countingLogger) Log(msg *logger.Message) error {
+	l.logged++
+	return nil
+}
+
+func (l *countingLogger) Name() string {
+	return "counting"
+}
+
+func (l *countingLogger) Close() error {
+	return nil
+}
+
+func TestDiskGuardedLogger(t *testing.T) {
+	local := &countingLogger{}
+	guard := newDiskGuardedLogger(local, "/var/log/docker", 100)
+
+	free := uint64(1000)
+	guard.freeSpace = func(path string) (uint64, error) {
+		return free, nil
+	}
+
+	log := func() {
+		// force a free space check on every message
+		guard.nextCheck = time.Time{}
+		if err := guard.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log()
+	if local.logged != 1 {
+		t.Fatal("Expected message to be written locally")
+	}
+
+	free = 99
+	log()
+	log()
+	if local.logged != 1 {
+		t.Fatal("Expected local writes to pause below the threshold")
+	}
+
+	free = 100
+	log()
+	if local.logged != 2 {
+		t.Fatal("Expected local writes to resume when space recovers")
+	}
+
+	// between checks the previous decision is kept
+	free = 0
+	guard.nextCheck = time.Now().Add(time.Hour)
+	if err := guard.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if local.logged != 3 {
+		t.Fatal("Expected free space not to be checked before the interval")
+	}
+}
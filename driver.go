@@ -19,24 +19,33 @@
 package main
 
 import (
-	"context"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/api/types/plugins/logdriver"
 	"github.com/docker/docker/daemon/logger"
 	"github.com/docker/docker/daemon/logger/jsonfilelog"
 	protoio "github.com/gogo/protobuf/io"
 	"github.com/pkg/errors"
-	"github.com/tonistiigi/fifo"
 )
 
+// How long docker logs waits for the queued lines to be written locally
+const readLogsFlushTimeout = 2 * time.Second
+
+// How long StopLogging waits for the message processor to send the last
+// lines and the exit event of the container before closing the loggers
+const processorStopTimeout = 10 * time.Second
+
 type driver struct {
 	mu     sync.Mutex
 	logs   map[string]*logPair // map for file and logger
@@ -45,16 +54,42 @@ type driver struct {
 }
 
 type logPair struct {
-	jsonl   logger.Logger
-	splunkl logger.Logger
+	// every event read from the stream is fanned out to all the sinks
+	sinks   []logger.Logger
+	jsonl   logger.Logger // serves docker logs
+	splunkl logger.Logger // nil when the container only logs locally
 	stream  io.ReadCloser
 	info    logger.Info
+	// effective options, for the audit log and the admin endpoint
+	options map[string]resolvedOption
+	// why the container is not forwarded, empty when it is
+	localOnly string
+	// numbers the events sent to Splunk, nil unless splunk-sequence
+	sequence *streamSequence
+
+	// closed once the message processor is done with the loggers, nil when
+	// no processor runs
+	processing chan struct{}
+	// Close is called by both the message processor and the driver
+	closeOnce sync.Once
 }
 
 func (lf *logPair) Close() {
-	lf.stream.Close()
-	lf.splunkl.Close()
-	lf.jsonl.Close()
+	lf.closeOnce.Do(func() {
+		lf.stream.Close()
+		// the processor ends with the closed stream, and sends the exit
+		// event of the container before the loggers are closed
+		if lf.processing != nil {
+			select {
+			case <-lf.processing:
+			case <-time.After(processorStopTimeout):
+				driverLog.WithField("id", lf.info.ContainerID).Warn("Message processor did not stop in time, closing the loggers")
+			}
+		}
+		for _, l := range lf.sinks {
+			l.Close()
+		}
+	})
 }
 
 func newDriver() *driver {
@@ -66,25 +101,96 @@ func newDriver() *driver {
 
 func (d *driver) StartLogging(file string, logCtx logger.Info) error {
 	d.mu.Lock()
-	// check if the specific file is already attached to a logger
-	if _, exists := d.logs[file]; exists {
-		d.mu.Unlock()
-		return fmt.Errorf("logger for %q already exists", file)
+	// a file still attached to a logger means its previous fifo was never
+	// stopped, close it so the stream and its loggers don't leak
+	stale, exists := d.logs[file]
+	if exists {
+		delete(d.logs, file)
+		if d.idx[stale.info.ContainerID] == stale {
+			delete(d.idx, stale.info.ContainerID)
+		}
 	}
 	d.mu.Unlock()
+	if exists {
+		driverLog.WithField("id", stale.info.ContainerID).WithField("file", file).Warn("Closing stale logger for reused file")
+		// waits for the processor, without holding the other containers
+		stale.Close()
+	}
+
+	// the log-opts win over the label overrides and the plugin defaults
+	config, sources, err := applyOptionDefaults(logCtx.Config, logCtx.ContainerLabels)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	logCtx.Config = config
 
 	// if there isn't a logger for the file, create a logger hanlder
 	if logCtx.LogPath == "" {
-		logCtx.LogPath = filepath.Join("/var/log/docker", logCtx.ContainerID)
+		logCtx.LogPath = filepath.Join(selfLogDir, logCtx.ContainerID)
 	}
-	if err := os.MkdirAll(filepath.Dir(logCtx.LogPath), 0755); err != nil {
-		return errors.Wrap(err, "error setting up logger dir")
+	compress, keep, err := parseLocalCompress(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
 	}
-
-	//create a json logger for the file
-	jsonl, err := jsonfilelog.New(logCtx)
+	maxAge, err := parseLocalMaxAge(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	cacheRequired, err := parseCacheRequired(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	// the pipeline of the container, once it is created
+	var lf *logPair
+	newLocalLogger := func() (logger.Logger, error) {
+		if err := os.MkdirAll(filepath.Dir(logCtx.LogPath), 0755); err != nil {
+			return nil, errors.Wrap(err, "error setting up logger dir")
+		}
+		//create a json logger for the file
+		jsonl, err := jsonfilelog.New(logCtx)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating jsonfile logger")
+		}
+		retention := &localRetention{}
+		var budget *cacheBudgetLogger
+		if cacheBudget != nil {
+			budget = newCacheBudgetLogger(jsonl, logCtx.LogPath, retention, cacheBudget)
+			jsonl = budget
+		}
+		if compress {
+			g := newGzipRotatedLogger(jsonl, logCtx.LogPath, keep, retention)
+			if budget != nil {
+				budget.locks = append(budget.locks, &g.mu)
+			}
+			jsonl = g
+		}
+		jsonl = newLocalRetentionLogger(jsonl, logCtx.LogPath, maxAge, retention)
+		if minFree := getAdvancedOptionInt(envVarLocalMinFreeMB, defaultLocalMinFreeMB); minFree > 0 {
+			jsonl = newDiskGuardedLogger(jsonl, filepath.Dir(logCtx.LogPath), uint64(minFree)*1024*1024)
+		}
+		// a full or read-only filesystem only stops the local log
+		fault := newCacheFaultLogger(jsonl, logCtx.ContainerID, filepath.Dir(logCtx.LogPath), getAdvancedOptionDuration(envVarCacheRetryInterval, defaultCacheRetryInterval))
+		fault.onChange = func(action string, reason string) {
+			lf.logLifecycle(action, reason)
+		}
+		return fault, nil
+	}
+	localJSON, err := parseLocalJSON(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	localOnly, err := localOnlyReason(logCtx)
 	if err != nil {
-		return errors.Wrap(err, "error creating jsonfile logger")
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	// a container which is not forwarded keeps its local log
+	var jsonl logger.Logger
+	var cacheErr error
+	if localJSON || localOnly != "" {
+		jsonl, cacheErr = newLocalLogger()
+		if cacheErr != nil && cacheRequired {
+			return cacheErr
+		}
 	}
 
 	err = ValidateLogOpt(logCtx.Config)
@@ -92,47 +198,314 @@ func (d *driver) StartLogging(file string, logCtx logger.Info) error {
 		return errors.Wrapf(err, "error options logger splunk: %q", file)
 	}
 
-	//create a splunk logger for the file
-	splunkl, err := New(logCtx)
+	partialTimeout, err := parsePartialTimeout(logCtx.Config)
 	if err != nil {
-		return errors.Wrap(err, "error creating splunk logger")
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	inputGzip, err := parseInputGzip(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	maxTimeSkew, err := parseMaxTimeSkew(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	normalizeNewlines, err := parseNormalizeNewlines(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	stripANSI, err := parseStripANSI(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	journaldCopy, err := parseJournaldCopy(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	sequence, err := parseSequence(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	syslogConfig, err := parseSyslogConfig(logCtx)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
 	}
 
-	logrus.WithField("id", logCtx.ContainerID).WithField("file", file).WithField("logpath", logCtx.LogPath).Debugf("Start logging")
+	forwardingRequired, err := parseForwardingRequired(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+	backfillMax, err := parseDegradedBackfillMax(logCtx.Config)
+	if err != nil {
+		return errors.Wrapf(err, "error options logger splunk: %q", file)
+	}
+
+	// without its local log, a container is only forwarded
+	var cache *cacheLogger
+	if cacheErr != nil {
+		if localOnly != "" {
+			return cacheErr
+		}
+		driverLog.WithField("id", logCtx.ContainerID).WithError(cacheErr).Warn("Cannot create the local log, forwarding to Splunk only and docker logs is not supported")
+		cache = newCacheLogger(logCtx.ContainerID, newLocalLogger, cacheErr)
+		jsonl = cache
+	}
+	// without local json log, docker logs searches the events in Splunk
+	var searchl *splunkSearchReader
+	if jsonl == nil {
+		if searchl, err = newSplunkSearchReader(logCtx); err != nil {
+			return errors.Wrapf(err, "error options logger splunk: %q", file)
+		}
+	}
+
+	//create a splunk logger for the file
+	var splunkl logger.Logger
+	var splunkDegraded *degradedSplunkLogger
+	var degradedSince time.Time
+	switch localOnly {
+	case "":
+		newSplunkLogger := func() (logger.Logger, error) {
+			l, err := New(logCtx)
+			if err != nil {
+				return nil, err
+			}
+			if sl, ok := l.(sequencedLogger); ok && sequence != nil {
+				sl.setSequence(sequence)
+			}
+			return l, nil
+		}
+		degradedSince = time.Now()
+		splunkl, err = newSplunkLogger()
+		// without its splunk logger, a container is only logged locally
+		if _, unavailable := err.(*splunkUnavailableError); unavailable && !forwardingRequired && cache == nil && jsonl != nil {
+			driverLog.WithField("id", logCtx.ContainerID).WithError(err).Warn("Cannot create the splunk logger, logging locally only until a retry succeeds")
+			atomic.AddUint64(&metrics.forwardingDegraded, 1)
+			splunkDegraded = newDegradedSplunkLogger(logCtx.ContainerID, newSplunkLogger, err)
+			splunkl, err = splunkDegraded, nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "error creating splunk logger")
+		}
+	case localOnlyImageAllowlist:
+		driverLog.WithField("id", logCtx.ContainerID).WithField("image", logCtx.ContainerImageName).Info("Image is not in allowlist, logging locally only")
+	default:
+		driverLog.WithField("id", logCtx.ContainerID).WithField("reason", localOnly).Info("Forwarding is disabled for the container, logging locally only")
+		logOptOut(logCtx, localOnly)
+	}
+
+	driverLog.WithField("id", logCtx.ContainerID).WithField("file", file).WithField("logpath", logCtx.LogPath).Debug("Start logging")
 	// open the log file in the background with read only access
-	f, err := fifo.OpenFifo(context.Background(), file, syscall.O_RDONLY, 0700)
+	f, err := openStream(file)
 	if err != nil {
 		return errors.Wrapf(err, "error opening logger fifo: %q", file)
 	}
 
+	// the journal copy never fails the container
+	var journal logger.Logger
+	if journaldCopy {
+		if writer, err := dialJournal(getAdvancedOptionString(envVarJournaldSocket, defaultJournaldSocket)); err != nil {
+			driverLog.WithField("id", logCtx.ContainerID).WithError(err).Warn("Cannot open the journal, messages are not copied to it")
+		} else {
+			journal = newJournaldLogger(writer, logCtx, getAdvancedOptionInt(envVarSinkQueueSize, defaultSinkQueueSize))
+		}
+	}
+	// the syslog server is only connected to when a message is sent
+	var syslogl logger.Logger
+	if syslogConfig != nil {
+		syslogl = newSyslogLogger(syslogConfig, logCtx)
+	}
+
+	options := resolveOptions(logCtx.Config, sources)
 	d.mu.Lock()
-	lf := &logPair{jsonl, splunkl, f, logCtx}
+	// the splunk logger queues on its own, the local logger gets a queue so
+	// slow disk writes don't hold back forwarding
+	var sinks []logger.Logger
+	var pausable *pausableLogger
+	if splunkl != nil {
+		pausable = &pausableLogger{Logger: splunkl}
+		sinks = append(sinks, pausable)
+	}
+	if jsonl != nil {
+		sinks = append(sinks, newQueuedLogger(jsonl, getAdvancedOptionInt(envVarSinkQueueSize, defaultSinkQueueSize)))
+	} else if searchl != nil {
+		jsonl = searchl
+	}
+	if journal != nil {
+		sinks = append(sinks, journal)
+	}
+	if syslogl != nil {
+		sinks = append(sinks, syslogl)
+	}
+	lf = &logPair{processing: make(chan struct{}), sinks: sinks, jsonl: jsonl, splunkl: splunkl, stream: f, info: logCtx, options: options, localOnly: localOnly, sequence: sequence}
+	if pausable != nil {
+		pausable.lf = lf
+	}
 	// add the json logger, splunk logger, log file, and logCtx to the logging driver
 	d.logs[file] = lf
 	d.idx[logCtx.ContainerID] = lf
 	d.mu.Unlock()
 
 	// start to process the logs generated by docker
-	logrus.Debug("Start processing messages")
+	driverLog.Debug("Start processing messages")
 	mg := &messageProcessor{
-		retryNumber: getAdvancedOptionInt(envVarReadFifoErrorRetryNumber, defaultReadFifoErrorRetryNumber),
+		retryNumber:       getAdvancedOptionInt(envVarReadFifoErrorRetryNumber, defaultReadFifoErrorRetryNumber),
+		panicRetryNumber:  getAdvancedOptionInt(envVarProcessPanicRetryNumber, defaultProcessPanicRetryNumber),
+		partialTimeout:    partialTimeout,
+		inputGzip:         inputGzip,
+		maxTimeSkew:       maxTimeSkew,
+		normalizeNewlines: normalizeNewlines,
+		stripANSI:         stripANSI,
+	}
+	lf.logLifecycle(lifecycleStart, lifecycleReasonStartLogging)
+	if cache != nil {
+		lf.logLifecycle(lifecycleDegraded, lifecycleReasonCacheUnavailable)
+		cache.onCreated = func() {
+			lf.logLifecycle(lifecycleRecovered, lifecycleReasonCacheCreated)
+		}
+		cache.start(getAdvancedOptionDuration(envVarCacheRetryInterval, defaultCacheRetryInterval))
+	}
+	if splunkDegraded != nil {
+		splunkDegraded.backfill = func(l logger.Logger, until time.Time) int {
+			return lf.backfill(l, degradedSince, until, backfillMax)
+		}
+		splunkDegraded.onCreated = func() {
+			lf.logLifecycle(lifecycleRecovered, lifecycleReasonSplunkCreated)
+		}
+		splunkDegraded.start(getAdvancedOptionDuration(envVarForwardingRetryInterval, defaultForwardingRetryInterval))
 	}
 	go mg.process(lf)
+	driverLog.WithField("id", logCtx.ContainerID).WithField("name", logCtx.Name()).WithField("forwarding", splunkl != nil).WithField("local_only", localOnly).WithField("options", options).Info("Resolved logging options")
 	return nil
 }
 
+// Label opting a container out of forwarding with the value off
+const forwardingLabel = "splunk.forwarding"
+
+// Reasons for logging a container locally only
+const (
+	localOnlyImageAllowlist = "image_allowlist"
+	localOnlyDisabledOption = "splunk_disabled"
+	localOnlyForwardingOff  = "forwarding_label"
+)
+
+// localOnlyReason() returns why the container must not be forwarded, or an
+// empty string
+func localOnlyReason(logCtx logger.Info) (string, error) {
+	if logCtx.ContainerLabels[forwardingLabel] == "off" {
+		return localOnlyForwardingOff, nil
+	}
+	if disabledStr, ok := logCtx.Config[splunkDisabledKey]; ok {
+		disabled, err := strconv.ParseBool(disabledStr)
+		if err != nil {
+			return "", err
+		}
+		if disabled {
+			return localOnlyDisabledOption, nil
+		}
+	}
+	forward, err := imageAllowed(logCtx.Config[splunkImageAllowlistKey], logCtx.ContainerImageName)
+	if err != nil || forward {
+		return "", err
+	}
+	return localOnlyImageAllowlist, nil
+}
+
+// imageAllowed() returns true if the image name matches one of the
+// comma separated globs in allowlist. An empty allowlist allows every image.
+func imageAllowed(allowlist string, image string) (bool, error) {
+	if allowlist == "" {
+		return true, nil
+	}
+	allowed := false
+	for _, pattern := range strings.Split(allowlist, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matched, err := path.Match(pattern, image)
+		if err != nil {
+			return false, fmt.Errorf("%s: invalid pattern %q in %s", driverName, pattern, splunkImageAllowlistKey)
+		}
+		allowed = allowed || matched
+	}
+	return allowed, nil
+}
+
 func (d *driver) StopLogging(file string) error {
-	logrus.WithField("file", file).Debug("Stop logging")
+	driverLog.WithField("file", file).Debug("Stop logging")
 	d.mu.Lock()
 	lf, ok := d.logs[file]
+	delete(d.logs, file)
+	d.mu.Unlock()
 	if ok {
+		// queued before the loggers are closed, which flushes it
+		lf.logLifecycle(lifecycleStop, lifecycleReasonStopLogging)
+		// waits for the processor, without holding the other containers
 		lf.Close()
-		delete(d.logs, file)
 	}
-	d.mu.Unlock()
 	return nil
 }
 
+// flushLocal() waits for the lines just read from the container which may
+// still be queued for the local json logger
+func (lf *logPair) flushLocal() {
+	for _, sink := range lf.sinks {
+		if q, ok := sink.(*queuedLogger); ok && !q.flush(readLogsFlushTimeout) {
+			driverLog.WithField("id", lf.info.ContainerID).Warn("Local log is behind, the most recent lines may be missing")
+		}
+	}
+}
+
+// localReader() returns the reader of the local log, ok is false when the
+// container has none
+func (lf *logPair) localReader() (lr logger.LogReader, ok bool) {
+	switch jsonl := lf.jsonl.(type) {
+	case *splunkSearchReader:
+		return nil, false
+	case *cacheLogger:
+		if jsonl.unavailable() != nil {
+			return nil, false
+		}
+	}
+	lr, ok = lf.jsonl.(logger.LogReader)
+	return lr, ok
+}
+
+// backfill() logs to l the lines of the local log since the time Splunk was
+// unavailable, until the last line logged without it unless until is zero,
+// at most backfillMax of them, and returns how many it logged
+func (lf *logPair) backfill(l logger.Logger, since time.Time, until time.Time, backfillMax int) int {
+	lr, ok := lf.localReader()
+	if !ok || backfillMax <= 0 {
+		return 0
+	}
+	lf.flushLocal()
+	watcher := lr.ReadLogs(logger.ReadConfig{Since: since, Tail: -1})
+	defer watcher.Close()
+	logged := 0
+	for logged < backfillMax {
+		select {
+		case msg, ok := <-watcher.Msg:
+			if !ok || (!until.IsZero() && msg.Timestamp.After(until)) {
+				// the next lines were sent by the splunk logger
+				return logged
+			}
+			// the local log ends the complete lines with a newline
+			msg.Line = bytes.TrimSuffix(msg.Line, []byte{'\n'})
+			if err := l.Log(msg); err != nil {
+				driverLog.WithField("id", lf.info.ContainerID).WithError(err).Warn("Cannot backfill the lines logged while Splunk was unavailable")
+				return logged
+			}
+			logged++
+		case err := <-watcher.Err:
+			driverLog.WithField("id", lf.info.ContainerID).WithError(err).Warn("Cannot read the local log, the lines logged while Splunk was unavailable are not backfilled")
+			return logged
+		}
+	}
+	driverLog.WithField("id", lf.info.ContainerID).WithField("max", backfillMax).Info("Backfilled the most lines allowed by " + splunkDegradedBackfillMaxKey)
+	return logged
+}
+
 func (d *driver) ReadLogs(info logger.Info, config logger.ReadConfig) (io.ReadCloser, error) {
 	d.mu.Lock()
 	lf, exists := d.idx[info.ContainerID]
@@ -141,12 +514,19 @@ func (d *driver) ReadLogs(info logger.Info, config logger.ReadConfig) (io.ReadCl
 		return nil, fmt.Errorf("logger does not exist for %s", info.ContainerID)
 	}
 
+	if cache, ok := lf.jsonl.(*cacheLogger); ok {
+		if err := cache.unavailable(); err != nil {
+			return nil, fmt.Errorf("logger does not support reading, the local log could not be created: %v", err)
+		}
+	}
 	r, w := io.Pipe()
 	lr, ok := lf.jsonl.(logger.LogReader)
 	if !ok {
 		return nil, fmt.Errorf("logger does not support reading")
 	}
 
+	lf.flushLocal()
+
 	go func() {
 		watcher := lr.ReadLogs(config)
 
@@ -0,0 +1,512 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/jsonfilelog"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+// startTestLogging creates a stream in dir and starts logging for it
+func startTestLogging(t *testing.T, d *driver, dir string, info logger.Info) string {
+	file := newTestStream(t, dir, info.ContainerID)
+	info.LogPath = filepath.Join(dir, info.ContainerID+".json")
+	if err := d.StartLogging(file, info); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestImageAllowlist(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		image   string
+		forward bool
+	}{
+		{"nginx:1.15", true},
+		{"registry.example.com/payments/api:2.0", true},
+		{"busybox:latest", false},
+		{"registry.example.com/debug/shell:1", false},
+	}
+
+	d := newDriver()
+	for i, test := range tests {
+		info := logger.Info{
+			Config: map[string]string{
+				splunkURLKey:            hec.URL(),
+				splunkTokenKey:          hec.token,
+				splunkImageAllowlistKey: "nginx*, registry.example.com/payments/*",
+			},
+			ContainerID:        fmt.Sprintf("containerid%d", i),
+			ContainerImageName: test.image,
+		}
+		file := startTestLogging(t, d, dir, info)
+
+		d.mu.Lock()
+		lf := d.logs[file]
+		d.mu.Unlock()
+		if (lf.splunkl != nil) != test.forward {
+			t.Fatalf("Unexpected forwarding decision for image %s, expected %v", test.image, test.forward)
+		}
+
+		if err := d.StopLogging(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := imageAllowed("[", "nginx"); err == nil {
+		t.Fatal("Expecting error on invalid pattern")
+	}
+}
+
+func countOpenFiles(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("Cannot list open files: ", err)
+	}
+	return len(fds)
+}
+
+func TestRestartLoggingClosesStaleStream(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "containeriid",
+	}
+
+	d := newDriver()
+	file := startTestLogging(t, d, dir, info)
+
+	// keep a writer open so the message processor blocks on the stream
+	writer, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	d.mu.Lock()
+	stale := d.logs[file]
+	d.mu.Unlock()
+	openFiles := countOpenFiles(t)
+
+	info.LogPath = filepath.Join(dir, info.ContainerID+".json")
+	if err := d.StartLogging(file, info); err != nil {
+		t.Fatal(err)
+	}
+	defer d.StopLogging(file)
+
+	if _, err := stale.stream.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected stale stream to be closed")
+	}
+
+	d.mu.Lock()
+	current := d.logs[file]
+	indexed := d.idx[info.ContainerID]
+	d.mu.Unlock()
+	if current == stale || indexed != current {
+		t.Fatal("Expected the new logger to replace the stale one")
+	}
+
+	if n := countOpenFiles(t); n != openFiles {
+		t.Fatalf("Expected %d open files after restarting logging, got %d", openFiles, n)
+	}
+}
+
+func TestForwardingOptOut(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		config map[string]string
+		labels map[string]string
+		reason string
+	}{
+		{map[string]string{splunkDisabledKey: "true"}, nil, localOnlyDisabledOption},
+		{nil, map[string]string{forwardingLabel: "off"}, localOnlyForwardingOff},
+		{map[string]string{splunkDisabledKey: "false"}, nil, ""},
+	}
+
+	d := newDriver()
+	for i, test := range tests {
+		info := logger.Info{
+			Config: map[string]string{
+				splunkURLKey:   hec.URL(),
+				splunkTokenKey: hec.token,
+			},
+			ContainerID:     fmt.Sprintf("containerid%d", i),
+			ContainerLabels: test.labels,
+		}
+		for key, value := range test.config {
+			info.Config[key] = value
+		}
+		file := startTestLogging(t, d, dir, info)
+
+		d.mu.Lock()
+		lf := d.logs[file]
+		d.mu.Unlock()
+		if (lf.splunkl == nil) != (test.reason != "") || lf.localOnly != test.reason {
+			t.Fatalf("Unexpected forwarding of %v %v: local only %q", test.config, test.labels, lf.localOnly)
+		}
+		if lf.jsonl == nil {
+			t.Fatal("Expected the local logs to be kept")
+		}
+		if states := d.containerStates(); states[0].LocalOnly != test.reason {
+			t.Fatalf("Expected the admin state to be local only for %q, got %+v", test.reason, states[0])
+		}
+
+		if err := d.StopLogging(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.Setenv(envVarLifecycleEvents, "true")
+	defer os.Unsetenv(envVarLifecycleEvents)
+	info := logger.Info{
+		Config:      map[string]string{splunkURLKey: hec.URL(), splunkTokenKey: hec.token},
+		ContainerID: "containeriid",
+	}
+	<-logOptOut(info, localOnlyForwardingOff)
+	if len(hec.messages) != 1 {
+		t.Fatalf("Expected the opt-out lifecycle event, got %d messages", len(hec.messages))
+	}
+	event, err := hec.messages[0].EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["action"] != lifecycleOptOut || event["reason"] != localOnlyForwardingOff {
+		t.Fatalf("Unexpected lifecycle event %v", event)
+	}
+}
+
+func TestCacheNotRequired(t *testing.T) {
+	os.Setenv(envVarLifecycleEvents, "true")
+	os.Setenv(envVarCacheRetryInterval, "10ms")
+	defer func() {
+		os.Setenv(envVarLifecycleEvents, "")
+		os.Setenv(envVarCacheRetryInterval, "")
+	}()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the directory of the local log cannot be created
+	blocked := filepath.Join(dir, "logs")
+	if err := ioutil.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "containeriid",
+		LogPath:     filepath.Join(blocked, "containeriid", "containeriid.json"),
+	}
+	d := newDriver()
+	if err := d.StartLogging(newTestStream(t, dir, "required"), info); err == nil {
+		t.Fatal("Expected the container to fail without its local log")
+	}
+
+	info.Config[splunkCacheRequiredKey] = "false"
+	file := newTestStream(t, dir, info.ContainerID)
+	if err := d.StartLogging(file, info); err != nil {
+		t.Fatal(err)
+	}
+	defer d.StopLogging(file)
+	// keep a writer open so the message processor blocks on the stream
+	writer, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	states := d.containerStates()
+	if len(states) != 1 || states[0].CacheUnavailable == "" || !states[0].Forwarding {
+		t.Fatalf("Expected the container to be forwarded without its local log, got %+v", states)
+	}
+	if _, err := d.ReadLogs(info, logger.ReadConfig{}); err == nil {
+		t.Fatal("Expected docker logs not to be supported without the local log")
+	}
+
+	// the local log is created once the directory can be
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); d.containerStates()[0].CacheUnavailable != ""; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the local log to be created by a retry, got %+v", d.containerStates())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r, err := d.ReadLogs(info, logger.ReadConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	d.StopLogging(file)
+
+	var actions []string
+	for _, message := range hec.messages {
+		event, err := message.EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, fmt.Sprint(event["action"], "/", event["reason"]))
+	}
+	expected := []string{
+		lifecycleStart + "/" + lifecycleReasonStartLogging,
+		lifecycleDegraded + "/" + lifecycleReasonCacheUnavailable,
+		lifecycleRecovered + "/" + lifecycleReasonCacheCreated,
+		lifecycleStop + "/" + lifecycleReasonStopLogging,
+	}
+	if fmt.Sprint(actions) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the lifecycle events %v, got %v", expected, actions)
+	}
+}
+
+func TestForwardingNotRequired(t *testing.T) {
+	os.Setenv(envVarLifecycleEvents, "true")
+	os.Setenv(envVarForwardingRetryInterval, "10ms")
+	defer func() {
+		os.Setenv(envVarLifecycleEvents, "")
+		os.Setenv(envVarForwardingRetryInterval, "")
+	}()
+
+	// Splunk is down until the mock serves on its address
+	hec := NewHTTPEventCollectorMock(t)
+	addr := hec.tcpListener.Addr().(*net.TCPAddr)
+	hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:              hec.URL(),
+			splunkTokenKey:            hec.token,
+			splunkVerifyConnectionKey: "true",
+		},
+		ContainerID: "containeriid",
+		LogPath:     filepath.Join(dir, "containeriid.json"),
+	}
+	d := newDriver()
+	if err := d.StartLogging(newTestStream(t, dir, "required"), info); err == nil {
+		t.Fatal("Expected the container to fail without its splunk logger")
+	}
+
+	info.Config[splunkForwardingRequiredKey] = "false"
+	info.Config[splunkDegradedBackfillMaxKey] = "2"
+	file := newTestStream(t, dir, info.ContainerID)
+	if err := d.StartLogging(file, info); err != nil {
+		t.Fatal(err)
+	}
+	defer d.StopLogging(file)
+	writer, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	states := d.containerStates()
+	if len(states) != 1 || states[0].ForwardingUnavailable == "" || states[0].Forwarding {
+		t.Fatalf("Expected the container to be logged locally only, got %+v", states)
+	}
+
+	enc := protoio.NewUint32DelimitedWriter(writer, binary.BigEndian)
+	for _, line := range []string{"first", "second", "third"} {
+		if err := enc.WriteMsg(&logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte(line)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		local, _ := ioutil.ReadFile(info.LogPath)
+		if bytes.Count(local, []byte("\n")) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the lines to be logged locally, got %q", local)
+		}
+	}
+
+	// the splunk logger is created once Splunk is up
+	if hec.tcpListener, err = net.ListenTCP("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	go hec.Serve()
+	defer hec.Close()
+	for deadline := time.Now().Add(5 * time.Second); d.containerStates()[0].ForwardingUnavailable != ""; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the splunk logger to be created by a retry, got %+v", d.containerStates())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !d.containerStates()[0].Forwarding {
+		t.Fatal("Expected the container to be forwarded")
+	}
+	d.StopLogging(file)
+
+	var events []string
+	for _, message := range hec.messages {
+		event, err := message.EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line, ok := event["line"]; ok {
+			events = append(events, fmt.Sprint(line))
+		} else {
+			events = append(events, fmt.Sprint(event["action"], "/", event["reason"]))
+		}
+	}
+	expected := []string{
+		"first",
+		"second",
+		lifecycleRecovered + "/" + lifecycleReasonSplunkCreated,
+		lifecycleStop + "/" + lifecycleReasonStopLogging,
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the events %v, got %v", expected, events)
+	}
+}
+
+func TestBackfillReadsLocalLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		ContainerID: "containeriid",
+		LogPath:     filepath.Join(dir, "containeriid.json"),
+	}
+	jsonl, err := jsonfilelog.New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jsonl.Close()
+	start := time.Now().Add(-time.Minute)
+	for i, line := range []string{"before", "first", "second", "after"} {
+		msg := &logger.Message{Line: []byte(line), Source: "stdout", Timestamp: start.Add(time.Duration(i) * time.Second)}
+		if err := jsonl.Log(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the whole local log is read, not only its tail
+	lf := &logPair{jsonl: jsonl, info: info}
+	l := &recordingLogger{}
+	if logged := lf.backfill(l, start.Add(time.Second), start.Add(2*time.Second), 10); logged != 2 {
+		t.Fatalf("Expected 2 lines backfilled, got %d", logged)
+	}
+	if len(l.messages) != 2 || string(l.messages[0].Line) != "first" || string(l.messages[1].Line) != "second" {
+		t.Fatalf("Expected the lines first and second, got %v", l.messages)
+	}
+}
+
+func TestStopLoggingSendsExitEvent(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:       hec.URL(),
+			splunkTokenKey:     hec.token,
+			splunkExitEventKey: "true",
+		},
+		ContainerID: "containeriid",
+	}
+	d := newDriver()
+	file := startTestLogging(t, d, dir, info)
+	// keep a writer open so the message processor blocks on the stream
+	writer, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	d.StopLogging(file)
+
+	var exits []string
+	for _, message := range hec.messages {
+		event, err := message.EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event["event_type"] == "container_exited" {
+			exits = append(exits, fmt.Sprint(event["reason"]))
+		}
+	}
+	if len(exits) != 1 || exits[0] != exitReasonLoggingStopped {
+		t.Fatalf("Expected one exit event with the reason %s, got %v", exitReasonLoggingStopped, exits)
+	}
+}
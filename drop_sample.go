@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// number of bytes of a dropped message which are logged
+	dropSampleBytes = 256
+	// maximum number of dropped messages logged per container and window
+	dropSamplesPerWindow = 3
+	dropSampleWindow     = time.Minute
+)
+
+// dropSampler logs, at debug level, the beginning of a few dropped messages
+// of a container so the drops can be told apart. The volume is bounded by
+// dropSamplesPerWindow whatever the number of drops.
+type dropSampler struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	sampled     int
+	// removed from the samples
+	token string
+}
+
+// newDropSampler() returns nil unless SPLUNK_DROP_SAMPLE is set
+func newDropSampler(token string) *dropSampler {
+	if !getAdvancedOptionBool(envVarDropSample, defaultDropSample) {
+		return nil
+	}
+	return &dropSampler{token: token}
+}
+
+// allow() returns true while fewer than dropSamplesPerWindow messages were
+// sampled in the current window
+func (s *dropSampler) allow(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.windowStart) >= dropSampleWindow {
+		s.windowStart = now
+		s.sampled = 0
+	}
+	if s.sampled >= dropSamplesPerWindow {
+		return false
+	}
+	s.sampled++
+	return true
+}
+
+// sample() returns the beginning of the encoded message with the token
+// redacted
+func (s *dropSampler) sample(message *splunkMessage) string {
+	encoded := message.encoded
+	if encoded == nil {
+		var err error
+		if encoded, err = json.Marshal(message); err != nil {
+			return ""
+		}
+	}
+	// redact before truncating, so a token on the boundary is removed
+	if len(encoded) > dropSampleBytes+len(s.token) {
+		encoded = encoded[:dropSampleBytes+len(s.token)]
+	}
+	sample := string(encoded)
+	if s.token != "" {
+		sample = strings.Replace(sample, s.token, "<redacted>", -1)
+	}
+	if len(sample) > dropSampleBytes {
+		sample = sample[:dropSampleBytes]
+	}
+	return sample
+}
+
+// sampleDropped() logs the first of the dropped messages if the sampler of
+// the container allows it
+func (hec *hecClient) sampleDropped(reason int, messages []*splunkMessage) {
+	s := hec.dropSamples
+	if s == nil || len(messages) == 0 || senderLog.Logger.Level < logrus.DebugLevel || !s.allow(time.Now()) {
+		return
+	}
+	senderLog.WithField("id", hec.shardKey).
+		WithField("reason", dropReasonNames[reason]).
+		WithField("count", len(messages)).
+		WithField("sample", s.sample(messages[0])).
+		Debug("Dropped messages sample")
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDropSampler(t *testing.T) {
+	if newDropSampler("token") != nil {
+		t.Fatal("Expected dropped messages not to be sampled by default")
+	}
+	os.Setenv(envVarDropSample, "true")
+	defer os.Unsetenv(envVarDropSample)
+	s := newDropSampler("secrettoken")
+
+	now := time.Now()
+	for i := 0; i < dropSamplesPerWindow; i++ {
+		if !s.allow(now.Add(time.Duration(i) * time.Second)) {
+			t.Fatalf("Expected sample %d to be allowed", i)
+		}
+	}
+	if s.allow(now.Add(30 * time.Second)) {
+		t.Fatal("Expected samples to be limited within the window")
+	}
+	if !s.allow(now.Add(dropSampleWindow)) {
+		t.Fatal("Expected samples to be allowed in the next window")
+	}
+
+	sample := s.sample(&splunkMessage{Event: "password secrettoken " + strings.Repeat("x", 1000)})
+	if len(sample) != dropSampleBytes {
+		t.Fatalf("Expected the sample to be truncated to %d bytes, got %d", dropSampleBytes, len(sample))
+	}
+	if strings.Contains(sample, "secrettoken") || !strings.Contains(sample, "password <redacted> xxx") {
+		t.Fatalf("Expected the token to be redacted, got %q", sample)
+	}
+
+	sample = s.sample(&splunkMessage{encoded: []byte(`{"event":"short"}`)})
+	if sample != `{"event":"short"}` {
+		t.Fatalf("Expected the encoded message to be sampled, got %q", sample)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Indexed field added to the events generated by the plug-in, so they can be
+// excluded from normal searches with NOT splunk_plugin_event=*
+const pluginEventField = "splunk_plugin_event"
+
+// dropSummaryEvent reports the events a container dropped during a stats interval
+type dropSummaryEvent struct {
+	EventType     string            `json:"event_type"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image"`
+	Tag           string            `json:"tag,omitempty"`
+	Dropped       uint64            `json:"dropped"`
+	Reasons       map[string]uint64 `json:"reasons"`
+	WindowStart   string            `json:"window_start"`
+	WindowEnd     string            `json:"window_end"`
+}
+
+// dropSummary holds the drop counters at the time of the last summary sent.
+// A single summary of the container is sent at a time.
+type dropSummary struct {
+	// 1 while a summary is being sent
+	sending    int32
+	index      string
+	sourceType string
+	event      dropSummaryEvent
+	last       [dropReasonCount]uint64
+	since      time.Time
+}
+
+func newDropSummary(info logger.Info, tag string) *dropSummary {
+	return &dropSummary{
+		index:      info.Config[splunkDropSummaryIndexKey],
+		sourceType: info.Config[splunkDropSummarySourceTypeKey],
+		event: dropSummaryEvent{
+			EventType:     "dropped_events_summary",
+			ContainerID:   info.ContainerID,
+			ContainerName: info.Name(),
+			Image:         info.ContainerImageName,
+			Tag:           tag,
+		},
+		since: time.Now(),
+	}
+}
+
+// reportDrops() sends a summary of the events dropped since the previous
+// summary. It bypasses the worker queue, so it is not subject to the buffer
+// limits. When the summary cannot be sent, or the previous one is still being
+// sent, its drops are reported again with the next one.
+func (l *splunkLogger) reportDrops(now time.Time) {
+	s := l.drops
+	if !atomic.CompareAndSwapInt32(&s.sending, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&s.sending, 0)
+	var current [dropReasonCount]uint64
+	var dropped uint64
+	reasons := make(map[string]uint64, dropReasonCount)
+	for i := range current {
+		current[i] = atomic.LoadUint64(&l.hec.metrics.droppedBy[i])
+		reasons[dropReasonNames[i]] = current[i] - s.last[i]
+		dropped += current[i] - s.last[i]
+	}
+	if dropped == 0 {
+		s.since = now
+		return
+	}
+
+	event := s.event
+	event.Dropped = dropped
+	event.Reasons = reasons
+	event.WindowStart = s.since.UTC().Format(time.RFC3339Nano)
+	event.WindowEnd = now.UTC().Format(time.RFC3339Nano)
+
+	message := *l.nullMessage
+	message.Time = fmt.Sprintf("%f", float64(now.UnixNano())/float64(time.Second))
+	message.Event = &event
+	if s.index != "" {
+		message.Index = s.index
+	}
+	if s.sourceType != "" {
+		message.SourceType = s.sourceType
+	}
+	tagPluginEvent(&message, event.EventType)
+
+	if err := l.hec.send([]*splunkMessage{&message}); err != nil {
+		senderLog.WithField("id", l.containerID).WithError(err).Warn("Failed to send the dropped events summary")
+		return
+	}
+	s.last = current
+	s.since = now
+}
+
+// tagPluginEvent() marks a message as generated by the plug-in
+func tagPluginEvent(message *splunkMessage, eventType string) {
+	setField(message, pluginEventField, eventType)
+}
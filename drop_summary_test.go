@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestDropSummary(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:              hec.URL(),
+			splunkTokenKey:            hec.token,
+			splunkIndexKey:            "containers",
+			splunkSourceTypeKey:       "app",
+			splunkDropSummaryIndexKey: "plugin",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageName: "container_image_name",
+	}
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := loggerDriver.(*splunkLoggerInline).splunkLogger
+	since := l.drops.since
+
+	c := l.containerMetrics()
+	c.addDropped(dropReasonBufferFull, 3)
+	c.addDropped(dropReasonRetryExhausted, 2)
+	end := since.Add(time.Minute)
+	l.reportDrops(end)
+
+	// nothing was dropped since the previous summary
+	l.reportDrops(end.Add(time.Minute))
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 1 {
+		t.Fatalf("Expected # of messages %d, got %d", 1, len(hec.messages))
+	}
+	message := hec.messages[0]
+	if message.Index != "plugin" || message.SourceType != "app" {
+		t.Fatalf("Unexpected index %s or sourcetype %s", message.Index, message.SourceType)
+	}
+	if message.Fields[pluginEventField] != "dropped_events_summary" {
+		t.Fatalf("Expected the summary to be tagged, got fields %v", message.Fields)
+	}
+	event, err := message.EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons, _ := event["reasons"].(map[string]interface{})
+	if event["event_type"] != "dropped_events_summary" ||
+		event["container_id"] != "containeriid" ||
+		event["container_name"] != "container_name" ||
+		event["dropped"] != float64(5) ||
+		reasons["buffer_full"] != float64(3) ||
+		reasons["retry_exhausted"] != float64(2) ||
+		reasons["too_large"] != float64(0) ||
+		event["window_start"] != since.UTC().Format(time.RFC3339Nano) ||
+		event["window_end"] != end.UTC().Format(time.RFC3339Nano) {
+		t.Fatalf("Unexpected summary %v", event)
+	}
+}
@@ -0,0 +1,384 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	// How long a bulk request can take
+	elasticsearchTimeout = 30 * time.Second
+	// Placeholder of the index replaced with the day of the event
+	elasticsearchDatePlaceholder = "{date}"
+	// Index of the events, one per day
+	defaultElasticsearchIndex = "docker-logs-" + elasticsearchDatePlaceholder
+	// Document fields of the container metadata, in the Elastic Common Schema
+	defaultElasticsearchFields = "container.id=id,container.name=name,container.image.name=image,host.name=host"
+)
+
+var elasticsearchTLSOptions = tlsOptions{
+	caPath:             logSinkElasticsearchCAPathKey,
+	insecureSkipVerify: logSinkElasticsearchInsecureSkipVerifyKey,
+}
+
+// elasticsearchSink posts events as documents to the _bulk endpoint of
+// Elasticsearch instead of HEC
+type elasticsearchSink struct {
+	url       string
+	apiKey    string
+	client    *http.Client
+	transport *http.Transport
+
+	// index of the events, the date placeholder is replaced per event
+	index string
+	// document fields with the container metadata, the same for every event
+	fields map[string]string
+}
+
+// elasticsearchBulkResponse is the part of a bulk response telling which
+// documents were rejected
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// elasticsearchError is a bulk request which failed as a whole
+type elasticsearchError struct {
+	status     string
+	statusCode int
+	// start of the response body
+	body string
+}
+
+func (e *elasticsearchError) Error() string {
+	snippet := strings.TrimSpace(e.body)
+	if len(snippet) > hecErrorSnippetLimit {
+		snippet = snippet[:hecErrorSnippetLimit] + "..."
+	}
+	if snippet == "" {
+		return fmt.Sprintf("%s: failed to post to Elasticsearch - %s", driverName, e.status)
+	}
+	return fmt.Sprintf("%s: failed to post to Elasticsearch - %s - %s", driverName, e.status, snippet)
+}
+
+// retryable() returns false for the requests Elasticsearch refuses, such as
+// an unknown API key
+func (e *elasticsearchError) retryable() bool {
+	return e.statusCode == http.StatusTooManyRequests || e.statusCode == http.StatusRequestTimeout || e.statusCode >= 500
+}
+
+func (e *elasticsearchError) busy() bool {
+	return false
+}
+
+func newElasticsearchSink(info logger.Info, hostname string) (*elasticsearchSink, error) {
+	endpoint, ok := info.Config[logSinkElasticsearchURLKey]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is expected with %s=%s", driverName, logSinkElasticsearchURLKey, logSinkKey, logSinkElasticsearch)
+	}
+	esURL, err := parseElasticsearchURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	index, err := parseElasticsearchIndex(info)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := parseElasticsearchFields(info.Config)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(mapping))
+	for field, metadata := range mapping {
+		if value := containerMetadata(info, hostname, metadata); value != "" {
+			fields[field] = value
+		}
+	}
+	tlsConfig, err := newTLSConfig(info.Config, elasticsearchTLSOptions)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	return &elasticsearchSink{
+		url:       esURL,
+		apiKey:    info.Config[logSinkElasticsearchAPIKeyKey],
+		client:    &http.Client{Transport: transport, Timeout: elasticsearchTimeout},
+		transport: transport,
+		index:     index,
+		fields:    fields,
+	}, nil
+}
+
+// parseElasticsearchURL() returns the URL of the cluster without a trailing
+// slash
+func parseElasticsearchURL(value string) (string, error) {
+	esURL, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to parse %s as url value in %s", driverName, value, logSinkElasticsearchURLKey)
+	}
+	if esURL.Scheme != "http" && esURL.Scheme != "https" {
+		return "", fmt.Errorf("%s: unsupported scheme %s in %s, expected http or https", driverName, esURL.Scheme, logSinkElasticsearchURLKey)
+	}
+	if esURL.User != nil {
+		return "", fmt.Errorf("%s: %s must not contain credentials, the API key is set with %s", driverName, logSinkElasticsearchURLKey, logSinkElasticsearchAPIKeyKey)
+	}
+	if esURL.Host == "" || esURL.RawQuery != "" || esURL.Fragment != "" {
+		return "", fmt.Errorf("%s: expected format scheme://dns_name_or_ip:port for %s", driverName, logSinkElasticsearchURLKey)
+	}
+	return strings.TrimSuffix(esURL.String(), "/"), nil
+}
+
+// parseElasticsearchIndex() expands the container templates of the index,
+// such as {{.Name}}, and keeps the date placeholder
+func parseElasticsearchIndex(info logger.Info) (string, error) {
+	value, ok := info.Config[logSinkElasticsearchIndexKey]
+	if !ok {
+		value = defaultElasticsearchIndex
+	}
+	tmpl, err := template.New("index").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid template in %s: %v", driverName, logSinkElasticsearchIndexKey, err)
+	}
+	var index bytes.Buffer
+	if err := tmpl.Execute(&index, &info); err != nil {
+		return "", fmt.Errorf("%s: invalid template in %s: %v", driverName, logSinkElasticsearchIndexKey, err)
+	}
+	// index names are lowercase
+	name := strings.ToLower(index.String())
+	if name == "" || strings.ContainsAny(name, ` "*\<|,>/?#:`) {
+		return "", fmt.Errorf("%s: invalid index name %q in %s", driverName, name, logSinkElasticsearchIndexKey)
+	}
+	return name, nil
+}
+
+// parseElasticsearchFields() returns the container metadata of every
+// document field, from a comma separated list of field=metadata
+func parseElasticsearchFields(config map[string]string) (map[string]string, error) {
+	value, ok := config[logSinkElasticsearchFieldsKey]
+	if !ok {
+		value = defaultElasticsearchFields
+	}
+	fields := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s: expected field=metadata in %s, got %q", driverName, logSinkElasticsearchFieldsKey, pair)
+		}
+		field, metadata := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !validContainerMetadata(metadata) {
+			return nil, fmt.Errorf("%s: unknown metadata %q in %s, supported metadata are id, name, image, image_id, command, created, host and label:<name>", driverName, metadata, logSinkElasticsearchFieldsKey)
+		}
+		fields[field] = metadata
+	}
+	return fields, nil
+}
+
+func validContainerMetadata(metadata string) bool {
+	switch metadata {
+	case "id", "name", "image", "image_id", "command", "created", "host":
+		return true
+	}
+	return strings.HasPrefix(metadata, "label:") && len(metadata) > len("label:")
+}
+
+// containerMetadata() returns the value of a metadata of
+// log-sink-elasticsearch-fields, empty when the container has none
+func containerMetadata(info logger.Info, hostname string, metadata string) string {
+	switch metadata {
+	case "id":
+		return info.FullID()
+	case "name":
+		return info.Name()
+	case "image":
+		return info.ImageName()
+	case "image_id":
+		return info.ImageFullID()
+	case "command":
+		return info.Command()
+	case "created":
+		if info.ContainerCreated.IsZero() {
+			return ""
+		}
+		return info.ContainerCreated.UTC().Format(time.RFC3339Nano)
+	case "host":
+		return hostname
+	}
+	return info.ContainerLabels[strings.TrimPrefix(metadata, "label:")]
+}
+
+// write() posts the messages in a bulk request and returns the number of
+// bytes posted and of documents Elasticsearch rejected
+func (s *elasticsearchSink) write(messages []*splunkMessage) (int, int, error) {
+	body, err := s.bulkBody(messages)
+	if err != nil {
+		return 0, 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	s.authorize(req)
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		errBody, _ := ioutil.ReadAll(io.LimitReader(res.Body, hecErrorBodyLimit))
+		io.Copy(ioutil.Discard, res.Body)
+		return 0, 0, &elasticsearchError{status: res.Status, statusCode: res.StatusCode, body: string(errBody)}
+	}
+	var response elasticsearchBulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil || !response.Errors {
+		io.Copy(ioutil.Discard, res.Body)
+		return len(body), 0, nil
+	}
+	rejected := 0
+	reason := ""
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Status < 200 || result.Status > 299 {
+				rejected++
+				if reason == "" && result.Error != nil {
+					reason = result.Error.Type + ": " + result.Error.Reason
+				}
+			}
+		}
+	}
+	if rejected > 0 {
+		senderLog.WithField("url", s.url).WithField("rejected", rejected).WithField("error", reason).Warn("Elasticsearch rejected documents")
+	}
+	return len(body), rejected, nil
+}
+
+// bulkBody() returns an index action and a document per message
+func (s *elasticsearchSink) bulkBody(messages []*splunkMessage) ([]byte, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, message := range messages {
+		timestamp := time.Now()
+		if nanos, ok := eventTimeNanos(message.Time); ok {
+			timestamp = time.Unix(0, nanos)
+		}
+		action := map[string]map[string]string{"index": {"_index": s.indexOf(timestamp)}}
+		if err := encoder.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(s.document(message, timestamp)); err != nil {
+			return nil, err
+		}
+	}
+	return body.Bytes(), nil
+}
+
+// indexOf() returns the index of an event, with the day of its timestamp
+func (s *elasticsearchSink) indexOf(timestamp time.Time) string {
+	return strings.Replace(s.index, elasticsearchDatePlaceholder, timestamp.UTC().Format("2006.01.02"), -1)
+}
+
+// document() maps a message to a document. The line of container messages
+// is the message, or the json field when it is JSON. Other events are the
+// event field as they are sent to HEC.
+func (s *elasticsearchSink) document(message *splunkMessage, timestamp time.Time) map[string]interface{} {
+	doc := make(map[string]interface{}, len(s.fields)+4)
+	for field, value := range s.fields {
+		doc[field] = value
+	}
+	doc["@timestamp"] = timestamp.UTC().Format(time.RFC3339Nano)
+	switch event := message.Event.(type) {
+	case *splunkMessageEvent:
+		switch line := event.Line.(type) {
+		case *json.RawMessage:
+			doc["json"] = line
+		default:
+			doc["message"] = line
+		}
+		if event.Source != "" {
+			doc["stream"] = event.Source
+		}
+		if event.Tag != "" {
+			doc["tag"] = event.Tag
+		}
+		if len(event.Attrs) > 0 {
+			doc["attrs"] = event.Attrs
+		}
+	case string:
+		doc["message"] = event
+	default:
+		doc["event"] = event
+	}
+	if len(message.Fields) > 0 {
+		doc["fields"] = message.Fields
+	}
+	return doc
+}
+
+// verify() requests the cluster information
+func (s *elasticsearchSink) verify(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, s.url+"/", nil)
+	if err != nil {
+		return err
+	}
+	s.authorize(req)
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, hecErrorBodyLimit))
+		return fmt.Errorf("%s: failed to verify connection - %s - %s", driverName, res.Status, body)
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
+
+func (s *elasticsearchSink) authorize(req *http.Request) {
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	}
+}
+
+func (s *elasticsearchSink) close() {
+	if s != nil {
+		s.transport.CloseIdleConnections()
+	}
+}
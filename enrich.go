@@ -0,0 +1,124 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+type enrichRequest struct {
+	ContainerID string            `json:"container_id"`
+	Image       string            `json:"image"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// enrichFields() asks the configured enrichment service for metadata about
+// the container. Failures are logged and never block the container start,
+// in that case no fields are returned.
+func enrichFields(info logger.Info) map[string]string {
+	enrichURL := info.Config[splunkEnrichURLKey]
+	if enrichURL == "" {
+		return nil
+	}
+	timeout := getAdvancedOptionDuration(envVarEnrichTimeout, defaultEnrichTimeout)
+	client := &http.Client{Timeout: timeout}
+
+	var fields map[string]string
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if fields, err = requestEnrichment(client, enrichURL, info); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		senderLog.WithField("id", info.ContainerID).WithField("url", enrichURL).WithError(err).Warn("Enrichment failed, continuing without enrichment")
+		return nil
+	}
+
+	for _, key := range strings.Split(info.Config[splunkEnrichRedactKey], ",") {
+		delete(fields, strings.TrimSpace(key))
+	}
+	return fields
+}
+
+// capFields() keeps the first limit fields by key. It returns the number of
+// fields removed.
+func capFields(fields map[string]string, limit int) (map[string]string, int) {
+	if limit < 0 {
+		limit = 0
+	}
+	if len(fields) <= limit {
+		return fields, 0
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	capped := make(map[string]string, limit)
+	for _, key := range keys[:limit] {
+		capped[key] = fields[key]
+	}
+	return capped, len(fields) - limit
+}
+
+func requestEnrichment(client *http.Client, enrichURL string, info logger.Info) (map[string]string, error) {
+	body, err := json.Marshal(&enrichRequest{
+		ContainerID: info.ContainerID,
+		Image:       info.ContainerImageName,
+		Labels:      info.ContainerLabels,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Post(enrichURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return nil, fmt.Errorf("%s: enrichment request failed - %s", driverName, res.Status)
+	}
+
+	var values map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&values); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(values))
+	for key, value := range values {
+		if str, ok := value.(string); ok {
+			fields[key] = str
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = string(encoded)
+	}
+	return fields, nil
+}
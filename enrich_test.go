@@ -0,0 +1,289 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestEnrichFields(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// fail the first request to verify that it is retried
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var req enrichRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.ContainerID != "containeriid" || req.Image != "container_image_name" || req.Labels["team"] != "payments" {
+			t.Errorf("Unexpected enrichment request %v", req)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"deployment": "payments-api",
+			"replicas":   3,
+			"secret":     "hunter2",
+		})
+	}))
+	defer server.Close()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:          hec.URL(),
+			splunkTokenKey:        hec.token,
+			splunkEnrichURLKey:    server.URL,
+			splunkEnrichRedactKey: "secret",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageID:   "contaimageid",
+		ContainerImageName: "container_image_name",
+		ContainerLabels:    map[string]string{"team": "payments"},
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 2 {
+		t.Fatalf("Expected enrichment to be requested once and retried once, got %d requests", requests)
+	}
+
+	if len(hec.messages) != 2 {
+		t.Fatal("Expected two messages")
+	}
+
+	for _, message := range hec.messages {
+		if message.Fields["deployment"] != "payments-api" ||
+			message.Fields["replicas"] != "3" ||
+			message.Fields[logDriverField] != defaultLogDriver ||
+			len(message.Fields) != 3 {
+			t.Fatalf("Unexpected fields in message %v", message.Fields)
+		}
+	}
+
+	err = hec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Enrichment service outage should not prevent the logger from starting
+func TestEnrichFieldsFailOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkEnrichURLKey: server.URL,
+		},
+		ContainerID: "containeriid",
+	}
+
+	if fields := enrichFields(info); fields != nil {
+		t.Fatalf("Expected no fields, got %v", fields)
+	}
+}
+
+func TestMaxFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"f00": "a", "f01": "b", "f02": "c", "f03": "d", "f04": "e", "f05": "f", "f06": "g", "f07": "h"}`))
+	}))
+	defer server.Close()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:       hec.URL(),
+			splunkTokenKey:     hec.token,
+			splunkEnrichURLKey: server.URL,
+			splunkMaxFieldsKey: "3",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageID:   "contaimageid",
+		ContainerImageName: "container_image_name",
+	}
+
+	dropped := atomic.LoadUint64(&metrics.fieldsDropped)
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 2 {
+		t.Fatal("Expected two messages")
+	}
+	// the log driver counts too
+	for _, message := range hec.messages {
+		if len(message.Fields) != 3 || message.Fields["f00"] != "a" || message.Fields["f02"] != "c" {
+			t.Fatalf("Expected only 3 fields, got %v", message.Fields)
+		}
+	}
+	if n := atomic.LoadUint64(&metrics.fieldsDropped) - dropped; n != 12 {
+		t.Fatalf("Expected 6 fields dropped from each event, got %d", n)
+	}
+
+	if err := hec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Verify that the fields of a JSON line count against splunk-max-fields
+func TestMaxFieldsJSONLine(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		for {
+			var event map[string]interface{}
+			if err := decoder.Decode(&event); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Error(err)
+				break
+			}
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:       server.URL,
+			splunkTokenKey:     "token",
+			splunkFormatKey:    splunkFormatMetric,
+			splunkMaxFieldsKey: "3",
+		},
+		ContainerID: "containeriid",
+	}
+	dropped := atomic.LoadUint64(&metrics.fieldsDropped)
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `{"cpu": 0.5, "d1": "a", "d2": "b", "d3": "c", "d4": "d", "d5": "e", "d6": "f"}`
+	if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("Expected a single event, got %v", events)
+	}
+	fields, _ := events[0]["fields"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"metric_name:cpu": 0.5,
+		"d1":              "a",
+		"d2":              "b",
+		"d3":              "c",
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected fields %v, got %v", expected, fields)
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Fatalf("Expected %s to be %v, got %v", key, value, fields[key])
+		}
+	}
+	// d4 to d6 and the log driver
+	if n := atomic.LoadUint64(&metrics.fieldsDropped) - dropped; n != 4 {
+		t.Fatalf("Expected 4 fields dropped, got %d", n)
+	}
+}
+
+func TestLogDriverField(t *testing.T) {
+	for _, test := range []struct {
+		config   map[string]string
+		expected string
+	}{
+		{map[string]string{}, defaultLogDriver},
+		{map[string]string{splunkLogDriverKey: "splunk-plugin-edge"}, "splunk-plugin-edge"},
+		{map[string]string{splunkLogDriverKey: ""}, ""},
+	} {
+		stub := &stubTransport{}
+		test.config[splunkURLKey] = "https://splunk.example.com:8088"
+		test.config[splunkTokenKey] = "00000000-0000-0000-0000-000000000000"
+		l, err := NewWithClient(logger.Info{Config: test.config, ContainerID: "containeriid"}, &http.Client{Transport: stub})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stub.mu.Lock()
+		if len(stub.messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(stub.messages))
+		}
+		logDriver, ok := stub.messages[0].Fields[logDriverField]
+		stub.mu.Unlock()
+		if logDriver != test.expected || ok != (test.expected != "") {
+			t.Fatalf("Expected the %s field %q with %v, got %q", logDriverField, test.expected, test.config[splunkLogDriverKey], logDriver)
+		}
+	}
+}
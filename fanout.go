@@ -0,0 +1,149 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+var errLoggerClosed = errors.New("logger is closed")
+
+// queuedLogger gives a synchronous sink its own queue and goroutine, so the
+// message processor can fan out an event to every sink of a container
+// without waiting for the slowest one. The splunk logger already queues and
+// retries on its own and is not wrapped. Log blocks only when the queue is
+// full.
+type queuedLogger struct {
+	logger.Logger
+
+	queue chan queuedMessage
+	done  chan struct{}
+
+	// guards the queue against being closed while a message is queued
+	mu     sync.RWMutex
+	closed bool
+}
+
+// queuedMessage is either a message or, with flushed set, a marker closing
+// flushed once the messages queued before it are written
+type queuedMessage struct {
+	msg     *logger.Message
+	flushed chan struct{}
+}
+
+func newQueuedLogger(l logger.Logger, size int) *queuedLogger {
+	q := &queuedLogger{
+		Logger: l,
+		queue:  make(chan queuedMessage, size),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Log() queues a copy of the message, the processor reuses its buffer
+func (q *queuedLogger) Log(msg *logger.Message) error {
+	m := *msg
+	m.Line = append([]byte(nil), msg.Line...)
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errLoggerClosed
+	}
+	q.queue <- queuedMessage{msg: &m}
+	return nil
+}
+
+// flush() waits up to timeout for the messages queued so far to be written,
+// so they can be read back. It returns false on timeout.
+func (q *queuedLogger) flush(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	flushed := make(chan struct{})
+
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return true
+	}
+	select {
+	case q.queue <- queuedMessage{flushed: flushed}:
+		q.mu.RUnlock()
+	case <-timer.C:
+		q.mu.RUnlock()
+		return false
+	}
+	select {
+	case <-flushed:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// queueDepth() returns the number of messages waiting to be written
+func (q *queuedLogger) queueDepth() int {
+	return len(q.queue)
+}
+
+func (q *queuedLogger) run() {
+	defer close(q.done)
+	for m := range q.queue {
+		if m.flushed != nil {
+			close(m.flushed)
+			continue
+		}
+		q.write(m.msg)
+	}
+}
+
+// write() hands a message to the wrapped logger, a panic only loses this
+// message
+func (q *queuedLogger) write(msg *logger.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			processorLog.WithField("logger", q.Logger.Name()).WithField("panic", fmt.Sprint(r)).WithField("stack", string(debug.Stack())).Error("Recovered from panic while writing log message")
+		}
+	}()
+	if err := q.Logger.Log(msg); err != nil {
+		processorLog.WithField("logger", q.Logger.Name()).WithError(err).WithField("message",
+			*msg).Error("Error writing log message")
+	}
+}
+
+// Close() waits for the queued messages to be written before closing the
+// wrapped logger
+func (q *queuedLogger) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.queue)
+	q.mu.Unlock()
+
+	<-q.done
+	return q.Logger.Close()
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+// gatedLogger records messages, but only after the gate is opened
+type gatedLogger struct {
+	recordingLogger
+	gate chan struct{}
+}
+
+func (l *gatedLogger) Log(msg *logger.Message) error {
+	<-l.gate
+	return l.recordingLogger.Log(msg)
+}
+
+func TestProcessFansOutToSinks(t *testing.T) {
+	const count = 20
+	fast := &recordingLogger{}
+	slow := &gatedLogger{gate: make(chan struct{})}
+
+	r, w := io.Pipe()
+	lf := &logPair{
+		sinks:  []logger.Logger{newQueuedLogger(slow, count), newQueuedLogger(fast, count)},
+		stream: r,
+		info:   logger.Info{ContainerID: "containeriid"},
+	}
+	done := make(chan struct{})
+	go func() {
+		messageProcessor{}.process(lf)
+		close(done)
+	}()
+
+	enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+	for i := 0; i < count; i++ {
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte(fmt.Sprintf("line %d", i))}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the slow sink has not written anything yet
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fast.logged()) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the fast sink to receive %d messages, got %d", count, len(fast.logged()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(slow.logged()) != 0 {
+		t.Fatalf("Expected the slow sink to be stalled, got %d messages", len(slow.logged()))
+	}
+
+	close(slow.gate)
+	w.Close()
+	<-done
+
+	for _, l := range []*recordingLogger{fast, &slow.recordingLogger} {
+		messages := l.logged()
+		if len(messages) != count {
+			t.Fatalf("Expected # of messages %d, got %d", count, len(messages))
+		}
+		for i, msg := range messages {
+			if string(msg.Line) != fmt.Sprintf("line %d", i) {
+				t.Fatalf("Unexpected message %d: %q", i, msg.Line)
+			}
+		}
+	}
+}
+
+func TestQueuedLoggerClosed(t *testing.T) {
+	local := &recordingLogger{}
+	q := newQueuedLogger(local, 1)
+	if err := q.Log(&logger.Message{Line: []byte("one")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(local.logged()) != 1 {
+		t.Fatalf("Expected queued messages to be written on close, got %v", local.logged())
+	}
+	if err := q.Log(&logger.Message{Line: []byte("two")}); err != errLoggerClosed {
+		t.Fatalf("Expected %v, got %v", errLoggerClosed, err)
+	}
+}
+
+// slowReadableLogger takes a while to write a message and serves what it
+// has written to docker logs
+type slowReadableLogger struct {
+	recordingLogger
+}
+
+func (l *slowReadableLogger) Log(msg *logger.Message) error {
+	time.Sleep(50 * time.Millisecond)
+	return l.recordingLogger.Log(msg)
+}
+
+func (l *slowReadableLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	watcher := logger.NewLogWatcher()
+	for _, msg := range l.logged() {
+		m := msg
+		watcher.Msg <- &m
+	}
+	close(watcher.Msg)
+	return watcher
+}
+
+func TestReadLogsWaitsForQueuedLines(t *testing.T) {
+	local := &slowReadableLogger{}
+	q := newQueuedLogger(local, 10)
+	d := newDriver()
+	d.idx["containeriid"] = &logPair{jsonl: local, sinks: []logger.Logger{q}}
+
+	if err := q.Log(&logger.Message{Line: []byte("just produced"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := d.ReadLogs(logger.Info{ContainerID: "containeriid"}, logger.ReadConfig{Tail: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	dec := protoio.NewUint32DelimitedReader(r, binary.BigEndian, 1e6)
+	var entry logdriver.LogEntry
+	if err := dec.ReadMsg(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Line) != "just produced" {
+		t.Fatalf("Unexpected line %q", entry.Line)
+	}
+	q.Close()
+}
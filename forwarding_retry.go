@@ -0,0 +1,277 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Longest wait between two attempts of creating a splunk logger
+const forwardingRetryMaxInterval = 5 * time.Minute
+
+// splunkUnavailableError is a failure of New() which a later attempt may not
+// have: the connection or the index could not be verified
+type splunkUnavailableError struct {
+	err error
+}
+
+func (e *splunkUnavailableError) Error() string {
+	return e.err.Error()
+}
+
+// parseForwardingRequired() returns whether a container fails to start when
+// its splunk logger cannot be created because Splunk is unavailable
+func parseForwardingRequired(config map[string]string) (bool, error) {
+	requiredStr, ok := config[splunkForwardingRequiredKey]
+	if !ok {
+		return true, nil
+	}
+	return strconv.ParseBool(requiredStr)
+}
+
+// parseDegradedBackfillMax() returns how many lines logged locally while
+// Splunk was unavailable are sent once the splunk logger is created, 0
+// sends none
+func parseDegradedBackfillMax(config map[string]string) (int, error) {
+	maxStr, ok := config[splunkDegradedBackfillMaxKey]
+	if !ok {
+		return 0, nil
+	}
+	backfillMax, err := strconv.Atoi(maxStr)
+	if err == nil && backfillMax < 0 {
+		err = fmt.Errorf("%s: %s must not be negative", driverName, splunkDegradedBackfillMaxKey)
+	}
+	return backfillMax, err
+}
+
+// degradedSplunkLogger is the splunk logger of a container which could not
+// be created, with splunk-forwarding-required=false. The lines are only
+// logged locally until a retry creates the splunk logger.
+type degradedSplunkLogger struct {
+	containerID string
+	create      func() (logger.Logger, error)
+	// called with the created splunk logger, and the timestamp of the last
+	// line logged without it, returns how many lines it sent
+	backfill func(l logger.Logger, until time.Time) int
+	// called once the splunk logger is created
+	onCreated func()
+
+	// serializes the lines of the container and the creation of the
+	// splunk logger
+	logMu sync.Mutex
+	// timestamp of the last line logged while the splunk logger did not
+	// exist, where the backfill ends
+	lastDegraded time.Time
+
+	mu      sync.Mutex
+	splunkl logger.Logger
+	// why the splunk logger could not be created, nil once it is created
+	// and backfilled
+	err error
+	// routing rules reloaded before the splunk logger was created
+	routingRules []*routingRule
+	rulesUpdated bool
+	// bandwidth limit reloaded before the splunk logger was created
+	bandwidthLimit   int64
+	bandwidthPolicy  string
+	bandwidthUpdated bool
+	stop             chan struct{}
+}
+
+func newDegradedSplunkLogger(containerID string, create func() (logger.Logger, error), err error) *degradedSplunkLogger {
+	return &degradedSplunkLogger{containerID: containerID, create: create, err: err, stop: make(chan struct{})}
+}
+
+// start() retries creating the splunk logger, first after interval and then
+// doubling it up to forwardingRetryMaxInterval
+func (d *degradedSplunkLogger) start(interval time.Duration) {
+	go func() {
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-timer.C:
+				if d.retry() {
+					return
+				}
+			}
+			if interval *= 2; interval > forwardingRetryMaxInterval {
+				interval = forwardingRetryMaxInterval
+			}
+			timer.Reset(interval)
+		}
+	}()
+}
+
+// retry() tries to create the splunk logger, it returns true once it exists
+func (d *degradedSplunkLogger) retry() bool {
+	splunkl, err := d.create()
+	if err != nil {
+		d.mu.Lock()
+		d.err = err
+		d.mu.Unlock()
+		driverLog.WithField("id", d.containerID).WithError(err).Debug("Cannot create the splunk logger, retrying")
+		return false
+	}
+
+	// the next lines go to the splunk logger, the lines logged until now
+	// are backfilled without holding them
+	d.logMu.Lock()
+	d.mu.Lock()
+	if d.stopped() {
+		// closed meanwhile
+		d.mu.Unlock()
+		d.logMu.Unlock()
+		splunkl.Close()
+		return true
+	}
+	d.splunkl = splunkl
+	if updater, ok := splunkl.(routingRulesUpdater); ok && d.rulesUpdated {
+		updater.setRoutingRules(d.routingRules)
+	}
+	if updater, ok := splunkl.(bandwidthLimitUpdater); ok && d.bandwidthUpdated {
+		updater.setBandwidthLimit(d.bandwidthLimit, d.bandwidthPolicy)
+	}
+	until := d.lastDegraded
+	d.mu.Unlock()
+	d.logMu.Unlock()
+
+	backfilled := 0
+	if d.backfill != nil && !until.IsZero() {
+		backfilled = d.backfill(splunkl, until)
+	}
+	d.mu.Lock()
+	if d.stopped() {
+		// closed during the backfill, the splunk logger is closed with it
+		d.mu.Unlock()
+		return true
+	}
+	d.err = nil
+	d.mu.Unlock()
+
+	atomic.AddUint64(&metrics.forwardingRecovered, 1)
+	atomic.AddUint64(&metrics.eventsBackfilled, uint64(backfilled))
+	driverLog.WithField("id", d.containerID).WithField("backfilled", backfilled).Info("Splunk logger created, forwarding again")
+	if d.onCreated != nil {
+		d.onCreated()
+	}
+	return true
+}
+
+func (d *degradedSplunkLogger) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// unavailable() returns why the splunk logger could not be created, nil once
+// it is
+func (d *degradedSplunkLogger) unavailable() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+func (d *degradedSplunkLogger) created() logger.Logger {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.splunkl
+}
+
+func (d *degradedSplunkLogger) Log(msg *logger.Message) error {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	splunkl := d.created()
+	if splunkl == nil {
+		d.lastDegraded = msg.Timestamp
+		return nil
+	}
+	return splunkl.Log(msg)
+}
+
+func (d *degradedSplunkLogger) Name() string {
+	return driverName
+}
+
+func (d *degradedSplunkLogger) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped() {
+		return nil
+	}
+	close(d.stop)
+	if d.splunkl != nil {
+		return d.splunkl.Close()
+	}
+	return nil
+}
+
+func (d *degradedSplunkLogger) logLifecycle(action string, reason string) error {
+	if l, ok := d.created().(lifecycleLogger); ok {
+		return l.logLifecycle(action, reason)
+	}
+	return fmt.Errorf("%s: splunk logger is not created", driverName)
+}
+
+func (d *degradedSplunkLogger) logContainerExit(reason string) error {
+	if l, ok := d.created().(containerExitLogger); ok {
+		return l.logContainerExit(reason)
+	}
+	return fmt.Errorf("%s: splunk logger is not created", driverName)
+}
+
+func (d *degradedSplunkLogger) containerMetrics() *containerMetrics {
+	if provider, ok := d.created().(metricsProvider); ok {
+		return provider.containerMetrics()
+	}
+	return nil
+}
+
+// setRoutingRules() updates the splunk logger, or the rules it gets once it
+// is created
+func (d *degradedSplunkLogger) setRoutingRules(rules []*routingRule) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if updater, ok := d.splunkl.(routingRulesUpdater); ok {
+		updater.setRoutingRules(rules)
+		return
+	}
+	d.routingRules, d.rulesUpdated = rules, true
+}
+
+// setBandwidthLimit() updates the splunk logger, or the limit it gets once
+// it is created
+func (d *degradedSplunkLogger) setBandwidthLimit(limit int64, policy string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if updater, ok := d.splunkl.(bandwidthLimitUpdater); ok {
+		updater.setBandwidthLimit(limit, policy)
+		return
+	}
+	d.bandwidthLimit, d.bandwidthPolicy, d.bandwidthUpdated = limit, policy, true
+}
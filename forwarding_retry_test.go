@@ -0,0 +1,118 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestDegradedSplunkLoggerStopsRetrying(t *testing.T) {
+	var attempts int32
+	d := newDegradedSplunkLogger("containeriid", func() (logger.Logger, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errors.New("connection refused")
+	}, errors.New("connection refused"))
+	d.start(time.Millisecond)
+
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&attempts) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected creating the splunk logger to be retried")
+		}
+	}
+	if err := d.Log(&logger.Message{Line: []byte("dropped")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// an attempt may be running while the logger closes
+	time.Sleep(10 * time.Millisecond)
+	stopped := atomic.LoadInt32(&attempts)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&attempts); n != stopped {
+		t.Fatalf("Expected no attempt after the logger closed, got %d more", n-stopped)
+	}
+	if d.unavailable() == nil {
+		t.Fatal("Expected the splunk logger to be unavailable")
+	}
+}
+
+func TestParseDegradedBackfillMax(t *testing.T) {
+	if backfillMax, err := parseDegradedBackfillMax(map[string]string{}); err != nil || backfillMax != 0 {
+		t.Fatalf("Expected no backfill by default, got %d, %v", backfillMax, err)
+	}
+	if backfillMax, err := parseDegradedBackfillMax(map[string]string{splunkDegradedBackfillMaxKey: "100"}); err != nil || backfillMax != 100 {
+		t.Fatalf("Expected 100, got %d, %v", backfillMax, err)
+	}
+	if _, err := parseDegradedBackfillMax(map[string]string{splunkDegradedBackfillMaxKey: "-1"}); err == nil {
+		t.Fatal("Expected a negative backfill to fail")
+	}
+}
+
+func TestDegradedSplunkLoggerBackfillDoesNotHoldLines(t *testing.T) {
+	splunkl := &recordingLogger{}
+	d := newDegradedSplunkLogger("containeriid", func() (logger.Logger, error) {
+		return splunkl, nil
+	}, errors.New("connection refused"))
+	degraded := time.Now()
+	if err := d.Log(&logger.Message{Line: []byte("degraded"), Timestamp: degraded}); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan time.Time, 1)
+	release := make(chan struct{})
+	d.backfill = func(l logger.Logger, until time.Time) int {
+		started <- until
+		<-release
+		return 1
+	}
+
+	done := make(chan bool, 1)
+	go func() { done <- d.retry() }()
+	if until := <-started; !until.Equal(degraded) {
+		t.Fatalf("Expected the backfill to end with the last degraded line, got %v", until)
+	}
+	// the lines of the container are logged during the backfill
+	logged := make(chan error, 1)
+	go func() { logged <- d.Log(&logger.Message{Line: []byte("live"), Timestamp: time.Now()}) }()
+	select {
+	case err := <-logged:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("Expected the lines not to wait for the backfill")
+	}
+	if d.unavailable() == nil {
+		t.Fatal("Expected the splunk logger to be unavailable until backfilled")
+	}
+	close(release)
+	if !<-done || d.unavailable() != nil {
+		t.Fatal("Expected the splunk logger to be created")
+	}
+	if lines := splunkl.logged(); len(lines) != 1 || string(lines[0].Line) != "live" {
+		t.Fatalf("Expected the live line to be sent, got %v", lines)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,355 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Drop rate reported by /healthz is computed over this window
+	healthDropRateWindow = time.Minute
+	// How long a single HEC health check can take
+	healthProbeTimeout = 5 * time.Second
+	// Pause of the senders after HEC answered server busy, doubled while
+	// it stays busy
+	busyPauseMin = time.Second
+	busyPauseMax = time.Minute
+)
+
+// healthProber periodically checks the HEC endpoints used by the loggers
+// and samples the drop rate, so /healthz answers from cached state and
+// never waits on the network.
+type healthProber struct {
+	metrics *pluginMetrics
+	// Maximum percentage of received events dropped over the window
+	maxDropPercent float64
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointHealth // by HEC url
+	samples   []healthSample
+}
+
+type endpointHealth struct {
+	url            string
+	healthCheckURL string
+	client         *http.Client
+	refs           int
+
+	// endpoints are considered up until a probe fails
+	up          bool
+	authFailing bool
+	lastError   string
+
+	// senders wait until busyUntil after HEC answered server busy, the
+	// pause started at busySince
+	busyPause time.Duration
+	busyUntil time.Time
+	busySince time.Time
+}
+
+type healthSample struct {
+	at       time.Time
+	received uint64
+	dropped  uint64
+}
+
+// healthFailure is a failed /healthz condition: endpoint_down,
+// auth_failing, drop_rate, buffers_full or container_degraded
+type healthFailure struct {
+	Condition string `json:"condition"`
+	Detail    string `json:"detail"`
+}
+
+type healthReport struct {
+	Healthy     bool            `json:"healthy"`
+	Failures    []healthFailure `json:"failures,omitempty"`
+	DropPercent float64         `json:"drop_percent"`
+	Endpoints   int             `json:"endpoints"`
+}
+
+var health = newHealthProber(metrics, defaultHealthMaxDropPercent)
+
+func newHealthProber(m *pluginMetrics, maxDropPercent float64) *healthProber {
+	return &healthProber{
+		metrics:        m,
+		maxDropPercent: maxDropPercent,
+		endpoints:      make(map[string]*endpointHealth),
+	}
+}
+
+// register() adds the HEC endpoint of a logger to the probed endpoints
+func (p *healthProber) register(hec *hecClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.endpoints[hec.url]
+	if !ok {
+		e = &endpointHealth{url: hec.url, healthCheckURL: hec.healthCheckURL, client: hec.client, up: true}
+		p.endpoints[hec.url] = e
+	}
+	e.refs++
+}
+
+func (p *healthProber) unregister(hec *hecClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.endpoints[hec.url]; ok {
+		e.refs--
+		if e.refs <= 0 {
+			delete(p.endpoints, hec.url)
+		}
+	}
+}
+
+// reportAuth() records whether HEC accepted the token of the last request
+func (p *healthProber) reportAuth(url string, statusCode int) {
+	failing := statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+	p.mu.Lock()
+	if e, ok := p.endpoints[url]; ok {
+		e.authFailing = failing
+	}
+	p.mu.Unlock()
+}
+
+// endpointUp() returns false when the last probe of the HEC endpoint failed
+func (p *healthProber) endpointUp(url string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.endpoints[url]; ok {
+		return e.up
+	}
+	return true
+}
+
+// pauseBusy() pauses the senders of the endpoint after HEC answered server
+// busy, twice as long as the previous pause, and returns the pause
+func (p *healthProber) pauseBusy(url string, now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.endpoints[url]
+	if !ok {
+		return 0
+	}
+	if e.busySince.IsZero() {
+		e.busySince = now
+	}
+	e.busyPause *= 2
+	if e.busyPause < busyPauseMin {
+		e.busyPause = busyPauseMin
+	}
+	if e.busyPause > busyPauseMax {
+		e.busyPause = busyPauseMax
+	}
+	e.busyUntil = now.Add(e.busyPause)
+	return e.busyPause
+}
+
+// busyPaused() returns true while the senders of the endpoint wait for HEC
+// to be less busy
+func (p *healthProber) busyPaused(url string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.endpoints[url]; ok {
+		return now.Before(e.busyUntil)
+	}
+	return false
+}
+
+// resumeBusy() ends the pause of the endpoint once a post succeeded
+func (p *healthProber) resumeBusy(url string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.endpoints[url]; ok {
+		p.resume(e, now)
+	}
+}
+
+// resume() must be called with mu held
+func (p *healthProber) resume(e *endpointHealth, now time.Time) {
+	if e.busySince.IsZero() {
+		return
+	}
+	atomic.AddUint64(&p.metrics.busyPausedNanos, uint64(now.Sub(e.busySince)))
+	senderLog.WithField("url", e.url).WithField("paused", now.Sub(e.busySince)).Info("HEC is no longer busy, resuming")
+	e.busyPause = 0
+	e.busyUntil = time.Time{}
+	e.busySince = time.Time{}
+}
+
+func (p *healthProber) start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.probe()
+			p.sample(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// probe() checks the health endpoint of every registered HEC endpoint
+func (p *healthProber) probe() {
+	p.mu.Lock()
+	endpoints := make([]*endpointHealth, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		endpoints = append(endpoints, e)
+	}
+	p.mu.Unlock()
+
+	for _, e := range endpoints {
+		err := checkEndpoint(e.client, e.healthCheckURL)
+		p.mu.Lock()
+		e.up = err == nil
+		e.lastError = ""
+		if err != nil {
+			e.lastError = err.Error()
+		} else {
+			p.resume(e, time.Now())
+		}
+		p.mu.Unlock()
+		if err != nil {
+			senderLog.WithField("url", e.healthCheckURL).WithError(err).Debug("HEC health check failed")
+		}
+	}
+}
+
+func checkEndpoint(client *http.Client, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: health check returned %s", driverName, res.Status)
+	}
+	return nil
+}
+
+// sample() records the event counters and forgets the samples which are no
+// longer needed to cover the drop rate window
+func (p *healthProber) sample(now time.Time) {
+	s := healthSample{
+		at:       now,
+		received: atomic.LoadUint64(&p.metrics.totals.received),
+		dropped:  atomic.LoadUint64(&p.metrics.totals.dropped),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples = append(p.samples, s)
+	for len(p.samples) > 2 && !p.samples[1].at.After(now.Add(-healthDropRateWindow)) {
+		p.samples = p.samples[1:]
+	}
+}
+
+// dropPercent() returns the percentage of received events dropped between
+// the oldest and the latest sample
+func (p *healthProber) dropPercent() float64 {
+	if len(p.samples) < 2 {
+		return 0
+	}
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	received := last.received - first.received
+	if received == 0 {
+		return 0
+	}
+	return 100 * float64(last.dropped-first.dropped) / float64(received)
+}
+
+func (p *healthProber) report() healthReport {
+	p.mu.Lock()
+	r := healthReport{
+		DropPercent: p.dropPercent(),
+		Endpoints:   len(p.endpoints),
+	}
+	for _, e := range p.endpoints {
+		if !e.up {
+			r.Failures = append(r.Failures, healthFailure{"endpoint_down", e.healthCheckURL + ": " + e.lastError})
+		}
+		if e.authFailing {
+			r.Failures = append(r.Failures, healthFailure{"auth_failing", e.url})
+		}
+	}
+	p.mu.Unlock()
+
+	if r.DropPercent > p.maxDropPercent {
+		r.Failures = append(r.Failures, healthFailure{"drop_rate", fmt.Sprintf("%.2f%% of events dropped in the last %s", r.DropPercent, healthDropRateWindow)})
+	}
+	for _, c := range p.metrics.snapshot() {
+		if c.queueCapacity > 0 && c.queueDepth() >= c.queueCapacity {
+			r.Failures = append(r.Failures, healthFailure{"buffers_full", c.id})
+		}
+		if c.isDegraded() {
+			r.Failures = append(r.Failures, healthFailure{"container_degraded", c.id})
+		}
+	}
+	sort.Slice(r.Failures, func(i, j int) bool {
+		if r.Failures[i].Condition != r.Failures[j].Condition {
+			return r.Failures[i].Condition < r.Failures[j].Condition
+		}
+		return r.Failures[i].Detail < r.Failures[j].Detail
+	})
+	r.Healthy = len(r.Failures) == 0
+	return r
+}
+
+// endpointState is the prober state of a HEC endpoint
+type endpointState struct {
+	URL         string     `json:"url"`
+	Up          bool       `json:"up"`
+	AuthFailing bool       `json:"auth_failing"`
+	LastError   string     `json:"last_error,omitempty"`
+	Loggers     int        `json:"loggers"`
+	BusyUntil   *time.Time `json:"busy_until,omitempty"`
+}
+
+// endpointStates() returns the state of every probed endpoint sorted by url
+func (p *healthProber) endpointStates() []endpointState {
+	p.mu.Lock()
+	states := make([]endpointState, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		state := endpointState{URL: e.url, Up: e.up, AuthFailing: e.authFailing, LastError: e.lastError, Loggers: e.refs}
+		if !e.busyUntil.IsZero() {
+			busyUntil := e.busyUntil
+			state.BusyUntil = &busyUntil
+		}
+		states = append(states, state)
+	}
+	p.mu.Unlock()
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].URL < states[j].URL
+	})
+	return states
+}
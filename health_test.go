@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func expectFailures(t *testing.T, r healthReport, conditions ...string) {
+	if len(r.Failures) != len(conditions) {
+		t.Fatalf("Expected failures %v, got %v", conditions, r.Failures)
+	}
+	for i, condition := range conditions {
+		if r.Failures[i].Condition != condition {
+			t.Fatalf("Expected failures %v, got %v", conditions, r.Failures)
+		}
+	}
+	if r.Healthy != (len(conditions) == 0) {
+		t.Fatalf("Unexpected health %v with failures %v", r.Healthy, r.Failures)
+	}
+}
+
+func TestHealthDropRate(t *testing.T) {
+	m := newPluginMetrics()
+	p := newHealthProber(m, 1)
+	depth := 0
+	c := m.register("container1", func() int { return depth })
+	c.queueCapacity = 10
+
+	now := time.Now()
+	p.sample(now)
+	c.addReceived(100)
+	c.addDropped(dropReasonBufferFull, 5)
+	p.sample(now.Add(30 * time.Second))
+	r := p.report()
+	if r.DropPercent != 5 {
+		t.Fatalf("Expected 5%% of events dropped, got %v", r.DropPercent)
+	}
+	expectFailures(t, r, "drop_rate")
+
+	// drops older than the window are forgotten
+	p.sample(now.Add(2 * time.Minute))
+	expectFailures(t, p.report())
+
+	depth = 10
+	expectFailures(t, p.report(), "buffers_full")
+}
+
+func TestHealthProbe(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	p := newHealthProber(newPluginMetrics(), 1)
+	hec := &hecClient{
+		client:         server.Client(),
+		url:            server.URL + "/services/collector/event/1.0",
+		healthCheckURL: server.URL + "/services/collector/health",
+	}
+	p.register(hec)
+	expectFailures(t, p.report())
+
+	p.probe()
+	p.reportAuth(hec.url, http.StatusForbidden)
+	expectFailures(t, p.report(), "auth_failing", "endpoint_down")
+
+	status = http.StatusOK
+	p.probe()
+	p.reportAuth(hec.url, http.StatusOK)
+	expectFailures(t, p.report())
+
+	p.unregister(hec)
+	if r := p.report(); r.Endpoints != 0 {
+		t.Fatalf("Expected no endpoints, got %d", r.Endpoints)
+	}
+}
+
+func TestHealthBusyPause(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	m := newPluginMetrics()
+	p := newHealthProber(m, 1)
+	hec := &hecClient{
+		client:         server.Client(),
+		url:            server.URL + "/services/collector/event/1.0",
+		healthCheckURL: server.URL + "/services/collector/health",
+	}
+	p.register(hec)
+
+	now := time.Now()
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if pause := p.pauseBusy(hec.url, now); pause != expected {
+			t.Fatalf("Expected a pause of %s, got %s", expected, pause)
+		}
+	}
+	if !p.busyPaused(hec.url, now.Add(3*time.Second)) || p.busyPaused(hec.url, now.Add(5*time.Second)) {
+		t.Fatal("Expected senders to be paused for the last pause")
+	}
+	for i := 0; i < 10; i++ {
+		p.pauseBusy(hec.url, now)
+	}
+	if p.busyPaused(hec.url, now.Add(busyPauseMax)) {
+		t.Fatalf("Expected pauses to be at most %s", busyPauseMax)
+	}
+
+	// a successful probe resumes the senders
+	p.probe()
+	if p.busyPaused(hec.url, now) || m.busyPausedNanos == 0 {
+		t.Fatalf("Expected the pause to end and be counted, got %d", m.busyPausedNanos)
+	}
+	if pause := p.pauseBusy(hec.url, now); pause != time.Second {
+		t.Fatalf("Expected pauses to start over, got %s", pause)
+	}
+}
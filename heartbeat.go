@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// How often the containers are checked for due heartbeats
+const heartbeatCheckInterval = time.Second
+
+// heartbeatEvent tells a silent container apart from a broken forwarding
+type heartbeatEvent struct {
+	EventType      string `json:"event_type"`
+	ContainerID    string `json:"container_id"`
+	ContainerName  string `json:"container_name"`
+	Image          string `json:"image"`
+	Tag            string `json:"tag,omitempty"`
+	LinesForwarded uint64 `json:"lines_forwarded"`
+	PluginHealthy  bool   `json:"plugin_healthy"`
+	// set on the first heartbeat after heartbeats were suppressed
+	CatchUp bool `json:"catch_up,omitempty"`
+}
+
+// heartbeat is only used from the heartbeat ticker goroutine
+type heartbeat struct {
+	interval     time.Duration
+	event        heartbeatEvent
+	next         time.Time
+	lastReceived uint64
+	suppressed   bool
+}
+
+func newHeartbeat(info logger.Info, tag string, interval time.Duration) *heartbeat {
+	if interval <= 0 {
+		return nil
+	}
+	return &heartbeat{
+		interval: interval,
+		event: heartbeatEvent{
+			EventType:     "heartbeat",
+			ContainerID:   info.ContainerID,
+			ContainerName: info.Name(),
+			Image:         info.ContainerImageName,
+			Tag:           tag,
+		},
+		next: time.Now().Add(interval),
+	}
+}
+
+// sendHeartbeat() queues a heartbeat when one is due, so it takes the same
+// path as the container logs. While the HEC endpoint is down or the queue is
+// full heartbeats are suppressed, and a single catch-up heartbeat is sent once
+// it recovers.
+func (l *splunkLogger) sendHeartbeat(now time.Time) {
+	h := l.heartbeats
+	if now.Before(h.next) {
+		return
+	}
+	if l.hec.socket == nil && l.hec.elasticsearch == nil && !health.endpointUp(l.hec.url) {
+		h.suppressed = true
+		return
+	}
+
+	received := atomic.LoadUint64(&l.hec.metrics.received)
+	event := h.event
+	event.LinesForwarded = received - h.lastReceived
+	event.PluginHealthy = health.report().Healthy
+	event.CatchUp = h.suppressed
+
+	message := l.createSplunkMessage(&logger.Message{Timestamp: now})
+	message.Event = &event
+	tagPluginEvent(message, event.EventType)
+	if !l.tryQueueMessage(message) {
+		h.suppressed = true
+		return
+	}
+	// the heartbeat itself is counted as received
+	h.lastReceived = received + 1
+	h.suppressed = false
+	h.next = now.Add(h.interval)
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestHeartbeat(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:               hec.URL(),
+			splunkTokenKey:             hec.token,
+			splunkHeartbeatIntervalKey: "1m",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageName: "container_image_name",
+	}
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := loggerDriver.(*splunkLoggerInline).splunkLogger
+	if l.containerMetrics().heartbeat == nil {
+		t.Fatal("Expected heartbeats to be enabled")
+	}
+
+	for _, line := range []string{"one", "two"} {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due := l.heartbeats.next
+	// not due yet
+	l.sendHeartbeat(due.Add(-time.Second))
+	l.sendHeartbeat(due)
+
+	// suppressed while the endpoint is down
+	setEndpointUp(l.hec.url, false)
+	l.sendHeartbeat(due.Add(time.Minute))
+	setEndpointUp(l.hec.url, true)
+	l.sendHeartbeat(due.Add(time.Minute + time.Second))
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 4 {
+		t.Fatalf("Expected # of messages %d, got %d", 4, len(hec.messages))
+	}
+	for i, expected := range []struct {
+		lines   float64
+		catchUp interface{}
+	}{{2, nil}, {0, true}} {
+		message := hec.messages[2+i]
+		if message.Fields[pluginEventField] != "heartbeat" {
+			t.Fatalf("Expected the heartbeat to be tagged, got fields %v", message.Fields)
+		}
+		event, err := message.EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event["event_type"] != "heartbeat" ||
+			event["container_id"] != "containeriid" ||
+			event["container_name"] != "container_name" ||
+			event["lines_forwarded"] != expected.lines ||
+			event["catch_up"] != expected.catchUp {
+			t.Fatalf("Unexpected heartbeat %v", event)
+		}
+	}
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	if newHeartbeat(logger.Info{}, "", 0) != nil {
+		t.Fatal("Expected heartbeats to be disabled by default")
+	}
+}
+
+func setEndpointUp(url string, up bool) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.endpoints[url].up = up
+}
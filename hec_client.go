@@ -17,16 +17,20 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/klauspost/compress/zstd"
 )
 
 type hecClient struct {
@@ -36,122 +40,585 @@ type hecClient struct {
 	url            string
 	healthCheckURL string
 	auth           string
+	// reads the token of auth from Vault when set
+	vault *vaultTokenSource
 
-	// http compression
+	// http compression, gzip or zstd
 	gzipCompression      bool
 	gzipCompressionLevel int
+	zstdCompression      bool
 
 	// Advanced options
 	postMessagesFrequency time.Duration
 	postMessagesBatchSize int
 	bufferMaximum         int
+	// maximum size of the events of a request, 0 means no limit
+	postMessagesMaxBytes int
+	// moving average of the encoded size of the events sent
+	avgEventSize int64
+
+	metrics *containerMetrics
+
+	// Batches are posted by the pool worker of shardKey, or directly when
+	// there is no pool
+	pool     *senderPool
+	shardKey string
+
+	// when set, events are written to a local forwarder socket instead of HEC
+	socket *socketSink
+	// when set, events are posted to Elasticsearch instead of HEC
+	elasticsearch *elasticsearchSink
+
+	// when set, events are exported over OTLP, before they are posted to
+	// HEC with splunk-backend=both
+	otlp    *otlpExporter
+	backend string
+
+	// tracks failed posts, nil when delivery alerts are disabled
+	monitor *deliveryMonitor
+	alert   func(event *deliveryAlertEvent)
+
+	// failed posts of the first batch not sent yet
+	failedAttempts int
+
+	// nil unless dropped messages are sampled
+	dropSamples *dropSampler
+
+	// nil unless retries are capped
+	retryBudget *retryBudget
+
+	// adds the time events spent in the plugin to every attempt to send them
+	addBufferLatency bool
+	// field of the milliseconds between the timestamp of an event and its
+	// post, empty unless splunk-delivery-latency-field
+	deliveryLatencyField string
+	// abandons the requests HEC does not answer, 0 waits forever
+	requestTimeout time.Duration
+}
+
+// sendError is an error of a backend telling whether sending the same
+// messages again may succeed
+type sendError interface {
+	error
+	retryable() bool
+	// the backend asks to send later rather than failed
+	busy() bool
+}
+
+// dropReasonOf() returns the reason of dropping the events which could not be
+// sent because of err. Events refused as too large or while the backend asked
+// to send later are told apart from the other failures.
+func dropReasonOf(err error, fallback int) int {
+	var statusCode int
+	switch e := err.(type) {
+	case *hecError:
+		statusCode = e.statusCode
+	case *otlpError:
+		statusCode = e.statusCode
+	case *elasticsearchError:
+		statusCode = e.statusCode
+	}
+	if statusCode == http.StatusRequestEntityTooLarge {
+		return dropReasonTooLarge
+	}
+	if sendErr, ok := err.(sendError); (ok && sendErr.busy()) || statusCode == http.StatusTooManyRequests {
+		return dropReasonRateLimited
+	}
+	return fallback
 }
 
 func (hec *hecClient) postMessages(messages []*splunkMessage, lastChance bool) []*splunkMessage {
-	logrus.Debugf("Received %d messages.", len(messages))
+	senderLog.WithField("count", len(messages)).Debug("Received messages")
 	messagesLen := len(messages)
-	for i := 0; i < messagesLen; i += hec.postMessagesBatchSize {
-		upperBound := i + hec.postMessagesBatchSize
-		if upperBound > messagesLen {
-			upperBound = messagesLen
+	if !lastChance && (forwarding.paused() || health.busyPaused(hec.url, time.Now())) {
+		// forwarding is paused or HEC asked to send later, only the buffer
+		// limit applies meanwhile
+		if messagesLen < hec.bufferMaximum {
+			return messages
+		}
+		upperBound := hec.batchLength(messages)
+		hec.metrics.addDropped(dropReasonBufferFull, upperBound)
+		hec.logDropped(dropReasonBufferFull, messages[:upperBound])
+		return messages[upperBound:]
+	}
+	for i, upperBound := 0, 0; i < messagesLen; i = upperBound {
+		upperBound = i + hec.batchLength(messages[i:])
+		now := time.Now()
+		if hec.failedAttempts > 0 && !hec.retryBudget.allowRetry(now) {
+			// the retries are over budget, give up on the batch rather than
+			// adding load on HEC
+			hec.metrics.addDropped(dropReasonRetryBudget, upperBound-i)
+			hec.logDropped(dropReasonRetryBudget, messages[i:upperBound])
+			metrics.batchRetries.observe(float64(hec.failedAttempts - 1))
+			hec.failedAttempts = 0
+			continue
 		}
-		if err := hec.tryPostMessages(messages[i:upperBound]); err != nil {
-			logrus.Error(err)
+		if hec.failedAttempts == 0 {
+			hec.retryBudget.recordRequest(now)
+		}
+		err := hec.send(messages[i:upperBound])
+		if sendErr, ok := err.(sendError); ok && sendErr.busy() && !lastChance {
+			// backpressure rather than a failure, the attempt is not counted
+			pause := health.pauseBusy(hec.url, time.Now())
+			senderLog.WithField("id", hec.shardKey).WithField("url", hec.url).WithField("pause", pause).Warn("HEC is busy, pausing")
+			return messages[i:messagesLen]
+		}
+		hec.recordSend(err)
+		if err != nil {
+			hec.failedAttempts++
+			sendErrorLog.error(hec.shardKey+"\x00"+err.Error(), senderLog.WithField("id", hec.shardKey).WithError(err), "Failed to send messages", time.Now())
+			hec.metrics.setLastError(err)
+			if sendErr, ok := err.(sendError); ok && !sendErr.retryable() {
+				// retrying would fail again, drop the batch and go on
+				reason := dropReasonOf(err, dropReasonRejected)
+				hec.metrics.addDropped(reason, upperBound-i)
+				hec.logDropped(reason, messages[i:upperBound])
+				metrics.batchRetries.observe(float64(hec.failedAttempts - 1))
+				hec.failedAttempts = 0
+				continue
+			}
 			if messagesLen-i >= hec.bufferMaximum || lastChance {
 				// If this is last chance - print them all to the daemon log
 				if lastChance {
 					upperBound = messagesLen
 				}
+				reason := dropReasonBufferFull
+				if lastChance {
+					reason = dropReasonRetryExhausted
+				}
+				reason = dropReasonOf(err, reason)
+				hec.metrics.addDropped(reason, upperBound-i)
+				metrics.batchRetries.observe(float64(hec.failedAttempts - 1))
+				hec.failedAttempts = 0
 				// Not all sent, but buffer has got to its maximum, let's log all messages
 				// we could not send and return buffer minus one batch size
-				for j := i; j < upperBound; j++ {
-					if jsonEvent, err := json.Marshal(messages[j]); err != nil {
-						logrus.Error(err)
-					} else {
-						logrus.Error(fmt.Errorf("Failed to send a message '%s'", string(jsonEvent)))
-					}
-				}
+				hec.logDropped(reason, messages[i:upperBound])
 				return messages[upperBound:messagesLen]
 			}
 			// Not all sent, returning buffer from where we have not sent messages
-			logrus.Debugf("%d messages failed to sent", messagesLen)
+			hec.metrics.addRetried(upperBound - i)
+			senderLog.WithField("count", messagesLen).Debug("Messages failed to send")
 			return messages[i:messagesLen]
 		}
+		metrics.batchRetries.observe(float64(hec.failedAttempts))
+		hec.failedAttempts = 0
+		health.resumeBusy(hec.url, time.Now())
 	}
 	// All sent, return empty buffer
-	logrus.Debugf("%d messages were sent successfully", messagesLen)
+	senderLog.WithField("count", messagesLen).Debug("Messages were sent successfully")
 	return messages[:0]
 }
 
+// batchLength() returns the number of messages sent in the next request.
+// With a size limit, the number of messages is derived from the average size
+// of the events sent so far, then reduced if the messages don't fit. A
+// message larger than the limit is sent alone.
+func (hec *hecClient) batchLength(messages []*splunkMessage) int {
+	n := hec.postMessagesBatchSize
+	if n > len(messages) {
+		n = len(messages)
+	}
+	if hec.postMessagesMaxBytes <= 0 {
+		return n
+	}
+	if avg := atomic.LoadInt64(&hec.avgEventSize); avg > 0 && int64(n)*avg > int64(hec.postMessagesMaxBytes) {
+		n = int(int64(hec.postMessagesMaxBytes) / avg)
+		if n < 1 {
+			n = 1
+		}
+	}
+	size := 0
+	for i, message := range messages[:n] {
+		encoded, err := message.encode()
+		if err != nil {
+			// reported when the request is encoded
+			continue
+		}
+		size += len(encoded)
+		if size > hec.postMessagesMaxBytes && i > 0 {
+			return i
+		}
+	}
+	return n
+}
+
+// observeEventSize() updates the moving average of the event size with a
+// request that was sent
+func (hec *hecClient) observeEventSize(bytes int64, events int) {
+	if events == 0 {
+		return
+	}
+	size := bytes / int64(events)
+	for {
+		avg := atomic.LoadInt64(&hec.avgEventSize)
+		next := size
+		if avg > 0 {
+			next = (3*avg + size) / 4
+		}
+		if atomic.CompareAndSwapInt64(&hec.avgEventSize, avg, next) {
+			return
+		}
+	}
+}
+
+// encode() returns the JSON encoding of the message, kept for the next
+// calls once measured
+func (message *splunkMessage) encode() ([]byte, error) {
+	if message.encoded != nil {
+		return message.encoded, nil
+	}
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	message.encoded = encoded
+	return encoded, nil
+}
+
+// withBufferLatency() returns copies of the messages with the milliseconds
+// between their read and now in the buffer_ms field. The messages are
+// stamped again when they are retried.
+func withBufferLatency(messages []*splunkMessage, now time.Time) []*splunkMessage {
+	stamped := make([]*splunkMessage, len(messages))
+	for i, message := range messages {
+		if message.readAt == 0 {
+			// not read from the container, such as heartbeats
+			stamped[i] = message
+			continue
+		}
+		copied := *message
+		copied.encoded = nil
+		setField(&copied, bufferLatencyField, strconv.FormatInt(now.Sub(time.Unix(0, message.readAt)).Nanoseconds()/int64(time.Millisecond), 10))
+		stamped[i] = &copied
+	}
+	return stamped
+}
+
+// logDropped() writes the messages which could not be sent to the
+// dead-letter file, or else to stderr with SPLUNK_LAST_RESORT_STDERR, or
+// else prints them to the daemon log
+func (hec *hecClient) logDropped(reason int, messages []*splunkMessage) {
+	hec.sampleDropped(reason, messages)
+	if deadLetters != nil && deadLetters.write(hec.shardKey, reason, messages) {
+		senderLog.WithField("id", hec.shardKey).WithField("reason", dropReasonNames[reason]).WithField("count", len(messages)).Warn("Messages written to the dead-letter file")
+		return
+	}
+	if lastResort != nil && lastResort.write(hec.shardKey, reason, messages) {
+		senderLog.WithField("id", hec.shardKey).WithField("reason", dropReasonNames[reason]).WithField("count", len(messages)).Warn("Messages written to stderr as a last resort")
+		return
+	}
+	for _, message := range messages {
+		if jsonEvent, err := json.Marshal(message); err != nil {
+			senderLog.WithField("id", hec.shardKey).WithError(err).Error("Failed to encode a message")
+		} else {
+			senderLog.WithField("id", hec.shardKey).WithField("message", string(jsonEvent)).Error("Failed to send a message")
+		}
+	}
+}
+
+// Size of the buffer between the event encoder and the HTTP request body.
+// Batches are streamed, so this bounds the memory used per request.
+const postBodyBufferSize = 64 * 1024
+
+// postBodyBufferedHook, when set, is called after every encoded event with
+// the number of bytes currently buffered for the request body
+var postBodyBufferedHook func(buffered int)
+
+func (hec *hecClient) send(messages []*splunkMessage) error {
+	if hec.pool == nil {
+		return hec.tryPostMessages(messages)
+	}
+	return hec.pool.send(hec, messages)
+}
+
 func (hec *hecClient) tryPostMessages(messages []*splunkMessage) error {
 	if len(messages) == 0 {
-		logrus.Debug("No message to post")
+		senderLog.Debug("No message to post")
 		return nil
 	}
-	var buffer bytes.Buffer
-	var writer io.Writer
-	var gzipWriter *gzip.Writer
-	var err error
-	// If gzip compression is enabled - create gzip writer with specified compression
-	// level. If gzip compression is disabled, use standard buffer as a writer
-	if hec.gzipCompression {
-		gzipWriter, err = gzip.NewWriterLevel(&buffer, hec.gzipCompressionLevel)
-		if err != nil {
+	if hec.otlp != nil {
+		if err := hec.exportOTLP(messages); err != nil {
 			return err
 		}
-		writer = gzipWriter
-	} else {
-		writer = &buffer
+		if hec.backend == splunkBackendOTLP {
+			return nil
+		}
 	}
-	for _, message := range messages {
-		jsonEvent, err := json.Marshal(message)
+	// the messages of the batch, which the stamped copies below are not
+	batch := messages
+	if hec.addBufferLatency {
+		messages = withBufferLatency(messages, time.Now())
+	}
+	var latencies []time.Duration
+	if hec.deliveryLatencyField != "" {
+		messages, latencies = withDeliveryLatency(messages, hec.deliveryLatencyField, time.Now())
+	}
+	if hec.socket != nil {
+		n, err := hec.socket.write(messages)
 		if err != nil {
 			return err
 		}
-		if _, err := writer.Write(jsonEvent); err != nil {
-			return err
-		}
+		hec.metrics.addSent(len(messages), n)
+		observeDeliveryLatency(latencies)
+		return nil
 	}
-	// If gzip compression is enabled, tell it, that we are done
-	if hec.gzipCompression {
-		err = gzipWriter.Close()
+	if hec.elasticsearch != nil {
+		n, rejected, err := hec.elasticsearch.write(messages)
 		if err != nil {
 			return err
 		}
+		hec.metrics.addSent(len(messages)-rejected, n)
+		if rejected > 0 {
+			hec.metrics.addDropped(dropReasonRejected, rejected)
+		}
+		observeDeliveryLatency(latencies)
+		return nil
+	}
+	// Each request has a single channel, so split the batch in runs of
+	// consecutive messages sharing the same channel. The runs posted before
+	// a run failed are not posted again when the batch is retried.
+	start := 0
+	for start < len(batch) && batch[start].posted {
+		start++
+	}
+	for i := start + 1; i <= len(messages); i++ {
+		if i == len(messages) || messages[i].channel != messages[start].channel {
+			if err := hec.postRequest(messages[start:i], messages[start].channel); err != nil {
+				return err
+			}
+			for _, message := range batch[start:i] {
+				message.posted = true
+			}
+			start = i
+		}
+	}
+	observeDeliveryLatency(latencies)
+	return nil
+}
+
+// exportOTLP() exports the messages which were not exported yet. With both
+// backends, a batch retried because HEC failed is not exported again.
+func (hec *hecClient) exportOTLP(messages []*splunkMessage) error {
+	pending := make([]*splunkMessage, 0, len(messages))
+	for _, message := range messages {
+		if !message.exported {
+			pending = append(pending, message)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	records := pending
+	if hec.addBufferLatency {
+		records = withBufferLatency(pending, time.Now())
 	}
-	req, err := http.NewRequest("POST", hec.url, bytes.NewBuffer(buffer.Bytes()))
+	var latencies []time.Duration
+	if hec.deliveryLatencyField != "" {
+		records, latencies = withDeliveryLatency(records, hec.deliveryLatencyField, time.Now())
+	}
+	n, rejected, err := hec.otlp.export(records)
+	if err != nil {
+		return err
+	}
+	for _, message := range pending {
+		message.exported = true
+	}
+	if hec.backend == splunkBackendOTLP {
+		// with both backends the messages are counted once posted to HEC
+		metrics.batchSize.observe(float64(len(pending)))
+		hec.metrics.addSent(len(pending)-rejected, n)
+		observeDeliveryLatency(latencies)
+		if rejected > 0 {
+			hec.metrics.addDropped(dropReasonRejected, rejected)
+		}
+	}
+	return nil
+}
+
+func (hec *hecClient) postRequest(messages []*splunkMessage, channel string) error {
+	if hec.vault == nil {
+		return hec.postRequestWithAuth(messages, channel, hec.auth)
+	}
+	token, err := hec.vault.get()
+	if err != nil {
+		return err
+	}
+	err = hec.postRequestWithAuth(messages, channel, "Splunk "+token)
+	if hecErr, ok := err.(*hecError); ok && hecErr.unauthorized() {
+		// the token may have been rotated in Vault, read it again once
+		if token, ok = hec.vault.refetch(token); ok {
+			return hec.postRequestWithAuth(messages, channel, "Splunk "+token)
+		}
+	}
+	return err
+}
+
+func (hec *hecClient) postRequestWithAuth(messages []*splunkMessage, channel string, auth string) error {
+	// Events are encoded in the background straight into the request body,
+	// so we never hold the whole payload in memory
+	body, bodyWriter := io.Pipe()
+	defer body.Close()
+	var encodedBytes, encodedAt int64
+	go hec.encodeMessages(bodyWriter, messages, &encodedBytes, &encodedAt)
+
+	req, err := http.NewRequest("POST", hec.url, body)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", hec.auth)
-	// Tell if we are sending gzip compressed body
+	req.Header.Set("Authorization", auth)
+	if channel != "" {
+		req.Header.Set("X-Splunk-Request-Channel", channel)
+	}
+	// Tell if we are sending compressed body
 	if hec.gzipCompression {
 		req.Header.Set("Content-Encoding", "gzip")
+	} else if hec.zstdCompression {
+		req.Header.Set("Content-Encoding", "zstd")
 	}
+	inflightRequests.acquire()
+	defer inflightRequests.release()
+	// a HEC which does not answer must not hold the slot forever, the
+	// deadline covers reading the response too
+	if hec.requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), hec.requestTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	start := time.Now()
 	res, err := hec.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		var body []byte
-		body, err = ioutil.ReadAll(res.Body)
-		if err != nil {
-			return err
+	health.reportAuth(hec.url, res.StatusCode)
+	// the body is streamed, so the latency is measured from the end of the
+	// serialization, or from the start of the request if HEC answered first
+	observeLatency := func() {
+		if encoded := atomic.LoadInt64(&encodedAt); encoded > start.UnixNano() {
+			start = time.Unix(0, encoded)
 		}
-		return fmt.Errorf("%s: failed to send event - %s - %s", driverName, res.Status, body)
+		metrics.requestLatency.observe(time.Since(start).Seconds())
+	}
+	if res.StatusCode != http.StatusOK {
+		hecErr := readHECError(res, strings.TrimPrefix(auth, "Splunk "))
+		hecErr.indexes = messageIndexes(messages)
+		observeLatency()
+		logHECError(hec.url, hecErr)
+		return hecErr
 	}
 	io.Copy(ioutil.Discard, res.Body)
+	observeLatency()
+	metrics.batchSize.observe(float64(len(messages)))
+	hec.observeEventSize(atomic.LoadInt64(&encodedBytes), len(messages))
+	hec.metrics.addSent(len(messages), int(atomic.LoadInt64(&encodedBytes)))
 	return nil
 }
 
-func (hec *hecClient) verifySplunkConnection(l *splunkLogger) error {
+// encodeMessages() writes the messages to the request body and closes it,
+// with the encoding error if there is any. encodedAt is set once all the
+// messages are encoded.
+func (hec *hecClient) encodeMessages(bodyWriter *io.PipeWriter, messages []*splunkMessage, encodedBytes *int64, encodedAt *int64) {
+	buffer := bufio.NewWriterSize(bodyWriter, postBodyBufferSize)
+	var writer io.Writer
+	var compressor io.WriteCloser
+	var err error
+	// If compression is enabled - create gzip writer with specified compression
+	// level or zstd writer. If compression is disabled, use standard buffer as a writer
+	if hec.gzipCompression {
+		compressor, err = gzip.NewWriterLevel(buffer, hec.gzipCompressionLevel)
+	} else if hec.zstdCompression {
+		compressor, err = zstd.NewWriter(buffer)
+	}
+	if err != nil {
+		bodyWriter.CloseWithError(err)
+		return
+	}
+	if compressor != nil {
+		writer = compressor
+	} else {
+		writer = buffer
+	}
+	for _, message := range messages {
+		jsonEvent := message.encoded
+		if jsonEvent == nil {
+			jsonEvent, err = json.Marshal(message)
+		}
+		if err != nil {
+			bodyWriter.CloseWithError(err)
+			return
+		}
+		if _, err := writer.Write(jsonEvent); err != nil {
+			bodyWriter.CloseWithError(err)
+			return
+		}
+		atomic.AddInt64(encodedBytes, int64(len(jsonEvent)))
+		if postBodyBufferedHook != nil {
+			postBodyBufferedHook(buffer.Buffered())
+		}
+	}
+	// If compression is enabled, tell it, that we are done
+	if compressor != nil {
+		if err = compressor.Close(); err != nil {
+			bodyWriter.CloseWithError(err)
+			return
+		}
+	}
+	atomic.StoreInt64(encodedAt, time.Now().UnixNano())
+	bodyWriter.CloseWithError(buffer.Flush())
+}
+
+// Wait after a failed verification of the connection, doubled after each
+// failure up to verifyRetryMaxInterval
+const (
+	verifyRetryInterval    = 100 * time.Millisecond
+	verifyRetryMaxInterval = 2 * time.Second
+)
+
+// verifyConnectionWithin() retries verifySplunkConnection until it succeeds
+// or timeout elapses, waiting longer after each failure. A timeout of 0
+// verifies once.
+func (hec *hecClient) verifyConnectionWithin(l *splunkLogger, timeout time.Duration) error {
+	if timeout <= 0 {
+		return hec.verifySplunkConnection(context.Background(), l)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	interval := verifyRetryInterval
+	for attempt := 1; ; attempt++ {
+		err := hec.verifySplunkConnection(ctx, l)
+		if err == nil {
+			return nil
+		}
+		senderLog.WithField("url", hec.healthCheckURL).WithField("attempt", attempt).WithError(err).Debug("Failed to verify connection, retrying")
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (%d attempts in %s)", err, attempt, timeout)
+		}
+		if interval *= 2; interval > verifyRetryMaxInterval {
+			interval = verifyRetryMaxInterval
+		}
+	}
+}
+
+func (hec *hecClient) verifySplunkConnection(ctx context.Context, l *splunkLogger) error {
+	if hec.socket != nil {
+		return hec.socket.connect()
+	}
+	if hec.elasticsearch != nil {
+		return hec.elasticsearch.verify(ctx)
+	}
+	if hec.otlp != nil {
+		if err := hec.otlp.verify(ctx); err != nil || hec.backend == splunkBackendOTLP {
+			return err
+		}
+	}
 	req, err := http.NewRequest(http.MethodGet, hec.healthCheckURL, nil)
 	if err != nil {
 		return err
 	}
-	res, err := hec.client.Do(req)
+	res, err := hec.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,425 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestPostMessagesMaxBytes(t *testing.T) {
+	const maxBytes = 2000
+	var (
+		mu       sync.Mutex
+		requests [][2]int // size and number of events of every request
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		events := 0
+		for dec := json.NewDecoder(bytes.NewReader(body)); ; events++ {
+			var message splunkMessage
+			if err := dec.Decode(&message); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Error(err)
+				break
+			}
+		}
+		mu.Lock()
+		requests = append(requests, [2]int{len(body), events})
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	hec := &hecClient{
+		client:                server.Client(),
+		url:                   server.URL,
+		postMessagesBatchSize: 1000,
+		bufferMaximum:         10000,
+		postMessagesMaxBytes:  maxBytes,
+	}
+	post := func(sizes ...int) (maxEvents int) {
+		mu.Lock()
+		requests = nil
+		mu.Unlock()
+		var messages []*splunkMessage
+		for i := 0; i < 100; i++ {
+			messages = append(messages, &splunkMessage{Event: strings.Repeat("x", sizes[i%len(sizes)])})
+		}
+		if remaining := hec.postMessages(messages, false); len(remaining) != 0 {
+			t.Fatalf("Expected all messages to be sent, %d left", len(remaining))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events := 0
+		for _, request := range requests {
+			if request[0] > maxBytes {
+				t.Fatalf("Request of %d bytes is larger than %d", request[0], maxBytes)
+			}
+			if request[1] > maxEvents {
+				maxEvents = request[1]
+			}
+			events += request[1]
+		}
+		if events != len(messages) {
+			t.Fatalf("Expected %d events, got %d", len(messages), events)
+		}
+		return maxEvents
+	}
+
+	small := post(10, 40, 20)
+	if small < 20 {
+		t.Fatalf("Expected batches of small events, got at most %d events", small)
+	}
+	// the number of events per request adapts to the larger events
+	large := post(300, 500, 400)
+	if large > 5 {
+		t.Fatalf("Expected batches of large events to be smaller, got %d events", large)
+	}
+	if avg := hec.avgEventSize; avg < 300 {
+		t.Fatalf("Expected the average event size to follow the events, got %d", avg)
+	}
+	if small := post(10); small <= large {
+		t.Fatalf("Expected batches to grow again, got at most %d events", small)
+	}
+}
+
+func TestServerBusyBackpressure(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		busy     = true
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if busy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text":"Server is busy","code":9}`))
+		}
+	}))
+	defer server.Close()
+
+	c := newPluginMetrics().register("containeriid", func() int { return 0 })
+	hec := &hecClient{
+		client:                server.Client(),
+		url:                   server.URL,
+		postMessagesBatchSize: 10,
+		bufferMaximum:         100,
+		metrics:               c,
+		monitor:               &deliveryMonitor{maxConsecutive: 1, window: time.Minute},
+	}
+	health.register(hec)
+	defer health.unregister(hec)
+
+	messages := []*splunkMessage{{Event: "one"}, {Event: "two"}}
+	if remaining := hec.postMessages(messages, false); len(remaining) != 2 {
+		t.Fatal("Expected the messages to be kept while HEC is busy")
+	}
+	// paused, nothing is sent
+	if remaining := hec.postMessages(messages, false); len(remaining) != 2 {
+		t.Fatal("Expected the messages to be kept while paused")
+	}
+	mu.Lock()
+	if requests != 1 {
+		t.Fatalf("Expected no request while paused, got %d requests", requests)
+	}
+	busy = false
+	mu.Unlock()
+	if hec.failedAttempts != 0 || c.retried != 0 || c.isDegraded() || len(hec.monitor.samples) != 0 {
+		t.Fatal("Expected server busy not to count as a failure")
+	}
+
+	health.resumeBusy(hec.url, time.Now())
+	if remaining := hec.postMessages(messages, false); len(remaining) != 0 {
+		t.Fatal("Expected the messages to be sent once HEC is no longer busy")
+	}
+}
+
+func TestDropReasons(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		body   string
+		reason int
+	}{
+		{http.StatusRequestEntityTooLarge, `{"text":"Content-Length of 900000 too large (maximum is 800000)"}`, dropReasonTooLarge},
+		{http.StatusTooManyRequests, ``, dropReasonRateLimited},
+		{http.StatusServiceUnavailable, `{"text":"Server is busy","code":9}`, dropReasonRateLimited},
+		{http.StatusInternalServerError, `{"text":"Internal server error","code":8}`, dropReasonRetryExhausted},
+		{http.StatusBadRequest, `{"text":"Invalid data format","code":6}`, dropReasonRejected},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		c := newPluginMetrics().register("containeriid", func() int { return 0 })
+		hec := &hecClient{
+			client:                server.Client(),
+			url:                   server.URL,
+			postMessagesBatchSize: 10,
+			bufferMaximum:         100,
+			metrics:               c,
+			monitor:               &deliveryMonitor{maxConsecutive: 1, window: time.Minute},
+		}
+		if remaining := hec.postMessages([]*splunkMessage{{Event: "one"}, {Event: "two"}}, true); len(remaining) != 0 {
+			t.Fatalf("Expected the messages to be dropped on the last chance, got %d", len(remaining))
+		}
+		server.Close()
+		if dropped := c.droppedBy[test.reason]; dropped != 2 {
+			t.Fatalf("Expected status %d to drop the messages as %s, got %v", test.status, dropReasonNames[test.reason], c.droppedBy)
+		}
+	}
+}
+
+func TestNewWithClient(t *testing.T) {
+	stub := &stubTransport{}
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   "https://splunk.example.com:8088",
+			splunkTokenKey: "00000000-0000-0000-0000-000000000000",
+		},
+		ContainerID: "containeriid",
+	}
+	l, err := NewWithClient(info, &http.Client{Transport: stub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one", "two"} {
+		if err := l.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.requests) == 0 {
+		t.Fatal("Expected the requests to go through the injected client")
+	}
+	req := stub.requests[0]
+	if req.URL.String() != "https://splunk.example.com:8088/services/collector/event/1.0" {
+		t.Fatalf("Unexpected request URL %s", req.URL)
+	}
+	if req.Header.Get("Authorization") != "Splunk 00000000-0000-0000-0000-000000000000" {
+		t.Fatalf("Unexpected authorization %s", req.Header.Get("Authorization"))
+	}
+	if len(stub.messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(stub.messages))
+	}
+	for i, line := range []string{"one", "two"} {
+		event, err := stub.messages[i].EventAsMap()
+		if err != nil || event["line"] != line {
+			t.Fatalf("Unexpected event %v", stub.messages[i].Event)
+		}
+	}
+}
+
+func TestVerifyConnectionRetries(t *testing.T) {
+	tests := []struct {
+		verify   string
+		timeout  string
+		failures int
+		attempts int
+		started  bool
+	}{
+		// HEC comes back within the timeout
+		{"true", "5s", 2, 3, true},
+		// HEC does not come back, the container fails to start
+		{"true", "300ms", 1000, -1, false},
+		// a single attempt by default
+		{"true", "", 1, 1, false},
+		// non-critical containers start anyway
+		{"false", "300ms", 1000, 0, true},
+	}
+	for _, test := range tests {
+		// a server per test, the requests canceled by a timeout are not
+		// counted by the next test
+		var mu sync.Mutex
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/services/collector/health" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts <= test.failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		info := logger.Info{
+			Config: map[string]string{
+				splunkURLKey:              server.URL,
+				splunkTokenKey:            "00000000-0000-0000-0000-000000000000",
+				splunkVerifyConnectionKey: test.verify,
+			},
+			ContainerID: "containeriid",
+		}
+		if test.timeout != "" {
+			info.Config[splunkVerifyTimeoutKey] = test.timeout
+		}
+
+		start := time.Now()
+		l, err := New(info)
+		if (err == nil) != test.started {
+			t.Fatalf("Unexpected start of %+v: %v", test, err)
+		}
+		if l != nil {
+			l.Close()
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Fatalf("Expected the verification to be bounded by its timeout, took %s", elapsed)
+		}
+		server.Close()
+		mu.Lock()
+		got := attempts
+		mu.Unlock()
+		if test.attempts == -1 {
+			if got < 2 {
+				t.Fatalf("Expected the verification to be retried, got %d attempts", got)
+			}
+		} else if got != test.attempts {
+			t.Fatalf("Expected %d attempts for %+v, got %d", test.attempts, test, got)
+		}
+	}
+}
+
+func TestBufferLatency(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		stub := &stubTransport{}
+		info := logger.Info{
+			Config: map[string]string{
+				splunkURLKey:   "https://splunk.example.com:8088",
+				splunkTokenKey: "00000000-0000-0000-0000-000000000000",
+			},
+			ContainerID: "containeriid",
+		}
+		if enabled {
+			info.Config[splunkAddBufferLatencyKey] = "true"
+		}
+		l, err := NewWithClient(info, &http.Client{Transport: stub})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Log(&logger.Message{Line: []byte("one"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		// the event waits in the buffer until the logger is closed
+		delay := 200 * time.Millisecond
+		time.Sleep(delay)
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stub.mu.Lock()
+		if len(stub.messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(stub.messages))
+		}
+		latency, ok := stub.messages[0].Fields[bufferLatencyField]
+		stub.mu.Unlock()
+		if !enabled {
+			if ok {
+				t.Fatalf("Expected no %s field without the option, got %s", bufferLatencyField, latency)
+			}
+			continue
+		}
+		ms, err := strconv.ParseInt(latency, 10, 64)
+		if err != nil {
+			t.Fatalf("Expected the %s field, got %q", bufferLatencyField, latency)
+		}
+		if ms < delay.Nanoseconds()/int64(time.Millisecond) || ms > 5000 {
+			t.Fatalf("Expected the buffering time of about %s, got %dms", delay, ms)
+		}
+	}
+}
+
+func TestDeliveryLatency(t *testing.T) {
+	stub := &stubTransport{}
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:                  "https://splunk.example.com:8088",
+			splunkTokenKey:                "00000000-0000-0000-0000-000000000000",
+			splunkDeliveryLatencyFieldKey: "delivery_ms",
+		},
+		ContainerID: "containeriid",
+	}
+	l, err := NewWithClient(info, &http.Client{Transport: stub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clamped := atomic.LoadUint64(&metrics.deliveryLatencyClamped)
+	metrics.deliveryLatency.mu.Lock()
+	observed := metrics.deliveryLatency.count
+	metrics.deliveryLatency.mu.Unlock()
+
+	// written by the container 300ms ago, and by a container with a clock
+	// an hour ahead
+	delay := 300 * time.Millisecond
+	for _, timestamp := range []time.Time{time.Now().Add(-delay), time.Now().Add(time.Hour)} {
+		if err := l.Log(&logger.Message{Line: []byte("line"), Source: "stdout", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(stub.messages))
+	}
+	ms, err := strconv.ParseInt(stub.messages[0].Fields["delivery_ms"], 10, 64)
+	if err != nil || ms < delay.Nanoseconds()/int64(time.Millisecond) || ms > 5000 {
+		t.Fatalf("Expected the delivery latency of about %s, got %v", delay, stub.messages[0].Fields)
+	}
+	if latency := stub.messages[1].Fields["delivery_ms"]; latency != "0" {
+		t.Fatalf("Expected the latency in the future clamped to 0, got %q", latency)
+	}
+	if got := atomic.LoadUint64(&metrics.deliveryLatencyClamped) - clamped; got != 1 {
+		t.Fatalf("Expected 1 clamped latency, got %d", got)
+	}
+	metrics.deliveryLatency.mu.Lock()
+	defer metrics.deliveryLatency.mu.Unlock()
+	if got := metrics.deliveryLatency.count - observed; got != 2 {
+		t.Fatalf("Expected 2 observed latencies, got %d", got)
+	}
+}
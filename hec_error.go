@@ -0,0 +1,211 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum number of bytes of a HEC error response which are logged
+	hecErrorBodyLimit = 1024
+	// At most one detailed log per destination, status and code in this interval
+	hecErrorLogInterval = time.Minute
+	// Maximum number of bytes of the body in the message of an unknown error
+	hecErrorSnippetLimit = 200
+)
+
+// hecErrorCode explains an error code of HEC. A request rejected with a
+// permanent code fails again when retried.
+type hecErrorCode struct {
+	text      string
+	fix       string
+	retryable bool
+}
+
+// Error codes documented for the HTTP Event Collector. Incorrect index (7)
+// is explained with the indexes of the request.
+var hecErrorCodes = map[int]hecErrorCode{
+	1:  {"token disabled", "enable the token in the HTTP Event Collector settings of Splunk", true},
+	2:  {"token is required", "set splunk-token", false},
+	3:  {"invalid authorization", "check that splunk-token is a HEC token", false},
+	4:  {"invalid token", "check that splunk-token matches a token of the HTTP Event Collector", true},
+	5:  {"no data", "the request had no events, please report this as an issue of the plug-in", false},
+	6:  {"invalid data format", "check that splunk-url-path is an event endpoint", false},
+	7:  {"incorrect index", "", false},
+	8:  {"internal server error", "check the health of the Splunk instance, events are retried", true},
+	9:  {"server is busy", "Splunk is overloaded, events are retried", true},
+	10: {"data channel is missing", "indexer acknowledgement is enabled for the token, set splunk-channel-from or disable it", false},
+	11: {"invalid data channel", "check the values used by splunk-channel-from", false},
+	12: {"event field is required", "check that splunk-url-path is an event endpoint", false},
+	13: {"event field cannot be blank", "check splunk-format, raw events must not be empty", false},
+	14: {"ACK is disabled", "enable indexer acknowledgement for the token", false},
+	15: {"error in handling indexed fields", "check the fields added to the events by labels, env, splunk-enrich-url and splunk-routing-rules", false},
+	16: {"query string authorization is not enabled", "check that splunk-url has no token in its query string", false},
+	18: {"HEC is unhealthy, queues are full", "Splunk is overloaded, events are retried", true},
+	19: {"HEC is unhealthy, ack service unavailable", "Splunk is overloaded, events are retried", true},
+	20: {"HEC is unhealthy, queues are full, ack service unavailable", "Splunk is overloaded, events are retried", true},
+}
+
+// hecError is a request rejected by HEC, with the reason given in the
+// response body ({"text":"Incorrect index","code":7})
+type hecError struct {
+	status     string
+	statusCode int
+	// response body, truncated and without the token
+	body string
+
+	Text string `json:"text"`
+	Code *int   `json:"code"`
+
+	// indexes of the rejected events, to explain an incorrect index
+	indexes []string
+}
+
+func (e *hecError) Error() string {
+	if e.Code != nil {
+		return fmt.Sprintf("%s: failed to send event - %s - code %d: %s", driverName, e.status, *e.Code, e.explain())
+	}
+	if snippet := e.snippet(); snippet != "" {
+		return fmt.Sprintf("%s: failed to send event - %s - %s", driverName, e.status, snippet)
+	}
+	return fmt.Sprintf("%s: failed to send event - %s", driverName, e.status)
+}
+
+// explain() describes the error code and how to fix it, or returns the
+// start of the body for an unknown code
+func (e *hecError) explain() string {
+	code, ok := hecErrorCodes[*e.Code]
+	if !ok {
+		return e.snippet()
+	}
+	if *e.Code == 7 {
+		if len(e.indexes) == 0 {
+			return "incorrect index, the default index of the token does not exist, set splunk-index"
+		}
+		return fmt.Sprintf("incorrect index, the index '%s' is not allowed for this token or does not exist, add it to the allowed indexes of the token or change splunk-index or splunk-routing-rules", strings.Join(e.indexes, "', '"))
+	}
+	return code.text + ", " + code.fix
+}
+
+func (e *hecError) snippet() string {
+	snippet := strings.TrimSpace(e.body)
+	if len(snippet) > hecErrorSnippetLimit {
+		snippet = snippet[:hecErrorSnippetLimit] + "..."
+	}
+	return snippet
+}
+
+// unauthorized() returns true when HEC rejected the token
+func (e *hecError) unauthorized() bool {
+	return e.statusCode == http.StatusUnauthorized || e.statusCode == http.StatusForbidden
+}
+
+// retryable() returns false when sending the same events again fails again.
+// Unknown codes are retried.
+func (e *hecError) retryable() bool {
+	if e.Code == nil {
+		return true
+	}
+	code, ok := hecErrorCodes[*e.Code]
+	return !ok || code.retryable
+}
+
+// busy() returns true when HEC asks to send later, as its queues are full
+func (e *hecError) busy() bool {
+	return e.Code != nil && *e.Code == 9
+}
+
+// messageIndexes() returns the distinct explicit indexes of the messages
+func messageIndexes(messages []*splunkMessage) []string {
+	var indexes []string
+	seen := make(map[string]bool)
+	for _, message := range messages {
+		if message.Index != "" && !seen[message.Index] {
+			seen[message.Index] = true
+			indexes = append(indexes, message.Index)
+		}
+	}
+	return indexes
+}
+
+// readHECError() reads the start of the response body and removes any
+// occurrence of token from it
+func readHECError(res *http.Response, token string) *hecError {
+	e := &hecError{status: res.Status, statusCode: res.StatusCode}
+	// read a bit more than the limit, so a token on the boundary is removed
+	// before truncating
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, int64(hecErrorBodyLimit+len(token))))
+	io.Copy(ioutil.Discard, res.Body)
+	if token != "" {
+		body = []byte(strings.Replace(string(body), token, "<redacted>", -1))
+	}
+	if len(body) > hecErrorBodyLimit {
+		body = body[:hecErrorBodyLimit]
+	}
+	e.body = string(body)
+	json.Unmarshal(body, e)
+	return e
+}
+
+// logLimiter allows one log per key and interval
+type logLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newLogLimiter(interval time.Duration) *logLimiter {
+	return &logLimiter{interval: interval, last: make(map[string]time.Time)}
+}
+
+func (l *logLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	l.last[key] = now
+	return true
+}
+
+var hecErrorLogs = newLogLimiter(hecErrorLogInterval)
+
+// logHECError() logs the reason of a rejected request, unless the same
+// rejection was already logged for the destination in the last minute
+func logHECError(url string, e *hecError) {
+	code := "none"
+	if e.Code != nil {
+		code = fmt.Sprintf("%d", *e.Code)
+	}
+	if !hecErrorLogs.allow(url+" "+e.status+" "+code, time.Now()) {
+		return
+	}
+	entry := senderLog.WithField("url", url).WithField("status", e.status).WithField("body", e.body)
+	if e.Code != nil {
+		entry = entry.WithField("code", *e.Code).WithField("text", e.Text).WithField("explanation", e.explain())
+	}
+	entry.WithField("retryable", e.retryable()).Error("HEC rejected events")
+}
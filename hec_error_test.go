@@ -0,0 +1,123 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newErrorResponse(status int, body string) *http.Response {
+	w := httptest.NewRecorder()
+	w.WriteHeader(status)
+	w.WriteString(body)
+	return w.Result()
+}
+
+func TestReadHECError(t *testing.T) {
+	token := "4642492F-D8BD-47F1-A005-0C08AE4657DF"
+	e := readHECError(newErrorResponse(http.StatusBadRequest, `{"text":"Incorrect index","code":7,"invalid-event-number":0}`), token)
+	if e.Code == nil || *e.Code != 7 || e.Text != "Incorrect index" {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	if e.Error() != "splunk: failed to send event - 400 Bad Request - code 7: incorrect index, the default index of the token does not exist, set splunk-index" {
+		t.Fatalf("Unexpected message %s", e.Error())
+	}
+
+	// the token is removed even when it crosses the size limit
+	body := strings.Repeat("x", hecErrorBodyLimit-10) + token + strings.Repeat("y", 100)
+	e = readHECError(newErrorResponse(http.StatusForbidden, body), token)
+	if e.Code != nil {
+		t.Fatalf("Unexpected code %d", *e.Code)
+	}
+	if len(e.body) != hecErrorBodyLimit || strings.Contains(e.body, token[:10]) {
+		t.Fatalf("Expected truncated body without token, got %q", e.body)
+	}
+	if !strings.HasSuffix(e.body, "<redacted>") {
+		t.Fatalf("Expected redacted token at the end of the body, got %q", e.body[hecErrorBodyLimit-20:])
+	}
+}
+
+func TestHECErrorExplain(t *testing.T) {
+	e := readHECError(newErrorResponse(http.StatusBadRequest, `{"text":"Incorrect index","code":7}`), "")
+	e.indexes = messageIndexes([]*splunkMessage{{Index: "foo"}, {}, {Index: "foo"}})
+	if !strings.Contains(e.Error(), "the index 'foo' is not allowed for this token") || e.retryable() {
+		t.Fatalf("Unexpected error %s", e.Error())
+	}
+
+	e = readHECError(newErrorResponse(http.StatusServiceUnavailable, `{"text":"Server is busy","code":9}`), "")
+	if !strings.HasSuffix(e.Error(), "code 9: server is busy, Splunk is overloaded, events are retried") || !e.retryable() {
+		t.Fatalf("Unexpected error %s", e.Error())
+	}
+
+	// unknown codes and bodies are retried and shown as they are
+	e = readHECError(newErrorResponse(http.StatusBadRequest, `{"text":"Something new","code":99}`), "")
+	if !strings.HasSuffix(e.Error(), `code 99: {"text":"Something new","code":99}`) || !e.retryable() {
+		t.Fatalf("Unexpected error %s", e.Error())
+	}
+	e = readHECError(newErrorResponse(http.StatusBadGateway, "  "+strings.Repeat("x", 300)), "")
+	if e.Error() != "splunk: failed to send event - 502 Bad Gateway - "+strings.Repeat("x", hecErrorSnippetLimit)+"..." || !e.retryable() {
+		t.Fatalf("Unexpected error %s", e.Error())
+	}
+}
+
+func TestPermanentHECErrorDropsBatch(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"Incorrect index","code":7}`))
+	}))
+	defer server.Close()
+
+	m := newPluginMetrics()
+	c := m.register("containeriid", func() int { return 0 })
+	hec := &hecClient{
+		client:                server.Client(),
+		url:                   server.URL,
+		postMessagesBatchSize: 1,
+		bufferMaximum:         10,
+		metrics:               c,
+	}
+	messages := []*splunkMessage{{Event: "one", Index: "foo"}, {Event: "two", Index: "foo"}}
+	if remaining := hec.postMessages(messages, false); len(remaining) != 0 {
+		t.Fatalf("Expected rejected messages to be dropped, got %d left", len(remaining))
+	}
+	if requests != 2 || c.droppedBy[dropReasonRejected] != 2 || c.retried != 0 {
+		t.Fatalf("Unexpected %d requests, %d rejected and %d retried", requests, c.droppedBy[dropReasonRejected], c.retried)
+	}
+}
+
+func TestLogLimiter(t *testing.T) {
+	l := newLogLimiter(time.Minute)
+	now := time.Now()
+	if !l.allow("url 400 7", now) {
+		t.Fatal("Expected first log to be allowed")
+	}
+	if l.allow("url 400 7", now.Add(30*time.Second)) {
+		t.Fatal("Expected second log in the interval to be limited")
+	}
+	if !l.allow("url 400 6", now.Add(30*time.Second)) || !l.allow("other 400 7", now.Add(30*time.Second)) {
+		t.Fatal("Expected other codes and destinations to be logged")
+	}
+	if !l.allow("url 400 7", now.Add(time.Minute)) {
+		t.Fatal("Expected log to be allowed after the interval")
+	}
+}
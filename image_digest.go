@@ -0,0 +1,36 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Field holding the digest of the image of the container
+const imageDigestField = "image_digest"
+
+// imageDigest() returns the digest of the image of the container: the
+// digest of the image reference when the container was started from
+// name@sha256:..., the image ID otherwise. It is empty when neither is known.
+func imageDigest(info logger.Info) string {
+	if i := strings.LastIndex(info.ContainerImageName, "@"); i >= 0 {
+		return info.ContainerImageName[i+1:]
+	}
+	return info.ContainerImageID
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestImageDigest(t *testing.T) {
+	for _, test := range []struct {
+		name, id, expected string
+	}{
+		{"registry.example.com/app@sha256:4e1a", "sha256:9f2b", "sha256:4e1a"},
+		{"registry.example.com:5000/app:1.0", "sha256:9f2b", "sha256:9f2b"},
+		{"app", "", ""},
+	} {
+		if digest := imageDigest(logger.Info{ContainerImageName: test.name, ContainerImageID: test.id}); digest != test.expected {
+			t.Fatalf("Expected digest %q of %s, got %q", test.expected, test.name, digest)
+		}
+	}
+}
+
+func TestImageDigestField(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:                hec.URL(),
+			splunkTokenKey:              hec.token,
+			splunkIncludeImageDigestKey: "true",
+		},
+		ContainerID:        "containeriid",
+		ContainerImageID:   "sha256:9f2b",
+		ContainerImageName: "container_image_name",
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loggerDriver.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(hec.messages))
+	}
+	if digest := hec.messages[0].Fields[imageDigestField]; digest != "sha256:9f2b" {
+		t.Fatalf("Expected the image digest sha256:9f2b, got %v", hec.messages[0].Fields)
+	}
+
+	if err := hec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestVerifyIndex(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		probes []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		probes = append(probes, event)
+		mu.Unlock()
+		if event["index"] != "main" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"text":"Incorrect index","code":7,"invalid-event-number":0}`))
+		}
+	}))
+	defer server.Close()
+
+	newLogger := func(index string) (logger.Logger, error) {
+		return New(logger.Info{
+			Config: map[string]string{
+				splunkURLKey:         server.URL,
+				splunkTokenKey:       "token",
+				splunkIndexKey:       index,
+				splunkVerifyIndexKey: "true",
+			},
+			ContainerID: "containeriid",
+		})
+	}
+
+	if _, err := newLogger("mian"); err == nil || !strings.Contains(err.Error(), `splunk-index "mian" does not exist`) {
+		t.Fatalf("Expected the index to be rejected, got %v", err)
+	}
+
+	l, err := newLogger("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	mu.Lock()
+	probe := probes[len(probes)-1]
+	mu.Unlock()
+	if fields, _ := probe["fields"].(map[string]interface{}); fields[pluginEventField] != "index_probe" {
+		t.Fatalf("Expected the probe to be marked, got %v", probe)
+	}
+
+	os.Setenv(envVarSkipVerifyIndex, "true")
+	defer os.Unsetenv(envVarSkipVerifyIndex)
+	mu.Lock()
+	sent := len(probes)
+	mu.Unlock()
+	l, err = newLogger("mian")
+	if err != nil {
+		t.Fatalf("Expected the verification to be skipped, got %v", err)
+	}
+	l.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(probes) != sent {
+		t.Fatal("Expected no probe when the verification is skipped")
+	}
+}
+
+func TestVerifyIndexAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "admin" || password != "changeme" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/services/data/indexes/main":
+			w.Write([]byte(`{"entry": [{"name": "main", "content": {"disabled": false}}]}`))
+		case "/services/data/indexes/old":
+			w.Write([]byte(`{"entry": [{"name": "old", "content": {"disabled": true}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	apiURL := strings.Replace(server.URL, "://", "://admin:changeme@", 1)
+
+	if err := verifyIndexAPI(server.Client(), apiURL, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyIndexAPI(server.Client(), apiURL, "mian"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("Expected a missing index, got %v", err)
+	}
+	if err := verifyIndexAPI(server.Client(), apiURL, "old"); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("Expected a disabled index, got %v", err)
+	}
+	if err := verifyIndexAPI(server.Client(), server.URL, "main"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Expected the credentials to be required, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// requestLimit caps the requests to HEC in flight across every container,
+// to protect HEC from the concurrency of many containers. Senders wait for
+// a free slot.
+type requestLimit struct {
+	slots chan struct{}
+}
+
+// inflightRequests is nil when the requests in flight are not limited
+var inflightRequests *requestLimit
+
+func newRequestLimit(max int) *requestLimit {
+	return &requestLimit{slots: make(chan struct{}, max)}
+}
+
+// acquire() waits for a slot, which is released by release()
+func (l *requestLimit) acquire() {
+	if l == nil {
+		return
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return
+	default:
+	}
+	start := time.Now()
+	l.slots <- struct{}{}
+	atomic.AddUint64(&metrics.inflightWaitNanos, uint64(time.Since(start)))
+}
+
+func (l *requestLimit) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
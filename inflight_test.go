@@ -0,0 +1,153 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestMaxInflightRequests(t *testing.T) {
+	const maxInflight = 3
+	var (
+		mu                    sync.Mutex
+		inflight, maxObserved int
+		events                int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		if inflight > maxObserved {
+			maxObserved = inflight
+		}
+		mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		events += strings.Count(string(body), `"event"`)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	os.Setenv(envVarPostMessagesFrequency, "5ms")
+	os.Setenv(envVarPostMessagesBatchSize, "1")
+	inflightRequests = newRequestLimit(maxInflight)
+	defer func() {
+		os.Setenv(envVarPostMessagesFrequency, "")
+		os.Setenv(envVarPostMessagesBatchSize, "")
+		inflightRequests = nil
+	}()
+
+	var loggers []logger.Logger
+	for i := 0; i < 20; i++ {
+		l, err := New(logger.Info{
+			Config: map[string]string{
+				splunkURLKey:   server.URL,
+				splunkTokenKey: "token",
+			},
+			ContainerID: fmt.Sprintf("%064d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		loggers = append(loggers, l)
+	}
+	var wg sync.WaitGroup
+	for _, l := range loggers {
+		wg.Add(1)
+		go func(l logger.Logger) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				if err := l.Log(&logger.Message{Line: []byte("line"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+					t.Error(err)
+				}
+			}
+			if err := l.Close(); err != nil {
+				t.Error(err)
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if events != 100 {
+		t.Fatalf("Expected every event to be sent, got %d", events)
+	}
+	if maxObserved > maxInflight {
+		t.Fatalf("Expected at most %d requests in flight, got %d", maxInflight, maxObserved)
+	}
+	if maxObserved < maxInflight {
+		t.Fatalf("Expected the containers to use the %d requests in flight, got %d", maxInflight, maxObserved)
+	}
+}
+
+func TestRequestTimeoutReleasesInflightSlot(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// HEC never answers
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	os.Setenv(envVarRequestTimeout, "50ms")
+	inflightRequests = newRequestLimit(1)
+	defer func() {
+		os.Setenv(envVarRequestTimeout, "")
+		inflightRequests = nil
+	}()
+
+	loggerDriver, err := New(logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   server.URL,
+			splunkTokenKey: "token",
+		},
+		ContainerID: "containeriid",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loggerDriver.Close()
+	l := loggerDriver.(*splunkLoggerInline).splunkLogger
+	message := l.createSplunkMessage(&logger.Message{Line: []byte("line"), Source: "stdout", Timestamp: time.Now()})
+	message.Event = "line"
+
+	result := make(chan error, 1)
+	go func() { result <- l.hec.postRequest([]*splunkMessage{message}, "") }()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("Expected the request to time out")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the request to be abandoned after the timeout")
+	}
+	if n := len(inflightRequests.slots); n != 0 {
+		t.Fatalf("Expected the slot to be released, %d still in use", n)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"strconv"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Maximum size of a decompressed line, longer lines are split in partial
+// messages like Docker does
+const inputGzipMaxLine = 16 * 1024
+
+// entryReader reads the log entries of a stream
+type entryReader interface {
+	ReadMsg(msg proto.Message) error
+}
+
+// parseInputGzip() returns the value of splunk-input-gzip
+func parseInputGzip(config map[string]string) (bool, error) {
+	inputGzipStr, ok := config[splunkInputGzipKey]
+	if !ok {
+		return false, nil
+	}
+	return strconv.ParseBool(inputGzipStr)
+}
+
+// gzipEntryReader decompresses the output of a container which writes
+// gzip. Docker splits the compressed bytes in lines, so the entries are
+// joined back, with the newlines Docker removed, into a single gzip stream
+// which is split again in lines once decompressed. The lines get the source
+// and time of the entry which completed them. Data which doesn't decompress
+// is skipped up to the next entry.
+type gzipEntryReader struct {
+	containerID string
+	dec         entryReader
+
+	// last entry read and its bytes not decompressed yet
+	entry   logdriver.LogEntry
+	pending []byte
+	// error of dec, which ends the stream
+	readErr error
+
+	lines *bufio.Reader
+}
+
+func newGzipEntryReader(dec entryReader, containerID string) *gzipEntryReader {
+	return &gzipEntryReader{containerID: containerID, dec: dec}
+}
+
+// Read() returns the compressed bytes of the entries
+func (r *gzipEntryReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		r.entry.Reset()
+		if err := r.dec.ReadMsg(&r.entry); err != nil {
+			r.readErr = err
+			return 0, err
+		}
+		r.pending = r.entry.Line
+		if !r.entry.Partial {
+			r.pending = append(r.pending, '\n')
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// ReadByte() returns the next compressed byte, so gzip reads no more of the
+// stream than it decompresses
+func (r *gzipEntryReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := r.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// ReadMsg() reads the next decompressed line
+func (r *gzipEntryReader) ReadMsg(msg proto.Message) error {
+	entry := msg.(*logdriver.LogEntry)
+	for {
+		if r.lines == nil {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				if err = r.skip(err); err != nil {
+					return err
+				}
+				continue
+			}
+			r.lines = bufio.NewReaderSize(zr, inputGzipMaxLine)
+		}
+		line, err := r.lines.ReadSlice('\n')
+		if err == nil || err == bufio.ErrBufferFull || (err == io.EOF && len(line) > 0) {
+			partial := err == bufio.ErrBufferFull
+			if !partial && line[len(line)-1] == '\n' {
+				line = line[:len(line)-1]
+			}
+			entry.Line = append(entry.Line[:0], line...)
+			entry.Source = r.entry.Source
+			entry.TimeNano = r.entry.TimeNano
+			entry.Partial = partial
+			return nil
+		}
+		if err = r.skip(err); err != nil {
+			return err
+		}
+	}
+}
+
+// skip() returns the error of the stream, or drops the data which didn't
+// decompress so the next entry starts a new gzip stream
+func (r *gzipEntryReader) skip(err error) error {
+	if r.readErr != nil {
+		return r.readErr
+	}
+	processorLog.WithField("id", r.containerID).WithError(err).Warn("Skipping output which is not gzip")
+	r.pending = nil
+	r.lines = nil
+	return nil
+}
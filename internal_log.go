@@ -0,0 +1,51 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	internalLogFormatText = "text"
+	internalLogFormatJSON = "json"
+)
+
+// Every plugin log entry has a component field, so entries can be routed:
+// driver for the plugin and container lifecycle, processor for reading
+// logs from docker and writing them locally, sender for delivering them.
+var (
+	driverLog    = logrus.WithField("component", "driver")
+	processorLog = logrus.WithField("component", "processor")
+	senderLog    = logrus.WithField("component", "sender")
+)
+
+// newInternalLogFormatter() returns the formatter of the plugin logs for
+// SPLUNK_INTERNAL_LOG_FORMAT
+func newInternalLogFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", internalLogFormatText:
+		return &logrus.TextFormatter{}, nil
+	case internalLogFormatJSON:
+		return &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}, nil
+	default:
+		return nil, fmt.Errorf("invalid internal log format: %s, supported formats are text and json", format)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestInternalLogFormatJSON(t *testing.T) {
+	formatter, err := newInternalLogFormatter(internalLogFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	log := logrus.New()
+	log.Out = &out
+	log.Formatter = formatter
+	log.WithFields(senderLog.Data).WithField("id", "containeriid").Info("Failed to send messages")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON entry, got %q: %v", out.String(), err)
+	}
+	if entry["component"] != "sender" || entry["id"] != "containeriid" || entry["msg"] != "Failed to send messages" {
+		t.Fatalf("Unexpected entry %v", entry)
+	}
+	timestamp, ok := entry["time"].(string)
+	if !ok {
+		t.Fatalf("Unexpected time in entry %v", entry)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInternalLogFormat(t *testing.T) {
+	for _, format := range []string{"", internalLogFormatText} {
+		formatter, err := newInternalLogFormatter(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := formatter.(*logrus.TextFormatter); !ok {
+			t.Fatalf("Expected text formatter for %q", format)
+		}
+	}
+	if _, err := newInternalLogFormatter("xml"); err == nil {
+		t.Fatal("Expecting error on unknown format")
+	}
+	for entry, component := range map[*logrus.Entry]string{driverLog: "driver", processorLog: "processor", senderLog: "sender"} {
+		if entry.Data["component"] != component {
+			t.Fatalf("Expected component %s, got %v", component, entry.Data)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+var errJournalQueueFull = errors.New("journal queue is full")
+
+// journalWriter sends an entry in the native journal protocol
+type journalWriter interface {
+	send(entry []byte) error
+	close() error
+}
+
+func parseJournaldCopy(config map[string]string) (bool, error) {
+	journaldCopyStr, ok := config[splunkJournaldCopyKey]
+	if !ok {
+		return false, nil
+	}
+	return strconv.ParseBool(journaldCopyStr)
+}
+
+// journaldLogger copies the messages of a container to the systemd journal,
+// next to the Splunk delivery. It never blocks nor fails the container: the
+// messages are queued and sent by its own goroutine, and the messages which
+// cannot be queued or sent are only counted.
+type journaldLogger struct {
+	writer journalWriter
+	info   logger.Info
+
+	queue chan []byte
+	done  chan struct{}
+
+	// guards the queue against being closed while a message is queued
+	mu     sync.RWMutex
+	closed bool
+	// a failure is logged once per container
+	reported int32
+}
+
+func newJournaldLogger(writer journalWriter, info logger.Info, size int) *journaldLogger {
+	j := &journaldLogger{
+		writer: writer,
+		info:   info,
+		queue:  make(chan []byte, size),
+		done:   make(chan struct{}),
+	}
+	go j.run()
+	return j
+}
+
+// Log() queues the journal entry of the message, or drops it when the queue
+// is full
+func (j *journaldLogger) Log(msg *logger.Message) error {
+	entry := journalEntry(j.info, msg)
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.closed {
+		return nil
+	}
+	select {
+	case j.queue <- entry:
+	default:
+		j.failed(errJournalQueueFull)
+	}
+	return nil
+}
+
+func (j *journaldLogger) run() {
+	defer close(j.done)
+	for entry := range j.queue {
+		if err := j.writer.send(entry); err != nil {
+			j.failed(err)
+		}
+	}
+}
+
+// failed() counts a message which did not make it to the journal
+func (j *journaldLogger) failed(err error) {
+	atomic.AddUint64(&metrics.journaldFailures, 1)
+	if atomic.CompareAndSwapInt32(&j.reported, 0, 1) {
+		driverLog.WithField("id", j.info.ContainerID).WithError(err).Warn("Cannot copy messages to the journal, the failures are counted by splunk_logging_journald_failures_total")
+	}
+}
+
+func (j *journaldLogger) Name() string {
+	return "journald"
+}
+
+// Close() waits for the queued messages to be sent before closing the
+// journal connection
+func (j *journaldLogger) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	close(j.queue)
+	j.mu.Unlock()
+	<-j.done
+	return j.writer.close()
+}
+
+// journalEntry() encodes the message with the native journal protocol
+func journalEntry(info logger.Info, msg *logger.Message) []byte {
+	var entry bytes.Buffer
+	writeJournalField(&entry, "MESSAGE", msg.Line)
+	writeJournalField(&entry, "CONTAINER_ID", []byte(info.ID()))
+	writeJournalField(&entry, "CONTAINER_NAME", []byte(info.Name()))
+	writeJournalField(&entry, "PRIORITY", []byte(strconv.Itoa(messageSeverity(msg.Source, msg.Line))))
+	return entry.Bytes()
+}
+
+// writeJournalField() writes a field as KEY=value, or with the binary
+// framing when the value holds a newline
+func writeJournalField(entry *bytes.Buffer, key string, value []byte) {
+	entry.WriteString(key)
+	if bytes.IndexByte(value, '\n') == -1 {
+		entry.WriteByte('=')
+		entry.Write(value)
+		entry.WriteByte('\n')
+		return
+	}
+	entry.WriteByte('\n')
+	binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+	entry.Write(value)
+	entry.WriteByte('\n')
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+)
+
+// journalSocket sends entries to the datagram socket of journald
+type journalSocket struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+func dialJournal(path string) (journalWriter, error) {
+	// an unbound socket, journald does not answer
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "", Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalSocket{conn: conn, addr: &net.UnixAddr{Name: path, Net: "unixgram"}}, nil
+}
+
+// send() writes the entry as one datagram. An entry too large for a
+// datagram is written to a deleted temporary file whose descriptor is
+// passed instead, as journald expects.
+func (s *journalSocket) send(entry []byte) error {
+	_, _, err := s.conn.WriteMsgUnix(entry, nil, s.addr)
+	if err == nil || !(errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)) {
+		return err
+	}
+	f, err := ioutil.TempFile("/dev/shm", "journal.")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(entry); err != nil {
+		return err
+	}
+	_, _, err = s.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), s.addr)
+	return err
+}
+
+func (s *journalSocket) close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// readJournalEntry() reads an entry sent to the fake journal socket, from
+// the datagram or from the file passed with it
+func readJournalEntry(t *testing.T, conn *net.UnixConn) map[string]string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1<<20)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := buf[:n]
+	if oobn > 0 {
+		messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds, err := syscall.ParseUnixRights(&messages[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := os.NewFile(uintptr(fds[0]), "journal")
+		defer f.Close()
+		// the offset is shared with the sender, journald maps the file
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if entry, err = ioutil.ReadAll(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fields := make(map[string]string)
+	for len(entry) > 0 {
+		end := bytes.IndexByte(entry, '\n')
+		if end == -1 {
+			t.Fatalf("Unterminated journal field %q", entry)
+		}
+		if eq := bytes.IndexByte(entry[:end], '='); eq != -1 {
+			fields[string(entry[:eq])] = string(entry[eq+1 : end])
+			entry = entry[end+1:]
+			continue
+		}
+		size := binary.LittleEndian.Uint64(entry[end+1 : end+9])
+		fields[string(entry[:end])] = string(entry[end+9 : end+9+int(size)])
+		entry = entry[end+9+int(size)+1:]
+	}
+	return fields
+}
+
+func TestJournaldCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "socket")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	writer, err := dialJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := logger.Info{ContainerID: "0123456789abcdef", ContainerName: "/web"}
+	journal := newJournaldLogger(writer, info, 10)
+
+	large := strings.Repeat("x", 1<<19)
+	messages := []*logger.Message{
+		{Line: []byte("2018-06-01 WARN disk almost full"), Source: "stdout"},
+		{Line: []byte("panic: boom\ngoroutine 1"), Source: "stderr"},
+		{Line: []byte(large), Source: "stdout"},
+	}
+	for _, msg := range messages {
+		if err := journal.Log(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []map[string]string{
+		{"MESSAGE": "2018-06-01 WARN disk almost full", "CONTAINER_ID": "0123456789ab", "CONTAINER_NAME": "web", "PRIORITY": "4"},
+		{"MESSAGE": "panic: boom\ngoroutine 1", "CONTAINER_ID": "0123456789ab", "CONTAINER_NAME": "web", "PRIORITY": "2"},
+		{"MESSAGE": large, "CONTAINER_ID": "0123456789ab", "CONTAINER_NAME": "web", "PRIORITY": "6"},
+	}
+	for _, want := range expected {
+		fields := readJournalEntry(t, server)
+		for key, value := range want {
+			if fields[key] != value {
+				t.Fatalf("Expected %s=%.40q, got %.40q", key, value, fields[key])
+			}
+		}
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// without journald, messages are counted and logging goes on
+	server.Close()
+	os.Remove(path)
+	writer, err = dialJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := atomic.LoadUint64(&metrics.journaldFailures)
+	journal = newJournaldLogger(writer, info, 10)
+	if err := journal.Log(messages[0]); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+	if atomic.LoadUint64(&metrics.journaldFailures) != failures+1 {
+		t.Fatal("Expected the failure to be counted")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// The json format sends the parsed line as it was written, numbers,
+// booleans and null keep their JSON type and 64-bit integers keep their
+// precision
+func TestJSONFormatValueTypes(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		body []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		body = append(body, b...)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	loggerDriver, err := New(logger.Info{
+		Config: map[string]string{
+			splunkURLKey:    server.URL,
+			splunkTokenKey:  "token",
+			splunkFormatKey: splunkFormatJSON,
+		},
+		ContainerID:   "containeriid",
+		ContainerName: "/container_name",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		`{"latency_ms": 12.5, "status": 200, "ok": true, "retry": false, "user": null}`,
+		`{"id": 9007199254740993, "min": -9223372036854775808, "max": 18446744073709551615, "tiny": 1e-300, "exp": 2.5E+10}`,
+		`{"nested": {"list": [1, 2.0, "3", false, null], "empty": {}}}`,
+	}
+	for _, line := range lines {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, expected := range []string{
+		`"line":{"latency_ms":12.5,"status":200,"ok":true,"retry":false,"user":null}`,
+		`"line":{"id":9007199254740993,"min":-9223372036854775808,"max":18446744073709551615,"tiny":1e-300,"exp":2.5E+10}`,
+		`"line":{"nested":{"list":[1,2.0,"3",false,null],"empty":{}}}`,
+	} {
+		if !bytes.Contains(body, []byte(expected)) {
+			t.Fatalf("Expected the body to hold %s, got %s", expected, body)
+		}
+	}
+}
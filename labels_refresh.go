@@ -0,0 +1,140 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// inspectContainerConfig returns the current labels and environment of a
+// container, from docker at SPLUNK_DOCKER_SOCKET
+var inspectContainerConfig = func(containerID string) (map[string]string, []string, error) {
+	socket := getAdvancedOptionString(envVarDockerSocket, defaultDockerSocket)
+	if socket == "" {
+		return nil, nil, fmt.Errorf("%s: %s is not set", driverName, envVarDockerSocket)
+	}
+	inspect, err := inspectContainer(socket, containerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inspect.Config.Labels, inspect.Config.Env, nil
+}
+
+// labelRefresher resolves the labels, env and env-regex attributes of a
+// container again every interval, for orchestrators which update the labels
+// of running containers
+type labelRefresher struct {
+	info     logger.Info
+	interval time.Duration
+
+	// *resolvedAttrs, the attributes last resolved
+	latest atomic.Value
+	stop   chan struct{}
+}
+
+// resolvedAttrs are attributes of a container, version is incremented every
+// time they change
+type resolvedAttrs struct {
+	version uint64
+	attrs   map[string]string
+}
+
+// newLabelRefresher() starts refreshing the attributes resolved at start,
+// nil when interval is 0
+func newLabelRefresher(info logger.Info, attrs map[string]string, interval time.Duration) *labelRefresher {
+	if interval <= 0 {
+		return nil
+	}
+	r := &labelRefresher{info: info, interval: interval, stop: make(chan struct{})}
+	r.latest.Store(&resolvedAttrs{attrs: attrs})
+	go r.run()
+	return r
+}
+
+func (r *labelRefresher) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+		err := r.refresh()
+		if err != nil && !failing {
+			driverLog.WithField("id", r.info.ContainerID).WithError(err).Warn("Failed to refresh the container labels, keeping the previous ones")
+		} else if err != nil {
+			driverLog.WithField("id", r.info.ContainerID).WithError(err).Debug("Failed to refresh the container labels")
+		}
+		failing = err != nil
+	}
+}
+
+// refresh() resolves the attributes from the inspected labels and
+// environment, and publishes them when they changed
+func (r *labelRefresher) refresh() error {
+	labels, env, err := inspectContainerConfig(r.info.ContainerID)
+	if err != nil {
+		return err
+	}
+	info := r.info
+	info.ContainerLabels = labels
+	info.ContainerEnv = env
+	attrs, err := info.ExtraAttributes(nil)
+	if err != nil {
+		return err
+	}
+	latest := r.latest.Load().(*resolvedAttrs)
+	if reflect.DeepEqual(attrs, latest.attrs) {
+		return nil
+	}
+	driverLog.WithField("id", r.info.ContainerID).WithField("attrs", attrs).Info("Container labels changed")
+	r.latest.Store(&resolvedAttrs{version: latest.version + 1, attrs: attrs})
+	return nil
+}
+
+func (r *labelRefresher) close() {
+	if r != nil {
+		close(r.stop)
+	}
+}
+
+// refreshedAttrs() returns the attributes resolved again since the last
+// call, false when they did not change. Only called by the goroutine
+// logging the messages, which owns the attributes of the loggers.
+func (l *splunkLogger) refreshedAttrs() (map[string]string, bool) {
+	if l.labels == nil {
+		return nil, false
+	}
+	latest := l.labels.latest.Load().(*resolvedAttrs)
+	if latest.version == l.labelsVersion {
+		return nil, false
+	}
+	l.labelsVersion = latest.version
+	if l.exitEvent != nil {
+		exitEvent := *l.exitEvent
+		exitEvent.Attrs = latest.attrs
+		l.exitEvent = &exitEvent
+	}
+	return latest.attrs, true
+}
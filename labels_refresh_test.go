@@ -0,0 +1,117 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestLabelsRefresh(t *testing.T) {
+	var mu sync.Mutex
+	labels := map[string]string{"team": "payments"}
+	defer func(inspect func(string) (map[string]string, []string, error)) { inspectContainerConfig = inspect }(inspectContainerConfig)
+	inspectContainerConfig = func(containerID string) (map[string]string, []string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return labels, []string{"STAGE=prod"}, nil
+	}
+
+	for _, format := range []string{splunkFormatInline, splunkFormatRaw} {
+		mu.Lock()
+		labels = map[string]string{"team": "payments"}
+		mu.Unlock()
+		stub := &stubTransport{}
+		info := logger.Info{
+			Config: map[string]string{
+				splunkURLKey:           "https://splunk.example.com:8088",
+				splunkTokenKey:         "00000000-0000-0000-0000-000000000000",
+				splunkFormatKey:        format,
+				splunkLabelsRefreshKey: "10ms",
+				labelsKey:              "team",
+				envKey:                 "STAGE",
+				tagKey:                 "",
+			},
+			ContainerID:     "containeriid",
+			ContainerLabels: map[string]string{"team": "payments"},
+			ContainerEnv:    []string{"STAGE=prod"},
+		}
+		l, err := NewWithClient(info, &http.Client{Transport: stub})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Log(&logger.Message{Line: []byte("before"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the orchestrator moves the container to another team
+		mu.Lock()
+		labels = map[string]string{"team": "billing"}
+		mu.Unlock()
+		var splunkl *splunkLogger
+		switch wrapper := l.(type) {
+		case *splunkLoggerInline:
+			splunkl = wrapper.splunkLogger
+		case *splunkLoggerRaw:
+			splunkl = wrapper.splunkLogger
+		}
+		for deadline := time.Now().Add(5 * time.Second); splunkl.labels.latest.Load().(*resolvedAttrs).version == 0; {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: the labels were not refreshed", format)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if err := l.Log(&logger.Message{Line: []byte("after"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stub.mu.Lock()
+		if len(stub.messages) != 2 {
+			t.Fatalf("%s: expected 2 messages, got %d", format, len(stub.messages))
+		}
+		for i, team := range []string{"payments", "billing"} {
+			message := stub.messages[i]
+			if format == splunkFormatRaw {
+				event, err := message.EventAsString()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(event, "team="+team+" ") || !strings.Contains(event, "STAGE=prod ") {
+					t.Fatalf("%s: expected the event %d with team=%s, got %q", format, i, team, event)
+				}
+				continue
+			}
+			event, err := message.EventAsMap()
+			if err != nil {
+				t.Fatal(err)
+			}
+			attrs, _ := event["attrs"].(map[string]interface{})
+			if attrs["team"] != team || attrs["STAGE"] != "prod" {
+				t.Fatalf("%s: expected the event %d with team=%s, got %v", format, i, team, event["attrs"])
+			}
+		}
+		stub.mu.Unlock()
+	}
+}
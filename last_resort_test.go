@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestLastResortStderr(t *testing.T) {
+	// a dead HEC endpoint, and no dead-letter file
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stderr := os.Stderr
+	os.Stderr = w
+	lastResort = newLastResortWriter(os.Stderr)
+	defer func() {
+		os.Stderr = stderr
+		lastResort = nil
+	}()
+
+	hec := &hecClient{
+		client:                server.Client(),
+		url:                   server.URL,
+		shardKey:              "container",
+		postMessagesBatchSize: 10,
+		bufferMaximum:         10,
+	}
+	var messages []*splunkMessage
+	for i := 0; i < 3; i++ {
+		messages = append(messages, &splunkMessage{Event: strconv.Itoa(i)})
+	}
+	if remaining := hec.postMessages(messages, true); len(remaining) != 0 {
+		t.Fatalf("Expected the messages to be given up, %d remain", len(remaining))
+	}
+	w.Close()
+
+	var records []deadLetterRecord
+	for scanner := bufio.NewScanner(r); scanner.Scan(); {
+		var record deadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Unexpected line on stderr %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != len(messages) {
+		t.Fatalf("Expected %d events on stderr, got %d", len(messages), len(records))
+	}
+	for i, record := range records {
+		if record.ContainerID != "container" {
+			t.Fatalf("Unexpected container ID %s", record.ContainerID)
+		}
+		var event splunkMessage
+		if err := json.Unmarshal(record.Event, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Event != strconv.Itoa(i) {
+			t.Fatalf("Expected event %d, got %v", i, event.Event)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Lifecycle actions and the reasons they are logged for
+const (
+	lifecycleStart   = "start"
+	lifecycleStop    = "stop"
+	lifecycleRestart = "restart"
+	// the container opted out of forwarding, the reason is a localOnly* value
+	lifecycleOptOut = "opt_out"
+	// the local log or the splunk logger of the container could not be
+	// created, and was later, or the local log could not be written
+	lifecycleDegraded  = "degraded"
+	lifecycleRecovered = "recovered"
+	// forwarding of the container was paused from the admin socket, and
+	// later resumed
+	lifecyclePaused  = "paused"
+	lifecycleResumed = "resumed"
+
+	lifecycleReasonStartLogging = "start_logging"
+	lifecycleReasonStopLogging  = "stop_logging"
+	lifecycleReasonFifoReopen   = "fifo_reopen"
+	lifecycleReasonPanic        = "panic_recovery"
+
+	lifecycleReasonCacheUnavailable = "cache_unavailable"
+	lifecycleReasonCacheCreated     = "cache_created"
+	lifecycleReasonCacheWriteFailed = "cache_write_failed"
+	lifecycleReasonCacheWritable    = "cache_writable"
+	lifecycleReasonSplunkCreated    = "splunk_created"
+	lifecycleReasonAdmin            = "admin"
+)
+
+// lifecycleEvent tells when the plug-in started and stopped forwarding the
+// logs of a container
+type lifecycleEvent struct {
+	EventType     string            `json:"event_type"`
+	Action        string            `json:"action"`
+	Reason        string            `json:"reason"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image"`
+	Options       map[string]string `json:"options"`
+}
+
+// lifecycleLogger is implemented by loggers which report when forwarding
+// starts, stops and restarts
+type lifecycleLogger interface {
+	logLifecycle(action string, reason string) error
+}
+
+// newLifecycleEvent() returns nil unless SPLUNK_LIFECYCLE_EVENTS is enabled
+func newLifecycleEvent(info logger.Info) *lifecycleEvent {
+	if !getAdvancedOptionBool(envVarLifecycleEvents, false) {
+		return nil
+	}
+	return &lifecycleEvent{
+		EventType:     "logging_lifecycle",
+		ContainerID:   info.ContainerID,
+		ContainerName: info.Name(),
+		Image:         info.ContainerImageName,
+		Options:       redactedConfig(info.Config),
+	}
+}
+
+// logLifecycle() queues a lifecycle event to SPLUNK_LIFECYCLE_EVENTS_INDEX,
+// when lifecycle events are enabled
+func (l *splunkLogger) logLifecycle(action string, reason string) error {
+	if l.lifecycle == nil {
+		return nil
+	}
+	message := l.createSplunkMessage(&logger.Message{Timestamp: time.Now()})
+	event := *l.lifecycle
+	event.Action = action
+	event.Reason = reason
+	message.Event = &event
+	if index := getAdvancedOptionString(envVarLifecycleEventsIndex, ""); index != "" {
+		message.Index = index
+	}
+	tagPluginEvent(message, event.EventType)
+	return l.queueMessageAsync(message)
+}
+
+// logLifecycle() sends a lifecycle event through the splunk logger of lf
+func (lf *logPair) logLifecycle(action string, reason string) {
+	if l, ok := lf.splunkl.(lifecycleLogger); ok {
+		if err := l.logLifecycle(action, reason); err != nil {
+			driverLog.WithField("id", lf.info.ContainerID).WithField("action", action).WithError(err).Debug("Cannot log lifecycle event")
+		}
+	}
+}
+
+// logOptOut() sends the lifecycle event of a container which opted out of
+// forwarding, through a splunk logger created for that event only. The
+// returned channel is closed once the event was posted, it is nil when
+// lifecycle events are disabled.
+func logOptOut(info logger.Info, reason string) <-chan struct{} {
+	if !getAdvancedOptionBool(envVarLifecycleEvents, false) {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l, err := New(info)
+		if err != nil {
+			driverLog.WithField("id", info.ContainerID).WithError(err).Debug("Cannot log opt-out lifecycle event")
+			return
+		}
+		lf := &logPair{splunkl: l, info: info}
+		lf.logLifecycle(lifecycleOptOut, reason)
+		l.Close()
+	}()
+	return done
+}
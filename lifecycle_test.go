@@ -0,0 +1,136 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+func TestLifecycleEvents(t *testing.T) {
+	os.Setenv(envVarLifecycleEvents, "true")
+	os.Setenv(envVarLifecycleEventsIndex, "plugin")
+	defer func() {
+		os.Setenv(envVarLifecycleEvents, "")
+		os.Setenv(envVarLifecycleEventsIndex, "")
+	}()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+			splunkIndexKey: "containers",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageName: "container_image_name",
+	}
+	d := newDriver()
+	file := startTestLogging(t, d, dir, info)
+	if err := d.StopLogging(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stop event is flushed when StopLogging returns
+	if len(hec.messages) != 2 {
+		t.Fatalf("Expected # of messages %d, got %d", 2, len(hec.messages))
+	}
+	for i, action := range []string{lifecycleStart, lifecycleStop} {
+		message := hec.messages[i]
+		if message.Index != "plugin" || message.Fields[pluginEventField] != "logging_lifecycle" {
+			t.Fatalf("Unexpected index %s or fields %v", message.Index, message.Fields)
+		}
+		event, err := message.EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		options, _ := event["options"].(map[string]interface{})
+		if event["action"] != action ||
+			event["container_id"] != "containeriid" ||
+			event["container_name"] != "container_name" ||
+			event["image"] != "container_image_name" ||
+			options[splunkIndexKey] != "containers" ||
+			options[splunkTokenKey] != redactSecret(hec.token) {
+			t.Fatalf("Unexpected lifecycle event %v", event)
+		}
+	}
+}
+
+func TestLifecycleEventOnPanicRecovery(t *testing.T) {
+	os.Setenv(envVarLifecycleEvents, "true")
+	defer os.Setenv(envVarLifecycleEvents, "")
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "containeriid",
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	local := &panickingLogger{panicOn: "boom"}
+	lf := &logPair{sinks: []logger.Logger{splunkl, local}, jsonl: local, splunkl: splunkl, stream: r, info: info}
+	go func() {
+		enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte("boom")}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Error(err)
+		}
+		w.Close()
+	}()
+
+	messageProcessor{panicRetryNumber: 1}.process(lf)
+
+	// the line which made the local logger panic was sent before the panic
+	if len(hec.messages) != 2 {
+		t.Fatalf("Expected # of messages %d, got %d", 2, len(hec.messages))
+	}
+	event, err := hec.messages[1].EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["action"] != lifecycleRestart || event["reason"] != lifecycleReasonPanic {
+		t.Fatalf("Unexpected lifecycle event %v", event)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// parseCacheRequired() returns whether a container fails to start when its
+// local json log, which serves docker logs, cannot be created
+func parseCacheRequired(config map[string]string) (bool, error) {
+	requiredStr, ok := config[splunkCacheRequiredKey]
+	if !ok {
+		return true, nil
+	}
+	return strconv.ParseBool(requiredStr)
+}
+
+// cacheLogger is the local json log of a container which could not be
+// created, with splunk-cache-required=false. The lines are only forwarded
+// and docker logs is not supported until a retry creates the log.
+type cacheLogger struct {
+	containerID string
+	create      func() (logger.Logger, error)
+	// called once the local log is created
+	onCreated func()
+
+	mu    sync.Mutex
+	cache logger.Logger
+	// why the local log could not be created, nil once it is
+	err  error
+	stop chan struct{}
+}
+
+func newCacheLogger(containerID string, create func() (logger.Logger, error), err error) *cacheLogger {
+	return &cacheLogger{containerID: containerID, create: create, err: err, stop: make(chan struct{})}
+}
+
+// start() retries creating the local log every interval
+func (c *cacheLogger) start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if c.retry() {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// retry() tries to create the local log, it returns true once it exists
+func (c *cacheLogger) retry() bool {
+	cache, err := c.create()
+	c.mu.Lock()
+	select {
+	case <-c.stop:
+		// closed meanwhile
+		c.mu.Unlock()
+		if err == nil {
+			cache.Close()
+		}
+		return true
+	default:
+	}
+	if err != nil {
+		c.err = err
+		c.mu.Unlock()
+		driverLog.WithField("id", c.containerID).WithError(err).Debug("Cannot create the local log, retrying")
+		return false
+	}
+	c.cache, c.err = cache, nil
+	c.mu.Unlock()
+	driverLog.WithField("id", c.containerID).Info("Local log created, docker logs is supported again")
+	if c.onCreated != nil {
+		c.onCreated()
+	}
+	return true
+}
+
+// unavailable() returns why the local log could not be created, nil once
+// it is
+func (c *cacheLogger) unavailable() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *cacheLogger) Log(msg *logger.Message) error {
+	c.mu.Lock()
+	cache := c.cache
+	c.mu.Unlock()
+	if cache == nil {
+		return nil
+	}
+	return cache.Log(msg)
+}
+
+func (c *cacheLogger) Name() string {
+	return "json-file"
+}
+
+func (c *cacheLogger) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.stop:
+		return nil
+	default:
+	}
+	close(c.stop)
+	if c.cache != nil {
+		return c.cache.Close()
+	}
+	return nil
+}
+
+// ReadLogs() reads the local log once it is created
+func (c *cacheLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	c.mu.Lock()
+	cache := c.cache
+	err := c.err
+	c.mu.Unlock()
+	if reader, ok := cache.(logger.LogReader); ok {
+		return reader.ReadLogs(config)
+	}
+	watcher := logger.NewLogWatcher()
+	if err == nil {
+		err = logger.ErrReadLogsNotSupported
+	}
+	watcher.Err <- err
+	return watcher
+}
@@ -0,0 +1,313 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// How often rotated local json files are looked for
+const localCompressInterval = 10 * time.Second
+
+// Rotated files are moved out of the way of the json logger, which names
+// them <path>.1 to <path>.<max-file - 1>, under a name holding the time they
+// were picked up, then compressed to that name with a .gz suffix
+const localSegmentTimeFormat = "20060102T150405.000000000"
+
+var localSegmentPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}(\.gz)?$`)
+
+// jsonLogLine is an entry of the local json files
+type jsonLogLine struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// parseLocalCompress() returns whether rotated local json files are
+// compressed and how many rotated files are kept
+func parseLocalCompress(config map[string]string) (bool, int, error) {
+	compress := false
+	if compressStr, ok := config[splunkLocalCompressKey]; ok {
+		var err error
+		compress, err = strconv.ParseBool(compressStr)
+		if err != nil {
+			return false, 0, err
+		}
+	}
+	keep := 0
+	if maxFileStr, ok := config[localMaxFileKey]; ok {
+		maxFile, err := strconv.Atoi(maxFileStr)
+		if err != nil {
+			return false, 0, err
+		}
+		keep = maxFile - 1
+	}
+	return compress, keep, nil
+}
+
+// gzipRotatedLogger wraps the local json logger, compresses the files it
+// rotates in the background and reads them back for docker logs. The
+// compressed files take the place of the rotated ones, so at most keep of
+// them are kept.
+type gzipRotatedLogger struct {
+	logger.Logger
+
+	path string
+	keep int
+	// records the compressed files removed, may be nil
+	retention *localRetention
+
+	// serializes compression passes with listing the compressed files
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newGzipRotatedLogger(l logger.Logger, path string, keep int, retention *localRetention) *gzipRotatedLogger {
+	g := &gzipRotatedLogger{
+		Logger:    l,
+		path:      path,
+		keep:      keep,
+		retention: retention,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go g.run()
+	return g
+}
+
+func (g *gzipRotatedLogger) run() {
+	defer close(g.done)
+	ticker := time.NewTicker(localCompressInterval)
+	defer ticker.Stop()
+	for {
+		g.compressRotated(time.Now())
+		select {
+		case <-ticker.C:
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+// Close() compresses the files rotated since the last pass once the json
+// logger is closed
+func (g *gzipRotatedLogger) Close() error {
+	close(g.stop)
+	<-g.done
+	err := g.Logger.Close()
+	g.compressRotated(time.Now())
+	return err
+}
+
+// compressRotated() moves the rotated files out of the way of the json
+// logger, compresses them and removes the oldest compressed files
+func (g *gzipRotatedLogger) compressRotated(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// the highest number is the oldest file
+	for i, n := g.keep, 0; i >= 1; i-- {
+		name := fmt.Sprintf("%s.%d", g.path, i)
+		segment := fmt.Sprintf("%s.%s", g.path, now.Add(time.Duration(n)).UTC().Format(localSegmentTimeFormat))
+		if err := os.Rename(name, segment); err != nil {
+			if !os.IsNotExist(err) {
+				processorLog.WithField("path", name).WithError(err).Warn("Cannot pick up rotated log file")
+			}
+			continue
+		}
+		n++
+	}
+
+	segments, err := g.segments()
+	if err != nil {
+		processorLog.WithField("path", g.path).WithError(err).Warn("Cannot list rotated log files")
+		return
+	}
+	compressed := segments[:0]
+	for _, segment := range segments {
+		if !strings.HasSuffix(segment, ".gz") {
+			if err := compressFile(segment); err != nil {
+				processorLog.WithField("path", segment).WithError(err).Warn("Cannot compress rotated log file")
+				continue
+			}
+			segment += ".gz"
+		}
+		compressed = append(compressed, segment)
+	}
+	for len(compressed) > g.keep {
+		info, statErr := os.Stat(compressed[0])
+		if err := os.Remove(compressed[0]); err != nil && !os.IsNotExist(err) {
+			processorLog.WithField("path", compressed[0]).WithError(err).Warn("Cannot remove rotated log file")
+		} else if err == nil && statErr == nil {
+			g.retention.removed(info.ModTime())
+		}
+		compressed = compressed[1:]
+	}
+}
+
+// segments() returns the rotated files picked up so far, compressed or
+// not, oldest first
+func (g *gzipRotatedLogger) segments() ([]string, error) {
+	matches, err := filepath.Glob(g.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, match := range matches {
+		if localSegmentPattern.MatchString(strings.TrimPrefix(match, g.path+".")) {
+			segments = append(segments, match)
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// compressFile() replaces name with name.gz
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	// the compressed file keeps the time of the last write, for splunk-local-max-age
+	if info, statErr := src.Stat(); err == nil && statErr == nil {
+		os.Chtimes(name+".gz", info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// ReadLogs() reads the compressed files before the ones of the json logger.
+// The json logger applies tail to its own files only, so compressed files
+// are skipped when a tail is requested.
+func (g *gzipRotatedLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	w := logger.NewLogWatcher()
+	go func() {
+		defer close(w.Msg)
+		if config.Tail < 0 {
+			if err := g.readCompressed(w, config); err != nil {
+				w.Err <- err
+				return
+			}
+		}
+		lr, ok := g.Logger.(logger.LogReader)
+		if !ok {
+			return
+		}
+		watcher := lr.ReadLogs(config)
+		defer watcher.Close()
+		forwardLogs(w, watcher)
+	}()
+	return w
+}
+
+func (g *gzipRotatedLogger) readCompressed(w *logger.LogWatcher, config logger.ReadConfig) error {
+	// files are opened before a compression pass can remove them
+	g.mu.Lock()
+	segments, err := g.segments()
+	var files []*os.File
+	for _, segment := range segments {
+		if !strings.HasSuffix(segment, ".gz") {
+			continue
+		}
+		f, openErr := os.Open(segment)
+		if openErr != nil {
+			err = openErr
+			break
+		}
+		files = append(files, f)
+	}
+	g.mu.Unlock()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		r := bufio.NewReader(zr)
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) > 0 {
+				var entry jsonLogLine
+				if err := json.Unmarshal(line, &entry); err != nil {
+					return err
+				}
+				if config.Since.IsZero() || !entry.Time.Before(config.Since) {
+					msg := logger.NewMessage()
+					msg.Line = append(msg.Line, entry.Log...)
+					msg.Source = entry.Stream
+					msg.Timestamp = entry.Time
+					select {
+					case w.Msg <- msg:
+					case <-w.WatchClose():
+						return nil
+					}
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/jsonfilelog"
+)
+
+func writeRotatedLog(t *testing.T, name string, lines ...string) {
+	var content []byte
+	for _, line := range lines {
+		b, err := json.Marshal(jsonLogLine{Log: line + "\n", Stream: "stdout", Time: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(append(content, b...), '\n')
+	}
+	if err := ioutil.WriteFile(name, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGzipRotatedLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "container-json.log")
+	info := logger.Info{
+		Config:      map[string]string{splunkLocalCompressKey: "true", localMaxSizeKey: "1m", localMaxFileKey: "3"},
+		ContainerID: "containeriid",
+		LogPath:     path,
+	}
+	compress, keep, err := parseLocalCompress(info.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compress || keep != 2 {
+		t.Fatalf("Unexpected compress %v and keep %d", compress, keep)
+	}
+
+	// rotated by the json logger, the highest number is the oldest
+	writeRotatedLog(t, path+".2", "one")
+	writeRotatedLog(t, path+".1", "two")
+	jsonl, err := jsonfilelog.New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newGzipRotatedLogger(jsonl, path, keep, nil)
+	defer g.Close()
+	if err := g.Log(&logger.Message{Line: []byte("three"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	readAll := func() []string {
+		w := g.ReadLogs(logger.ReadConfig{Tail: -1})
+		defer w.Close()
+		var lines []string
+		for {
+			select {
+			case msg, ok := <-w.Msg:
+				if !ok {
+					return lines
+				}
+				lines = append(lines, strings.TrimSuffix(string(msg.Line), "\n"))
+			case err := <-w.Err:
+				t.Fatal(err)
+			}
+		}
+	}
+
+	g.compressRotated(time.Now())
+	for _, name := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be compressed, got %v", name, err)
+		}
+	}
+	segments, err := g.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || !strings.HasSuffix(segments[0], ".gz") || !strings.HasSuffix(segments[1], ".gz") {
+		t.Fatalf("Expected 2 compressed files, got %v", segments)
+	}
+	if lines := readAll(); strings.Join(lines, ",") != "one,two,three" {
+		t.Fatalf("Unexpected lines %v", lines)
+	}
+
+	// compressed files count towards max-file
+	writeRotatedLog(t, path+".1", "four")
+	g.compressRotated(time.Now())
+	if lines := readAll(); strings.Join(lines, ",") != "two,four,three" {
+		t.Fatalf("Unexpected lines %v", lines)
+	}
+}
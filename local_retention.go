@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// How often rotated local json files older than splunk-local-max-age are
+// looked for
+const localRetentionInterval = time.Minute
+
+// Rotated files which may expire, <path>.<n> from the json logger and the
+// files compressed by splunk-local-compress. Files being compressed are left
+// alone.
+var localExpiringPattern = regexp.MustCompile(`^(\d+|\d{8}T\d{6}\.\d{9}\.gz)$`)
+
+// localRetention is the time before which local logs were removed, as they
+// expired or as compressed files were rotated out. docker logs may miss the
+// entries written before it.
+type localRetention struct {
+	removedUntil int64
+}
+
+// removed() records the removal of a file last written at lastWrite
+func (r *localRetention) removed(lastWrite time.Time) {
+	if r == nil {
+		return
+	}
+	for {
+		until := atomic.LoadInt64(&r.removedUntil)
+		if lastWrite.UnixNano() <= until || atomic.CompareAndSwapInt64(&r.removedUntil, until, lastWrite.UnixNano()) {
+			return
+		}
+	}
+}
+
+// since() returns the time from which the local logs are complete, zero
+// when nothing was removed
+func (r *localRetention) since() time.Time {
+	if until := atomic.LoadInt64(&r.removedUntil); until != 0 {
+		return time.Unix(0, until)
+	}
+	return time.Time{}
+}
+
+// parseLocalMaxAge() returns the age after which rotated local json files
+// are removed, 0 when they are kept up to max-file
+func parseLocalMaxAge(config map[string]string) (time.Duration, error) {
+	maxAgeStr, ok := config[splunkLocalMaxAgeKey]
+	if !ok {
+		return 0, nil
+	}
+	maxAge, err := time.ParseDuration(maxAgeStr)
+	if err != nil {
+		return 0, err
+	}
+	if maxAge < 0 {
+		return 0, fmt.Errorf("%s: %s must not be negative", driverName, splunkLocalMaxAgeKey)
+	}
+	return maxAge, nil
+}
+
+// localRetentionLogger wraps the local json logger, removes the rotated
+// files last written more than maxAge ago, and tells docker logs when the
+// logs requested since a time older than the retention were removed
+type localRetentionLogger struct {
+	logger.Logger
+
+	path      string
+	maxAge    time.Duration
+	retention *localRetention
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newLocalRetentionLogger(l logger.Logger, path string, maxAge time.Duration, retention *localRetention) *localRetentionLogger {
+	r := &localRetentionLogger{
+		Logger:    l,
+		path:      path,
+		maxAge:    maxAge,
+		retention: retention,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if maxAge > 0 {
+		go r.run()
+	} else {
+		close(r.done)
+	}
+	return r
+}
+
+func (r *localRetentionLogger) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(localRetentionInterval)
+	defer ticker.Stop()
+	for {
+		r.removeExpired(time.Now())
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *localRetentionLogger) Close() error {
+	close(r.stop)
+	<-r.done
+	return r.Logger.Close()
+}
+
+// removeExpired() removes the rotated files last written more than maxAge
+// before now
+func (r *localRetentionLogger) removeExpired(now time.Time) {
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		processorLog.WithField("path", r.path).WithError(err).Warn("Cannot list rotated log files")
+		return
+	}
+	for _, match := range matches {
+		if !localExpiringPattern.MatchString(strings.TrimPrefix(match, r.path+".")) {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil || now.Sub(info.ModTime()) <= r.maxAge {
+			continue
+		}
+		if err := os.Remove(match); err != nil {
+			if !os.IsNotExist(err) {
+				processorLog.WithField("path", match).WithError(err).Warn("Cannot remove expired log file")
+			}
+			continue
+		}
+		r.retention.removed(info.ModTime())
+		processorLog.WithField("path", match).WithField("modified", info.ModTime()).WithField("maxAge", r.maxAge).Debug("Removed expired log file")
+	}
+}
+
+// ReadLogs() starts with a line on stderr when logs written after since
+// were removed, at the time from which the logs are complete
+func (r *localRetentionLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	watcher := r.Logger.(logger.LogReader).ReadLogs(config)
+	removedUntil := r.retention.since()
+	if config.Since.IsZero() || config.Tail >= 0 || removedUntil.IsZero() || !config.Since.Before(removedUntil) {
+		return watcher
+	}
+	w := logger.NewLogWatcher()
+	go func() {
+		defer close(w.Msg)
+		defer watcher.Close()
+		msg := logger.NewMessage()
+		msg.Line = append(msg.Line, fmt.Sprintf("%s: logs before %s were removed from the local log, the logs since %s are incomplete\n",
+			driverName, removedUntil.UTC().Format(time.RFC3339Nano), config.Since.UTC().Format(time.RFC3339Nano))...)
+		msg.Source = "stderr"
+		msg.Timestamp = removedUntil
+		select {
+		case w.Msg <- msg:
+		case <-w.WatchClose():
+			return
+		}
+		forwardLogs(w, watcher)
+	}()
+	return w
+}
+
+// forwardLogs() sends the messages and the error of watcher to w until
+// watcher ends or w is closed
+func forwardLogs(w *logger.LogWatcher, watcher *logger.LogWatcher) {
+	for {
+		select {
+		case msg, ok := <-watcher.Msg:
+			if !ok {
+				return
+			}
+			select {
+			case w.Msg <- msg:
+			case <-w.WatchClose():
+				return
+			}
+		case err := <-watcher.Err:
+			w.Err <- err
+			return
+		case <-w.WatchClose():
+			return
+		}
+	}
+}
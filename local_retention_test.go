@@ -0,0 +1,110 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/jsonfilelog"
+)
+
+func TestLocalRetentionTruncation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "container-json.log")
+	info := logger.Info{
+		Config:      map[string]string{splunkLocalMaxAgeKey: "1h"},
+		ContainerID: "containeriid",
+		LogPath:     path,
+	}
+	maxAge, err := parseLocalMaxAge(info.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a rotated file last written two hours ago has expired
+	expired := time.Now().Add(-2 * time.Hour)
+	writeRotatedLog(t, path+".1", "old")
+	if err := os.Chtimes(path+".1", expired, expired); err != nil {
+		t.Fatal(err)
+	}
+	jsonl, err := jsonfilelog.New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newLocalRetentionLogger(jsonl, path, maxAge, &localRetention{})
+	defer r.Close()
+	if err := r.Log(&logger.Message{Line: []byte("recent"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); r.retention.since().IsZero(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the expired file to be removed")
+		}
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the expired file to be removed, got %v", err)
+	}
+
+	readAll := func(since time.Time) []*logger.Message {
+		w := r.ReadLogs(logger.ReadConfig{Since: since, Tail: -1})
+		defer w.Close()
+		var messages []*logger.Message
+		for {
+			select {
+			case msg, ok := <-w.Msg:
+				if !ok {
+					return messages
+				}
+				messages = append(messages, msg)
+			case err := <-w.Err:
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// since predates the removed file, the output tells it is incomplete
+	messages := readAll(time.Now().Add(-3 * time.Hour))
+	if len(messages) != 2 {
+		t.Fatalf("Expected the truncation line and 1 message, got %d", len(messages))
+	}
+	if messages[0].Source != "stderr" || !strings.Contains(string(messages[0].Line), "incomplete") {
+		t.Fatalf("Expected the truncation line on stderr, got %s %q", messages[0].Source, messages[0].Line)
+	}
+	if !messages[0].Timestamp.Equal(r.retention.since()) {
+		t.Fatalf("Expected the truncation line at %s, got %s", r.retention.since(), messages[0].Timestamp)
+	}
+	if string(messages[1].Line) != "recent\n" {
+		t.Fatalf("Unexpected message %q", messages[1].Line)
+	}
+
+	// the logs since an hour ago are complete
+	messages = readAll(time.Now().Add(-time.Hour))
+	if len(messages) != 1 || string(messages[0].Line) != "recent\n" {
+		t.Fatalf("Expected only the message, got %d messages", len(messages))
+	}
+}
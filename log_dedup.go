@@ -0,0 +1,109 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// logDeduper logs an error once per interval for every key. The identical
+// errors of the interval are only counted, and logged once in a summary
+// when the interval is over, so that an unreachable HEC does not flood the
+// plugin log with the same line for every retry of every container.
+type logDeduper struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dedupedLog
+}
+
+type dedupedLog struct {
+	entry   *logrus.Entry
+	message string
+	// when the error was last logged
+	since      time.Time
+	suppressed int
+}
+
+// sendErrorLog dedupes the send failures of the containers
+var sendErrorLog = newLogDeduper(defaultSendErrorLogInterval)
+
+func newLogDeduper(interval time.Duration) *logDeduper {
+	return &logDeduper{interval: interval, entries: make(map[string]*dedupedLog)}
+}
+
+// error() logs the entry, unless an error with the same key was logged less
+// than the interval ago. Every error is logged when the interval is 0.
+func (d *logDeduper) error(key string, entry *logrus.Entry, message string, now time.Time) {
+	if d.interval <= 0 {
+		entry.Error(message)
+		return
+	}
+	d.mu.Lock()
+	e, ok := d.entries[key]
+	if ok && now.Sub(e.since) < d.interval {
+		e.suppressed++
+		d.mu.Unlock()
+		return
+	}
+	d.entries[key] = &dedupedLog{entry: entry, message: message, since: now}
+	d.mu.Unlock()
+	if ok {
+		e.summarize(d.interval)
+	}
+	entry.Error(message)
+}
+
+// summarize() logs the errors suppressed during the intervals which are
+// over, and forgets their keys
+func (d *logDeduper) summarize(now time.Time) {
+	d.mu.Lock()
+	var over []*dedupedLog
+	for key, e := range d.entries {
+		if now.Sub(e.since) >= d.interval {
+			over = append(over, e)
+			delete(d.entries, key)
+		}
+	}
+	d.mu.Unlock()
+	for _, e := range over {
+		e.summarize(d.interval)
+	}
+}
+
+func (e *dedupedLog) summarize(interval time.Duration) {
+	if e.suppressed > 0 {
+		e.entry.WithField("suppressed", e.suppressed).WithField("interval", interval).Error(e.message + ", identical errors were suppressed")
+	}
+}
+
+// start() summarizes the suppressed errors every interval
+func (d *logDeduper) start() {
+	if d.interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			d.summarize(now)
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestLogDeduper(t *testing.T) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.Out = &out
+	log.Formatter = &logrus.JSONFormatter{}
+	entries := func() []map[string]interface{} {
+		var logged []map[string]interface{}
+		for dec := json.NewDecoder(bytes.NewReader(out.Bytes())); dec.More(); {
+			var entry map[string]interface{}
+			if err := dec.Decode(&entry); err != nil {
+				t.Fatal(err)
+			}
+			logged = append(logged, entry)
+		}
+		out.Reset()
+		return logged
+	}
+
+	d := newLogDeduper(time.Minute)
+	start := time.Now()
+	refused := errors.New("connection refused")
+	sendError := func(id string, err error, at time.Duration) {
+		d.error(id+"\x00"+err.Error(), log.WithField("id", id).WithError(err), "Failed to send messages", start.Add(at))
+	}
+
+	// HEC is down, every retry of the container fails the same way
+	for i := 0; i < 5; i++ {
+		sendError("containeriid", refused, time.Duration(i)*time.Second)
+	}
+	// other errors and other containers are logged on their own
+	sendError("containeriid", errors.New("timeout"), 5*time.Second)
+	sendError("otheriid", refused, 5*time.Second)
+	logged := entries()
+	if len(logged) != 3 {
+		t.Fatalf("Expected 3 entries, got %v", logged)
+	}
+	if logged[0]["id"] != "containeriid" || logged[0]["error"] != "connection refused" || logged[0]["msg"] != "Failed to send messages" {
+		t.Fatalf("Unexpected entry %v", logged[0])
+	}
+
+	d.summarize(start.Add(time.Minute))
+	logged = entries()
+	if len(logged) != 1 || logged[0]["id"] != "containeriid" || logged[0]["error"] != "connection refused" || logged[0]["suppressed"] != float64(4) {
+		t.Fatalf("Expected a single summary of the 4 suppressed errors, got %v", logged)
+	}
+
+	// a new interval starts with the next failure
+	sendError("containeriid", refused, time.Minute+time.Second)
+	sendError("containeriid", refused, time.Minute+2*time.Second)
+	if logged = entries(); len(logged) != 1 || logged[0]["suppressed"] != nil {
+		t.Fatalf("Expected the failure to be logged again, got %v", logged)
+	}
+	d.summarize(start.Add(10 * time.Minute))
+	if logged = entries(); len(logged) != 1 || logged[0]["suppressed"] != float64(1) {
+		t.Fatalf("Expected a summary of the suppressed error, got %v", logged)
+	}
+	// without errors to summarize, nothing is logged
+	d.summarize(start.Add(20 * time.Minute))
+	if logged = entries(); len(logged) != 0 {
+		t.Fatalf("Expected no summary, got %v", logged)
+	}
+}
+
+func TestLogDeduperDisabled(t *testing.T) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.Out = &out
+	d := newLogDeduper(0)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		d.error("key", log.WithField("id", "containeriid"), "Failed to send messages", now)
+	}
+	if n := bytes.Count(out.Bytes(), []byte("Failed to send messages")); n != 3 {
+		t.Fatalf("Expected every error to be logged, got %d", n)
+	}
+}
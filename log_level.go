@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// logLevelController changes the plugin log level at runtime. Debug
+// logging reverts to the base level once its TTL expires, so a host is
+// not left in debug forever.
+type logLevelController struct {
+	mu sync.Mutex
+	// level to return to when debug logging is turned off
+	base   logrus.Level
+	revert *time.Timer
+	// TTL of debug logging when none is given, 0 keeps debug until changed
+	debugTTL time.Duration
+	// applies the level, logrus.SetLevel outside of tests
+	setLevel func(logrus.Level)
+	getLevel func() logrus.Level
+}
+
+var logLevel = newLogLevelController(logrus.SetLevel, logrus.GetLevel)
+
+func newLogLevelController(setLevel func(logrus.Level), getLevel func() logrus.Level) *logLevelController {
+	return &logLevelController{
+		base:     logrus.InfoLevel,
+		setLevel: setLevel,
+		getLevel: getLevel,
+	}
+}
+
+func parseLogLevel(name string) (logrus.Level, error) {
+	level, ok := logLevels[name]
+	if !ok {
+		return level, fmt.Errorf("invalid log level: %s", name)
+	}
+	return level, nil
+}
+
+// set() changes the log level. When the level is debug and ttl is positive
+// the base level is restored after ttl.
+func (c *logLevelController) set(level logrus.Level, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.revert != nil {
+		c.revert.Stop()
+		c.revert = nil
+	}
+	if level != logrus.DebugLevel {
+		c.base = level
+	}
+	c.setLevel(level)
+	driverLog.WithField("level", level.String()).WithField("ttl", ttl).Info("Log level changed")
+
+	if level == logrus.DebugLevel && ttl > 0 {
+		var revert *time.Timer
+		revert = time.AfterFunc(ttl, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// the level was changed again since this timer was set
+			if c.revert != revert {
+				return
+			}
+			c.revert = nil
+			c.setLevel(c.base)
+			driverLog.WithField("level", c.base.String()).Info("Debug logging expired, log level restored")
+		})
+		c.revert = revert
+	}
+}
+
+// toggle() switches between debug and the base level, on SIGUSR2
+func (c *logLevelController) toggle() {
+	c.mu.Lock()
+	level := logrus.DebugLevel
+	if c.getLevel() == logrus.DebugLevel {
+		level = c.base
+	}
+	ttl := c.debugTTL
+	c.mu.Unlock()
+	c.set(level, ttl)
+}
+
+func (c *logLevelController) level() logrus.Level {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getLevel()
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type testLevel struct {
+	mu    sync.Mutex
+	level logrus.Level
+}
+
+func (l *testLevel) set(level logrus.Level) {
+	l.mu.Lock()
+	l.level = level
+	l.mu.Unlock()
+}
+
+func (l *testLevel) get() logrus.Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+func newTestLogLevelController() (*logLevelController, *testLevel) {
+	current := &testLevel{level: logrus.InfoLevel}
+	return newLogLevelController(current.set, current.get), current
+}
+
+func TestLogLevelToggle(t *testing.T) {
+	c, current := newTestLogLevelController()
+	c.set(logrus.WarnLevel, 0)
+
+	c.toggle()
+	if current.get() != logrus.DebugLevel {
+		t.Fatalf("Expected debug level, got %s", current.get())
+	}
+	c.toggle()
+	if current.get() != logrus.WarnLevel {
+		t.Fatalf("Expected warn level to be restored, got %s", current.get())
+	}
+}
+
+func TestLogLevelDebugTTL(t *testing.T) {
+	c, current := newTestLogLevelController()
+	c.set(logrus.DebugLevel, 50*time.Millisecond)
+	if current.get() != logrus.DebugLevel {
+		t.Fatalf("Expected debug level, got %s", current.get())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for current.get() != logrus.InfoLevel {
+		if time.Now().After(deadline) {
+			t.Fatal("Debug level did not expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a later change cancels the pending revert
+	c.set(logrus.DebugLevel, 50*time.Millisecond)
+	c.set(logrus.DebugLevel, 0)
+	time.Sleep(100 * time.Millisecond)
+	if current.get() != logrus.DebugLevel {
+		t.Fatalf("Expected debug level to be kept, got %s", current.get())
+	}
+
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Fatal("Expecting error on invalid level")
+	}
+}
@@ -17,8 +17,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/sdk"
@@ -33,6 +37,14 @@ var logLevels = map[string]logrus.Level{
 	"error": logrus.ErrorLevel,
 }
 
+// notifySignals() relays the signals to the channel, nothing when the
+// platform has none of them
+func notifySignals(c chan<- os.Signal, signals ...os.Signal) {
+	if len(signals) > 0 {
+		signal.Notify(c, signals...)
+	}
+}
+
 func main() {
 	levelVal := os.Getenv("LOG_LEVEL")
 	if levelVal == "" {
@@ -40,14 +52,151 @@ func main() {
 	}
 	if level, exists := logLevels[levelVal]; exists {
 		logrus.SetLevel(level)
+		if level != logrus.DebugLevel {
+			logLevel.base = level
+		}
 	} else {
 		fmt.Fprintln(os.Stderr, "invalid log level: ", levelVal)
 		os.Exit(1)
 	}
 
+	formatter, err := newInternalLogFormatter(os.Getenv(envVarInternalLogFormat))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	logrus.SetFormatter(formatter)
+
+	selfTestFlag := flag.Bool("selftest", false, "check that the plugin defaults deliver an event to HEC, print the report and exit")
+	selfTestID := flag.String("selftest-id", "", "correlation ID of the self-test event, random when empty")
+	selfTestTimeout := flag.Duration("selftest-timeout", defaultSelfTestTimeout, "time allowed for the self-test")
+	flag.Parse()
+	if *selfTestFlag {
+		if path := os.Getenv(envVarDefaultsFile); path != "" {
+			hostDefaults = newDefaultsFile(path)
+			hostDefaults.reload()
+		}
+		report := runSelfTest(*selfTestID, *selfTestTimeout)
+		report.writeTo(os.Stdout)
+		if !report.Passed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	logLevel.debugTTL = getAdvancedOptionDuration(envVarDebugTTL, defaultDebugTTL)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		notifySignals(signals, debugLevelSignals...)
+		for range signals {
+			logLevel.toggle()
+		}
+	}()
+
+	debugLog := newLogRingBuffer(getAdvancedOptionInt(envVarDebugLogLines, defaultDebugLogLines))
+	logrus.AddHook(debugLog)
+
+	var selfLog *selfLogFile
+	if path := os.Getenv(envVarSelfLogFile); path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(selfLogDir, path)
+		}
+		selfLog, err = newSelfLogFile(path,
+			int64(getAdvancedOptionInt(envVarSelfLogMaxSizeMB, defaultSelfLogMaxSizeMB))*1024*1024,
+			getAdvancedOptionInt(envVarSelfLogMaxFiles, defaultSelfLogMaxFiles))
+		if err != nil {
+			driverLog.WithError(err).WithField("file", path).Error("Cannot open the self log file")
+		} else {
+			logrus.AddHook(selfLog)
+		}
+	}
+
+	if path := os.Getenv(envVarDeadLetterFile); path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(selfLogDir, path)
+		}
+		deadLetters, err = newDeadLetterFile(path, int64(getAdvancedOptionInt(envVarDeadLetterMaxSizeMB, defaultDeadLetterMaxSizeMB))*1024*1024)
+		if err != nil {
+			driverLog.WithError(err).WithField("file", path).Error("Cannot open the dead-letter file")
+		}
+	}
+
+	if getAdvancedOptionBool(envVarLastResortStderr, defaultLastResortStderr) {
+		lastResort = newLastResortWriter(os.Stderr)
+	}
+
+	metrics.configureBuckets()
+	if workers := senderWorkerCount(); workers > 0 {
+		senderWorkers = newSenderPool(workers)
+	}
+	if maxBytes := getAdvancedOptionInt(envVarCacheTotalMaxBytes, defaultCacheTotalMaxBytes); maxBytes > 0 {
+		cacheBudget = newCacheAccountant(int64(maxBytes))
+		cacheBudget.start(cacheBudgetInterval)
+	}
+	if maxInflight := getAdvancedOptionInt(envVarMaxInflight, defaultMaxInflight); maxInflight > 0 {
+		inflightRequests = newRequestLimit(maxInflight)
+	}
+	health.maxDropPercent = float64(getAdvancedOptionInt(envVarHealthMaxDropPercent, defaultHealthMaxDropPercent))
+	health.start(getAdvancedOptionDuration(envVarHealthInterval, defaultHealthInterval))
+	startStatsReporter(getAdvancedOptionDuration(envVarStatsInterval, defaultStatsInterval))
+	sendErrorLog = newLogDeduper(getAdvancedOptionDuration(envVarSendErrorLogInterval, defaultSendErrorLogInterval))
+	sendErrorLog.start()
+	startHeartbeats()
+
+	d := newDriver()
+	if path := os.Getenv(envVarDefaultsFile); path != "" {
+		hostDefaults = newDefaultsFile(path)
+		hostDefaults.reload()
+		hostDefaults.onReload = d.reloadDefaults
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		go hostDefaults.watch(defaultsFilePollInterval, signals)
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		notifySignals(signals, stateDumpSignals...)
+		flushTimeout := getAdvancedOptionDuration(envVarSignalFlushTimeout, defaultSignalFlushTimeout)
+		for range signals {
+			d.handleDebugSignal(stateDumpDir, flushTimeout)
+		}
+	}()
+	if t := newTelemetry(d); t != nil {
+		t.start()
+	}
+	if adminSocket := getAdvancedOptionString(envVarAdminSocket, defaultAdminSocket); adminSocket != "" {
+		admin := newAdminServer(d, debugLog, os.Getenv(envVarAdminToken))
+		admin.selfLog = selfLog
+		go func() {
+			if err := admin.serveUnix(adminSocket); err != nil {
+				driverLog.WithError(err).Error("Admin socket stopped")
+			}
+		}()
+	}
+
+	var servers []*httpServer
+	if metricsAddr := os.Getenv(envVarMetricsAddr); metricsAddr != "" {
+		servers = append(servers, startMetricsServer(metricsAddr, getAdvancedOptionInt(envVarMetricsMaxContainers, defaultMetricsMaxContainers)))
+	}
+	if pprofAddr := os.Getenv(envVarPprofAddr); pprofAddr != "" {
+		servers = append(servers, startPprofServer(pprofAddr,
+			getAdvancedOptionInt(envVarPprofMutexFraction, defaultPprofMutexFraction),
+			getAdvancedOptionInt(envVarPprofBlockRate, defaultPprofBlockRate)))
+	}
+	if len(servers) > 0 {
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+			<-signals
+			for _, server := range servers {
+				server.shutdown()
+			}
+			os.Exit(0)
+		}()
+	}
+
 	h := sdk.NewHandler(`{"Implements": ["LoggingDriver"]}`)
-	handlers(&h, newDriver())
-	if err := h.ServeUnix(socketAddress, 0); err != nil {
+	handlers(&h, d)
+	if err := serve(h); err != nil {
 		panic(err)
 	}
 }
@@ -19,13 +19,16 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"os"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/api/types/plugins/logdriver"
 	"github.com/docker/docker/daemon/logger"
 	protoio "github.com/gogo/protobuf/io"
@@ -33,70 +36,216 @@ import (
 
 type messageProcessor struct {
 	retryNumber int
+	// Number of times reading restarts after a panic, -1 means forever
+	panicRetryNumber int
+	// How long an incomplete reassembly waits for its next fragment, 0 means forever
+	partialTimeout time.Duration
+	// the container writes gzip, decompressed before processing
+	inputGzip bool
+	// How far from now timestamps are kept, 0 means only zero timestamps are replaced
+	maxTimeSkew time.Duration
+	// \r\n ends lines like \n, and a bare \r ends lines too
+	normalizeNewlines bool
+	// ANSI escape sequences are removed from the lines
+	stripANSI bool
+}
+
+// Reasons for the end of a log stream, sent in container_exited events
+const (
+	exitReasonStreamClosed   = "stream_closed"
+	exitReasonLoggingStopped = "logging_stopped"
+	exitReasonReadError      = "read_error"
+	exitReasonPanic          = "panic"
+)
+
+// containerExitLogger is implemented by loggers which report the end of
+// the log stream of a container
+type containerExitLogger interface {
+	logContainerExit(reason string) error
 }
 
 func (mg messageProcessor) process(lf *logPair) {
-	logrus.Debug("Start to consume log")
-	mg.consumeLog(lf)
+	processorLog.Debug("Start to consume log")
+	defer lf.Close()
+	// runs before the deferred Close, a Close of the driver waits for it
+	defer func() {
+		if lf.processing != nil {
+			close(lf.processing)
+		}
+	}()
+	reason := exitReasonPanic
+	for restarts := 0; ; restarts++ {
+		var panicked bool
+		reason, panicked = mg.consumeLogRecovered(lf)
+		if !panicked {
+			break
+		}
+		atomic.AddUint64(&metrics.processorPanics, 1)
+		if mg.panicRetryNumber != -1 && restarts >= mg.panicRetryNumber {
+			processorLog.WithField("id", lf.info.ContainerID).WithField("panicRetryNumber", mg.panicRetryNumber).Error("Stop restarting after panic. Shutting down loggers")
+			break
+		}
+		lf.logLifecycle(lifecycleRestart, lifecycleReasonPanic)
+	}
+	// the exit event is queued before the loggers are closed, so it is sent
+	// with the last messages of the container
+	if exitLogger, ok := lf.splunkl.(containerExitLogger); ok {
+		if err := exitLogger.logContainerExit(reason); err != nil {
+			processorLog.WithField("id", lf.info.ContainerID).WithError(err).Debug("Cannot log container exit")
+		}
+	}
+}
+
+// consumeLogRecovered() runs consumeLog and returns true if it panicked
+func (mg messageProcessor) consumeLogRecovered(lf *logPair) (reason string, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			processorLog.WithField("id", lf.info.ContainerID).WithField("panic", fmt.Sprint(r)).WithField("stack", string(debug.Stack())).Error("Recovered from panic while processing logs, restarting")
+			reason = exitReasonPanic
+			panicked = true
+		}
+	}()
+	return mg.consumeLog(lf), false
 }
 
 /*
 This is a routine to decode the log stream into LogEntry and store it in buffer
-and send the buffer to splunk logger and json logger
+and send the buffer to splunk logger and json logger. It returns why the stream
+ended.
 */
-func (mg messageProcessor) consumeLog(lf *logPair) {
+func (mg messageProcessor) consumeLog(lf *logPair) string {
 	// Initialize temp buffer
 	tmpBuf := &partialMsgBuffer{
 		bufferTimer: time.Now(),
 	}
 	// create a protobuf reader for the log stream
-	dec := protoio.NewUint32DelimitedReader(lf.stream, binary.BigEndian, 1e6)
-	defer dec.Close()
-	defer lf.Close()
+	// the stream is closed with the loggers by process()
+	dec := mg.newEntryReader(lf)
 	// a temp buffer for each log entry
 	var buf logdriver.LogEntry
 	curRetryNumber := 0
+
+	// the partial timer flushes an incomplete reassembly from its own
+	// goroutine, mu guards the temp buffer against it
+	var (
+		mu           sync.Mutex
+		partialTimer *time.Timer
+		stopped      bool
+	)
+	defer func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+	}()
 	for {
 		// reads a message from the log stream and put it in a buffer
 		if err := dec.ReadMsg(&buf); err != nil {
 			// exit the loop if reader reaches EOF or the fifo is closed by the writer
 			if err == io.EOF || err == os.ErrClosed || strings.Contains(err.Error(), "file already closed") {
-				logrus.WithField("id", lf.info.ContainerID).WithError(err).Info("shutting down loggers")
-				return
+				processorLog.WithField("id", lf.info.ContainerID).WithError(err).Info("shutting down loggers")
+				if err == io.EOF {
+					return exitReasonStreamClosed
+				}
+				return exitReasonLoggingStopped
 			}
 
 			// exit the loop if retry number reaches the specified number
 			if mg.retryNumber != -1 && curRetryNumber > mg.retryNumber {
-				logrus.WithField("id", lf.info.ContainerID).WithField("curRetryNumber", curRetryNumber).WithField("retryNumber", mg.retryNumber).WithError(err).Error("Stop retrying. Shutting down loggers")
-				return
+				processorLog.WithField("id", lf.info.ContainerID).WithField("curRetryNumber", curRetryNumber).WithField("retryNumber", mg.retryNumber).WithError(err).Error("Stop retrying. Shutting down loggers")
+				return exitReasonReadError
 			}
 
 			// if there is any other error, retry for robustness. If retryNumber is -1, retry forever
 			curRetryNumber++
-			logrus.WithField("id", lf.info.ContainerID).WithField("curRetryNumber", curRetryNumber).WithField("retryNumber", mg.retryNumber).WithError(err).Error("Encountered error and retrying")
+			processorLog.WithField("id", lf.info.ContainerID).WithField("curRetryNumber", curRetryNumber).WithField("retryNumber", mg.retryNumber).WithError(err).Error("Encountered error and retrying")
 			time.Sleep(500 * time.Millisecond)
-			dec = protoio.NewUint32DelimitedReader(lf.stream, binary.BigEndian, 1e6)
+			dec = mg.newEntryReader(lf)
+			lf.logLifecycle(lifecycleRestart, lifecycleReasonFifoReopen)
 		}
 		curRetryNumber = 0
 
-		if mg.shouldSendMessage(buf.Line) {
-			if tmpBuf.tBuf.Len() == 0 {
-				logrus.Debug("First messaging, reseting timer")
-				tmpBuf.bufferTimer = time.Now()
+		// unlocked by a deferred call, in case a logger panics
+		func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if partialTimer != nil {
+				partialTimer.Stop()
 			}
-			// Append to temp buffer
-			if err := tmpBuf.append(&buf); err == nil {
-				// Send message to splunk and json logger
-				mg.sendMessage(lf.splunkl, &buf, tmpBuf, lf.info.ContainerID)
-				mg.sendMessage(lf.jsonl, &buf, tmpBuf, lf.info.ContainerID)
-				//temp buffer and values reset
-				tmpBuf.reset()
+			if mg.shouldSendMessage(buf.Line) {
+				// before anything depends on the timestamp, the
+				// reassembly has the timestamp of its last fragment
+				tmpBuf.originalTime = mg.correctTime(&buf, time.Now(), lf.info.ContainerID)
+				if tmpBuf.tBuf.Len() == 0 {
+					processorLog.Debug("First messaging, reseting timer")
+					tmpBuf.bufferTimer = time.Now()
+				}
+				// Append to temp buffer
+				if err := tmpBuf.append(&buf); err == nil {
+					if mg.normalizeNewlines {
+						mg.sendCarriageReturnLines(lf, &buf, tmpBuf)
+					}
+					// Send message to every sink
+					for _, l := range lf.sinks {
+						mg.sendMessage(l, &buf, tmpBuf, lf.info.ContainerID)
+					}
+					//temp buffer and values reset
+					tmpBuf.reset()
+				}
 			}
-		}
+			if mg.partialTimeout > 0 && tmpBuf.tBuf.Len() > 0 {
+				source, timeNano := buf.Source, buf.TimeNano
+				partialTimer = time.AfterFunc(mg.partialTimeout, func() {
+					mu.Lock()
+					defer mu.Unlock()
+					if !stopped {
+						mg.flushIncomplete(lf, tmpBuf, source, timeNano)
+					}
+				})
+			}
+		}()
 		buf.Reset()
 	}
 }
 
+// newEntryReader() returns a reader of the log entries of the stream,
+// decompressed with splunk-input-gzip
+func (mg messageProcessor) newEntryReader(lf *logPair) entryReader {
+	dec := protoio.NewUint32DelimitedReader(lf.stream, binary.BigEndian, 1e6)
+	if mg.inputGzip {
+		return newGzipEntryReader(dec, lf.info.ContainerID)
+	}
+	return dec
+}
+
+// flushIncomplete() sends a reassembly which did not receive its last
+// fragment in time, marked as partial
+func (mg messageProcessor) flushIncomplete(lf *logPair, t *partialMsgBuffer, source string, timeNano int64) {
+	if t.tBuf.Len() == 0 {
+		return
+	}
+	processorLog.WithField("id", lf.info.ContainerID).WithField("size", t.tBuf.Len()).WithField("partialTimeout", mg.partialTimeout).Debug("Flushing incomplete partial message")
+	line := t.tBuf.Bytes()
+	if mg.normalizeNewlines {
+		line = trimCarriageReturn(line)
+	}
+	if mg.stripANSI {
+		line = stripANSI(line)
+	}
+	for _, l := range lf.sinks {
+		// loggers may recycle the message, each one gets its own
+		msg := logger.Message{
+			Line:      line,
+			Source:    source,
+			Partial:   true,
+			Timestamp: time.Unix(0, timeNano),
+			Attrs:     t.attrs(),
+		}
+		logToSink(l, &msg, lf.info.ContainerID)
+	}
+	t.bufferReset = true
+	t.reset()
+}
+
 // send the log entry message to logger
 func (mg messageProcessor) sendMessage(l logger.Logger, buf *logdriver.LogEntry, t *partialMsgBuffer, containerid string) {
 	var msg logger.Message
@@ -104,31 +253,55 @@ func (mg messageProcessor) sendMessage(l logger.Logger, buf *logdriver.LogEntry,
 	// Check for temp buffer timer expiration
 	if !buf.Partial || t.shouldFlush(time.Now()) {
 		msg.Line = t.tBuf.Bytes()
+		if mg.normalizeNewlines {
+			msg.Line = trimCarriageReturn(msg.Line)
+			if len(bytes.TrimSpace(msg.Line)) == 0 {
+				// only the end of a line sent already
+				t.bufferReset = true
+				return
+			}
+		}
+		if mg.stripANSI {
+			msg.Line = stripANSI(msg.Line)
+			if len(bytes.TrimSpace(msg.Line)) == 0 {
+				// only escape sequences
+				t.bufferReset = true
+				return
+			}
+		}
 		msg.Source = buf.Source
 		msg.Partial = buf.Partial
 		msg.Timestamp = time.Unix(0, buf.TimeNano)
+		msg.Attrs = t.attrs()
 
-		if err := l.Log(&msg); err != nil {
-			logrus.WithField("id", containerid).WithError(err).WithField("message",
-				msg).Error("Error writing log message")
-		}
+		logToSink(l, &msg, containerid)
 		t.bufferReset = true
 	}
 }
 
+// logToSink() hands a message to one sink of a container. An error of the
+// sink is only logged: the other sinks, and forwarding to Splunk, get the
+// message whatever happens to the local log.
+func logToSink(l logger.Logger, msg *logger.Message, containerID string) {
+	if err := l.Log(msg); err != nil {
+		processorLog.WithField("id", containerID).WithField("logger", l.Name()).WithError(err).WithField("message",
+			*msg).Error("Error writing log message")
+	}
+}
+
 // shouldSendMessage() returns a boolean indicating
 // if the message should be sent to Splunk
 func (mg messageProcessor) shouldSendMessage(message []byte) bool {
 	trimedLine := bytes.Fields(message)
 	if len(trimedLine) == 0 {
-		logrus.Info("Ignoring empty string")
+		processorLog.Info("Ignoring empty string")
 		return false
 	}
 
 	// even if the message byte array is not a valid utf8 string
 	// we are still sending the message to splunk
 	if !utf8.Valid(message) {
-		logrus.Warnf("%v is not UTF-8 decodable", message)
+		processorLog.WithField("message", fmt.Sprintf("%q", message)).Warn("Message is not UTF-8 decodable")
 		return true
 	}
 	return true
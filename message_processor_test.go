@@ -16,7 +16,22 @@
 
 package main
 
-import "testing"
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	protoio "github.com/gogo/protobuf/io"
+)
 
 func TestShouldSendMessage(t *testing.T) {
 	mg := &messageProcessor{}
@@ -69,3 +84,448 @@ func TestShouldSendMessage(t *testing.T) {
 		t.Fatalf("%s is non utf8 decodable, but the event should still be sent", test)
 	}
 }
+
+// panickingLogger panics on messages matching the line panicOn
+type panickingLogger struct {
+	panicOn string
+	lines   []string
+	closed  bool
+}
+
+func (l *panickingLogger) Log(msg *logger.Message) error {
+	if string(msg.Line) == l.panicOn {
+		panic("crafted message")
+	}
+	l.lines = append(l.lines, string(msg.Line))
+	return nil
+}
+
+func (l *panickingLogger) Name() string {
+	return "panicking"
+}
+
+func (l *panickingLogger) Close() error {
+	l.closed = true
+	return nil
+}
+
+func TestProcessRecoversFromPanic(t *testing.T) {
+	r, w := io.Pipe()
+	local := &panickingLogger{panicOn: "boom"}
+	lf := &logPair{sinks: []logger.Logger{local}, jsonl: local, stream: r, info: logger.Info{ContainerID: "containeriid"}}
+
+	go func() {
+		enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+		for _, line := range []string{"one", "boom", "two"} {
+			entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte(line)}
+			if err := enc.WriteMsg(entry); err != nil {
+				t.Error(err)
+			}
+		}
+		w.Close()
+	}()
+
+	panics := atomic.LoadUint64(&metrics.processorPanics)
+	mg := messageProcessor{retryNumber: 3, panicRetryNumber: 1}
+	mg.process(lf)
+
+	if len(local.lines) != 2 || local.lines[0] != "one" || local.lines[1] != "two" {
+		t.Fatalf("Expected processing to continue after the panic, got %v", local.lines)
+	}
+	if atomic.LoadUint64(&metrics.processorPanics) != panics+1 {
+		t.Fatal("Expected the panic to be counted")
+	}
+	if !local.closed {
+		t.Fatal("Expected loggers to be closed at the end of the stream")
+	}
+}
+
+func TestProcessLogsContainerExit(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:       hec.URL(),
+			splunkTokenKey:     hec.token,
+			splunkExitEventKey: "true",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageName: "container_image_name",
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	local := &panickingLogger{}
+	lf := &logPair{sinks: []logger.Logger{splunkl, local}, jsonl: local, splunkl: splunkl, stream: r, info: info}
+	go func() {
+		enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte("last words")}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Error(err)
+		}
+		// the container exits
+		w.Close()
+	}()
+
+	messageProcessor{}.process(lf)
+
+	if len(hec.messages) != 2 {
+		t.Fatalf("Expected # of messages %d, got %d", 2, len(hec.messages))
+	}
+	event, err := hec.messages[1].EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["event_type"] != "container_exited" ||
+		event["reason"] != exitReasonStreamClosed ||
+		event["container_id"] != "containeriid" ||
+		event["container_name"] != "container_name" ||
+		event["image"] != "container_image_name" {
+		t.Fatalf("Unexpected exit event %v", event)
+	}
+}
+
+// recordingLogger keeps a copy of the messages logged from any goroutine
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []logger.Message
+}
+
+func (l *recordingLogger) Log(msg *logger.Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := *msg
+	m.Line = append([]byte(nil), msg.Line...)
+	l.messages = append(l.messages, m)
+	return nil
+}
+
+func (l *recordingLogger) Name() string {
+	return "recording"
+}
+
+func (l *recordingLogger) Close() error {
+	return nil
+}
+
+func (l *recordingLogger) logged() []logger.Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]logger.Message(nil), l.messages...)
+}
+
+func TestProcessLogsPartialTimeout(t *testing.T) {
+	holdDuration, bufferMaximum := partialMsgBufferHoldDuration, partialMsgBufferMaximum
+	partialMsgBufferHoldDuration, partialMsgBufferMaximum = time.Minute, defaultPartialMsgBufferMaximum
+	defer func() {
+		partialMsgBufferHoldDuration, partialMsgBufferMaximum = holdDuration, bufferMaximum
+	}()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:            hec.URL(),
+			splunkTokenKey:          hec.token,
+			splunkPartialTimeoutKey: "50ms",
+		},
+		ContainerID: "containeriid",
+	}
+	timeout, err := parsePartialTimeout(info.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	local := &recordingLogger{}
+	lf := &logPair{sinks: []logger.Logger{splunkl, local}, jsonl: local, splunkl: splunkl, stream: r, info: info}
+	done := make(chan struct{})
+	go func() {
+		messageProcessor{partialTimeout: timeout}.process(lf)
+		close(done)
+	}()
+
+	// the container hangs before writing the last fragment
+	enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+	for _, fragment := range []string{"hel", "lo"} {
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte(fragment), Partial: true}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(local.logged()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the incomplete message to be flushed after the timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Close()
+	<-done
+
+	messages := local.logged()
+	if len(messages) != 1 || string(messages[0].Line) != "hello" || !messages[0].Partial {
+		t.Fatalf("Unexpected local messages %v", messages)
+	}
+	if len(hec.messages) != 1 {
+		t.Fatalf("Expected # of messages %d, got %d", 1, len(hec.messages))
+	}
+	if hec.messages[0].Fields[partialIncompleteField] != "true" {
+		t.Fatalf("Expected the message to be marked incomplete, got fields %v", hec.messages[0].Fields)
+	}
+	event, err := hec.messages[0].EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["line"] != "hello" {
+		t.Fatalf("Unexpected event %v", event)
+	}
+}
+
+func TestParsePartialTimeout(t *testing.T) {
+	if timeout, err := parsePartialTimeout(map[string]string{}); err != nil || timeout != partialMsgBufferHoldDuration {
+		t.Fatalf("Expected the hold duration by default, got %v %v", timeout, err)
+	}
+	if _, err := parsePartialTimeout(map[string]string{splunkPartialTimeoutKey: "-1s"}); err == nil {
+		t.Fatal("Expected an error for a negative timeout")
+	}
+	if _, err := parsePartialTimeout(map[string]string{splunkPartialTimeoutKey: "soon"}); err == nil {
+		t.Fatal("Expected an error for an invalid timeout")
+	}
+}
+
+func TestProcessCorrectsTimestamps(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:         hec.URL(),
+			splunkTokenKey:       hec.token,
+			splunkMaxTimeSkewKey: "168h",
+		},
+		ContainerID: "containeriid",
+	}
+	maxTimeSkew, err := parseMaxTimeSkew(info.Config)
+	if err != nil || maxTimeSkew != 7*24*time.Hour {
+		t.Fatalf("Expected a skew of 7 days, got %v %v", maxTimeSkew, err)
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	local := &recordingLogger{}
+	lf := &logPair{sinks: []logger.Logger{splunkl, local}, jsonl: local, splunkl: splunkl, stream: r, info: info}
+	done := make(chan struct{})
+	go func() {
+		messageProcessor{maxTimeSkew: maxTimeSkew}.process(lf)
+		close(done)
+	}()
+
+	corrected := atomic.LoadUint64(&metrics.timestampsCorrected)
+	start := time.Now()
+	skewed := start.Add(-30 * 24 * time.Hour)
+	valid := start.Add(-time.Hour)
+	enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+	for _, timeNano := range []int64{0, skewed.UnixNano(), valid.UnixNano()} {
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: timeNano, Line: []byte("line")}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	<-done
+
+	if got := atomic.LoadUint64(&metrics.timestampsCorrected) - corrected; got != 2 {
+		t.Fatalf("Expected 2 corrected timestamps, got %d", got)
+	}
+	messages := local.logged()
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 local messages, got %d", len(messages))
+	}
+	for i, message := range messages[:2] {
+		if message.Timestamp.Before(start) || message.Timestamp.After(time.Now()) {
+			t.Fatalf("Expected the timestamp %d to be replaced with the current time, got %v", i, message.Timestamp)
+		}
+	}
+	if !messages[2].Timestamp.Equal(valid) || messages[2].Attrs != nil {
+		t.Fatalf("Expected the valid timestamp to be kept, got %v %v", messages[2].Timestamp, messages[2].Attrs)
+	}
+
+	if len(hec.messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(hec.messages))
+	}
+	originals := []string{"0.000000", fmt.Sprintf("%f", float64(skewed.UnixNano())/float64(time.Second)), ""}
+	for i, original := range originals {
+		if got := hec.messages[i].Fields[originalTimeField]; got != original {
+			t.Fatalf("Expected the message %d with %s %q, got %q", i, originalTimeField, original, got)
+		}
+	}
+}
+
+func TestProcessDropsInvalidTimestamps(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:               hec.URL(),
+			splunkTokenKey:             hec.token,
+			splunkInvalidTimePolicyKey: invalidTimePolicyDrop,
+		},
+		ContainerID: "containeriid",
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := splunkl.(*splunkLoggerInline).containerMetrics()
+
+	r, w := io.Pipe()
+	local := &recordingLogger{}
+	lf := &logPair{sinks: []logger.Logger{splunkl, local}, jsonl: local, splunkl: splunkl, stream: r, info: info}
+	done := make(chan struct{})
+	go func() {
+		messageProcessor{}.process(lf)
+		close(done)
+	}()
+
+	valid := time.Now().Add(-time.Hour)
+	enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+	for _, timeNano := range []int64{0, valid.UnixNano()} {
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: timeNano, Line: []byte("line")}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	<-done
+
+	if len(local.logged()) != 2 {
+		t.Fatalf("Expected both lines in the local log, got %d", len(local.logged()))
+	}
+	if len(hec.messages) != 1 || hec.messages[0].Fields[originalTimeField] != "" {
+		t.Fatalf("Expected only the valid timestamp to be sent, got %d messages", len(hec.messages))
+	}
+	if dropped := c.droppedBy[dropReasonInvalidTime]; dropped != 1 {
+		t.Fatalf("Expected 1 event dropped for its timestamp, got %d", dropped)
+	}
+	if _, err := parseInvalidTimePolicy(map[string]string{splunkInvalidTimePolicyKey: "ignore"}); err == nil {
+		t.Fatal("Expected an error for an unknown policy")
+	}
+}
+
+func TestParseMaxTimeSkew(t *testing.T) {
+	if skew, err := parseMaxTimeSkew(map[string]string{}); err != nil || skew != 0 {
+		t.Fatalf("Expected no skew check by default, got %v %v", skew, err)
+	}
+	if skew, err := parseMaxTimeSkew(map[string]string{splunkMaxTimeSkewKey: "0s"}); err != nil || skew != 0 {
+		t.Fatalf("Expected no skew check, got %v %v", skew, err)
+	}
+	if _, err := parseMaxTimeSkew(map[string]string{splunkMaxTimeSkewKey: "-1h"}); err == nil {
+		t.Fatal("Expected an error for a negative skew")
+	}
+	// only zero timestamps are replaced without a skew check
+	entry := &logdriver.LogEntry{TimeNano: 1}
+	if original := (messageProcessor{}).correctTime(entry, time.Now(), "containeriid"); original != "" || entry.TimeNano != 1 {
+		t.Fatalf("Expected the timestamp to be kept, got %q %d", original, entry.TimeNano)
+	}
+}
+
+func TestProcessInputGzip(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:       hec.URL(),
+			splunkTokenKey:     hec.token,
+			splunkFormatKey:    splunkFormatRaw,
+			splunkInputGzipKey: "true",
+		},
+		ContainerID: "containeriid",
+	}
+	inputGzip, err := parseInputGzip(info.Config)
+	if err != nil || !inputGzip {
+		t.Fatalf("Expected %s to be enabled, got %v", splunkInputGzipKey, err)
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("x", 20000)
+	lines := []string{"first line", long, "last line"}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	zw.Close()
+
+	r, w := io.Pipe()
+	local := &recordingLogger{}
+	lf := &logPair{sinks: []logger.Logger{splunkl, local}, jsonl: local, splunkl: splunkl, stream: r, info: info}
+	done := make(chan struct{})
+	go func() {
+		messageProcessor{inputGzip: inputGzip}.process(lf)
+		close(done)
+	}()
+
+	// Docker splits the compressed output on newlines and in chunks
+	enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+	pieces := bytes.Split(compressed.Bytes(), []byte{'\n'})
+	for i, piece := range pieces {
+		last := i == len(pieces)-1
+		if last && len(piece) == 0 {
+			break
+		}
+		for len(piece) > 100 {
+			entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: piece[:100], Partial: true}
+			if err := enc.WriteMsg(entry); err != nil {
+				t.Fatal(err)
+			}
+			piece = piece[100:]
+		}
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: piece, Partial: last}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	<-done
+
+	messages := local.logged()
+	if len(messages) != len(lines) {
+		t.Fatalf("Expected %d decompressed lines, got %d", len(lines), len(messages))
+	}
+	for i, line := range lines {
+		if string(messages[i].Line) != line {
+			t.Fatalf("Expected line %d to be decompressed, got %q", i, messages[i].Line)
+		}
+	}
+	if len(hec.messages) != len(lines) {
+		t.Fatalf("Expected %d messages, got %d", len(lines), len(hec.messages))
+	}
+	for i, line := range lines {
+		// raw events are prefixed with the tag
+		if event, err := hec.messages[i].EventAsString(); err != nil || !strings.HasSuffix(event, " "+line) {
+			t.Fatalf("Expected event %d to be the decompressed line, got %.40q", i, event)
+		}
+	}
+}
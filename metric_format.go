@@ -0,0 +1,291 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Prefix of the fields holding the values of a HEC metric event
+const metricNamePrefix = "metric_name:"
+
+// Keys of the metric name and value of splunk-metrics-mode by default
+const defaultMetricsMapping = "name=metric,value=value"
+
+// How a line is sent with splunk-metrics-mode
+const (
+	metricLineEvent = iota
+	metricLineMetric
+	// the line has the shape of a metric, but its value is not a number
+	metricLineMalformed
+)
+
+// metricsMapping selects the JSON lines sent as metric events by
+// splunk-metrics-mode, with the keys of their metric name and value
+type metricsMapping struct {
+	nameKey  string
+	valueKey string
+	// index of the metric events, empty for the index of the events
+	index string
+	// dimensions describing the container
+	metadata map[string]string
+}
+
+// splunkLoggerMetric sends JSON lines as HEC metric events: every numeric
+// field is a metric value and every other field a dimension
+type splunkLoggerMetric struct {
+	*splunkLogger
+
+	// dimensions of every event, from the labels and environment
+	attrs map[string]string
+}
+
+func (l *splunkLoggerMetric) Log(msg *logger.Message) error {
+	metrics, dimensions, ok := parseMetricLine(msg.Line)
+	if !ok {
+		// a metrics index does not accept events
+		processorLog.WithField("id", l.containerID).Debug("Dropping a line without metric values")
+		l.hec.metrics.addDropped(dropReasonNotMetric, 1)
+		logger.PutMessage(msg)
+		return nil
+	}
+	if attrs, ok := l.refreshedAttrs(); ok {
+		l.attrs = attrs
+	}
+	message := l.createSplunkMessage(msg)
+	message.Event = "metric"
+	for key, value := range l.attrs {
+		if _, ok := dimensions[key]; !ok {
+			dimensions[key] = value
+		}
+	}
+	for key, value := range message.Fields {
+		dimensions[key] = value
+	}
+	message.Fields = dimensions
+	message.metrics = metrics
+	logger.PutMessage(msg)
+	return l.queueMessageAsync(message)
+}
+
+// parseMetricLine() splits a JSON object in metric values and dimensions.
+// Nested objects are flattened with dotted names. It returns false when the
+// line is not a JSON object or has no numeric field.
+func parseMetricLine(line []byte) (map[string]float64, map[string]string, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, nil, false
+	}
+	metrics := make(map[string]float64)
+	dimensions := make(map[string]string)
+	flattenMetricObject("", object, metrics, dimensions)
+	if len(metrics) == 0 {
+		return nil, nil, false
+	}
+	return metrics, dimensions, true
+}
+
+func flattenMetricObject(prefix string, object map[string]interface{}, metrics map[string]float64, dimensions map[string]string) {
+	for key, value := range object {
+		name := prefix + key
+		switch v := value.(type) {
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				metrics[name] = f
+			}
+		case string:
+			dimensions[name] = v
+		case map[string]interface{}:
+			flattenMetricObject(name+".", v, metrics, dimensions)
+		case nil:
+		default:
+			encoded, _ := json.Marshal(v)
+			dimensions[name] = string(encoded)
+		}
+	}
+}
+
+// MarshalJSON() adds the values of a metric event to its fields
+func (message *splunkMessage) MarshalJSON() ([]byte, error) {
+	type plainMessage splunkMessage
+	if message.metrics == nil {
+		return json.Marshal((*plainMessage)(message))
+	}
+	fields := make(map[string]interface{}, len(message.Fields)+len(message.metrics))
+	for key, value := range message.Fields {
+		fields[key] = value
+	}
+	names := make([]string, 0, len(message.metrics))
+	for name := range message.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields[metricNamePrefix+name] = message.metrics[name]
+	}
+	return json.Marshal(&struct {
+		*plainMessage
+		Fields map[string]interface{} `json:"fields"`
+	}{(*plainMessage)(message), fields})
+}
+
+// parseMetricsMapping() returns the keys of the name and the value of
+// splunk-metrics-mapping, name=<key>,value=<key>
+func parseMetricsMapping(value string) (string, string, error) {
+	var nameKey, valueKey string
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return "", "", fmt.Errorf("%s: %s must be name=<key>,value=<key>, got %q", driverName, splunkMetricsMappingKey, value)
+		}
+		switch kv[0] {
+		case "name":
+			nameKey = kv[1]
+		case "value":
+			valueKey = kv[1]
+		default:
+			return "", "", fmt.Errorf("%s: unknown key %q in %s, supported keys are name and value", driverName, kv[0], splunkMetricsMappingKey)
+		}
+	}
+	if nameKey == "" || valueKey == "" || nameKey == valueKey {
+		return "", "", fmt.Errorf("%s: %s must be name=<key>,value=<key> with two keys, got %q", driverName, splunkMetricsMappingKey, value)
+	}
+	return nameKey, valueKey, nil
+}
+
+// parseMetricsMode() returns the mapping of splunk-metrics-mode, nil when
+// it is disabled
+func parseMetricsMode(info logger.Info) (*metricsMapping, error) {
+	enabled := false
+	if enabledStr, ok := info.Config[splunkMetricsModeKey]; ok {
+		var err error
+		enabled, err = strconv.ParseBool(enabledStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !enabled {
+		return nil, nil
+	}
+	switch info.Config[splunkFormatKey] {
+	case splunkFormatRaw, splunkFormatMetric:
+		return nil, fmt.Errorf("%s: %s is not supported with the %s format", driverName, splunkMetricsModeKey, info.Config[splunkFormatKey])
+	}
+	mapping := defaultMetricsMapping
+	if mappingStr, ok := info.Config[splunkMetricsMappingKey]; ok {
+		mapping = mappingStr
+	}
+	nameKey, valueKey, err := parseMetricsMapping(mapping)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsMapping{
+		nameKey:  nameKey,
+		valueKey: valueKey,
+		index:    info.Config[splunkMetricsIndexKey],
+		metadata: map[string]string{
+			"container_id":   info.ContainerID,
+			"container_name": info.Name(),
+			"image":          info.ContainerImageName,
+		},
+	}, nil
+}
+
+// parse() returns the metric of a JSON line with a name and a value, and
+// the other keys of the line as dimensions
+func (m *metricsMapping) parse(line []byte) (string, float64, map[string]string, int) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return "", 0, nil, metricLineEvent
+	}
+	name, ok := object[m.nameKey].(string)
+	value, hasValue := object[m.valueKey]
+	if !ok || name == "" || !hasValue {
+		return "", 0, nil, metricLineEvent
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return "", 0, nil, metricLineMalformed
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return "", 0, nil, metricLineMalformed
+	}
+	dimensions := make(map[string]string, len(object))
+	for key, v := range object {
+		switch v := v.(type) {
+		case string:
+			dimensions[key] = v
+		case nil:
+		default:
+			encoded, _ := json.Marshal(v)
+			dimensions[key] = string(encoded)
+		}
+	}
+	delete(dimensions, m.nameKey)
+	delete(dimensions, m.valueKey)
+	return name, f, dimensions, metricLineMetric
+}
+
+// logMetricLine() queues the line as a metric event when it has the shape
+// of splunk-metrics-mode. It returns false when the line is to be sent as
+// an event.
+func (l *splunkLogger) logMetricLine(msg *logger.Message, attrs map[string]string) (bool, error) {
+	if l.metricsMode == nil {
+		return false, nil
+	}
+	name, value, dimensions, shape := l.metricsMode.parse(msg.Line)
+	switch shape {
+	case metricLineEvent:
+		return false, nil
+	case metricLineMalformed:
+		atomic.AddUint64(&metrics.metricLinesMalformed, 1)
+		processorLog.WithField("id", l.containerID).Debug("Metric value is not a number, sending the line as an event")
+		return false, nil
+	}
+	message := l.createSplunkMessage(msg)
+	message.Event = "metric"
+	if l.metricsMode.index != "" {
+		message.Index = l.metricsMode.index
+	}
+	for _, values := range []map[string]string{attrs, l.metricsMode.metadata} {
+		for key, value := range values {
+			if _, ok := dimensions[key]; !ok {
+				dimensions[key] = value
+			}
+		}
+	}
+	for key, value := range message.Fields {
+		dimensions[key] = value
+	}
+	message.Fields = dimensions
+	message.metrics = map[string]float64{name: value}
+	logger.PutMessage(msg)
+	return true, l.queueMessageAsync(message)
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestMetricFormat(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		for {
+			var event map[string]interface{}
+			if err := decoder.Decode(&event); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Error(err)
+				break
+			}
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:    server.URL,
+			splunkTokenKey:  "token",
+			splunkFormatKey: splunkFormatMetric,
+			splunkIndexKey:  "metrics",
+			labelsKey:       "service",
+		},
+		ContainerID:     "containeriid",
+		ContainerName:   "/container_name",
+		ContainerLabels: map[string]string{"service": "payments"},
+	}
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := loggerDriver.(metricsProvider).containerMetrics()
+
+	for _, line := range []string{
+		`{"cpu": 0.5, "mem": {"used": 1024, "unit": "MB"}, "region": "us-east", "healthy": true, "tags": ["a"]}`,
+		`not a metric`,
+		`{"message": "no numbers"}`,
+	} {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("Expected a single metric event, got %v", events)
+	}
+	event := events[0]
+	if event["event"] != "metric" || event["index"] != "metrics" {
+		t.Fatalf("Unexpected metric event %v", event)
+	}
+	fields, _ := event["fields"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"metric_name:cpu":      0.5,
+		"metric_name:mem.used": float64(1024),
+		"mem.unit":             "MB",
+		"region":               "us-east",
+		"healthy":              "true",
+		"tags":                 `["a"]`,
+		"service":              "payments",
+		logDriverField:         defaultLogDriver,
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected fields %v, got %v", expected, fields)
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Fatalf("Expected %s to be %v, got %v", key, value, fields[key])
+		}
+	}
+	if dropped := c.droppedBy[dropReasonNotMetric]; dropped != 2 {
+		t.Fatalf("Expected the lines without metric to be counted, got %d", dropped)
+	}
+}
+
+func TestMetricsMode(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		for {
+			var event map[string]interface{}
+			if err := decoder.Decode(&event); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Error(err)
+				break
+			}
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:            server.URL,
+			splunkTokenKey:          "token",
+			splunkIndexKey:          "main",
+			splunkMetricsModeKey:    "true",
+			splunkMetricsMappingKey: "name=name,value=val",
+			splunkMetricsIndexKey:   "metrics",
+			labelsKey:               "service",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageName: "worker:1.2",
+		ContainerLabels:    map[string]string{"service": "payments"},
+	}
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := atomic.LoadUint64(&metrics.metricLinesMalformed)
+
+	for _, line := range []string{
+		`{"name": "queue_depth", "val": 12, "queue": "orders", "shard": 3}`,
+		`{"name": "queue_depth", "val": "twelve"}`,
+		`{"msg": "started", "val": 1}`,
+		`plain text`,
+	} {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %v", events)
+	}
+	if events[0]["event"] != "metric" || events[0]["index"] != "metrics" {
+		t.Fatalf("Unexpected metric event %v", events[0])
+	}
+	fields, _ := events[0]["fields"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"metric_name:queue_depth": float64(12),
+		"queue":                   "orders",
+		"shard":                   "3",
+		"service":                 "payments",
+		"container_id":            "containeriid",
+		"container_name":          "container_name",
+		"image":                   "worker:1.2",
+		logDriverField:            defaultLogDriver,
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected fields %v, got %v", expected, fields)
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Fatalf("Expected %s to be %v, got %v", key, value, fields[key])
+		}
+	}
+	// the other lines go through the event path
+	for i, event := range events[1:] {
+		line, _ := event["event"].(map[string]interface{})
+		if event["index"] != "main" || line["line"] == nil {
+			t.Fatalf("Expected line %d as an event, got %v", i+1, event)
+		}
+	}
+	if n := atomic.LoadUint64(&metrics.metricLinesMalformed) - malformed; n != 1 {
+		t.Fatalf("Expected 1 malformed metric line, got %d", n)
+	}
+}
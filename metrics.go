@@ -0,0 +1,528 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventCounters are updated atomically by the senders
+type eventCounters struct {
+	received  uint64
+	sent      uint64
+	dropped   uint64
+	retried   uint64
+	bytesSent uint64
+	routed    uint64
+}
+
+// Reasons for dropping events, reported in the dropped events summary
+const (
+	dropReasonBufferFull = iota
+	dropReasonTooLarge
+	dropReasonRateLimited
+	dropReasonRetryExhausted
+	dropReasonRejected
+	dropReasonNotMetric
+	dropReasonRetryBudget
+	dropReasonBandwidthLimited
+	dropReasonInvalidTime
+	dropReasonPaused
+	dropReasonCount
+)
+
+var dropReasonNames = [dropReasonCount]string{"buffer_full", "too_large", "rate_limited", "retry_exhausted", "rejected", "not_metric", "retry_budget", "bandwidth_limited", "invalid_time", "paused"}
+
+// containerMetrics holds the counters of a single splunk logger. Every update
+// is also applied to the plugin totals, which stay monotonic when loggers go away.
+type containerMetrics struct {
+	eventCounters
+	id         string
+	parent     *pluginMetrics
+	queueDepth func() int
+	// queue depth from which events get dropped, 0 when unknown
+	queueCapacity int
+
+	// time of the last received event and of the last successful post
+	// (unix nanoseconds), and last post error
+	lastReceived int64
+	lastSend     int64
+	mu           sync.Mutex
+	lastError    string
+
+	// dropped events by reason
+	droppedBy [dropReasonCount]uint64
+	// 1 while the delivery of the container is degraded
+	degraded int32
+	// called by the stats reporter to send the dropped events summary, may be nil
+	reportDrops func(now time.Time)
+	// called by the heartbeat ticker, nil when heartbeats are disabled
+	heartbeat func(now time.Time)
+}
+
+func (c *containerMetrics) addReceived(n int) {
+	if c == nil {
+		return
+	}
+	atomic.StoreInt64(&c.lastReceived, time.Now().UnixNano())
+	atomic.AddUint64(&c.received, uint64(n))
+	atomic.AddUint64(&c.parent.totals.received, uint64(n))
+}
+
+func (c *containerMetrics) addSent(n int, bytes int) {
+	if c == nil {
+		return
+	}
+	atomic.StoreInt64(&c.lastSend, time.Now().UnixNano())
+	atomic.AddUint64(&c.sent, uint64(n))
+	atomic.AddUint64(&c.parent.totals.sent, uint64(n))
+	atomic.AddUint64(&c.bytesSent, uint64(bytes))
+	atomic.AddUint64(&c.parent.totals.bytesSent, uint64(bytes))
+}
+
+func (c *containerMetrics) addDropped(reason int, n int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.droppedBy[reason], uint64(n))
+	atomic.AddUint64(&c.dropped, uint64(n))
+	atomic.AddUint64(&c.parent.totals.dropped, uint64(n))
+}
+
+func (c *containerMetrics) addFieldsDropped(n int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.parent.fieldsDropped, uint64(n))
+}
+
+func (c *containerMetrics) addRetried(n int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.retried, uint64(n))
+	atomic.AddUint64(&c.parent.totals.retried, uint64(n))
+}
+
+func (c *containerMetrics) setLastError(err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.lastError = err.Error()
+	c.mu.Unlock()
+}
+
+func (c *containerMetrics) getLastError() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastError
+}
+
+func (c *containerMetrics) setDegraded(degraded bool) {
+	if c == nil {
+		return
+	}
+	var value int32
+	if degraded {
+		value = 1
+	}
+	atomic.StoreInt32(&c.degraded, value)
+}
+
+func (c *containerMetrics) isDegraded() bool {
+	return atomic.LoadInt32(&c.degraded) == 1
+}
+
+func (c *containerMetrics) addRouted(n int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.routed, uint64(n))
+	atomic.AddUint64(&c.parent.totals.routed, uint64(n))
+}
+
+// histogram is a cumulative histogram in the prometheus sense
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+
+	// state of the previous summary
+	lastCounts []uint64
+	lastCount  uint64
+	max        float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)), lastCounts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+	if value > h.max {
+		h.max = value
+	}
+}
+
+func (h *histogram) writeTo(w io.Writer, name string, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// histogramSummary estimates quantiles of the observations in a period
+type histogramSummary struct {
+	count uint64
+	p50   float64
+	p95   float64
+	p99   float64
+	max   float64
+}
+
+// summarize() returns the summary of the observations since the previous call
+func (h *histogram) summarize() histogramSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = h.counts[i] - h.lastCounts[i]
+	}
+	summary := histogramSummary{count: h.count - h.lastCount, max: h.max}
+	if summary.count > 0 {
+		summary.p50 = quantile(0.5, h.buckets, counts, summary.count, h.max)
+		summary.p95 = quantile(0.95, h.buckets, counts, summary.count, h.max)
+		summary.p99 = quantile(0.99, h.buckets, counts, summary.count, h.max)
+	}
+	copy(h.lastCounts, h.counts)
+	h.lastCount = h.count
+	h.max = 0
+	return summary
+}
+
+// quantile() interpolates the q-quantile within the bucket holding it, like
+// prometheus' histogram_quantile, and never returns more than the maximum
+func quantile(q float64, buckets []float64, counts []uint64, count uint64, max float64) float64 {
+	rank := q * float64(count)
+	lower, below := 0.0, uint64(0)
+	for i, bound := range buckets {
+		if float64(counts[i]) >= rank {
+			value := bound
+			if inBucket := counts[i] - below; inBucket > 0 {
+				value = lower + (bound-lower)*(rank-float64(below))/float64(inBucket)
+			}
+			return math.Min(value, max)
+		}
+		lower, below = bound, counts[i]
+	}
+	return max
+}
+
+// parseBuckets() parses comma separated increasing bucket bounds
+func parseBuckets(value string, parse func(string) (float64, error)) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bound, err := parse(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("bucket bounds must be increasing: %s", value)
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
+}
+
+func parseSecondsBucket(value string) (float64, error) {
+	d, err := time.ParseDuration(value)
+	return d.Seconds(), err
+}
+
+func parseCountBucket(value string) (float64, error) {
+	return strconv.ParseFloat(value, 64)
+}
+
+func getAdvancedOptionBuckets(envName string, defaultValue []float64, parse func(string) (float64, error)) []float64 {
+	valueStr := os.Getenv(envName)
+	if valueStr == "" {
+		return defaultValue
+	}
+	buckets, err := parseBuckets(valueStr, parse)
+	if err != nil {
+		driverLog.WithField("env", envName).WithField("default", defaultValue).WithError(err).Error("Failed to parse value as histogram buckets, using default")
+		return defaultValue
+	}
+	return buckets
+}
+
+type pluginMetrics struct {
+	totals eventCounters
+	// message processors restarted after a panic
+	processorPanics uint64
+	// time senders waited for a busy HEC, in nanoseconds
+	busyPausedNanos uint64
+	// time senders waited for a request slot of
+	// SPLUNK_LOGGING_DRIVER_MAX_INFLIGHT, in nanoseconds
+	inflightWaitNanos uint64
+	// fields not sent because of splunk-max-fields
+	fieldsDropped uint64
+	// messages not copied to the journal by splunk-journald-copy
+	journaldFailures uint64
+	// messages not copied to the syslog server of splunk-syslog-url
+	syslogFailures uint64
+	// lines of splunk-metrics-mode sent as events as their value is not a number
+	metricLinesMalformed uint64
+	// negative delivery latencies of splunk-delivery-latency-field, clamped to 0
+	deliveryLatencyClamped uint64
+	// zero or skewed timestamps replaced with the time they were read
+	timestampsCorrected uint64
+	// splunk loggers which could not be created, with
+	// splunk-forwarding-required=false, and later were
+	forwardingDegraded  uint64
+	forwardingRecovered uint64
+	// local logs which stopped being written as their filesystem was full
+	// or read-only
+	cacheDegraded uint64
+	// bytes of rotated local json files removed over SPLUNK_CACHE_TOTAL_MAX_BYTES
+	cacheBudgetReclaimed uint64
+	// lines logged locally while Splunk was unavailable and sent afterwards
+	eventsBackfilled uint64
+	// events and bytes over splunk-bandwidth-limit which were dropped, and
+	// time the containers waited for it with the block policy, in nanoseconds
+	bandwidthDropped      uint64
+	bandwidthDroppedBytes uint64
+	bandwidthWaitNanos    uint64
+
+	mu         sync.Mutex
+	containers map[*containerMetrics]struct{}
+
+	requestLatency *histogram
+	batchSize      *histogram
+	batchRetries   *histogram
+	// seconds between the timestamp of an event and its post, with
+	// splunk-delivery-latency-field
+	deliveryLatency *histogram
+}
+
+func newPluginMetrics() *pluginMetrics {
+	return &pluginMetrics{
+		containers:      make(map[*containerMetrics]struct{}),
+		requestLatency:  newHistogram(defaultLatencyBuckets),
+		batchSize:       newHistogram([]float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}),
+		batchRetries:    newHistogram(defaultRetryBuckets),
+		deliveryLatency: newHistogram(defaultLatencyBuckets),
+	}
+}
+
+// Default bounds of the HEC request duration (in seconds) and batch retries
+// histograms
+var (
+	defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	defaultRetryBuckets   = []float64{0, 1, 2, 3, 5, 10, 25, 50}
+)
+
+// configureBuckets() sets the bounds of the histograms from the environment,
+// before anything is observed
+func (m *pluginMetrics) configureBuckets() {
+	m.requestLatency = newHistogram(getAdvancedOptionBuckets(envVarMetricsLatencyBuckets, defaultLatencyBuckets, parseSecondsBucket))
+	m.batchRetries = newHistogram(getAdvancedOptionBuckets(envVarMetricsRetryBuckets, defaultRetryBuckets, parseCountBucket))
+	m.deliveryLatency = newHistogram(getAdvancedOptionBuckets(envVarMetricsLatencyBuckets, defaultLatencyBuckets, parseSecondsBucket))
+}
+
+var metrics = newPluginMetrics()
+
+func (m *pluginMetrics) register(id string, queueDepth func() int) *containerMetrics {
+	return m.add(&containerMetrics{id: id, queueDepth: queueDepth})
+}
+
+// add() registers counters prepared by the caller, so that their fields
+// are set before the reporters can see them
+func (m *pluginMetrics) add(c *containerMetrics) *containerMetrics {
+	c.parent = m
+	m.mu.Lock()
+	m.containers[c] = struct{}{}
+	m.mu.Unlock()
+	return c
+}
+
+func (m *pluginMetrics) unregister(c *containerMetrics) {
+	m.mu.Lock()
+	delete(m.containers, c)
+	m.mu.Unlock()
+}
+
+// snapshot() returns the registered containers sorted by container id
+func (m *pluginMetrics) snapshot() []*containerMetrics {
+	m.mu.Lock()
+	containers := make([]*containerMetrics, 0, len(m.containers))
+	for c := range m.containers {
+		containers = append(containers, c)
+	}
+	m.mu.Unlock()
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].id < containers[j].id
+	})
+	return containers
+}
+
+// writeTo() writes the metrics in the prometheus text format. Per container
+// series are written for at most maxContainers containers, 0 writes only
+// the aggregated series.
+func (m *pluginMetrics) writeTo(w io.Writer, maxContainers int) {
+	containers := m.snapshot()
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(c *eventCounters) uint64
+	}{
+		{"events_received_total", "Events received by the splunk senders.", func(c *eventCounters) uint64 { return atomic.LoadUint64(&c.received) }},
+		{"events_sent_total", "Events successfully sent to HEC.", func(c *eventCounters) uint64 { return atomic.LoadUint64(&c.sent) }},
+		{"events_dropped_total", "Events dropped after failing to be sent.", func(c *eventCounters) uint64 { return atomic.LoadUint64(&c.dropped) }},
+		{"events_retried_total", "Events in batches that failed and are retried.", func(c *eventCounters) uint64 { return atomic.LoadUint64(&c.retried) }},
+		{"sent_bytes_total", "Bytes of events successfully sent to HEC.", func(c *eventCounters) uint64 { return atomic.LoadUint64(&c.bytesSent) }},
+		{"events_routed_total", "Events matching a routing rule.", func(c *eventCounters) uint64 { return atomic.LoadUint64(&c.routed) }},
+	}
+	for _, counter := range counters {
+		name := "splunk_logging_" + counter.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, counter.help, name)
+		fmt.Fprintf(w, "%s %d\n", name, counter.value(&m.totals))
+		if maxContainers == 0 {
+			continue
+		}
+		name = "splunk_logging_container_" + counter.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, counter.help, name)
+		for i, c := range containers {
+			if i >= maxContainers {
+				break
+			}
+			fmt.Fprintf(w, "%s{container_id=\"%s\"} %d\n", name, escapeLabelValue(c.id), counter.value(&c.eventCounters))
+		}
+	}
+
+	queueDepth := 0
+	fmt.Fprintf(w, "# HELP splunk_logging_container_queue_depth Events waiting to be sent.\n# TYPE splunk_logging_container_queue_depth gauge\n")
+	for i, c := range containers {
+		depth := c.queueDepth()
+		queueDepth += depth
+		if i < maxContainers {
+			fmt.Fprintf(w, "splunk_logging_container_queue_depth{container_id=\"%s\"} %d\n", escapeLabelValue(c.id), depth)
+		}
+	}
+	fmt.Fprintf(w, "# HELP splunk_logging_queue_depth Events waiting to be sent.\n# TYPE splunk_logging_queue_depth gauge\n")
+	fmt.Fprintf(w, "splunk_logging_queue_depth %d\n", queueDepth)
+
+	fmt.Fprintf(w, "# HELP splunk_logging_active_loggers Splunk loggers currently running.\n# TYPE splunk_logging_active_loggers gauge\n")
+	fmt.Fprintf(w, "splunk_logging_active_loggers %d\n", len(containers))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_processor_panics_total Log processing panics recovered.\n# TYPE splunk_logging_processor_panics_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_processor_panics_total %d\n", atomic.LoadUint64(&m.processorPanics))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_hec_busy_paused_seconds_total Time senders paused because HEC was busy.\n# TYPE splunk_logging_hec_busy_paused_seconds_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_hec_busy_paused_seconds_total %s\n", strconv.FormatFloat(time.Duration(atomic.LoadUint64(&m.busyPausedNanos)).Seconds(), 'g', -1, 64))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_inflight_wait_seconds_total Time senders waited for one of the requests in flight allowed by SPLUNK_LOGGING_DRIVER_MAX_INFLIGHT.\n# TYPE splunk_logging_inflight_wait_seconds_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_inflight_wait_seconds_total %s\n", strconv.FormatFloat(time.Duration(atomic.LoadUint64(&m.inflightWaitNanos)).Seconds(), 'g', -1, 64))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_fields_dropped_total Fields not sent because of splunk-max-fields.\n# TYPE splunk_logging_fields_dropped_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_fields_dropped_total %d\n", atomic.LoadUint64(&m.fieldsDropped))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_journald_failures_total Messages not copied to the journal by splunk-journald-copy.\n# TYPE splunk_logging_journald_failures_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_journald_failures_total %d\n", atomic.LoadUint64(&m.journaldFailures))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_syslog_failures_total Messages not copied to the syslog server of splunk-syslog-url.\n# TYPE splunk_logging_syslog_failures_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_syslog_failures_total %d\n", atomic.LoadUint64(&m.syslogFailures))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_metric_lines_malformed_total Metric lines of splunk-metrics-mode sent as events as their value is not a number.\n# TYPE splunk_logging_metric_lines_malformed_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_metric_lines_malformed_total %d\n", atomic.LoadUint64(&m.metricLinesMalformed))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_delivery_latency_clamped_total Delivery latencies of splunk-delivery-latency-field which were negative and clamped to 0.\n# TYPE splunk_logging_delivery_latency_clamped_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_delivery_latency_clamped_total %d\n", atomic.LoadUint64(&m.deliveryLatencyClamped))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_timestamps_corrected_total Zero or skewed timestamps replaced with the time they were read.\n# TYPE splunk_logging_timestamps_corrected_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_timestamps_corrected_total %d\n", atomic.LoadUint64(&m.timestampsCorrected))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_forwarding_degraded_total Containers started without their splunk logger as Splunk was unavailable.\n# TYPE splunk_logging_forwarding_degraded_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_forwarding_degraded_total %d\n", atomic.LoadUint64(&m.forwardingDegraded))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_forwarding_recovered_total Splunk loggers created by a retry after the container started.\n# TYPE splunk_logging_forwarding_recovered_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_forwarding_recovered_total %d\n", atomic.LoadUint64(&m.forwardingRecovered))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_cache_degraded_total Local logs which stopped being written as their filesystem was full or read-only.\n# TYPE splunk_logging_cache_degraded_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_cache_degraded_total %d\n", atomic.LoadUint64(&m.cacheDegraded))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_cache_budget_reclaimed_bytes_total Bytes of rotated local json files removed as all of them were over SPLUNK_CACHE_TOTAL_MAX_BYTES.\n# TYPE splunk_logging_cache_budget_reclaimed_bytes_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_cache_budget_reclaimed_bytes_total %d\n", atomic.LoadUint64(&m.cacheBudgetReclaimed))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_events_backfilled_total Lines logged locally while Splunk was unavailable and sent once it was available.\n# TYPE splunk_logging_events_backfilled_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_events_backfilled_total %d\n", atomic.LoadUint64(&m.eventsBackfilled))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_bandwidth_dropped_total Events dropped as they were over splunk-bandwidth-limit.\n# TYPE splunk_logging_bandwidth_dropped_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_bandwidth_dropped_total %d\n", atomic.LoadUint64(&m.bandwidthDropped))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_bandwidth_dropped_bytes_total Bytes of the events dropped as they were over splunk-bandwidth-limit.\n# TYPE splunk_logging_bandwidth_dropped_bytes_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_bandwidth_dropped_bytes_total %d\n", atomic.LoadUint64(&m.bandwidthDroppedBytes))
+
+	fmt.Fprintf(w, "# HELP splunk_logging_bandwidth_wait_seconds_total Time containers waited for splunk-bandwidth-limit with splunk-bandwidth-policy=block.\n# TYPE splunk_logging_bandwidth_wait_seconds_total counter\n")
+	fmt.Fprintf(w, "splunk_logging_bandwidth_wait_seconds_total %s\n", strconv.FormatFloat(time.Duration(atomic.LoadUint64(&m.bandwidthWaitNanos)).Seconds(), 'g', -1, 64))
+
+	m.requestLatency.writeTo(w, "splunk_logging_hec_request_duration_seconds", "Duration of HEC requests.")
+	m.batchSize.writeTo(w, "splunk_logging_batch_size", "Number of events per HEC request.")
+	m.batchRetries.writeTo(w, "splunk_logging_batch_retries", "Retries of a batch before it was sent or dropped.")
+	m.deliveryLatency.writeTo(w, "splunk_logging_event_delivery_latency_seconds", "Time between the timestamp of an event and its post, with splunk-delivery-latency-field.")
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// startMetricsServer() serves /metrics when SPLUNK_METRICS_ADDR is set
+func startMetricsServer(addr string, maxContainers int) *httpServer {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.writeTo(w, maxContainers)
+	})
+	return startHTTPServer("metrics", addr, mux)
+}
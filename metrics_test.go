@@ -0,0 +1,226 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newPluginMetrics()
+	c1 := m.register("container1", func() int { return 2 })
+	c2 := m.register("container2", func() int { return 3 })
+	c1.addReceived(5)
+	c1.addSent(4, 400)
+	c1.addRetried(1)
+	c2.addReceived(7)
+	c2.addDropped(dropReasonBufferFull, 7)
+	m.requestLatency.observe(0.02)
+	m.batchSize.observe(4)
+
+	var out bytes.Buffer
+	m.writeTo(&out, 1)
+	expected := []string{
+		"splunk_logging_events_received_total 12\n",
+		"splunk_logging_events_sent_total 4\n",
+		"splunk_logging_events_dropped_total 7\n",
+		"splunk_logging_events_retried_total 1\n",
+		"splunk_logging_sent_bytes_total 400\n",
+		"splunk_logging_container_events_received_total{container_id=\"container1\"} 5\n",
+		"splunk_logging_queue_depth 5\n",
+		"splunk_logging_active_loggers 2\n",
+		"splunk_logging_hec_request_duration_seconds_bucket{le=\"0.01\"} 0\n",
+		"splunk_logging_hec_request_duration_seconds_bucket{le=\"0.025\"} 1\n",
+		"splunk_logging_batch_size_count 1\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line) {
+			t.Fatalf("Expected %q in metrics\n%s", line, out.String())
+		}
+	}
+	// cardinality cap
+	if strings.Contains(out.String(), "container2") {
+		t.Fatalf("Expected only one container in per container series\n%s", out.String())
+	}
+
+	// aggregated metrics only
+	out.Reset()
+	m.writeTo(&out, 0)
+	if strings.Contains(out.String(), "container_id") {
+		t.Fatalf("Expected no per container series\n%s", out.String())
+	}
+
+	// totals survive loggers going away
+	m.unregister(c1)
+	out.Reset()
+	m.writeTo(&out, 1)
+	if !strings.Contains(out.String(), "splunk_logging_events_received_total 12\n") ||
+		!strings.Contains(out.String(), "splunk_logging_active_loggers 1\n") {
+		t.Fatalf("Unexpected metrics after unregister\n%s", out.String())
+	}
+}
+
+func TestLoggerMetrics(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "metricscontainer",
+	}
+
+	received := metrics.totals.received
+	sent := metrics.totals.sent
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(fmt.Sprintf("%d", i)), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	metrics.writeTo(&out, 100)
+	if !strings.Contains(out.String(), "splunk_logging_container_events_received_total{container_id=\"metricscontainer\"} 3\n") {
+		t.Fatalf("Expected container metrics\n%s", out.String())
+	}
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics.totals.received-received != 3 || metrics.totals.sent-sent != 3 {
+		t.Fatal("Unexpected plugin totals")
+	}
+
+	out.Reset()
+	metrics.writeTo(&out, 100)
+	if strings.Contains(out.String(), "metricscontainer") {
+		t.Fatal("Expected container metrics to be removed when the logger is closed")
+	}
+
+	err = hec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistogramSummary(t *testing.T) {
+	h := newHistogram([]float64{0.1, 0.2, 0.5, 1})
+	for i := 0; i < 90; i++ {
+		h.observe(0.05)
+	}
+	for i := 0; i < 10; i++ {
+		h.observe(0.4)
+	}
+	summary := h.summarize()
+	if summary.count != 100 || summary.max != 0.4 {
+		t.Fatalf("Unexpected summary %+v", summary)
+	}
+	// interpolated within the bucket holding the quantile
+	if summary.p50 < 0.05 || summary.p50 > 0.1 {
+		t.Fatalf("Unexpected p50 %v", summary.p50)
+	}
+	if summary.p95 <= 0.2 || summary.p95 > 0.4 || summary.p99 != 0.4 {
+		t.Fatalf("Unexpected p95 %v and p99 %v", summary.p95, summary.p99)
+	}
+
+	// the next summary only covers what was observed since
+	h.observe(2)
+	summary = h.summarize()
+	if summary.count != 1 || summary.p50 != 2 || summary.max != 2 {
+		t.Fatalf("Unexpected summary %+v", summary)
+	}
+	if summary = h.summarize(); summary.count != 0 || summary.max != 0 {
+		t.Fatalf("Expected an empty summary, got %+v", summary)
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	buckets, err := parseBuckets("50ms, 250ms,1s", parseSecondsBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 3 || buckets[0] != 0.05 || buckets[1] != 0.25 || buckets[2] != 1 {
+		t.Fatalf("Unexpected buckets %v", buckets)
+	}
+	for _, value := range []string{"1s,500ms", "1s,1s", "1s,", "fast"} {
+		if _, err := parseBuckets(value, parseSecondsBucket); err == nil {
+			t.Fatalf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestBatchRetries(t *testing.T) {
+	batchRetries := metrics.batchRetries
+	metrics.batchRetries = newHistogram(defaultRetryBuckets)
+	defer func() {
+		metrics.batchRetries = batchRetries
+	}()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "containeriid",
+	}
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loggerDriver.Close()
+	client := loggerDriver.(*splunkLoggerInline).splunkLogger.hec
+
+	messages := []*splunkMessage{{Event: "retried"}}
+	hec.simulateServerError = true
+	for i := 0; i < 2; i++ {
+		if remaining := client.postMessages(messages, false); len(remaining) != 1 {
+			t.Fatal("Expected the message to be kept for a retry")
+		}
+	}
+	hec.simulateServerError = false
+	if remaining := client.postMessages(messages, false); len(remaining) != 0 {
+		t.Fatal("Expected the message to be sent")
+	}
+	if remaining := client.postMessages(messages, false); len(remaining) != 0 {
+		t.Fatal("Expected the message to be sent")
+	}
+
+	summary := metrics.batchRetries.summarize()
+	if summary.count != 2 || summary.max != 2 {
+		t.Fatalf("Expected a batch sent after 2 retries and one sent at once, got %+v", summary)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	// labels giving the network of a container without an inspect lookup
+	networkIPLabel   = "com.splunk.network.ip"
+	networkNameLabel = "com.splunk.network.name"
+
+	networkIPField   = "container_ip"
+	networkNameField = "container_network"
+)
+
+// containerInspect is the part of the docker inspect response holding the
+// labels, the environment, the resource limits and the networks of a
+// container
+type containerInspect struct {
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+		Env    []string          `json:"Env"`
+	} `json:"Config"`
+	HostConfig struct {
+		Memory    int64 `json:"Memory"`
+		NanoCpus  int64 `json:"NanoCpus"`
+		CPUQuota  int64 `json:"CpuQuota"`
+		CPUPeriod int64 `json:"CpuPeriod"`
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		IPAddress string `json:"IPAddress"`
+		Networks  map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// networkFields() returns the primary IP and network of the container, from
+// its labels or from docker when SPLUNK_DOCKER_SOCKET is set. Failures are
+// logged and never block the container start, in that case no fields are
+// returned.
+func networkFields(info logger.Info) map[string]string {
+	if ip := info.ContainerLabels[networkIPLabel]; ip != "" {
+		fields := map[string]string{networkIPField: ip}
+		if name := info.ContainerLabels[networkNameLabel]; name != "" {
+			fields[networkNameField] = name
+		}
+		return fields
+	}
+	socket := getAdvancedOptionString(envVarDockerSocket, defaultDockerSocket)
+	if socket == "" {
+		return nil
+	}
+	fields, err := inspectNetwork(socket, info.ContainerID)
+	if err != nil {
+		driverLog.WithField("id", info.ContainerID).WithField("socket", socket).WithError(err).Warn("Network lookup failed, continuing without network fields")
+		return nil
+	}
+	return fields
+}
+
+func inspectNetwork(socket string, containerID string) (map[string]string, error) {
+	inspect, err := inspectContainer(socket, containerID)
+	if err != nil {
+		return nil, err
+	}
+	settings := inspect.NetworkSettings
+	names := make([]string, 0, len(settings.Networks))
+	for name := range settings.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// the default bridge comes first, then networks by name
+	for _, name := range names {
+		ip := settings.Networks[name].IPAddress
+		if ip != "" && (settings.IPAddress == "" || ip == settings.IPAddress) {
+			return map[string]string{networkIPField: ip, networkNameField: name}, nil
+		}
+	}
+	if settings.IPAddress != "" {
+		return map[string]string{networkIPField: settings.IPAddress}, nil
+	}
+	return nil, nil
+}
+
+// inspectContainer() returns the docker inspect response of a container from
+// the docker socket
+func inspectContainer(socket string, containerID string) (*containerInspect, error) {
+	client := &http.Client{
+		Timeout: getAdvancedOptionDuration(envVarEnrichTimeout, defaultEnrichTimeout),
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	res, err := client.Get("http://docker/containers/" + containerID + "/json")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return nil, fmt.Errorf("%s: container inspect failed - %s", driverName, res.Status)
+	}
+
+	var inspect containerInspect
+	if err := json.NewDecoder(res.Body).Decode(&inspect); err != nil {
+		return nil, err
+	}
+	return &inspect, nil
+}
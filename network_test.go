@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestIncludeNetwork(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:            hec.URL(),
+			splunkTokenKey:          hec.token,
+			splunkIncludeNetworkKey: "true",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageID:   "contaimageid",
+		ContainerImageName: "container_image_name",
+		ContainerLabels:    map[string]string{networkIPLabel: "10.0.3.7", networkNameLabel: "backend"},
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loggerDriver.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(hec.messages))
+	}
+	fields := hec.messages[0].Fields
+	if fields[networkIPField] != "10.0.3.7" || fields[networkNameField] != "backend" {
+		t.Fatalf("Expected network fields, got %v", fields)
+	}
+
+	if err := hec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInspectNetwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/containeriid/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"NetworkSettings": {"IPAddress": "", "Networks": {
+			"frontend": {"IPAddress": "172.19.0.2"},
+			"backend": {"IPAddress": "172.18.0.5"}}}}`))
+	}))
+	defer l.Close()
+
+	fields, err := inspectNetwork(socket, "containeriid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields[networkIPField] != "172.18.0.5" || fields[networkNameField] != "backend" {
+		t.Fatalf("Unexpected network fields %v", fields)
+	}
+
+	if _, err := inspectNetwork(socket, "unknown"); err == nil {
+		t.Fatal("Expected the lookup of an unknown container to fail")
+	}
+
+	os.Setenv(envVarDockerSocket, socket)
+	defer os.Unsetenv(envVarDockerSocket)
+	fields = networkFields(logger.Info{ContainerID: "containeriid"})
+	if fields[networkIPField] != "172.18.0.5" {
+		t.Fatalf("Expected the network to be looked up, got %v", fields)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+)
+
+// parseNormalizeNewlines() returns whether carriage returns end lines, as
+// in the progress output of terminals and the line endings of Windows
+func parseNormalizeNewlines(config map[string]string) (bool, error) {
+	normalizeStr, ok := config[splunkNormalizeNewlinesKey]
+	if !ok {
+		return false, nil
+	}
+	return strconv.ParseBool(normalizeStr)
+}
+
+// splitCarriageReturns() converts \r\n to \n in a reassembly and returns the
+// lines ended by a bare \r, and what follows the last one. A \r at the end
+// stays in rest, as the next fragment may start with its \n.
+func splitCarriageReturns(buffered []byte) (lines [][]byte, rest []byte) {
+	if bytes.Contains(buffered, []byte("\r\n")) {
+		buffered = bytes.Replace(buffered, []byte("\r\n"), []byte("\n"), -1)
+	}
+	end := bytes.LastIndexByte(buffered[:len(buffered)-1], '\r')
+	if end < 0 {
+		return nil, buffered
+	}
+	return bytes.Split(buffered[:end], []byte("\r")), buffered[end+1:]
+}
+
+// trimCarriageReturn() removes the \r of a line ended by \r\n, whose \n
+// Docker removed
+func trimCarriageReturn(line []byte) []byte {
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
+
+// sendCarriageReturnLines() sends the lines ended by a bare \r in the
+// reassembly as soon as they are complete, the rest stays in the buffer
+func (mg messageProcessor) sendCarriageReturnLines(lf *logPair, buf *logdriver.LogEntry, t *partialMsgBuffer) {
+	if t.tBuf.Len() == 0 || bytes.IndexByte(t.tBuf.Bytes(), '\r') < 0 {
+		return
+	}
+	lines, rest := splitCarriageReturns(t.tBuf.Bytes())
+	for _, line := range lines {
+		if mg.stripANSI {
+			line = stripANSI(line)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		for _, l := range lf.sinks {
+			// loggers may recycle the message, each one gets its own
+			msg := logger.Message{
+				Line:      line,
+				Source:    buf.Source,
+				Timestamp: time.Unix(0, buf.TimeNano),
+				Attrs:     t.attrs(),
+			}
+			if err := l.Log(&msg); err != nil {
+				processorLog.WithField("id", lf.info.ContainerID).WithError(err).WithField("message",
+					msg).Error("Error writing log message")
+			}
+		}
+	}
+	rest = append([]byte(nil), rest...)
+	t.tBuf.Reset()
+	t.tBuf.Write(rest)
+}
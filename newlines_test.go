@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+func TestNormalizeNewlines(t *testing.T) {
+	process := func(normalize bool, entries []*logdriver.LogEntry) []string {
+		r, w := io.Pipe()
+		local := &recordingLogger{}
+		lf := &logPair{sinks: []logger.Logger{local}, jsonl: local, stream: r, info: logger.Info{ContainerID: "containeriid"}}
+		done := make(chan struct{})
+		go func() {
+			messageProcessor{normalizeNewlines: normalize}.process(lf)
+			close(done)
+		}()
+		enc := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+		for _, entry := range entries {
+			entry.Source, entry.TimeNano = "stdout", time.Now().UnixNano()
+			if err := enc.WriteMsg(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+		<-done
+		var lines []string
+		for _, msg := range local.logged() {
+			lines = append(lines, string(msg.Line))
+		}
+		return lines
+	}
+	entries := func() []*logdriver.LogEntry {
+		return []*logdriver.LogEntry{
+			{Line: []byte("built on windows\r")},
+			{Line: []byte("progress 10%\rprogress 50%\r\rprogress 100%")},
+			// the \r\n straddles two fragments of a long line
+			{Line: []byte("first half\r"), Partial: true},
+			{Line: []byte("\nsecond half"), Partial: true},
+			{Line: []byte(" end\r")},
+			// a bare \r at the end of a fragment
+			{Line: []byte("downloading\r"), Partial: true},
+			{Line: []byte("done")},
+		}
+	}
+
+	expected := []string{
+		"built on windows",
+		"progress 10%", "progress 50%", "progress 100%",
+		"first half\nsecond half end",
+		"downloading", "done",
+	}
+	if lines := process(true, entries()); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Expected %q, got %q", expected, lines)
+	}
+
+	expected = []string{
+		"built on windows\r",
+		"progress 10%\rprogress 50%\r\rprogress 100%",
+		"first half\r\nsecond half end\r",
+		"downloading\rdone",
+	}
+	if lines := process(false, entries()); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Expected the lines unchanged %q, got %q", expected, lines)
+	}
+}
+
+func TestSplitCarriageReturns(t *testing.T) {
+	lines, rest := splitCarriageReturns([]byte("a\rb\r\nc\rd\r"))
+	if !reflect.DeepEqual(lines, [][]byte{[]byte("a"), []byte("b\nc")}) || string(rest) != "d\r" {
+		t.Fatalf("Unexpected lines %q and rest %q", lines, rest)
+	}
+	if lines, rest = splitCarriageReturns([]byte("no carriage return")); lines != nil || string(rest) != "no carriage return" {
+		t.Fatalf("Unexpected lines %q and rest %q", lines, rest)
+	}
+}
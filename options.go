@@ -0,0 +1,247 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/loggerutils"
+)
+
+// Where the effective value of an option comes from
+const (
+	optionSourceLogOpt  = "log-opt"
+	optionSourceLabel   = "label"
+	optionSourceFile    = "file"
+	optionSourceEnv     = "env"
+	optionSourceDefault = "default"
+)
+
+// resolvedOption is the effective value of an option for a container
+type resolvedOption struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// optionDefault is the value of an option which is not set as a log-opt.
+// Options without a key are only set by the plugin environment variable.
+type optionDefault struct {
+	key   string
+	env   string
+	value string
+}
+
+var optionDefaults = []optionDefault{
+	{key: splunkURLPathKey, value: "/services/collector/event/1.0"},
+	{key: splunkInsecureSkipVerifyKey, value: "false"},
+	{key: splunkPinTypeKey, value: pinTypeCert},
+	{key: splunkTLSMinVersionKey, value: "1.2"},
+	{key: splunkFormatKey, value: splunkFormatInline},
+	{key: splunkVerifyConnectionKey, value: "false"},
+	{key: splunkVerifyTimeoutKey, value: "0s"},
+	{key: splunkVerifyIndexKey, value: "false"},
+	{key: splunkGzipCompressionKey, value: "false"},
+	{key: splunkGzipCompressionLevelKey, value: "-1"},
+	{key: splunkEventIDKey, value: "false"},
+	{key: splunkIncludeDockerEnvelopeKey, value: "false"},
+	{key: splunkIncludeResourcesKey, value: "false"},
+	{key: splunkMaxFieldsKey, value: "0"},
+	{key: splunkConfigHashKey, value: "false"},
+	{key: splunkIncludeImageDigestKey, value: "false"},
+	{key: splunkExitEventKey, value: "false"},
+	{key: splunkHeartbeatIntervalKey, env: envVarHeartbeatInterval, value: time.Duration(defaultHeartbeatInterval).String()},
+	{key: splunkPartialTimeoutKey, env: envVarPartialMsgBufferHoldDuration, value: defaultPartialMsgBufferHoldDuration.String()},
+	{key: splunkFlushOnIdleKey, value: "0s"},
+	{key: splunkMaxEventAgeKey, value: "0s"},
+	{key: splunkLocalCompressKey, value: "false"},
+	{key: splunkLocalMaxAgeKey, value: "0s"},
+	{key: splunkLocalJSONKey, value: "true"},
+	{key: splunkInputGzipKey, value: "false"},
+	{key: splunkNormalizeNewlinesKey, value: "false"},
+	{key: splunkStripANSIKey, value: "false"},
+	{key: splunkDisabledKey, value: "false"},
+	{key: splunkStrictOptsKey, value: "true"},
+	{key: splunkJournaldCopyKey, value: "false"},
+	{key: splunkAddBufferLatencyKey, value: "false"},
+	{key: splunkSyslogFacilityKey, value: defaultSyslogFacility},
+	{key: splunkSyslogTagKey, value: loggerutils.DefaultTemplate},
+	{key: splunkSyslogInsecureSkipVerifyKey, value: "false"},
+	{key: splunkLabelsRefreshKey, value: "0s"},
+	{key: splunkMetricsModeKey, value: "false"},
+	{key: splunkMetricsMappingKey, value: defaultMetricsMapping},
+	{key: splunkSequenceKey, value: "false"},
+	{key: splunkAccessLogFormatKey, value: accessLogFormatNone},
+	{key: splunkMaxTimeSkewKey, value: "0s"},
+	{key: splunkInvalidTimePolicyKey, value: invalidTimePolicyCorrect},
+	{key: splunkTokenVaultAuthKey, value: vaultAuthKubernetes},
+	{key: splunkTokenVaultFieldKey, value: defaultVaultTokenField},
+	{key: splunkLogDriverKey, value: defaultLogDriver},
+	{key: splunkCacheRequiredKey, value: "true"},
+	{key: splunkForwardingRequiredKey, value: "true"},
+	{key: splunkDegradedBackfillMaxKey, value: "0"},
+	{key: splunkBandwidthLimitKey, value: "0"},
+	{key: splunkBandwidthPolicyKey, value: bandwidthPolicyDrop},
+	{key: splunkPreserveOrderKey, value: "false"},
+	{key: splunkPreserveOrderWindowKey, value: defaultPreserveOrderWindow.String()},
+	{key: splunkBackendKey, value: splunkBackendHEC},
+	{key: splunkOTLPInsecureSkipVerifyKey, value: "false"},
+	{key: logSinkKey, value: logSinkHEC},
+	{key: logSinkElasticsearchIndexKey, value: defaultElasticsearchIndex},
+	{key: logSinkElasticsearchFieldsKey, value: defaultElasticsearchFields},
+	{key: logSinkElasticsearchInsecureSkipVerifyKey, value: "false"},
+	{key: tagKey, env: envVarDefaultTag, value: loggerutils.DefaultTemplate},
+	{env: envVarPostMessagesFrequency, value: defaultPostMessagesFrequency.String()},
+	{env: envVarPostMessagesBatchSize, value: strconv.Itoa(defaultPostMessagesBatchSize)},
+	{env: envVarPostMessagesMaxBytes, value: strconv.Itoa(defaultPostMessagesMaxBytes)},
+	{env: envVarBufferMaximum, value: strconv.Itoa(defaultBufferMaximum)},
+	{env: envVarStreamChannelSize, value: strconv.Itoa(defaultStreamChannelSize)},
+}
+
+// Prefix of the container labels overriding a splunk-* option, followed by
+// the option without splunk-, for example splunk.option.index=payments
+const optionLabelPrefix = "splunk.option."
+
+// optionLabel() returns the label overriding a splunk-* option
+func optionLabel(key string) string {
+	return optionLabelPrefix + strings.TrimPrefix(key, "splunk-")
+}
+
+// labelOptions() returns the splunk-* options set by the labels of a
+// container. Unknown or invalid overrides fail with SPLUNK_LABEL_OPTIONS_STRICT,
+// otherwise they are ignored with a warning.
+func labelOptions(labels map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		if strings.HasPrefix(label, optionLabelPrefix) {
+			names = append(names, label)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+	strict := getAdvancedOptionBool(envVarLabelOptionsStrict, defaultLabelOptionsStrict)
+	options := make(map[string]string, len(names))
+	var problems []string
+	for _, label := range names {
+		key := "splunk-" + strings.TrimPrefix(label, optionLabelPrefix)
+		var err error
+		if isSupportedLogOpt(key) {
+			err = ValidateLogOpt(map[string]string{key: labels[label]})
+		} else {
+			err = unknownLogOptError(key)
+		}
+		if err == nil {
+			options[key] = labels[label]
+			continue
+		}
+		if strict {
+			problems = append(problems, fmt.Sprintf("label %s: %v", label, err))
+		} else {
+			driverLog.WithField("label", label).WithError(err).Warn("Ignoring invalid label override")
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return options, nil
+}
+
+// defaultOptionEnv() returns the plugin environment variable holding the
+// default of a splunk-* option, SPLUNK_DEFAULT_INDEX for splunk-index
+func defaultOptionEnv(key string) string {
+	name := strings.TrimPrefix(key, "splunk-")
+	return "SPLUNK_DEFAULT_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applyOptionDefaults() returns the options of a container completed with
+// the options it doesn't set as log-opt, taken from a container label, the
+// defaults file or the plugin environment, and where each of them comes
+// from. Only the splunk-* options have labels and environment variables.
+// The log-opts are never overridden.
+func applyOptionDefaults(config map[string]string, labels map[string]string) (map[string]string, map[string]string, error) {
+	overrides, err := labelOptions(labels)
+	if err != nil {
+		return nil, nil, err
+	}
+	merged := make(map[string]string, len(config))
+	for key, value := range config {
+		merged[key] = value
+	}
+	sources := make(map[string]string)
+	fileOptions := hostDefaults.containerOptions(labels)
+	for _, key := range supportedLogOpts {
+		if _, ok := config[key]; ok {
+			continue
+		}
+		splunkOption := strings.HasPrefix(key, "splunk-")
+		if value, ok := overrides[key]; ok {
+			merged[key] = value
+			sources[key] = optionSourceLabel
+		} else if value, ok := fileOptions[key]; ok {
+			merged[key] = value
+			sources[key] = optionSourceFile
+		} else if value := os.Getenv(defaultOptionEnv(key)); value != "" && splunkOption {
+			merged[key] = value
+			sources[key] = optionSourceEnv
+		}
+	}
+	return merged, sources, nil
+}
+
+// resolveOptions() returns the effective options of a container by log-opt
+// key, or by environment variable for the plugin wide settings. The options
+// in sources were not set as log-opt but from the given source. Secrets are
+// redacted.
+func resolveOptions(config map[string]string, sources map[string]string) map[string]resolvedOption {
+	options := make(map[string]resolvedOption, len(config)+len(optionDefaults))
+	source := func(key string) string {
+		if s, ok := sources[key]; ok {
+			return s
+		}
+		return optionSourceLogOpt
+	}
+	for key, value := range redactedConfig(config) {
+		options[key] = resolvedOption{value, source(key)}
+	}
+	// the URL as the plugin uses it, without a collector path
+	if splunkURL, err := parseURL(logger.Info{Config: config}); err == nil {
+		options[splunkURLKey] = resolvedOption{splunkURL.Scheme + "://" + splunkURL.Host, source(splunkURLKey)}
+	}
+	for _, d := range optionDefaults {
+		if _, ok := config[d.key]; d.key != "" && ok {
+			continue
+		}
+		name := d.key
+		if name == "" {
+			name = d.env
+		}
+		option := resolvedOption{d.value, optionSourceDefault}
+		if value := os.Getenv(d.env); d.env != "" && value != "" {
+			option = resolvedOption{value, optionSourceEnv}
+		}
+		options[name] = option
+	}
+	return options
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveOptions(t *testing.T) {
+	os.Setenv(envVarHeartbeatInterval, "5m")
+	defer os.Setenv(envVarHeartbeatInterval, "")
+
+	options := resolveOptions(map[string]string{
+		splunkURLKey:    "https://splunk.example.com:8088",
+		splunkTokenKey:  "00000000-0000-0000-0000-000000001234",
+		splunkFormatKey: splunkFormatJSON,
+	}, nil)
+
+	tests := []struct {
+		name   string
+		value  string
+		source string
+	}{
+		{splunkURLKey, "https://splunk.example.com:8088", optionSourceLogOpt},
+		{splunkTokenKey, "****1234", optionSourceLogOpt},
+		{splunkFormatKey, splunkFormatJSON, optionSourceLogOpt},
+		{splunkURLPathKey, "/services/collector/event/1.0", optionSourceDefault},
+		{splunkHeartbeatIntervalKey, "5m", optionSourceEnv},
+		{envVarPostMessagesBatchSize, "1000", optionSourceDefault},
+	}
+	for _, test := range tests {
+		if option := options[test.name]; option.Value != test.value || option.Source != test.source {
+			t.Fatalf("Expected %s=%s from %s, got %+v", test.name, test.value, test.source, option)
+		}
+	}
+}
+
+func TestOptionPrecedence(t *testing.T) {
+	os.Setenv(defaultOptionEnv(splunkIndexKey), "env_index")
+	defer os.Unsetenv(defaultOptionEnv(splunkIndexKey))
+	os.Setenv(defaultOptionEnv(splunkSourceKey), "env_source")
+	defer os.Unsetenv(defaultOptionEnv(splunkSourceKey))
+	os.Setenv(defaultOptionEnv(splunkSourceTypeKey), "env_sourcetype")
+	defer os.Unsetenv(defaultOptionEnv(splunkSourceTypeKey))
+
+	if env := defaultOptionEnv(splunkGzipCompressionLevelKey); env != "SPLUNK_DEFAULT_GZIP_LEVEL" {
+		t.Fatalf("Unexpected environment variable %s", env)
+	}
+
+	config, sources, err := applyOptionDefaults(map[string]string{
+		splunkURLKey:   "https://splunk.example.com:8088",
+		splunkIndexKey: "opt_index",
+	}, map[string]string{
+		optionLabel(splunkIndexKey):  "label_index",
+		optionLabel(splunkSourceKey): "label_source",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := resolveOptions(config, sources)
+
+	tests := []struct {
+		name   string
+		value  string
+		source string
+	}{
+		{splunkIndexKey, "opt_index", optionSourceLogOpt},
+		{splunkSourceKey, "label_source", optionSourceLabel},
+		{splunkSourceTypeKey, "env_sourcetype", optionSourceEnv},
+		{splunkFormatKey, splunkFormatInline, optionSourceDefault},
+	}
+	for _, test := range tests {
+		if option := options[test.name]; option.Value != test.value || option.Source != test.source {
+			t.Fatalf("Expected %s=%s from %s, got %+v", test.name, test.value, test.source, option)
+		}
+	}
+}
+
+func TestLabelOptions(t *testing.T) {
+	labels := map[string]string{
+		optionLabel(splunkIndexKey):           "payments",
+		optionLabel(splunkGzipCompressionKey): "maybe",
+		optionLabelPrefix + "indx":            "typo",
+		"other":                               "label",
+	}
+
+	// invalid overrides are ignored by default
+	config, sources, err := applyOptionDefaults(nil, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config[splunkIndexKey] != "payments" || sources[splunkIndexKey] != optionSourceLabel {
+		t.Fatalf("Expected the index from the label, got %v", config)
+	}
+	if _, ok := config[splunkGzipCompressionKey]; ok {
+		t.Fatalf("Expected the invalid override to be ignored, got %v", config)
+	}
+	if _, ok := config["splunk-indx"]; ok {
+		t.Fatalf("Expected the unknown override to be ignored, got %v", config)
+	}
+
+	os.Setenv(envVarLabelOptionsStrict, "true")
+	defer os.Unsetenv(envVarLabelOptionsStrict)
+	_, _, err = applyOptionDefaults(nil, labels)
+	if err == nil {
+		t.Fatal("Expected invalid overrides to fail in strict mode")
+	}
+	for _, expected := range []string{optionLabel(splunkGzipCompressionKey), "did you mean 'splunk-index'"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %q in the error, got %v", expected, err)
+		}
+	}
+}
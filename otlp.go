@@ -0,0 +1,505 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	splunkBackendHEC  = "hec"
+	splunkBackendOTLP = "otlp"
+	splunkBackendBoth = "both"
+)
+
+const (
+	// Path of the logs of an OTLP/HTTP endpoint given without path
+	otlpLogsPath = "/v1/logs"
+	// How long an export request can take
+	otlpTimeout = 30 * time.Second
+	// Name of the instrumentation scope of the log records
+	otlpScopeName = "splunk-logging-plugin"
+)
+
+var otlpTLSOptions = tlsOptions{
+	caPath:             splunkOTLPCAPathKey,
+	caName:             splunkOTLPCANameKey,
+	insecureSkipVerify: splunkOTLPInsecureSkipVerifyKey,
+	clientCert:         splunkOTLPCertKey,
+	clientKey:          splunkOTLPKeyKey,
+}
+
+// OTLP severity numbers and texts of the syslog severities
+var (
+	otlpSeverityNumbers = map[int]int{
+		severityCrit: 21, severityErr: 17, severityWarning: 13,
+		severityNotice: 10, severityInfo: 9, severityDebug: 5,
+	}
+	otlpSeverityTexts = map[int]string{
+		severityCrit: "FATAL", severityErr: "ERROR", severityWarning: "WARN",
+		severityNotice: "NOTICE", severityInfo: "INFO", severityDebug: "DEBUG",
+	}
+)
+
+// otlpExporter exports the events of a container as log records to the
+// OTLP/HTTP endpoint of an OpenTelemetry collector, with the JSON encoding
+type otlpExporter struct {
+	url       string
+	headers   map[string]string
+	client    *http.Client
+	transport *http.Transport
+
+	// attributes of the container, the resource of every record
+	resource []otlpKeyValue
+}
+
+// JSON encoding of an ExportLogsServiceRequest, 64 bits integers are strings
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue has one of its values set, or none for null
+type otlpAnyValue struct {
+	StringValue *string        `json:"stringValue,omitempty"`
+	BoolValue   *bool          `json:"boolValue,omitempty"`
+	IntValue    *string        `json:"intValue,omitempty"`
+	DoubleValue *float64       `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArray     `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKeyValues `json:"kvlistValue,omitempty"`
+}
+
+type otlpArray struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKeyValues struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpExportResponse tells which records were rejected by the collector
+type otlpExportResponse struct {
+	PartialSuccess *struct {
+		RejectedLogRecords json.RawMessage `json:"rejectedLogRecords"`
+		ErrorMessage       string          `json:"errorMessage"`
+	} `json:"partialSuccess"`
+}
+
+// otlpError is an export request rejected by the collector
+type otlpError struct {
+	status     string
+	statusCode int
+	// start of the response body
+	body string
+}
+
+func (e *otlpError) Error() string {
+	snippet := strings.TrimSpace(e.body)
+	if len(snippet) > hecErrorSnippetLimit {
+		snippet = snippet[:hecErrorSnippetLimit] + "..."
+	}
+	if snippet == "" {
+		return fmt.Sprintf("%s: failed to export logs - %s", driverName, e.status)
+	}
+	return fmt.Sprintf("%s: failed to export logs - %s - %s", driverName, e.status, snippet)
+}
+
+// retryable() returns true for the statuses retried by OTLP/HTTP exporters
+func (e *otlpError) retryable() bool {
+	switch e.statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// busy() returns true when the collector asks to send later
+func (e *otlpError) busy() bool {
+	return e.statusCode == http.StatusTooManyRequests || e.statusCode == http.StatusServiceUnavailable
+}
+
+// parseBackend() returns the value of splunk-backend, hec by default
+func parseBackend(config map[string]string) (string, error) {
+	backend, ok := config[splunkBackendKey]
+	if !ok {
+		return splunkBackendHEC, nil
+	}
+	switch backend {
+	case splunkBackendHEC, splunkBackendOTLP, splunkBackendBoth:
+		return backend, nil
+	}
+	return "", fmt.Errorf("unknown backend specified %s, supported backends are hec, otlp and both", backend)
+}
+
+// parseOTLPEndpoint() returns the URL the logs are exported to, the endpoint
+// followed by /v1/logs when it has no path
+func parseOTLPEndpoint(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to parse %s: %v", driverName, splunkOTLPEndpointKey, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "grpc", "grpcs":
+		return "", fmt.Errorf("%s: OTLP/gRPC is not supported, set %s to the OTLP/HTTP endpoint of the collector, such as http://collector:4318", driverName, splunkOTLPEndpointKey)
+	default:
+		return "", fmt.Errorf("%s: %s must be an http:// or https:// URL, got %q", driverName, splunkOTLPEndpointKey, value)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%s: %s must be <scheme>://<host>[:<port>][/<path>], got %q", driverName, splunkOTLPEndpointKey, value)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpLogsPath
+	}
+	return u.String(), nil
+}
+
+// parseOTLPHeaders() parses the headers of the export requests,
+// key1=value1,key2=value2
+func parseOTLPHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, header := range strings.Split(value, ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		kv := strings.SplitN(header, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("%s: %s must be a list of key=value, got %q", driverName, splunkOTLPHeadersKey, redactSecret(header))
+		}
+		headers[key] = strings.TrimSpace(kv[1])
+	}
+	return headers, nil
+}
+
+func newOTLPExporter(info logger.Info, hostname string) (*otlpExporter, error) {
+	endpoint, ok := info.Config[splunkOTLPEndpointKey]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is expected with %s=%s", driverName, splunkOTLPEndpointKey, splunkBackendKey, info.Config[splunkBackendKey])
+	}
+	exportURL, err := parseOTLPEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := parseOTLPHeaders(info.Config[splunkOTLPHeadersKey])
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(info.Config, otlpTLSOptions)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	return &otlpExporter{
+		url:       exportURL,
+		headers:   headers,
+		client:    &http.Client{Transport: transport, Timeout: otlpTimeout},
+		transport: transport,
+		resource:  otlpResourceAttributes(info, hostname),
+	}, nil
+}
+
+// otlpResourceAttributes() describes the container with the attributes of
+// the OpenTelemetry semantic conventions
+func otlpResourceAttributes(info logger.Info, hostname string) []otlpKeyValue {
+	attributes := []otlpKeyValue{
+		{"container.id", otlpString(info.FullID())},
+		{"container.name", otlpString(info.Name())},
+		{"container.runtime", otlpString("docker")},
+		{"host.name", otlpString(hostname)},
+	}
+	if info.ImageFullID() != "" {
+		attributes = append(attributes, otlpKeyValue{"container.image.id", otlpString(info.ImageFullID())})
+	}
+	if name, tag := splitImageName(info.ImageName()); name != "" {
+		attributes = append(attributes, otlpKeyValue{"container.image.name", otlpString(name)})
+		if tag != "" {
+			attributes = append(attributes, otlpKeyValue{"container.image.tags", otlpAnyValue{ArrayValue: &otlpArray{Values: []otlpAnyValue{otlpString(tag)}}}})
+		}
+	}
+	return attributes
+}
+
+// splitImageName() returns the repository and the tag of an image name,
+// without the digest
+func splitImageName(image string) (string, string) {
+	if i := strings.IndexByte(image, '@'); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndexByte(image, ':'); i > strings.LastIndexByte(image, '/') {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+// export() sends the messages in a single request and returns the size of
+// the request and the number of records the collector rejected
+func (e *otlpExporter) export(messages []*splunkMessage) (int, int, error) {
+	body, err := json.Marshal(e.request(messages, time.Now()))
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := e.post(context.Background(), body)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		errBody, _ := ioutil.ReadAll(io.LimitReader(res.Body, hecErrorBodyLimit))
+		io.Copy(ioutil.Discard, res.Body)
+		return 0, 0, &otlpError{status: res.Status, statusCode: res.StatusCode, body: string(errBody)}
+	}
+	var response otlpExportResponse
+	json.NewDecoder(io.LimitReader(res.Body, hecErrorBodyLimit)).Decode(&response)
+	io.Copy(ioutil.Discard, res.Body)
+	if response.PartialSuccess == nil {
+		return len(body), 0, nil
+	}
+	rejected, _ := strconv.Atoi(strings.Trim(string(response.PartialSuccess.RejectedLogRecords), `"`))
+	if rejected > 0 {
+		senderLog.WithField("url", e.url).WithField("rejected", rejected).WithField("error", response.PartialSuccess.ErrorMessage).Warn("Collector rejected log records")
+	}
+	return len(body), rejected, nil
+}
+
+// verify() sends an empty export request
+func (e *otlpExporter) verify(ctx context.Context) error {
+	res, err := e.post(ctx, []byte("{}"))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, hecErrorBodyLimit))
+		return fmt.Errorf("%s: failed to verify connection - %s - %s", driverName, res.Status, body)
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
+
+func (e *otlpExporter) post(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	return e.client.Do(req.WithContext(ctx))
+}
+
+func (e *otlpExporter) close() {
+	if e != nil {
+		e.transport.CloseIdleConnections()
+	}
+}
+
+func (e *otlpExporter) request(messages []*splunkMessage, now time.Time) *otlpLogsRequest {
+	observed := strconv.FormatInt(now.UnixNano(), 10)
+	records := make([]otlpLogRecord, len(messages))
+	for i, message := range messages {
+		records[i] = otlpRecord(message, observed)
+	}
+	return &otlpLogsRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource: otlpResource{Attributes: e.resource},
+		ScopeLogs: []otlpScopeLogs{{
+			Scope:      otlpScope{Name: otlpScopeName},
+			LogRecords: records,
+		}},
+	}}}
+}
+
+// otlpRecord() maps a message to a log record. The line of container
+// messages is the body, with the level found at its beginning as severity.
+// Other events are the body as they are sent to HEC. The Splunk metadata
+// and the indexed fields are attributes.
+func otlpRecord(message *splunkMessage, observed string) otlpLogRecord {
+	record := otlpLogRecord{ObservedTimeUnixNano: observed}
+	if nanos, ok := eventTimeNanos(message.Time); ok {
+		record.TimeUnixNano = strconv.FormatInt(nanos, 10)
+	}
+	var line []byte
+	switch event := message.Event.(type) {
+	case *splunkMessageEvent:
+		switch l := event.Line.(type) {
+		case string:
+			line = []byte(l)
+			record.Body = otlpString(l)
+		case *json.RawMessage:
+			line = *l
+			record.Body = otlpJSONValue(*l)
+		}
+		if event.Source != "" {
+			record.Attributes = append(record.Attributes, otlpKeyValue{"log.iostream", otlpString(event.Source)})
+		}
+		if event.Tag != "" {
+			record.Attributes = append(record.Attributes, otlpKeyValue{"docker.tag", otlpString(event.Tag)})
+		}
+		record.Attributes = appendOTLPAttributes(record.Attributes, event.Attrs)
+	case string:
+		line = []byte(event)
+		record.Body = otlpString(event)
+	default:
+		if encoded, err := json.Marshal(event); err == nil {
+			record.Body = otlpJSONValue(encoded)
+		}
+	}
+	if severity, ok := detectSeverity(line); ok {
+		record.SeverityNumber = otlpSeverityNumbers[severity]
+		record.SeverityText = otlpSeverityTexts[severity]
+	}
+	for _, attribute := range []otlpKeyValue{
+		{"com.splunk.source", otlpString(message.Source)},
+		{"com.splunk.sourcetype", otlpString(message.SourceType)},
+		{"com.splunk.index", otlpString(message.Index)},
+	} {
+		if *attribute.Value.StringValue != "" {
+			record.Attributes = append(record.Attributes, attribute)
+		}
+	}
+	record.Attributes = appendOTLPAttributes(record.Attributes, message.Fields)
+	return record
+}
+
+// appendOTLPAttributes() appends the values sorted by key
+func appendOTLPAttributes(attributes []otlpKeyValue, values map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attributes = append(attributes, otlpKeyValue{key, otlpString(values[key])})
+	}
+	return attributes
+}
+
+// eventTimeNanos() converts the time of an event, seconds with an optional
+// fraction, to nanoseconds
+func eventTimeNanos(value string) (int64, bool) {
+	seconds, fraction := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		seconds, fraction = value[:i], value[i+1:]
+	}
+	s, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(fraction) > 9 {
+		fraction = fraction[:9]
+	}
+	nanos, err := strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return s*int64(time.Second) + nanos, true
+}
+
+func otlpString(value string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &value}
+}
+
+// otlpJSONValue() maps a JSON document to a value, objects become lists of
+// key values. A document which cannot be decoded is a string.
+func otlpJSONValue(document []byte) otlpAnyValue {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return otlpString(string(document))
+	}
+	return otlpValue(value)
+}
+
+func otlpValue(value interface{}) otlpAnyValue {
+	switch v := value.(type) {
+	case string:
+		return otlpString(v)
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			s := strconv.FormatInt(i, 10)
+			return otlpAnyValue{IntValue: &s}
+		}
+		f, _ := v.Float64()
+		return otlpAnyValue{DoubleValue: &f}
+	case []interface{}:
+		values := make([]otlpAnyValue, len(v))
+		for i, item := range v {
+			values[i] = otlpValue(item)
+		}
+		return otlpAnyValue{ArrayValue: &otlpArray{Values: values}}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]otlpKeyValue, len(keys))
+		for i, key := range keys {
+			values[i] = otlpKeyValue{key, otlpValue(v[key])}
+		}
+		return otlpAnyValue{KvlistValue: &otlpKeyValues{Values: values}}
+	}
+	return otlpAnyValue{}
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// otlpCollector keeps the export requests it received
+type otlpCollector struct {
+	mu       sync.Mutex
+	paths    []string
+	headers  []http.Header
+	requests []otlpLogsRequest
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request otlpLogsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.paths = append(c.paths, r.URL.Path)
+	c.headers = append(c.headers, r.Header)
+	c.requests = append(c.requests, request)
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+func (c *otlpCollector) records() []otlpLogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var records []otlpLogRecord
+	for _, request := range c.requests {
+		for _, resourceLogs := range request.ResourceLogs {
+			for _, scopeLogs := range resourceLogs.ScopeLogs {
+				records = append(records, scopeLogs.LogRecords...)
+			}
+		}
+	}
+	return records
+}
+
+func otlpAttribute(attributes []otlpKeyValue, key string) (otlpAnyValue, bool) {
+	for _, attribute := range attributes {
+		if attribute.Key == key {
+			return attribute.Value, true
+		}
+	}
+	return otlpAnyValue{}, false
+}
+
+func TestParseOTLPEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		url   string
+		err   string
+	}{
+		{value: "http://collector:4318", url: "http://collector:4318/v1/logs"},
+		{value: "https://collector:4318/", url: "https://collector:4318/v1/logs"},
+		{value: "http://collector/custom/logs", url: "http://collector/custom/logs"},
+		{value: "grpc://collector:4317", err: "OTLP/gRPC is not supported"},
+		{value: "collector:4318", err: "must be an http:// or https:// URL"},
+		{value: "http:///v1/logs", err: "must be <scheme>://<host>"},
+	} {
+		u, err := parseOTLPEndpoint(tc.value)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s: expected an error with %q, got %v", tc.value, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.value, err)
+		}
+		if u != tc.url {
+			t.Fatalf("%s: expected %s, got %s", tc.value, tc.url, u)
+		}
+	}
+}
+
+func TestOTLPRecord(t *testing.T) {
+	line := json.RawMessage(`{"level":"warn","msg":"slow","took":1.5,"count":3}`)
+	message := &splunkMessage{
+		Event:      &splunkMessageEvent{Line: &line, Source: "stdout", Tag: "web", Attrs: map[string]string{"team": "payments"}},
+		Time:       "1500000000.123456",
+		Source:     "app",
+		SourceType: "docker",
+		Fields:     map[string]string{"event_id": "42"},
+	}
+	record := otlpRecord(message, "1")
+	if record.TimeUnixNano != "1500000000123456000" {
+		t.Fatalf("Expected the time of the message, got %s", record.TimeUnixNano)
+	}
+	if record.SeverityNumber != 13 || record.SeverityText != "WARN" {
+		t.Fatalf("Expected the WARN severity, got %d %s", record.SeverityNumber, record.SeverityText)
+	}
+	if record.Body.KvlistValue == nil {
+		t.Fatalf("Expected the JSON line as a list of key values, got %+v", record.Body)
+	}
+	body, _ := otlpAttribute(record.Body.KvlistValue.Values, "count")
+	if body.IntValue == nil || *body.IntValue != "3" {
+		t.Fatalf("Expected an integer count, got %+v", body)
+	}
+	for key, expected := range map[string]string{
+		"log.iostream":          "stdout",
+		"docker.tag":            "web",
+		"team":                  "payments",
+		"com.splunk.source":     "app",
+		"com.splunk.sourcetype": "docker",
+		"event_id":              "42",
+	} {
+		value, ok := otlpAttribute(record.Attributes, key)
+		if !ok || *value.StringValue != expected {
+			t.Fatalf("Expected the attribute %s=%s, got %+v", key, expected, record.Attributes)
+		}
+	}
+	if _, ok := otlpAttribute(record.Attributes, "com.splunk.index"); ok {
+		t.Fatalf("Expected no index attribute without an index")
+	}
+
+	// without a level in the line there is no severity
+	record = otlpRecord(&splunkMessage{Event: &splunkMessageEvent{Line: "starting", Source: "stderr"}}, "1")
+	if record.SeverityNumber != 0 || *record.Body.StringValue != "starting" {
+		t.Fatalf("Expected a string body without severity, got %+v", record)
+	}
+}
+
+func TestOTLPBackend(t *testing.T) {
+	collector := &otlpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkBackendKey:      splunkBackendOTLP,
+			splunkOTLPEndpointKey: server.URL,
+			splunkOTLPHeadersKey:  "authorization=Bearer secret, x-scope=logs",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageID:   "sha256:abcdef",
+		ContainerImageName: "registry:5000/team/app:1.2",
+	}
+	// neither splunk-url nor splunk-token are needed to export over OTLP
+	l, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Log(&logger.Message{Line: []byte("ERROR failed to connect"), Source: "stdout", Timestamp: time.Unix(1500000000, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := collector.records()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	if *records[0].Body.StringValue != "ERROR failed to connect" || records[0].SeverityNumber != 17 {
+		t.Fatalf("Unexpected record %+v", records[0])
+	}
+	if records[0].TimeUnixNano != "1500000000000000000" {
+		t.Fatalf("Expected the time of the message, got %s", records[0].TimeUnixNano)
+	}
+	if collector.paths[0] != otlpLogsPath {
+		t.Fatalf("Expected the records on %s, got %s", otlpLogsPath, collector.paths[0])
+	}
+	if collector.headers[0].Get("Authorization") != "Bearer secret" || collector.headers[0].Get("X-Scope") != "logs" {
+		t.Fatalf("Expected the headers of %s, got %v", splunkOTLPHeadersKey, collector.headers[0])
+	}
+	resource := collector.requests[0].ResourceLogs[0].Resource.Attributes
+	for key, expected := range map[string]string{
+		"container.id":         "containeriid",
+		"container.name":       "container_name",
+		"container.image.id":   "sha256:abcdef",
+		"container.image.name": "registry:5000/team/app",
+	} {
+		value, ok := otlpAttribute(resource, key)
+		if !ok || *value.StringValue != expected {
+			t.Fatalf("Expected the resource attribute %s=%s, got %+v", key, expected, resource)
+		}
+	}
+	tags, _ := otlpAttribute(resource, "container.image.tags")
+	if tags.ArrayValue == nil || len(tags.ArrayValue.Values) != 1 || *tags.ArrayValue.Values[0].StringValue != "1.2" {
+		t.Fatalf("Expected the image tag, got %+v", tags)
+	}
+}
+
+// failingTransport answers the first failures requests with 500 and the
+// others like HEC
+type failingTransport struct {
+	stubTransport
+	failures int
+}
+
+func (f *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	fail := f.failures > 0
+	f.failures--
+	f.mu.Unlock()
+	if fail {
+		return &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Status:     "500 Internal Server Error",
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+	return f.stubTransport.RoundTrip(req)
+}
+
+func TestOTLPBothBackendsRetry(t *testing.T) {
+	collector := &otlpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	transport := &failingTransport{failures: 1}
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:          "https://splunk.example.com:8088",
+			splunkTokenKey:        "00000000-0000-0000-0000-000000000000",
+			splunkBackendKey:      splunkBackendBoth,
+			splunkOTLPEndpointKey: server.URL,
+		},
+		ContainerID: "containeriid",
+	}
+	l, err := NewWithClient(info, &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	hec := l.(*splunkLoggerInline).hec
+	messages := []*splunkMessage{{Event: &splunkMessageEvent{Line: "one", Source: "stdout"}}}
+
+	if err := hec.tryPostMessages(messages); err == nil {
+		t.Fatal("Expected the failure of HEC")
+	}
+	if err := hec.tryPostMessages(messages); err != nil {
+		t.Fatal(err)
+	}
+	// the retry only posted the batch to HEC again
+	if records := collector.records(); len(records) != 1 {
+		t.Fatalf("Expected the record exported once, got %d", len(records))
+	}
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if len(transport.messages) != 1 {
+		t.Fatalf("Expected the message posted to HEC once, got %d", len(transport.messages))
+	}
+}
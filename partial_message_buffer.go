@@ -18,9 +18,10 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"time"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types/backend"
 	"github.com/docker/docker/api/types/plugins/logdriver"
 )
 
@@ -33,6 +34,17 @@ type partialMsgBuffer struct {
 	tBuf        bytes.Buffer
 	bufferTimer time.Time
 	bufferReset bool
+	// timestamp of the last fragment replaced by the processor, if any
+	originalTime string
+}
+
+// attrs() returns the attributes of the reassembled message, nil unless its
+// timestamp was replaced
+func (b *partialMsgBuffer) attrs() backend.LogAttributes {
+	if b.originalTime == "" {
+		return nil
+	}
+	return backend.LogAttributes{originalTimeField: b.originalTime}
 }
 
 func (b *partialMsgBuffer) append(l *logdriver.LogEntry) (err error) {
@@ -40,7 +52,7 @@ func (b *partialMsgBuffer) append(l *logdriver.LogEntry) (err error) {
 	ps, err := b.tBuf.Write(l.Line)
 	b.bufferReset = false
 	if err != nil {
-		logrus.WithError(err).WithField("Appending to Temp Buffer with size:", ps).Error(
+		processorLog.WithError(err).WithField("size", ps).Error(
 			"Error appending to temp buffer")
 		b.reset()
 		return err
@@ -52,22 +64,39 @@ func (b *partialMsgBuffer) reset() {
 	if b.bufferReset {
 		b.tBuf.Reset()
 		b.bufferTimer = time.Now()
-		logrus.WithField("resetBufferTimer", b.bufferTimer).Debug("resetting buffer Timer")
+		processorLog.WithField("resetBufferTimer", b.bufferTimer).Debug("resetting buffer Timer")
 	}
 }
 
 func (b *partialMsgBuffer) hasHoldDurationExpired(t time.Time) bool {
 	diff := t.Sub(b.bufferTimer)
-	logrus.WithField("currentTime", t).WithField("bufferTime", b.bufferTimer).WithField("diff", diff).Debug("Timeout settings")
-	logrus.WithField("partialMsgBufferHoldDuration", partialMsgBufferHoldDuration).WithField("hasHoldDurationExpired", diff > partialMsgBufferHoldDuration).Debug("check timeout")
+	processorLog.WithField("currentTime", t).WithField("bufferTime", b.bufferTimer).WithField("diff", diff).Debug("Timeout settings")
+	processorLog.WithField("partialMsgBufferHoldDuration", partialMsgBufferHoldDuration).WithField("hasHoldDurationExpired", diff > partialMsgBufferHoldDuration).Debug("check timeout")
 	return diff > partialMsgBufferHoldDuration
 }
 
 func (b *partialMsgBuffer) hasLengthExceeded() bool {
-	logrus.WithField("buffer size limit exceeded", partialMsgBufferMaximum < b.tBuf.Len()).Debug("check size")
+	processorLog.WithField("sizeLimitExceeded", partialMsgBufferMaximum < b.tBuf.Len()).Debug("check size")
 	return partialMsgBufferMaximum < b.tBuf.Len()
 }
 
 func (b *partialMsgBuffer) shouldFlush(t time.Time) bool {
 	return b.hasLengthExceeded() || b.hasHoldDurationExpired(t)
 }
+
+// parsePartialTimeout() returns how long an incomplete reassembly waits for its
+// next fragment before it is flushed, 0 means until the next fragment
+func parsePartialTimeout(config map[string]string) (time.Duration, error) {
+	timeoutStr, ok := config[splunkPartialTimeoutKey]
+	if !ok {
+		return partialMsgBufferHoldDuration, nil
+	}
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("%s: %s must not be negative", driverName, splunkPartialTimeoutKey)
+	}
+	return timeout, nil
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// forwardingControl pauses the forwarding to HEC of every container, e.g.
+// during a Splunk maintenance. While paused events stay in the container
+// buffers, up to their maximum, and are still written locally.
+type forwardingControl struct {
+	mu          sync.Mutex
+	pausedSince time.Time
+}
+
+var forwarding = &forwardingControl{}
+
+func (c *forwardingControl) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.pausedSince.IsZero() {
+		return
+	}
+	c.pausedSince = time.Now()
+	driverLog.Warn("Forwarding paused")
+}
+
+func (c *forwardingControl) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pausedSince.IsZero() {
+		return
+	}
+	driverLog.WithField("paused", time.Since(c.pausedSince)).Info("Forwarding resumed")
+	c.pausedSince = time.Time{}
+}
+
+func (c *forwardingControl) paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.pausedSince.IsZero()
+}
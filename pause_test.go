@@ -0,0 +1,260 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/jsonfilelog"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+func TestPauseForwarding(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		events += strings.Count(string(body), `"event"`)
+	}))
+	defer server.Close()
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+
+	if err := os.Setenv(envVarPostMessagesFrequency, "5ms"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv(envVarPostMessagesFrequency, "")
+
+	admin := newAdminServer(newDriver(), newLogRingBuffer(1), "secret")
+	control := func(path string) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		var state map[string]bool
+		if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		if state["paused"] != (path == "/pause") {
+			t.Fatalf("Unexpected state after %s: %v", path, state)
+		}
+	}
+	control("/pause")
+	defer forwarding.resume()
+
+	loggerDriver, err := New(logger.Info{
+		Config: map[string]string{
+			splunkURLKey:              server.URL,
+			splunkTokenKey:            "token",
+			splunkVerifyConnectionKey: "false",
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageID:   "contaimageid",
+		ContainerImageName: "container_image_name",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(fmt.Sprintf("%d", i)), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if received() != 0 {
+		t.Fatalf("Expected no event forwarded while paused, got %d", received())
+	}
+
+	control("/resume")
+	for deadline := time.Now().Add(5 * time.Second); received() < 10 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if received() != 10 {
+		t.Fatalf("Expected the buffered events to be flushed on resume, got %d", received())
+	}
+	if err := loggerDriver.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPauseContainer(t *testing.T) {
+	os.Setenv(envVarLifecycleEvents, "true")
+	defer os.Setenv(envVarLifecycleEvents, "")
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "containeriid",
+	}
+	d := newDriver()
+	file := startTestLogging(t, d, dir, info)
+	defer d.StopLogging(file)
+	writer, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	admin := newAdminServer(d, newLogRingBuffer(1), "secret")
+	control := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w.Code
+	}
+	enc := protoio.NewUint32DelimitedWriter(writer, binary.BigEndian)
+	logged := 0
+	log := func(line string) {
+		if err := enc.WriteMsg(&logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte(line)}); err != nil {
+			t.Fatal(err)
+		}
+		logged++
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			local, _ := ioutil.ReadFile(filepath.Join(dir, info.ContainerID+".json"))
+			if bytes.Count(local, []byte("\n")) == logged {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the line %s to be logged locally, got %q", line, local)
+			}
+		}
+	}
+
+	if code := control("/containers/unknown/pause"); code != http.StatusNotFound {
+		t.Fatalf("Expected an unknown container to be rejected, got %d", code)
+	}
+	if code := control("/containers/container/pause?mode=later"); code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown mode to be rejected, got %d", code)
+	}
+	if code := control("/containers/container/pause"); code != http.StatusOK {
+		t.Fatalf("Expected the container to be paused by its id prefix, got %d", code)
+	}
+	states := d.containerStates()
+	if states[0].PausedSince == nil || states[0].PauseMode != pauseModeDiscard {
+		t.Fatalf("Expected the container to be paused, got %+v", states[0])
+	}
+	log("discarded")
+	control("/containers/containeriid/resume")
+	log("forwarded")
+	control("/containers/containeriid/pause?mode=spool")
+	log("spooled")
+	control("/containers/containeriid/resume")
+	log("last")
+	if states := d.containerStates(); states[0].PausedSince != nil {
+		t.Fatalf("Expected the container to be resumed, got %+v", states[0])
+	}
+	d.StopLogging(file)
+
+	var events []string
+	for _, message := range hec.messages {
+		event, err := message.EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line, ok := event["line"]; ok {
+			events = append(events, fmt.Sprint(line))
+		} else {
+			events = append(events, fmt.Sprint(event["action"]))
+		}
+	}
+	expected := []string{lifecycleStart, lifecyclePaused, lifecycleResumed, "forwarded", lifecyclePaused, lifecycleResumed, "spooled", "last", lifecycleStop}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the events %v, got %v", expected, events)
+	}
+}
+
+func TestResumeReplaysSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		ContainerID: "containeriid",
+		LogPath:     filepath.Join(dir, "containeriid.json"),
+	}
+	jsonl, err := jsonfilelog.New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jsonl.Close()
+	l := &recordingLogger{}
+	p := &pausableLogger{Logger: l, lf: &logPair{jsonl: jsonl, info: info}}
+	log := func(line string) {
+		for _, sink := range []logger.Logger{jsonl, p} {
+			if err := sink.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	log("forwarded")
+	if paused, err := p.pause(pauseModeSpool); !paused || err != nil {
+		t.Fatalf("Expected the container to be paused, got %v, %v", paused, err)
+	}
+	log("spooled 1")
+	log("spooled 2")
+	if !p.resume() {
+		t.Fatal("Expected the container to be resumed")
+	}
+
+	// only the lines logged while paused are replayed from the local log
+	var lines []string
+	for _, msg := range l.messages {
+		lines = append(lines, string(msg.Line))
+	}
+	if fmt.Sprint(lines) != "[forwarded spooled 1 spooled 2]" {
+		t.Fatalf("Expected the spooled lines to be replayed once, got %q", lines)
+	}
+}
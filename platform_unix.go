@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/tonistiigi/fifo"
+)
+
+// selfLogDir is where a relative self log file is written, the state
+// directory of the plugin which also holds the local json logs
+const selfLogDir = "/var/log/docker"
+
+// signals toggling the debug level and writing the state dump
+var (
+	debugLevelSignals = []os.Signal{syscall.SIGUSR2}
+	stateDumpSignals  = []os.Signal{syscall.SIGUSR1}
+)
+
+// openStream() opens the fifo the daemon writes the output of a container
+// to, with read only access
+func openStream(file string) (io.ReadCloser, error) {
+	return fifo.OpenFifo(context.Background(), file, syscall.O_RDONLY, 0700)
+}
+
+// serve() answers the daemon on the plugin socket
+func serve(h sdk.Handler) error {
+	return h.ServeUnix(socketAddress, 0)
+}
+
+func statfsFreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// newTestStream() creates the fifo of a container in dir, as the daemon does
+func newTestStream(t *testing.T, dir string, id string) string {
+	file := filepath.Join(dir, id+".fifo")
+	if err := syscall.Mkfifo(file, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/docker/go-plugins-helpers/sdk"
+	"golang.org/x/sys/windows"
+)
+
+// pipeAddress is the named pipe the daemon finds the plugin at, through the
+// spec file written in its plugins folder
+const pipeAddress = `//./pipe/splunklog`
+
+// pipeDialTimeout is how long to wait for the daemon to accept the
+// connection to the named pipe of a container
+const pipeDialTimeout = 10 * time.Second
+
+// selfLogDir is where a relative self log file is written, the state
+// directory of the plugin which also holds the local json logs
+var selfLogDir = filepath.Join(programData(), "docker", "splunk-logging-plugin")
+
+// Windows has no SIGUSR1 and SIGUSR2, LOG_LEVEL sets the debug level and
+// there is no state dump
+var (
+	debugLevelSignals []os.Signal
+	stateDumpSignals  []os.Signal
+)
+
+// programData() returns the ProgramData folder of the host
+func programData() string {
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		return dir
+	}
+	return `C:\ProgramData`
+}
+
+// openStream() connects to the named pipe the daemon writes the output of a
+// container to, the daemon hands an npipe path rather than a fifo on Windows
+func openStream(file string) (io.ReadCloser, error) {
+	timeout := pipeDialTimeout
+	return winio.DialPipe(file, &timeout)
+}
+
+// serve() answers the daemon on the plugin named pipe
+func serve(h sdk.Handler) error {
+	return h.ServeWindows(pipeAddress, "splunklog", sdk.WindowsDefaultDaemonRootDir(), nil)
+}
+
+func statfsFreeSpace(path string) (uint64, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, &total, &totalFree); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+// testPipeServer stands for the daemon, which serves the output of a
+// container on a named pipe
+type testPipeServer struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+	// receives every accepted connection
+	accepted chan net.Conn
+}
+
+// newTestStream() starts a named pipe server for the container and returns
+// the path of the pipe, the server stops with the test
+func newTestStream(t *testing.T, dir string, id string) string {
+	return newTestPipeServer(t, id).path()
+}
+
+func newTestPipeServer(t *testing.T, id string) *testPipeServer {
+	listener, err := winio.ListenPipe(`\\.\pipe\splunk-logging-plugin-test-`+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testPipeServer{listener: listener, accepted: make(chan net.Conn, 10)}
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *testPipeServer) path() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testPipeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		select {
+		case s.accepted <- conn:
+		default:
+		}
+	}
+}
+
+func (s *testPipeServer) close() {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func TestStartLoggingNamedPipe(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "containeriid",
+		LogPath:     filepath.Join(dir, "containeriid.json"),
+	}
+	server := newTestPipeServer(t, info.ContainerID)
+	d := newDriver()
+	if err := d.StartLogging(server.path(), info); err != nil {
+		t.Fatal(err)
+	}
+
+	var conn net.Conn
+	select {
+	case conn = <-server.accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the plugin to connect to the named pipe")
+	}
+	enc := protoio.NewUint32DelimitedWriter(conn, binary.BigEndian)
+	for _, line := range []string{"one", "two"} {
+		entry := &logdriver.LogEntry{Source: "stdout", TimeNano: time.Now().UnixNano(), Line: []byte(line)}
+		if err := enc.WriteMsg(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.mu.Lock()
+	c := d.logs[server.path()].splunkl.(metricsProvider).containerMetrics()
+	d.mu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint64(&c.received) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the events to be read from the named pipe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the container stops
+	conn.Close()
+
+	if err := d.StopLogging(server.path()); err != nil {
+		t.Fatal(err)
+	}
+	if len(hec.messages) != 2 {
+		t.Fatalf("Expected the events read from the named pipe to be sent, got %d", len(hec.messages))
+	}
+}
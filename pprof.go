@@ -0,0 +1,45 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// newPprofHandler() returns the net/http/pprof handlers on their usual paths.
+// Mutex and block profiles are served by pprof.Index, through
+// /debug/pprof/mutex and /debug/pprof/block.
+func newPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startPprofServer() serves profiles when SPLUNK_PPROF_ADDR is set. Mutex and
+// block sampling are only turned on here, so profiling costs nothing unless
+// it is enabled.
+func startPprofServer(addr string, mutexFraction int, blockRate int) *httpServer {
+	runtime.SetMutexProfileFraction(mutexFraction)
+	runtime.SetBlockProfileRate(blockRate)
+	return startHTTPServer("profiles", addr, newPprofHandler())
+}
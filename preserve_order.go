@@ -0,0 +1,75 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// How long a message waits for messages of the other stream with an
+// earlier timestamp, with splunk-preserve-order
+const defaultPreserveOrderWindow = time.Second
+
+// parsePreserveOrder() returns how long messages are held to be sent in the
+// order of their timestamps across stdout and stderr, 0 when they are sent
+// in the order they are read
+func parsePreserveOrder(config map[string]string) (time.Duration, error) {
+	preserveStr, ok := config[splunkPreserveOrderKey]
+	if !ok {
+		return 0, nil
+	}
+	preserve, err := strconv.ParseBool(preserveStr)
+	if err != nil || !preserve {
+		return 0, err
+	}
+	windowStr, ok := config[splunkPreserveOrderWindowKey]
+	if !ok {
+		return defaultPreserveOrderWindow, nil
+	}
+	window, err := time.ParseDuration(windowStr)
+	if err == nil && window <= 0 {
+		err = fmt.Errorf("%s: %s must be positive", driverName, splunkPreserveOrderWindowKey)
+	}
+	return window, err
+}
+
+// orderMessages() sorts the messages by timestamp and splits them in the
+// ones ready to be sent and the ones read less than the order window ago,
+// which wait for older messages of the other stream. Messages after a held
+// one are held too, so that none is sent ahead of an older one. Without
+// an order window, or when all is set, every message is ready.
+func (l *splunkLogger) orderMessages(messages []*splunkMessage, all bool, now time.Time) (ready []*splunkMessage, held []*splunkMessage) {
+	if l.preserveOrder <= 0 {
+		return messages, nil
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].orderNano < messages[j].orderNano
+	})
+	if all {
+		return messages, nil
+	}
+	heldSince := now.Add(-l.preserveOrder).UnixNano()
+	for i, message := range messages {
+		if message.readAt > heldSince {
+			return messages[:i], messages[i:]
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestPreserveOrder(t *testing.T) {
+	if err := os.Setenv(envVarPostMessagesFrequency, "10ms"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv(envVarPostMessagesFrequency, "")
+
+	// the stdout line with the earliest timestamp is read after a stderr
+	// line was posted on its own
+	send := func(config map[string]string) []string {
+		hec := NewHTTPEventCollectorMock(t)
+		go hec.Serve()
+		config[splunkURLKey] = hec.URL()
+		config[splunkTokenKey] = hec.token
+		loggerDriver, err := New(logger.Info{Config: config, ContainerID: "containeriid", ContainerName: "/container_name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		log := func(line string, source string, offset time.Duration) {
+			if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: source, Timestamp: start.Add(offset)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		log("err1", "stderr", 2*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		log("out1", "stdout", time.Millisecond)
+		log("out2", "stdout", 3*time.Millisecond)
+		log("err2", "stderr", 4*time.Millisecond)
+		log("out3", "stdout", 4*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		log("err3", "stderr", 6*time.Millisecond)
+		log("out4", "stdout", 5*time.Millisecond)
+		if err := loggerDriver.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, message := range hec.messages {
+			lines = append(lines, message.Event.(map[string]interface{})["line"].(string))
+		}
+		return lines
+	}
+	equal := func(lines []string, expected []string) bool {
+		if len(lines) != len(expected) {
+			return false
+		}
+		for i := range lines {
+			if lines[i] != expected[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	if lines := send(map[string]string{}); !equal(lines, []string{"err1", "out1", "out2", "err2", "out3", "err3", "out4"}) {
+		t.Fatalf("Expected the lines in the order they were read, got %v", lines)
+	}
+	lines := send(map[string]string{splunkPreserveOrderKey: "true", splunkPreserveOrderWindowKey: "500ms"})
+	// equal timestamps keep the order they were read
+	if expected := []string{"out1", "err1", "out2", "err2", "out3", "out4", "err3"}; !equal(lines, expected) {
+		t.Fatalf("Expected the lines in the order of their timestamps %v, got %v", expected, lines)
+	}
+}
+
+func TestPreserveOrderHeld(t *testing.T) {
+	l := &splunkLogger{preserveOrder: time.Second}
+	now := time.Now()
+	messages := []*splunkMessage{
+		{Event: "b", orderNano: 2, readAt: now.Add(-2 * time.Second).UnixNano()},
+		{Event: "c", orderNano: 3, readAt: now.UnixNano()},
+		{Event: "a", orderNano: 1, readAt: now.Add(-time.Second / 2).UnixNano()},
+		{Event: "d", orderNano: 4, readAt: now.Add(-2 * time.Second).UnixNano()},
+	}
+	ready, held := l.orderMessages(messages, false, now)
+	// a was read recently, and b and d are newer than it
+	if len(ready) != 0 || len(held) != 4 || held[0].Event != "a" {
+		t.Fatalf("Expected every message to wait for the window of a, got %d ready", len(ready))
+	}
+	ready, held = l.orderMessages(messages, false, now.Add(600*time.Millisecond))
+	if len(ready) != 2 || ready[0].Event != "a" || ready[1].Event != "b" || len(held) != 2 {
+		t.Fatalf("Expected a and b to be ready, got %d ready", len(ready))
+	}
+	if ready, held = l.orderMessages(messages, true, now); len(ready) != 4 || held != nil {
+		t.Fatal("Expected every message to be ready when flushing")
+	}
+}
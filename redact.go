@@ -0,0 +1,47 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// secretOptions are never shown in full outside of the logger
+var secretOptions = map[string]bool{
+	splunkTokenKey:                true,
+	splunkVerifyIndexAPIKey:       true,
+	splunkSearchTokenKey:          true,
+	logSinkElasticsearchAPIKeyKey: true,
+	// the headers carry the credentials of the OTLP collector
+	splunkOTLPHeadersKey: true,
+}
+
+// redactedConfig() returns a copy of the options where secrets only show
+// their last 4 characters
+func redactedConfig(cfg map[string]string) map[string]string {
+	redacted := make(map[string]string, len(cfg))
+	for key, value := range cfg {
+		if secretOptions[key] {
+			value = redactSecret(value)
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+func redactSecret(value string) string {
+	if len(value) <= 4 {
+		return "****"
+	}
+	return "****" + value[len(value)-4:]
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactedConfig(t *testing.T) {
+	cfg := map[string]string{
+		splunkURLKey:         "https://splunk:8088",
+		splunkTokenKey:       "abcdef",
+		splunkOTLPHeadersKey: "authorization=Bearer secret,x-api-key=key1",
+	}
+	redacted := redactedConfig(cfg)
+	if redacted[splunkTokenKey] != "****cdef" {
+		t.Fatalf("Unexpected redacted token %s", redacted[splunkTokenKey])
+	}
+	if redacted[splunkOTLPHeadersKey] != "****key1" {
+		t.Fatalf("Unexpected redacted OTLP headers %s", redacted[splunkOTLPHeadersKey])
+	}
+	if redacted[splunkURLKey] != cfg[splunkURLKey] {
+		t.Fatalf("Unexpected url %s", redacted[splunkURLKey])
+	}
+	if cfg[splunkTokenKey] != "abcdef" {
+		t.Fatal("Original options should not be modified")
+	}
+	if redactSecret("abc") != "****" {
+		t.Fatal("Short secrets should be fully redacted")
+	}
+}
+
+func TestValidateRedactsOTLPHeaders(t *testing.T) {
+	cfg := map[string]string{splunkOTLPHeadersKey: "x-scope=logs,Bearer secret-token"}
+	err := ValidateLogOpt(cfg)
+	if err == nil {
+		t.Fatal("Expected the malformed headers to be invalid")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("Expected the headers to be redacted, got %v", err)
+	}
+	report := validateOptions(cfg, false)
+	if message := report.Options[splunkOTLPHeadersKey].Message; message == "" || strings.Contains(message, "secret") {
+		t.Fatalf("Expected the headers to be redacted, got %q", message)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	// labels giving the resource limits of a container without an inspect
+	// lookup, the memory in bytes and the number of CPUs
+	resourcesMemoryLabel = "com.splunk.resources.memory"
+	resourcesCPUsLabel   = "com.splunk.resources.cpus"
+
+	resourcesMemoryField = "container_memory_limit"
+	resourcesCPUsField   = "container_cpu_limit"
+
+	// CPU period docker applies when only a quota is set, in microseconds
+	defaultCPUPeriod = 100000
+)
+
+// resourceFields() returns the memory limit in bytes and the CPU limit of
+// the container, from its labels or from docker when SPLUNK_DOCKER_SOCKET is
+// set. A limit which is not set, or unknown, has no field. Failures are
+// logged and never block the container start.
+func resourceFields(info logger.Info) map[string]string {
+	fields := make(map[string]string)
+	if memory, err := strconv.ParseInt(info.ContainerLabels[resourcesMemoryLabel], 10, 64); err == nil && memory > 0 {
+		fields[resourcesMemoryField] = strconv.FormatInt(memory, 10)
+	}
+	if cpus, err := strconv.ParseFloat(info.ContainerLabels[resourcesCPUsLabel], 64); err == nil && cpus > 0 {
+		fields[resourcesCPUsField] = strconv.FormatFloat(cpus, 'f', -1, 64)
+	}
+	if len(fields) == 2 {
+		return fields
+	}
+	socket := getAdvancedOptionString(envVarDockerSocket, defaultDockerSocket)
+	if socket == "" {
+		return fields
+	}
+	inspected, err := inspectResources(socket, info.ContainerID)
+	if err != nil {
+		driverLog.WithField("id", info.ContainerID).WithField("socket", socket).WithError(err).Warn("Resources lookup failed, continuing without the limits docker knows")
+		return fields
+	}
+	// the labels take precedence
+	for key, value := range inspected {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+func inspectResources(socket string, containerID string) (map[string]string, error) {
+	inspect, err := inspectContainer(socket, containerID)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string)
+	config := inspect.HostConfig
+	if config.Memory > 0 {
+		fields[resourcesMemoryField] = strconv.FormatInt(config.Memory, 10)
+	}
+	// --cpus sets NanoCpus, --cpu-quota and --cpu-period the CFS quota
+	var cpus float64
+	if config.NanoCpus > 0 {
+		cpus = float64(config.NanoCpus) / 1e9
+	} else if config.CPUQuota > 0 {
+		period := config.CPUPeriod
+		if period <= 0 {
+			period = defaultCPUPeriod
+		}
+		cpus = float64(config.CPUQuota) / float64(period)
+	}
+	if cpus > 0 {
+		fields[resourcesCPUsField] = strconv.FormatFloat(cpus, 'f', -1, 64)
+	}
+	return fields, nil
+}
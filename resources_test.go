@@ -0,0 +1,115 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestIncludeResources(t *testing.T) {
+	send := func(labels map[string]string) map[string]string {
+		hec := NewHTTPEventCollectorMock(t)
+		go hec.Serve()
+		defer hec.Close()
+		loggerDriver, err := New(logger.Info{
+			Config: map[string]string{
+				splunkURLKey:              hec.URL(),
+				splunkTokenKey:            hec.token,
+				splunkIncludeResourcesKey: "true",
+			},
+			ContainerID:     "containeriid",
+			ContainerName:   "/container_name",
+			ContainerLabels: labels,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := loggerDriver.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := loggerDriver.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if len(hec.messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(hec.messages))
+		}
+		return hec.messages[0].Fields
+	}
+
+	fields := send(map[string]string{resourcesMemoryLabel: "536870912", resourcesCPUsLabel: "1.5"})
+	if fields[resourcesMemoryField] != "536870912" || fields[resourcesCPUsField] != "1.5" {
+		t.Fatalf("Expected the resource limits in the fields, got %v", fields)
+	}
+
+	fields = send(map[string]string{resourcesMemoryLabel: "unlimited"})
+	if _, ok := fields[resourcesMemoryField]; ok {
+		t.Fatalf("Expected no memory limit field when it is unknown, got %v", fields)
+	}
+	if _, ok := fields[resourcesCPUsField]; ok {
+		t.Fatalf("Expected no CPU limit field when it is unknown, got %v", fields)
+	}
+}
+
+func TestInspectResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/limited/json":
+			w.Write([]byte(`{"HostConfig": {"Memory": 268435456, "NanoCpus": 0, "CpuQuota": 50000, "CpuPeriod": 0}}`))
+		case "/containers/unlimited/json":
+			w.Write([]byte(`{"HostConfig": {"Memory": 0, "NanoCpus": 0, "CpuQuota": 0}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer l.Close()
+
+	os.Setenv(envVarDockerSocket, socket)
+	defer os.Unsetenv(envVarDockerSocket)
+	fields := resourceFields(logger.Info{ContainerID: "limited"})
+	if fields[resourcesMemoryField] != "268435456" || fields[resourcesCPUsField] != "0.5" {
+		t.Fatalf("Expected the limits to be looked up, got %v", fields)
+	}
+	// the labels take precedence
+	fields = resourceFields(logger.Info{ContainerID: "limited", ContainerLabels: map[string]string{resourcesCPUsLabel: "2"}})
+	if fields[resourcesMemoryField] != "268435456" || fields[resourcesCPUsField] != "2" {
+		t.Fatalf("Expected the CPU limit of the label, got %v", fields)
+	}
+	if fields = resourceFields(logger.Info{ContainerID: "unlimited"}); len(fields) != 0 {
+		t.Fatalf("Expected no fields without limits, got %v", fields)
+	}
+	if fields = resourceFields(logger.Info{ContainerID: "unknown"}); len(fields) != 0 {
+		t.Fatalf("Expected no fields when the lookup fails, got %v", fields)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// the window of a retry budget is tracked in this many buckets
+	retryBudgetBuckets = 10
+	// retries always allowed per window, so a container sending few
+	// requests can still retry
+	retryBudgetMinRetries = 3
+)
+
+// retryBudget caps the retries of a container to a percentage of its
+// requests over a rolling window, so an outage doesn't multiply the load
+// on HEC. A nil budget allows every retry.
+type retryBudget struct {
+	percent     int
+	bucketWidth time.Duration
+
+	mu      sync.Mutex
+	buckets [retryBudgetBuckets]retryBudgetBucket
+}
+
+type retryBudgetBucket struct {
+	start    time.Time
+	requests int
+	retries  int
+}
+
+// newRetryBudget() returns nil when percent is not positive
+func newRetryBudget(percent int, window time.Duration) *retryBudget {
+	if percent <= 0 {
+		return nil
+	}
+	width := window / retryBudgetBuckets
+	if width <= 0 {
+		width = time.Millisecond
+	}
+	return &retryBudget{percent: percent, bucketWidth: width}
+}
+
+// bucket() returns the bucket of now, cleared if it held an older
+// period, must be called with mu held
+func (b *retryBudget) bucket(now time.Time) *retryBudgetBucket {
+	start := now.Truncate(b.bucketWidth)
+	bucket := &b.buckets[(start.UnixNano()/int64(b.bucketWidth))%retryBudgetBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = retryBudgetBucket{start: start}
+	}
+	return bucket
+}
+
+// recordRequest() counts the first attempt of a batch
+func (b *retryBudget) recordRequest(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket(now).requests++
+}
+
+// allowRetry() returns true, and counts the retry, if the retries over the
+// window stay within the budget
+func (b *retryBudget) allowRetry(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current := b.bucket(now)
+	window := b.bucketWidth * retryBudgetBuckets
+	requests, retries := 0, 0
+	for _, bucket := range b.buckets {
+		if !bucket.start.IsZero() && now.Sub(bucket.start) < window {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	allowed := requests * b.percent / 100
+	if allowed < retryBudgetMinRetries {
+		allowed = retryBudgetMinRetries
+	}
+	if retries >= allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	const (
+		batchSize = 10
+		ticks     = 100
+		percent   = 10
+	)
+	var (
+		mu       sync.Mutex
+		requests int
+		batches  = make(map[string]bool)
+	)
+	// fails every request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var first splunkMessage
+		if err := json.NewDecoder(r.Body).Decode(&first); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		requests++
+		batches[first.Event.(string)] = true
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	deadLetters, err = newDeadLetterFile(filepath.Join(dir, "dead.log"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		deadLetters.Close()
+		deadLetters = nil
+	}()
+
+	hec := &hecClient{
+		client:                server.Client(),
+		url:                   server.URL,
+		postMessagesBatchSize: batchSize,
+		bufferMaximum:         ticks * batchSize,
+		retryBudget:           newRetryBudget(percent, time.Minute),
+	}
+	var buffer []*splunkMessage
+	for tick, n := 0, 0; tick < ticks; tick++ {
+		for i := 0; i < batchSize; i++ {
+			buffer = append(buffer, &splunkMessage{Event: strconv.Itoa(n)})
+			n++
+		}
+		buffer = hec.postMessages(buffer, false)
+	}
+
+	mu.Lock()
+	retries := requests - len(batches)
+	allowed := len(batches) * percent / 100
+	mu.Unlock()
+	if allowed < retryBudgetMinRetries {
+		allowed = retryBudgetMinRetries
+	}
+	if retries > allowed {
+		t.Fatalf("Expected at most %d retries for %d batches, got %d", allowed, len(batches), retries)
+	}
+	if len(batches) < ticks/2 {
+		t.Fatalf("Expected batches to be given up rather than retried, only %d were sent", len(batches))
+	}
+
+	f, err := os.Open(filepath.Join(dir, "dead.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	deadLettered := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); deadLettered++ {
+		var record deadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Reason != "retry_budget" {
+			t.Fatalf("Unexpected dead-letter reason %s", record.Reason)
+		}
+	}
+	if expected := ticks*batchSize - len(buffer); deadLettered != expected {
+		t.Fatalf("Expected %d dead-lettered events, got %d", expected, deadLettered)
+	}
+}
+
+func TestRetryBudgetWindow(t *testing.T) {
+	b := newRetryBudget(50, 10*time.Second)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		b.recordRequest(now)
+	}
+	for i := 0; i < 5; i++ {
+		if !b.allowRetry(now) {
+			t.Fatalf("Expected retry %d to be allowed", i)
+		}
+	}
+	if b.allowRetry(now) {
+		t.Fatal("Expected the retries over budget to be refused")
+	}
+	// the requests and retries leave the window
+	if !b.allowRetry(now.Add(11 * time.Second)) {
+		t.Fatal("Expected the minimum retries once the window moved on")
+	}
+	var disabled *retryBudget
+	if !disabled.allowRetry(now) {
+		t.Fatal("Expected a nil budget to allow every retry")
+	}
+}
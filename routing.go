@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// routingRule sends the events it matches to a different index and/or
+// sourcetype. Rules are evaluated in order and the first match wins.
+type routingRule struct {
+	Match      routingMatch `json:"match"`
+	Index      string       `json:"index"`
+	SourceType string       `json:"sourcetype"`
+
+	regex   *regexp.Regexp
+	matched uint64
+}
+
+// routingMatch matches either the line against a regular expression or the
+// value of a field of the parsed JSON line (or of the event fields).
+type routingMatch struct {
+	Regex  string `json:"regex"`
+	Field  string `json:"field"`
+	Equals string `json:"equals"`
+}
+
+// parseRoutingRules() validates and compiles the rules of splunk-routing-rules
+func parseRoutingRules(rulesStr string) ([]*routingRule, error) {
+	if rulesStr == "" {
+		return nil, nil
+	}
+	var rules []*routingRule
+	if err := json.Unmarshal([]byte(rulesStr), &rules); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse %s: %v", driverName, splunkRoutingRulesKey, err)
+	}
+	for i, rule := range rules {
+		if rule == nil {
+			return nil, fmt.Errorf("%s: rule %d of %s is empty", driverName, i, splunkRoutingRulesKey)
+		}
+		if (rule.Match.Regex == "") == (rule.Match.Field == "") {
+			return nil, fmt.Errorf("%s: rule %d of %s must match either a regex or a field", driverName, i, splunkRoutingRulesKey)
+		}
+		if rule.Index == "" && rule.SourceType == "" {
+			return nil, fmt.Errorf("%s: rule %d of %s must set an index or a sourcetype", driverName, i, splunkRoutingRulesKey)
+		}
+		if rule.Match.Regex != "" {
+			regex, err := regexp.Compile(rule.Match.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %d of %s has invalid regex: %v", driverName, i, splunkRoutingRulesKey, err)
+			}
+			rule.regex = regex
+		}
+	}
+	return rules, nil
+}
+
+// routeMessage() applies the first matching rule to the message and returns
+// true if there was a match
+func routeMessage(rules []*routingRule, message *splunkMessage, line []byte) bool {
+	var parsed map[string]interface{}
+	parsedLine := false
+	for _, rule := range rules {
+		if rule.regex != nil {
+			if !rule.regex.Match(line) {
+				continue
+			}
+		} else {
+			if !parsedLine {
+				json.Unmarshal(line, &parsed)
+				parsedLine = true
+			}
+			if !fieldEquals(parsed, message.Fields, rule.Match.Field, rule.Match.Equals) {
+				continue
+			}
+		}
+		atomic.AddUint64(&rule.matched, 1)
+		if rule.Index != "" {
+			message.Index = rule.Index
+		}
+		if rule.SourceType != "" {
+			message.SourceType = rule.SourceType
+		}
+		return true
+	}
+	return false
+}
+
+// parseSourceTypeIndexMap() parses the JSON object of
+// splunk-sourcetype-index-map, given inline or as the path of a file
+func parseSourceTypeIndexMap(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	content := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		content, err = ioutil.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read %s: %v", driverName, splunkSourceTypeIndexMapKey, err)
+		}
+	}
+	var indexes map[string]string
+	if err := json.Unmarshal(content, &indexes); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse %s: %v", driverName, splunkSourceTypeIndexMapKey, err)
+	}
+	for sourceType, index := range indexes {
+		if sourceType == "" || index == "" {
+			return nil, fmt.Errorf("%s: %s must map sourcetypes to indexes, got %q: %q", driverName, splunkSourceTypeIndexMapKey, sourceType, index)
+		}
+	}
+	return indexes, nil
+}
+
+func fieldEquals(parsed map[string]interface{}, fields map[string]string, name string, expected string) bool {
+	if value, ok := parsed[name]; ok {
+		if str, ok := value.(string); ok {
+			return str == expected
+		}
+		encoded, err := json.Marshal(value)
+		return err == nil && string(encoded) == expected
+	}
+	value, ok := fields[name]
+	return ok && value == expected
+}
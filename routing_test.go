@@ -0,0 +1,191 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestParseRoutingRules(t *testing.T) {
+	invalid := []string{
+		`not json`,
+		`[{"match": {}, "index": "audit"}]`,
+		`[{"match": {"regex": "a", "field": "b"}, "index": "audit"}]`,
+		`[{"match": {"regex": "AUDIT"}}]`,
+		`[{"match": {"regex": "("}, "index": "audit"}]`,
+	}
+	for _, rules := range invalid {
+		if _, err := parseRoutingRules(rules); err == nil {
+			t.Fatalf("Expecting error on invalid rules %s", rules)
+		}
+	}
+}
+
+func TestRoutingRules(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:        hec.URL(),
+			splunkTokenKey:      hec.token,
+			splunkIndexKey:      "main",
+			splunkSourceTypeKey: "app",
+			splunkFormatKey:     splunkFormatJSON,
+			splunkRoutingRulesKey: `[
+				{"match": {"regex": "^AUDIT "}, "index": "audit", "sourcetype": "audit"},
+				{"match": {"field": "level", "equals": "security"}, "index": "security"},
+				{"match": {"regex": "AUDIT"}, "index": "never"}
+			]`,
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageID:   "contaimageid",
+		ContainerImageName: "container_image_name",
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{
+		"AUDIT user logged in",
+		`{"level": "security", "msg": "denied"}`,
+		`{"level": "info", "msg": "hello"}`,
+	}
+	for _, line := range lines {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 3 {
+		t.Fatal("Expected three messages")
+	}
+
+	expected := []struct {
+		index      string
+		sourceType string
+	}{
+		{"audit", "audit"},
+		{"security", "app"},
+		{"main", "app"},
+	}
+	for i, message := range hec.messages {
+		if message.Index != expected[i].index || message.SourceType != expected[i].sourceType {
+			t.Fatalf("Unexpected routing of message %d: %v", i, message)
+		}
+	}
+
+	rules := loggerDriver.(*splunkLoggerJSON).rules()
+	if rules[0].matched != 1 || rules[1].matched != 1 || rules[2].matched != 0 {
+		t.Fatal("Unexpected matched rule counts")
+	}
+
+	err = hec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourceTypeIndexMap(t *testing.T) {
+	for _, value := range []string{`not json`, `{"app": ""}`, `{"": "main"}`, `/does/not/exist`} {
+		if _, err := parseSourceTypeIndexMap(value); err == nil {
+			t.Fatalf("Expecting error on invalid map %s", value)
+		}
+	}
+
+	file, err := ioutil.TempFile("", "index-map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(`{"audit": "security", "access_combined": "web"}`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:                hec.URL(),
+			splunkTokenKey:              hec.token,
+			splunkIndexKey:              "main",
+			splunkSourceTypeKey:         "app",
+			splunkSourceTypeIndexMapKey: file.Name(),
+			splunkRoutingRulesKey: `[
+				{"match": {"regex": "^AUDIT "}, "sourcetype": "audit"},
+				{"match": {"regex": "^GET "}, "sourcetype": "access_combined", "index": "proxy"}
+			]`,
+		},
+		ContainerID: "containeriid",
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"AUDIT user logged in", "GET /index.html", "hello"} {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 3 {
+		t.Fatal("Expected three messages")
+	}
+	expected := []struct {
+		index      string
+		sourceType string
+	}{
+		// mapped from the sourcetype set by a rule
+		{"security", "audit"},
+		// the index of the rule wins
+		{"proxy", "access_combined"},
+		// unmapped sourcetypes keep the default index
+		{"main", "app"},
+	}
+	for i, message := range hec.messages {
+		if message.Index != expected[i].index || message.SourceType != expected[i].sourceType {
+			t.Fatalf("Unexpected index of message %d: %v", i, message)
+		}
+	}
+
+	err = hec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
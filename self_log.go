@@ -0,0 +1,169 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// selfLogFile is a logrus hook writing the warnings and errors of the
+// plugin's own log to a file inside the plugin, which survives when the
+// docker daemon loses the plugin's stderr. The file is rotated by size:
+// path is the current file, path.1 to path.<maxFiles - 1> the older ones.
+type selfLogFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// selfLogFileInfo describes a file of the self log for the admin endpoint
+type selfLogFileInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func newSelfLogFile(path string, maxSize int64, maxFiles int) (*selfLogFile, error) {
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	s := &selfLogFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := s.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open() opens the current file, must be called with mu held
+func (s *selfLogFile) open(flag int) error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|flag, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *selfLogFile) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+// Fire() cannot log its own errors, which would call it again
+func (s *selfLogFile) Fire(entry *logrus.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot rotate %s: %v\n", s.path, err)
+			if s.f == nil {
+				return err
+			}
+		}
+	}
+	n, err := s.f.WriteString(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate() shifts the older files and starts a new current file, must be
+// called with mu held
+func (s *selfLogFile) rotate() error {
+	s.f.Close()
+	s.f = nil
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		from := s.path
+		if i > 1 {
+			from = s.path + "." + strconv.Itoa(i-1)
+		}
+		if err := os.Rename(from, s.path+"."+strconv.Itoa(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.open(os.O_TRUNC)
+}
+
+// files() returns the files of the self log, the current one first
+func (s *selfLogFile) files() []selfLogFileInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []selfLogFileInfo
+	for i := 0; i < s.maxFiles; i++ {
+		name := s.fileName(i)
+		info, err := os.Stat(filepath.Join(filepath.Dir(s.path), name))
+		if err != nil {
+			continue
+		}
+		files = append(files, selfLogFileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files
+}
+
+func (s *selfLogFile) fileName(i int) string {
+	name := filepath.Base(s.path)
+	if i > 0 {
+		name += "." + strconv.Itoa(i)
+	}
+	return name
+}
+
+// openFile() opens one of the files listed by files() for reading
+func (s *selfLogFile) openFile(name string) (*os.File, error) {
+	for i := 0; i < s.maxFiles; i++ {
+		if name == s.fileName(i) {
+			return os.Open(filepath.Join(filepath.Dir(s.path), name))
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (s *selfLogFile) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
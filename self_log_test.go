@@ -0,0 +1,117 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestSelfLogFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selflog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	selfLog, err := newSelfLogFile(filepath.Join(dir, "plugin", "plugin.log"), 2048, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer selfLog.Close()
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Formatter = &logrus.TextFormatter{DisableTimestamp: true}
+	log.Hooks.Add(selfLog)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				log.Info("not kept")
+				log.Warn("kept " + strings.Repeat("x", 40))
+			}
+		}()
+	}
+	wg.Wait()
+
+	files := selfLog.files()
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 files, got %v", files)
+	}
+	for _, file := range files {
+		if file.Size > 2048 {
+			t.Fatalf("Expected %s to be rotated, got %d bytes", file.Name, file.Size)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, "plugin", file.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			if strings.TrimSpace(line) != `level=warning msg="kept `+strings.Repeat("x", 40)+`"` {
+				t.Fatalf("Unexpected line in %s: %q", file.Name, line)
+			}
+		}
+	}
+
+	admin := newAdminServer(newDriver(), newLogRingBuffer(1), "secret")
+	req := httptest.NewRequest(http.MethodGet, "/debug/selflog", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected the endpoint to be disabled without self log, got %d", w.Code)
+	}
+
+	admin.selfLog = selfLog
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	var listed []selfLogFileInfo
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 || listed[0].Name != "plugin.log" || listed[2].Name != "plugin.log.2" {
+		t.Fatalf("Unexpected files %v", listed)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/selflog?file=plugin.log.1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "kept") {
+		t.Fatalf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/selflog?file=../plugin.log", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected files outside of the self log to be refused, got %d", w.Code)
+	}
+}
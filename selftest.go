@@ -0,0 +1,322 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	// Stages of the self-test, in order
+	selfTestConfig = "config"
+	selfTestDNS    = "dns"
+	selfTestTLS    = "tls"
+	selfTestHealth = "health"
+	selfTestSend   = "send"
+	selfTestAck    = "ack"
+
+	selfTestPassed  = "passed"
+	selfTestFailed  = "failed"
+	selfTestSkipped = "skipped"
+
+	// How often the acknowledgement of the test event is polled
+	selfTestAckInterval = 500 * time.Millisecond
+	// Time allowed for all the stages
+	defaultSelfTestTimeout = 30 * time.Second
+)
+
+// selfTestStage is the result of a stage of the self-test
+type selfTestStage struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// selfTestReport tells whether the host can deliver events to HEC with the
+// plug-in defaults
+type selfTestReport struct {
+	Passed        bool            `json:"passed"`
+	CorrelationID string          `json:"correlation_id"`
+	URL           string          `json:"url,omitempty"`
+	Stages        []selfTestStage `json:"stages"`
+}
+
+// selfTest runs the stages against HEC, each stage needs the previous ones
+type selfTest struct {
+	correlationID string
+	config        map[string]string
+
+	splunkURL *url.URL
+	token     string
+	tlsConfig *tls.Config
+	client    *http.Client
+	ackID     *int64
+}
+
+// runSelfTest() resolves the options of a container without log-opts, from
+// the defaults file and the SPLUNK_DEFAULT_* variables, then checks every
+// stage of the delivery to HEC within timeout. The test event carries
+// selftest=true and the correlation ID.
+func runSelfTest(correlationID string, timeout time.Duration) *selfTestReport {
+	if correlationID == "" {
+		correlationID = newBootID()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t := &selfTest{correlationID: correlationID}
+	report := &selfTestReport{Passed: true, CorrelationID: correlationID}
+	stages := []struct {
+		name string
+		run  func(ctx context.Context) (string, error)
+	}{
+		{selfTestConfig, t.checkConfig},
+		{selfTestDNS, t.checkDNS},
+		{selfTestTLS, t.checkTLS},
+		{selfTestHealth, t.checkHealth},
+		{selfTestSend, t.sendEvent},
+		{selfTestAck, t.pollAck},
+	}
+	for _, stage := range stages {
+		result := selfTestStage{Name: stage.name, Status: selfTestSkipped}
+		if report.Passed {
+			start := time.Now()
+			detail, err := stage.run(ctx)
+			result.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+			result.Detail = detail
+			switch {
+			case err == errSelfTestSkipped:
+				result.DurationMs = 0
+			case err != nil:
+				result.Status, result.Error = selfTestFailed, err.Error()
+				report.Passed = false
+			default:
+				result.Status = selfTestPassed
+			}
+		}
+		report.Stages = append(report.Stages, result)
+	}
+	if t.splunkURL != nil {
+		report.URL = t.splunkURL.String()
+	}
+	return report
+}
+
+// errSelfTestSkipped is returned by the stages which do not apply
+var errSelfTestSkipped = fmt.Errorf("%s: skipped", driverName)
+
+func (t *selfTest) checkConfig(ctx context.Context) (string, error) {
+	config, _, err := applyOptionDefaults(map[string]string{}, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateLogOpt(config); err != nil {
+		return "", err
+	}
+	if sink := config[logSinkKey]; sink != "" && sink != logSinkHEC {
+		return "", fmt.Errorf("%s: the self-test only supports %s=%s, got %s", driverName, logSinkKey, logSinkHEC, sink)
+	}
+	if t.splunkURL, err = parseURL(logger.Info{Config: config}); err != nil {
+		return "", err
+	}
+	token, ok := config[splunkTokenKey]
+	vaultConfig, err := parseVaultTokenConfig(config)
+	if err != nil {
+		return "", err
+	}
+	if vaultConfig != nil {
+		vault, err := newVaultTokenSource(vaultConfig)
+		if err != nil {
+			return "", err
+		}
+		if token, err = vault.get(); err != nil {
+			return "", err
+		}
+	} else if !ok {
+		return "", fmt.Errorf("%s: %s is expected", driverName, splunkTokenKey)
+	}
+	if t.tlsConfig, err = newTLSConfig(config, hecTLSOptions); err != nil {
+		return "", err
+	}
+	t.config, t.token = config, token
+	t.client = &http.Client{Transport: &http.Transport{TLSClientConfig: t.tlsConfig}}
+	return "url " + t.splunkURL.String(), nil
+}
+
+func (t *selfTest) checkDNS(ctx context.Context) (string, error) {
+	host := t.splunkURL.Hostname()
+	if net.ParseIP(host) != nil {
+		return "", errSelfTestSkipped
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return host + " is " + strings.Join(addrs, ", "), nil
+}
+
+func (t *selfTest) checkTLS(ctx context.Context) (string, error) {
+	if t.splunkURL.Scheme != "https" {
+		return "", errSelfTestSkipped
+	}
+	address := t.splunkURL.Host
+	if t.splunkURL.Port() == "" {
+		address = net.JoinHostPort(t.splunkURL.Hostname(), "443")
+	}
+	config := t.tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = t.splunkURL.Hostname()
+	}
+	conn, err := (&tls.Dialer{Config: config}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	return tls.VersionName(state.Version) + " " + tls.CipherSuiteName(state.CipherSuite), nil
+}
+
+func (t *selfTest) checkHealth(ctx context.Context) (string, error) {
+	hec := &hecClient{client: t.client, healthCheckURL: composeHealthCheckURL(t.splunkURL)}
+	if err := hec.verifySplunkConnection(ctx, nil); err != nil {
+		return "", err
+	}
+	return hec.healthCheckURL, nil
+}
+
+func (t *selfTest) sendEvent(ctx context.Context) (string, error) {
+	hostname, _ := os.Hostname()
+	message := &splunkMessage{
+		Event: map[string]string{
+			"message":        "self-test of the Splunk logging plug-in",
+			"correlation_id": t.correlationID,
+		},
+		Time:       fmt.Sprintf("%f", float64(time.Now().UnixNano())/float64(time.Second)),
+		Host:       hostname,
+		Source:     t.config[splunkSourceKey],
+		SourceType: t.config[splunkSourceTypeKey],
+		Index:      t.config[splunkIndexKey],
+		Fields:     map[string]string{"selftest": "true", "correlation_id": t.correlationID},
+	}
+	tagPluginEvent(message, "selftest")
+	body, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.splunkURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	t.authorize(req)
+	res, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", readHECError(res, t.token)
+	}
+	var response struct {
+		AckID *int64 `json:"ackId"`
+	}
+	json.NewDecoder(io.LimitReader(res.Body, hecErrorBodyLimit)).Decode(&response)
+	io.Copy(ioutil.Discard, res.Body)
+	t.ackID = response.AckID
+	if t.ackID != nil {
+		return "accepted with ackId " + strconv.FormatInt(*t.ackID, 10), nil
+	}
+	return "accepted", nil
+}
+
+// pollAck() waits until the test event is indexed, when indexer
+// acknowledgement is enabled for the token
+func (t *selfTest) pollAck(ctx context.Context) (string, error) {
+	if t.ackID == nil {
+		return "indexer acknowledgement is disabled for the token", errSelfTestSkipped
+	}
+	ackURL := t.splunkURL.Scheme + "://" + t.splunkURL.Host + "/services/collector/ack"
+	body := fmt.Sprintf(`{"acks":[%d]}`, *t.ackID)
+	for polls := 1; ; polls++ {
+		acked, err := t.ack(ctx, ackURL, body)
+		if err != nil {
+			return "", err
+		}
+		if acked {
+			return "indexed after " + strconv.Itoa(polls) + " polls", nil
+		}
+		select {
+		case <-time.After(selfTestAckInterval):
+		case <-ctx.Done():
+			return "", fmt.Errorf("%s: the test event was not indexed after %d polls: %v", driverName, polls, ctx.Err())
+		}
+	}
+}
+
+func (t *selfTest) ack(ctx context.Context, ackURL string, body string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, ackURL, strings.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	t.authorize(req)
+	res, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, readHECError(res, t.token)
+	}
+	var response struct {
+		Acks map[string]bool `json:"acks"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, hecErrorBodyLimit)).Decode(&response); err != nil {
+		return false, err
+	}
+	return response.Acks[strconv.FormatInt(*t.ackID, 10)], nil
+}
+
+// authorize() sets the token and the channel of the test, which indexer
+// acknowledgement requires
+func (t *selfTest) authorize(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+t.token)
+	req.Header.Set("X-Splunk-Request-Channel", channelGUID("selftest-"+t.correlationID))
+}
+
+// writeTo() prints the report as indented JSON
+func (r *selfTestReport) writeTo(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSelfTest(t *testing.T) {
+	var mu sync.Mutex
+	var event map[string]interface{}
+	polls := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && (r.Header.Get("Authorization") != "Splunk token" || r.Header.Get("X-Splunk-Request-Channel") == "") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/services/collector/health":
+			w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+		case "/services/collector/event/1.0":
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &event); err != nil {
+				t.Error(err)
+			}
+			w.Write([]byte(`{"text":"Success","code":0,"ackId":7}`))
+		case "/services/collector/ack":
+			polls++
+			w.Write([]byte(`{"acks":{"7":` + map[bool]string{true: "true", false: "false"}[polls > 1] + `}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv(defaultOptionEnv(splunkURLKey), strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+	os.Setenv(defaultOptionEnv(splunkTokenKey), "token")
+	os.Setenv(defaultOptionEnv(splunkInsecureSkipVerifyKey), "true")
+	defer func() {
+		os.Setenv(defaultOptionEnv(splunkURLKey), "")
+		os.Setenv(defaultOptionEnv(splunkTokenKey), "")
+		os.Setenv(defaultOptionEnv(splunkInsecureSkipVerifyKey), "")
+	}()
+
+	report := runSelfTest("rollout-42", 10*time.Second)
+	if !report.Passed || report.CorrelationID != "rollout-42" {
+		t.Fatalf("Expected the self-test to pass, got %+v", report)
+	}
+	var stages []string
+	for _, stage := range report.Stages {
+		stages = append(stages, stage.Name+"="+stage.Status)
+	}
+	expected := "config=passed dns=passed tls=passed health=passed send=passed ack=passed"
+	if strings.Join(stages, " ") != expected {
+		t.Fatalf("Expected the stages %s, got %+v", expected, report.Stages)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	fields, _ := event["fields"].(map[string]interface{})
+	if fields["selftest"] != "true" || fields["correlation_id"] != "rollout-42" {
+		t.Fatalf("Expected the test event to be marked, got %v", event)
+	}
+}
+
+func TestSelfTestFails(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	os.Setenv(defaultOptionEnv(splunkURLKey), server.URL)
+	os.Setenv(defaultOptionEnv(splunkTokenKey), "token")
+	defer func() {
+		os.Setenv(defaultOptionEnv(splunkURLKey), "")
+		os.Setenv(defaultOptionEnv(splunkTokenKey), "")
+	}()
+
+	report := runSelfTest("", 10*time.Second)
+	if report.Passed || report.CorrelationID == "" {
+		t.Fatalf("Expected the self-test to fail with a random correlation ID, got %+v", report)
+	}
+	var stages []string
+	for _, stage := range report.Stages {
+		stages = append(stages, stage.Name+"="+stage.Status)
+	}
+	expected := "config=passed dns=skipped tls=skipped health=failed send=skipped ack=skipped"
+	if strings.Join(stages, " ") != expected {
+		t.Fatalf("Expected the stages %s, got %+v", expected, report.Stages)
+	}
+	if report.Stages[3].Error == "" {
+		t.Fatal("Expected the failed stage to have its error")
+	}
+
+	// without a URL the configuration fails
+	os.Setenv(defaultOptionEnv(splunkURLKey), "")
+	if report := runSelfTest("", 10*time.Second); report.Passed || report.Stages[0].Status != selfTestFailed {
+		t.Fatalf("Expected the configuration to fail, got %+v", report)
+	}
+}
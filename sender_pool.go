@@ -0,0 +1,120 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"hash/fnv"
+	"os"
+	"runtime"
+)
+
+// SPLUNK_LOGGING_DRIVER_SENDER_WORKERS value scaling the workers to the CPUs
+const senderWorkersAuto = "auto"
+
+// availableCPUs returns the number of CPUs the plugin runs on, GOMAXPROCS
+// so that a CPU limit set with it is respected
+var availableCPUs = func() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// senderPool is a fixed set of workers shared by all splunk loggers to post
+// batches to HEC. Containers are sharded by a consistent hash of their id, so
+// the batches of a container are always posted by the same worker, in order.
+type senderPool struct {
+	workers []chan *sendRequest
+
+	// onSend, when set, is called by a worker before posting a batch
+	onSend func(worker int, hec *hecClient)
+}
+
+type sendRequest struct {
+	hec      *hecClient
+	messages []*splunkMessage
+	result   chan error
+}
+
+// senderWorkers is the pool used by new loggers, nil when every logger posts
+// its batches from its own goroutine
+var senderWorkers *senderPool
+
+// senderWorkerCount() returns the number of sender workers: a worker per
+// available CPU within the bounds with auto, else the configured number.
+// The batches of a container are posted in order by the same worker
+// whatever the number is.
+func senderWorkerCount() int {
+	if os.Getenv(envVarSenderWorkers) != senderWorkersAuto {
+		return getAdvancedOptionInt(envVarSenderWorkers, defaultSenderWorkers)
+	}
+	minWorkers := getAdvancedOptionInt(envVarSenderWorkersMin, defaultSenderWorkersMin)
+	maxWorkers := getAdvancedOptionInt(envVarSenderWorkersMax, defaultSenderWorkersMax)
+	cpus := availableCPUs()
+	workers := cpus
+	if maxWorkers > 0 && workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers < minWorkers {
+		workers = minWorkers
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	senderLog.WithField("cpus", cpus).WithField("workers", workers).Info("Scaled the sender workers to the available CPUs")
+	return workers
+}
+
+func newSenderPool(size int) *senderPool {
+	p := &senderPool{workers: make([]chan *sendRequest, size)}
+	for i := range p.workers {
+		p.workers[i] = make(chan *sendRequest)
+		go p.work(i)
+	}
+	return p
+}
+
+func (p *senderPool) work(worker int) {
+	for req := range p.workers[worker] {
+		if p.onSend != nil {
+			p.onSend(worker, req.hec)
+		}
+		req.result <- req.hec.tryPostMessages(req.messages)
+	}
+}
+
+// send() posts the messages from the worker of the shard and waits for the result
+func (p *senderPool) send(hec *hecClient, messages []*splunkMessage) error {
+	req := &sendRequest{hec: hec, messages: messages, result: make(chan error, 1)}
+	p.workers[p.shard(hec.shardKey)] <- req
+	return <-req.result
+}
+
+func (p *senderPool) shard(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return jumpHash(h.Sum64(), len(p.workers))
+}
+
+// jumpHash() is the jump consistent hash of Lamping and Veach: when the
+// number of buckets changes only the keys of the new buckets move
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJumpHash(t *testing.T) {
+	moved := 0
+	for key := uint64(0); key < 1000; key++ {
+		bucket := jumpHash(key, 10)
+		if bucket < 0 || bucket >= 10 {
+			t.Fatalf("Unexpected bucket %d", bucket)
+		}
+		if jumpHash(key, 10) != bucket {
+			t.Fatal("Expected the same bucket for the same key")
+		}
+		if jumpHash(key, 11) != bucket {
+			moved++
+		}
+	}
+	// adding a bucket only moves about 1/11 of the keys
+	if moved == 0 || moved > 200 {
+		t.Fatalf("Unexpected number of moved keys %d", moved)
+	}
+}
+
+func TestSenderPool(t *testing.T) {
+	// requests are held until both containers are being posted, so the test
+	// only passes when two containers are posted concurrently
+	concurrent := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-concurrent:
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	pool := newSenderPool(4)
+	var mu sync.Mutex
+	workers := make(map[string]map[int]bool)
+	pool.onSend = func(worker int, hec *hecClient) {
+		mu.Lock()
+		if workers[hec.shardKey] == nil {
+			workers[hec.shardKey] = make(map[int]bool)
+		}
+		workers[hec.shardKey][worker] = true
+		mu.Unlock()
+	}
+
+	// find two containers in different shards
+	first := "container0"
+	second := ""
+	for i := 1; second == ""; i++ {
+		if id := fmt.Sprintf("container%d", i); pool.shard(id) != pool.shard(first) {
+			second = id
+		}
+	}
+
+	newHec := func(id string) *hecClient {
+		return &hecClient{client: &http.Client{}, url: server.URL, auth: "Splunk token", pool: pool, shardKey: id}
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range []string{first, second} {
+		wg.Add(1)
+		go func(hec *hecClient) {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				if err := hec.send([]*splunkMessage{{Event: "message"}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(newHec(id))
+	}
+	// release the requests once both containers have one in flight
+	go func() {
+		for {
+			mu.Lock()
+			both := len(workers) == 2
+			mu.Unlock()
+			if both {
+				close(concurrent)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	wg.Wait()
+
+	for _, id := range []string{first, second} {
+		if len(workers[id]) != 1 {
+			t.Fatalf("Expected a single worker for %s, got %v", id, workers[id])
+		}
+	}
+	if pool.shard(first) == pool.shard(second) {
+		t.Fatal("Expected the containers to use different workers")
+	}
+}
+
+func TestSenderWorkerCount(t *testing.T) {
+	defer func(cpus func() int) { availableCPUs = cpus }(availableCPUs)
+	defer func() {
+		for _, env := range []string{envVarSenderWorkers, envVarSenderWorkersMin, envVarSenderWorkersMax} {
+			os.Unsetenv(env)
+		}
+	}()
+
+	tests := []struct {
+		workers  string
+		min      string
+		max      string
+		cpus     int
+		expected int
+	}{
+		{"", "", "", 8, defaultSenderWorkers},
+		{"3", "", "", 8, 3},
+		{senderWorkersAuto, "", "", 1, 1},
+		{senderWorkersAuto, "", "", 8, 8},
+		{senderWorkersAuto, "", "", 64, 64},
+		{senderWorkersAuto, "2", "16", 1, 2},
+		{senderWorkersAuto, "2", "16", 8, 8},
+		{senderWorkersAuto, "2", "16", 64, 16},
+	}
+	for _, test := range tests {
+		os.Setenv(envVarSenderWorkers, test.workers)
+		os.Setenv(envVarSenderWorkersMin, test.min)
+		os.Setenv(envVarSenderWorkersMax, test.max)
+		cpus := test.cpus
+		availableCPUs = func() int { return cpus }
+		if workers := senderWorkerCount(); workers != test.expected {
+			t.Fatalf("Expected %d workers for %q with %d CPUs within [%s, %s], got %d", test.expected, test.workers, test.cpus, test.min, test.max, workers)
+		}
+	}
+}
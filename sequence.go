@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sequenceField = "seq"
+	bootIDField   = "boot_id"
+)
+
+// bootID identifies this start of the plugin. The sequence numbers start
+// again from 1 with every boot ID, so a reset is not taken for a loss.
+var bootID = newBootID()
+
+func newBootID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// streamSequence numbers the events of a container, separately for every
+// stream, so that a Splunk search can detect gaps and reordering. It lives
+// in the logPair and is only incremented when the event is sent to Splunk,
+// after the messages which are dropped on purpose were filtered out.
+type streamSequence struct {
+	stdout uint64
+	stderr uint64
+}
+
+// parseSequence() returns the sequence of the container, nil unless
+// splunk-sequence is enabled
+func parseSequence(config map[string]string) (*streamSequence, error) {
+	sequenceStr, ok := config[splunkSequenceKey]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(sequenceStr)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	return &streamSequence{}, nil
+}
+
+// next() returns the next number of the stream, false for the events which
+// were not read from a stream, like the exit and heartbeat events
+func (s *streamSequence) next(source string) (uint64, bool) {
+	switch source {
+	case "stdout":
+		return atomic.AddUint64(&s.stdout, 1), true
+	case "stderr":
+		return atomic.AddUint64(&s.stderr, 1), true
+	}
+	return 0, false
+}
+
+// sequencedLogger is a sink which numbers the events it sends
+type sequencedLogger interface {
+	setSequence(sequence *streamSequence)
+}
+
+func (l *splunkLogger) setSequence(sequence *streamSequence) {
+	l.sequence = sequence
+}
+
+// addSequence() sets the sequence number and the boot ID of the message
+func (l *splunkLogger) addSequence(message *splunkMessage, source string) {
+	if l.sequence == nil {
+		return
+	}
+	seq, ok := l.sequence.next(source)
+	if !ok {
+		return
+	}
+	setField(message, sequenceField, strconv.FormatUint(seq, 10))
+	setField(message, bootIDField, bootID)
+}
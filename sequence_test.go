@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestSequence(t *testing.T) {
+	stub := &stubTransport{}
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:      "https://splunk.example.com:8088",
+			splunkTokenKey:    "00000000-0000-0000-0000-000000000000",
+			splunkSequenceKey: "true",
+		},
+		ContainerID: "containeriid",
+	}
+	sequence, err := parseSequence(info.Config)
+	if err != nil || sequence == nil {
+		t.Fatalf("expected a sequence, got %v, %v", sequence, err)
+	}
+	l, err := NewWithClient(info, &http.Client{Transport: stub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(sequencedLogger).setSequence(sequence)
+
+	sources := []string{"stdout", "stderr", "stdout", "stdout", "stderr"}
+	for _, source := range sources {
+		if err := l.Log(&logger.Message{Line: []byte("line"), Source: source, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.messages) != len(sources) {
+		t.Fatalf("expected %d messages, got %d", len(sources), len(stub.messages))
+	}
+	for i, expected := range []string{"1", "1", "2", "3", "2"} {
+		fields := stub.messages[i].Fields
+		if fields[sequenceField] != expected {
+			t.Fatalf("expected the %s event %d with seq %s, got %v", sources[i], i, expected, fields)
+		}
+		if fields[bootIDField] != bootID || bootID == "" {
+			t.Fatalf("expected the event %d with boot_id %s, got %v", i, bootID, fields)
+		}
+	}
+}
+
+func TestSequenceDisabled(t *testing.T) {
+	for _, config := range []map[string]string{{}, {splunkSequenceKey: "false"}} {
+		sequence, err := parseSequence(config)
+		if err != nil || sequence != nil {
+			t.Fatalf("expected no sequence for %v, got %v, %v", config, sequence, err)
+		}
+	}
+	if _, err := parseSequence(map[string]string{splunkSequenceKey: "sometimes"}); err == nil {
+		t.Fatal("expected an error for an invalid value")
+	}
+	if _, ok := (&streamSequence{}).next(""); ok {
+		t.Fatal("expected no number for an event without a stream")
+	}
+}
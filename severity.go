@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// syslog severities, also the journald priorities
+const (
+	severityCrit    = 2
+	severityErr     = 3
+	severityWarning = 4
+	severityNotice  = 5
+	severityInfo    = 6
+	severityDebug   = 7
+)
+
+// severityPattern finds the level of a line among its first bytes
+var severityPattern = regexp.MustCompile(`(?i)\b(fatal|panic|crit|critical|error|err|warn|warning|notice|info|debug|trace)\b`)
+
+// Number of bytes of a line searched for its level
+const severityPrefix = 128
+
+// messageSeverity() detects the level of a line from the first level name
+// found at its beginning, such as "ERROR" or "level=warn". Lines without a
+// level are errors on stderr and informational on stdout, like with the
+// journald and syslog log drivers of Docker.
+func messageSeverity(source string, line []byte) int {
+	if severity, ok := detectSeverity(line); ok {
+		return severity
+	}
+	if source == "stderr" {
+		return severityErr
+	}
+	return severityInfo
+}
+
+// detectSeverity() returns the level of the first level name found at the
+// beginning of the line, false when there is none
+func detectSeverity(line []byte) (int, bool) {
+	if len(line) > severityPrefix {
+		line = line[:severityPrefix]
+	}
+	match := severityPattern.FindSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+	switch string(bytes.ToLower(match[1])) {
+	case "fatal", "panic", "crit", "critical":
+		return severityCrit, true
+	case "error", "err":
+		return severityErr, true
+	case "warn", "warning":
+		return severityWarning, true
+	case "notice":
+		return severityNotice, true
+	case "info":
+		return severityInfo, true
+	}
+	return severityDebug, true
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessageSeverity(t *testing.T) {
+	tests := []struct {
+		source   string
+		line     string
+		severity int
+	}{
+		{"stdout", "hello", severityInfo},
+		{"stderr", "hello", severityErr},
+		{"stdout", `{"level":"error","msg":"failed"}`, severityErr},
+		{"stderr", "time=\"2018-06-01\" level=debug msg=starting", severityDebug},
+		{"stdout", "[Warning] low memory", severityWarning},
+		{"stdout", "FATAL could not start", severityCrit},
+		{"stdout", "errors=0 warnings=0", severityInfo},
+		{"stdout", strings.Repeat(" ", severityPrefix) + "ERROR", severityInfo},
+	}
+	for _, test := range tests {
+		if severity := messageSeverity(test.source, []byte(test.line)); severity != test.severity {
+			t.Fatalf("Expected severity %d for %q on %s, got %d", test.severity, test.line, test.source, severity)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// flusher is a sink buffering messages, which can be asked to write them
+// out now
+type flusher interface {
+	flush(timeout time.Duration) bool
+	queueDepth() int
+}
+
+// sinkFlush is the outcome of flushing one sink of a container
+type sinkFlush struct {
+	id      string
+	sink    string
+	before  int
+	after   int
+	flushed bool
+}
+
+// flushAll() asks the buffering sinks of every container to write their
+// messages, waiting up to timeout, and logs their queue depths before and
+// after. The sinks are flushed in parallel, a HEC endpoint being down only
+// delays its own containers. It returns the number of sinks which did not
+// flush in time.
+func (d *driver) flushAll(timeout time.Duration) int {
+	var results []*sinkFlush
+	var sinks []flusher
+	d.mu.Lock()
+	for _, lf := range d.logs {
+		for _, sink := range lf.sinks {
+			if f, ok := sink.(flusher); ok {
+				results = append(results, &sinkFlush{id: lf.info.ContainerID, sink: sink.Name(), before: f.queueDepth()})
+				sinks = append(sinks, f)
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range sinks {
+		wg.Add(1)
+		go func(f flusher, result *sinkFlush) {
+			defer wg.Done()
+			result.flushed = f.flush(timeout)
+			result.after = f.queueDepth()
+		}(sinks[i], results[i])
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].id != results[j].id {
+			return results[i].id < results[j].id
+		}
+		return results[i].sink < results[j].sink
+	})
+	timedOut := 0
+	for _, result := range results {
+		if !result.flushed {
+			timedOut++
+		}
+		driverLog.WithField("id", result.id).WithField("sink", result.sink).WithField("queue_depth", result.before).WithField("remaining", result.after).WithField("flushed", result.flushed).Warn("Flushed the buffered messages")
+	}
+	driverLog.WithField("sinks", len(results)).WithField("timed_out", timedOut).Warn("Flush of all the loggers done")
+	return timedOut
+}
+
+// handleDebugSignal() runs on SIGUSR1, it flushes the loggers when
+// flushTimeout is not 0 and then writes the state dump to dir
+func (d *driver) handleDebugSignal(dir string, flushTimeout time.Duration) {
+	if flushTimeout > 0 {
+		d.flushAll(flushTimeout)
+	}
+	if path, err := d.dumpState(dir); err != nil {
+		driverLog.WithError(err).Error("Cannot write the state dump")
+	} else {
+		driverLog.WithField("file", path).Warn("State dump written")
+	}
+}
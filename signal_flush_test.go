@@ -0,0 +1,113 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestHandleDebugSignalFlushes(t *testing.T) {
+	if err := os.Setenv(envVarPostMessagesFrequency, "1h"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv(envVarPostMessagesFrequency, "")
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	debugLog := newLogRingBuffer(10)
+	hooks := logrus.StandardLogger().Hooks
+	logrus.StandardLogger().Hooks = make(logrus.LevelHooks)
+	logrus.StandardLogger().Hooks.Add(debugLog)
+	defer func() { logrus.StandardLogger().Hooks = hooks }()
+
+	info := logger.Info{
+		Config:      map[string]string{splunkURLKey: hec.URL(), splunkTokenKey: hec.token},
+		ContainerID: "containeriid",
+	}
+	splunkl, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &recordingLogger{}
+	d := newDriver()
+	d.logs["/run/docker/logging/fifo"] = &logPair{
+		sinks:   []logger.Logger{splunkl, newQueuedLogger(local, 10)},
+		jsonl:   local,
+		splunkl: splunkl,
+		info:    info,
+	}
+
+	for i := 0; i < 2; i++ {
+		msg := &logger.Message{Line: []byte(fmt.Sprintf("%d", i)), Source: "stdout", Timestamp: time.Now()}
+		for _, sink := range d.logs["/run/docker/logging/fifo"].sinks {
+			if err := sink.Log(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	d.handleDebugSignal(dir, 5*time.Second)
+
+	// the messages are posted long before the post frequency
+	if sent := atomic.LoadUint64(&splunkl.(*splunkLoggerInline).containerMetrics().sent); sent != 2 {
+		t.Fatalf("Expected the buffered messages to be sent, got %d", sent)
+	}
+	local.mu.Lock()
+	written := len(local.messages)
+	local.mu.Unlock()
+	if written != 2 {
+		t.Fatalf("Expected the queued messages to be written, got %d", written)
+	}
+
+	flushed := 0
+	for _, line := range debugLog.recent() {
+		if strings.Contains(line, "Flushed the buffered messages") && strings.Contains(line, "queue_depth=") && strings.Contains(line, "remaining=0") {
+			flushed++
+		}
+	}
+	if flushed != 2 {
+		t.Fatalf("Expected the queue depths of both sinks to be logged, got %v", debugLog.recent())
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Fatalf("Expected the state dump to be written, got %v %v", files, err)
+	}
+
+	if err := splunkl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a closed logger does not block the flush
+	if timedOut := d.flushAll(time.Second); timedOut != 0 {
+		t.Fatalf("Expected no flush to time out, got %d", timedOut)
+	}
+}
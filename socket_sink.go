@@ -0,0 +1,97 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long connecting and writing to the forwarder socket can take
+const socketSinkTimeout = 10 * time.Second
+
+// socketSink writes events as newline delimited JSON to the unix socket
+// of a local forwarder. A failed connection is dropped and re-established.
+type socketSink struct {
+	path string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSocketSink(path string) *socketSink {
+	return &socketSink{path: path}
+}
+
+// connect() opens the connection if there is none. Must be called with mu
+// held, or before the sink is shared.
+func (s *socketSink) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", s.path, socketSinkTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// write() sends the messages, one JSON object per line, and returns the
+// number of bytes written. When the forwarder closed the connection, the
+// batch is written again once on a new connection.
+func (s *socketSink) write(messages []*splunkMessage) (int, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, message := range messages {
+		if err := encoder.Encode(message); err != nil {
+			return 0, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = s.connect(); err != nil {
+			return 0, err
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(socketSinkTimeout))
+		if _, err = s.conn.Write(body.Bytes()); err == nil {
+			return body.Len(), nil
+		}
+		senderLog.WithField("socket", s.path).WithError(err).Warn("Failed to write to forwarder socket, reconnecting")
+		s.conn.Close()
+		s.conn = nil
+	}
+	return 0, err
+}
+
+func (s *socketSink) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+type forwarderMock struct {
+	listener net.Listener
+	conns    chan net.Conn
+	lines    chan string
+}
+
+func newForwarderMock(t *testing.T, path string) *forwarderMock {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &forwarderMock{
+		listener: listener,
+		conns:    make(chan net.Conn, 10),
+		lines:    make(chan string, 100),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.conns <- conn
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					f.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return f
+}
+
+func (f *forwarderMock) nextLine(t *testing.T) *splunkMessage {
+	select {
+	case line := <-f.lines:
+		var message splunkMessage
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			t.Fatalf("Unexpected line %q: %v", line, err)
+		}
+		return &message
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received by the forwarder")
+	}
+	return nil
+}
+
+func (f *forwarderMock) nextConn(t *testing.T) net.Conn {
+	select {
+	case conn := <-f.conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("No connection accepted by the forwarder")
+	}
+	return nil
+}
+
+func TestSocketSink(t *testing.T) {
+	if err := os.Setenv(envVarPostMessagesBatchSize, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "splunk-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forwarder.sock")
+	forwarder := newForwarderMock(t, path)
+	defer forwarder.listener.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			logSinkKey:       logSinkUnixSocket,
+			logSinkSocketKey: path,
+		},
+		ContainerID:        "containeriid",
+		ContainerName:      "/container_name",
+		ContainerImageID:   "contaimageid",
+		ContainerImageName: "container_image_name",
+	}
+
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := func(line string) {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLine := func(line string) {
+		event, err := forwarder.nextLine(t).EventAsMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event["line"] != line {
+			t.Fatalf("Expected line %s, got %v", line, event)
+		}
+	}
+
+	log("1")
+	conn := forwarder.nextConn(t)
+	expectLine("1")
+	log("2")
+	expectLine("2")
+
+	// the forwarder restarts, the next batch is written on a new connection
+	conn.Close()
+	log("3")
+	conn = forwarder.nextConn(t)
+	defer conn.Close()
+	expectLine("3")
+
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delete(info.Config, logSinkSocketKey)
+	if _, err := New(info); err == nil {
+		t.Fatal("Expecting error without socket path")
+	}
+	info.Config[logSinkKey] = "kafka"
+	if _, err := New(info); err == nil {
+		t.Fatal("Expecting error on unknown sink")
+	}
+
+	if err := os.Setenv(envVarPostMessagesBatchSize, ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -19,44 +19,153 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/tls"
-	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash/fnv"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/logger"
 	"github.com/docker/docker/daemon/logger/loggerutils"
 	"github.com/docker/docker/pkg/urlutil"
 )
 
+// Indexed field set on messages flushed before all their fragments arrived
+const partialIncompleteField = "partial_incomplete"
+
+// Indexed field with the milliseconds an event was buffered, with splunk-add-buffer-latency
+const bufferLatencyField = "buffer_ms"
+
+// Indexed field naming the log driver of the events, to tell them from the
+// events of other log drivers
+const (
+	logDriverField   = "log_driver"
+	defaultLogDriver = "splunk-plugin"
+)
+
 const (
-	driverName                    = "splunk"
-	splunkURLKey                  = "splunk-url"
-	splunkURLPathKey              = "splunk-url-path"
-	splunkTokenKey                = "splunk-token"
-	splunkSourceKey               = "splunk-source"
-	splunkSourceTypeKey           = "splunk-sourcetype"
-	splunkIndexKey                = "splunk-index"
-	splunkCAPathKey               = "splunk-capath"
-	splunkCANameKey               = "splunk-caname"
-	splunkInsecureSkipVerifyKey   = "splunk-insecureskipverify"
-	splunkFormatKey               = "splunk-format"
-	splunkVerifyConnectionKey     = "splunk-verify-connection"
-	splunkGzipCompressionKey      = "splunk-gzip"
-	splunkGzipCompressionLevelKey = "splunk-gzip-level"
-	envKey                        = "env"
-	envRegexKey                   = "env-regex"
-	labelsKey                     = "labels"
-	tagKey                        = "tag"
+	driverName                                = "splunk"
+	splunkURLKey                              = "splunk-url"
+	splunkURLPathKey                          = "splunk-url-path"
+	splunkTokenKey                            = "splunk-token"
+	splunkSourceKey                           = "splunk-source"
+	splunkSourceTypeKey                       = "splunk-sourcetype"
+	splunkIndexKey                            = "splunk-index"
+	splunkCAPathKey                           = "splunk-capath"
+	splunkCANameKey                           = "splunk-caname"
+	splunkInsecureSkipVerifyKey               = "splunk-insecureskipverify"
+	splunkPinnedCertSHA256Key                 = "splunk-pinned-cert-sha256"
+	splunkPinTypeKey                          = "splunk-pin-type"
+	splunkTLSMinVersionKey                    = "splunk-tls-min-version"
+	splunkTLSCiphersKey                       = "splunk-tls-ciphers"
+	splunkFormatKey                           = "splunk-format"
+	splunkVerifyConnectionKey                 = "splunk-verify-connection"
+	splunkVerifyTimeoutKey                    = "splunk-verify-timeout"
+	splunkVerifyIndexKey                      = "splunk-verify-index"
+	splunkVerifyIndexAPIKey                   = "splunk-verify-index-api"
+	splunkGzipCompressionKey                  = "splunk-gzip"
+	splunkGzipCompressionLevelKey             = "splunk-gzip-level"
+	splunkCompressionKey                      = "splunk-compression"
+	splunkEventIDKey                          = "splunk-event-id"
+	splunkImageAllowlistKey                   = "splunk-image-allowlist"
+	splunkEnrichURLKey                        = "splunk-enrich-url"
+	splunkEnrichRedactKey                     = "splunk-enrich-redact"
+	splunkIncludeNetworkKey                   = "splunk-include-network"
+	splunkIncludeResourcesKey                 = "splunk-include-resources"
+	splunkMaxFieldsKey                        = "splunk-max-fields"
+	splunkConfigHashKey                       = "splunk-config-hash"
+	splunkIncludeImageDigestKey               = "splunk-include-image-digest"
+	splunkRoutingRulesKey                     = "splunk-routing-rules"
+	splunkSourceTypeIndexMapKey               = "splunk-sourcetype-index-map"
+	splunkChannelFromKey                      = "splunk-channel-from"
+	splunkIncludeDockerEnvelopeKey            = "splunk-include-docker-envelope"
+	splunkExitEventKey                        = "splunk-exit-event"
+	splunkDropSummaryIndexKey                 = "splunk-drop-summary-index"
+	splunkDropSummarySourceTypeKey            = "splunk-drop-summary-sourcetype"
+	splunkHeartbeatIntervalKey                = "splunk-heartbeat-interval"
+	splunkPartialTimeoutKey                   = "splunk-partial-timeout"
+	splunkFlushOnIdleKey                      = "splunk-flush-on-idle"
+	splunkMaxEventAgeKey                      = "splunk-max-event-age"
+	splunkLocalCompressKey                    = "splunk-local-compress"
+	splunkLocalMaxAgeKey                      = "splunk-local-max-age"
+	splunkLocalJSONKey                        = "splunk-local-json"
+	splunkSearchURLKey                        = "splunk-search-url"
+	splunkSearchTokenKey                      = "splunk-search-token"
+	splunkSearchCAPathKey                     = "splunk-search-capath"
+	splunkSearchCANameKey                     = "splunk-search-caname"
+	splunkSearchInsecureSkipVerifyKey         = "splunk-search-insecureskipverify"
+	splunkInputGzipKey                        = "splunk-input-gzip"
+	splunkNormalizeNewlinesKey                = "splunk-normalize-newlines"
+	splunkStripANSIKey                        = "splunk-strip-ansi"
+	splunkDisabledKey                         = "splunk-disabled"
+	splunkStrictOptsKey                       = "splunk-strict-opts"
+	splunkJournaldCopyKey                     = "splunk-journald-copy"
+	splunkAddBufferLatencyKey                 = "splunk-add-buffer-latency"
+	splunkSyslogURLKey                        = "splunk-syslog-url"
+	splunkSyslogFacilityKey                   = "splunk-syslog-facility"
+	splunkSyslogTagKey                        = "splunk-syslog-tag"
+	splunkSyslogMatchKey                      = "splunk-syslog-match"
+	splunkSyslogCAPathKey                     = "splunk-syslog-capath"
+	splunkSyslogCANameKey                     = "splunk-syslog-caname"
+	splunkSyslogInsecureSkipVerifyKey         = "splunk-syslog-insecureskipverify"
+	splunkSyslogCertKey                       = "splunk-syslog-cert"
+	splunkSyslogKeyKey                        = "splunk-syslog-key"
+	splunkBackendKey                          = "splunk-backend"
+	splunkOTLPEndpointKey                     = "splunk-otlp-endpoint"
+	splunkOTLPHeadersKey                      = "splunk-otlp-headers"
+	splunkOTLPCAPathKey                       = "splunk-otlp-capath"
+	splunkOTLPCANameKey                       = "splunk-otlp-caname"
+	splunkOTLPInsecureSkipVerifyKey           = "splunk-otlp-insecureskipverify"
+	splunkOTLPCertKey                         = "splunk-otlp-cert"
+	splunkOTLPKeyKey                          = "splunk-otlp-key"
+	splunkLabelsRefreshKey                    = "splunk-labels-refresh"
+	splunkMetricsModeKey                      = "splunk-metrics-mode"
+	splunkMetricsMappingKey                   = "splunk-metrics-mapping"
+	splunkMetricsIndexKey                     = "splunk-metrics-index"
+	splunkSequenceKey                         = "splunk-sequence"
+	splunkAccessLogFormatKey                  = "splunk-access-log-format"
+	splunkDeliveryLatencyFieldKey             = "splunk-delivery-latency-field"
+	splunkMaxTimeSkewKey                      = "splunk-max-time-skew"
+	splunkInvalidTimePolicyKey                = "splunk-invalid-time-policy"
+	splunkTokenVaultAddrKey                   = "splunk-token-vault-addr"
+	splunkTokenVaultPathKey                   = "splunk-token-vault-path"
+	splunkTokenVaultRoleKey                   = "splunk-token-vault-role"
+	splunkTokenVaultAuthKey                   = "splunk-token-vault-auth"
+	splunkTokenVaultFieldKey                  = "splunk-token-vault-field"
+	splunkTokenVaultCAPathKey                 = "splunk-token-vault-capath"
+	splunkLogDriverKey                        = "splunk-log-driver"
+	splunkCacheRequiredKey                    = "splunk-cache-required"
+	splunkForwardingRequiredKey               = "splunk-forwarding-required"
+	splunkDegradedBackfillMaxKey              = "splunk-degraded-backfill-max"
+	splunkBandwidthLimitKey                   = "splunk-bandwidth-limit"
+	splunkBandwidthPolicyKey                  = "splunk-bandwidth-policy"
+	splunkPreserveOrderKey                    = "splunk-preserve-order"
+	splunkPreserveOrderWindowKey              = "splunk-preserve-order-window"
+	logSinkKey                                = "log-sink"
+	logSinkSocketKey                          = "log-sink-socket"
+	logSinkElasticsearchURLKey                = "log-sink-elasticsearch-url"
+	logSinkElasticsearchIndexKey              = "log-sink-elasticsearch-index"
+	logSinkElasticsearchFieldsKey             = "log-sink-elasticsearch-fields"
+	logSinkElasticsearchAPIKeyKey             = "log-sink-elasticsearch-api-key"
+	logSinkElasticsearchCAPathKey             = "log-sink-elasticsearch-capath"
+	logSinkElasticsearchInsecureSkipVerifyKey = "log-sink-elasticsearch-insecureskipverify"
+	envKey                                    = "env"
+	envRegexKey                               = "env-regex"
+	labelsKey                                 = "labels"
+	tagKey                                    = "tag"
+	// options of the local json logger
+	localMaxSizeKey = "max-size"
+	localMaxFileKey = "max-file"
 )
 
 const (
@@ -64,6 +173,8 @@ const (
 	defaultPostMessagesFrequency = 5 * time.Second
 	// How big can be batch of messages
 	defaultPostMessagesBatchSize = 1000
+	// Maximum size of the events of a request, 0 means no limit
+	defaultPostMessagesMaxBytes = 0
 	// Maximum number of messages we can store in buffer
 	defaultBufferMaximum = 10 * defaultPostMessagesBatchSize
 	// Number of messages allowed to be queued in the channel
@@ -75,16 +186,157 @@ const (
 	// Number of retry if error happens while reading logs from docker provided fifo
 	// -1 means retry forever
 	defaultReadFifoErrorRetryNumber = 3
+	// Number of times log processing restarts after a panic
+	// -1 means restart forever
+	defaultProcessPanicRetryNumber = 10
+	// Unix socket of the admin endpoints
+	defaultAdminSocket = "/run/docker/plugins/splunklog-admin.sock"
+	// Number of plugin log entries kept for the /debug/log endpoint
+	defaultDebugLogLines = 1000
+	// How long debug logging set at runtime lasts, 0 keeps it until changed
+	defaultDebugTTL = 0
+	// Size in MB after which the self log file is rotated
+	defaultSelfLogMaxSizeMB = 10
+	// Number of self log files kept, including the current one
+	defaultSelfLogMaxFiles = 5
+	// Maximum number of containers with their own metrics series, 0 means aggregated metrics only
+	defaultMetricsMaxContainers = 100
+	// Number of workers shared by all containers to post to HEC, 0 means
+	// every container posts from its own goroutine
+	defaultSenderWorkers = 0
+	// Bounds of the number of sender workers scaled to the CPUs, 0 means no maximum
+	defaultSenderWorkersMin = 1
+	defaultSenderWorkersMax = 0
+	// Maximum number of requests to HEC in flight across all containers, 0 means no limit
+	defaultMaxInflight = 0
+	// How long a request to HEC may take before it is abandoned, 0 means no limit
+	defaultRequestTimeout = 30 * time.Second
+	// How often plugin statistics are logged, 0 disables them
+	defaultStatsInterval = 0
+	// How often an identical send failure of a container is logged, 0 logs every failure
+	defaultSendErrorLogInterval = time.Minute
+	// How often every container sends a heartbeat event, 0 disables them
+	defaultHeartbeatInterval = 0
+	// Failed posts in a row after which the delivery of a container is degraded, 0 disables alerts
+	defaultAlertConsecutiveFailures = 0
+	// Percentage of failed posts over the window after which the delivery of a container is degraded
+	defaultAlertFailurePercent = 50
+	// Window of the failed posts percentage
+	defaultAlertWindow = 5 * time.Minute
+	// Minimum time between two delivery alerts of a container
+	defaultAlertMinInterval = 10 * time.Minute
+	// Skip splunk-verify-index, e.g. when HEC is not reachable at startup
+	defaultSkipVerifyIndex = false
+	// Fail the start of containers with invalid splunk.option.* labels rather than ignoring the labels
+	defaultLabelOptionsStrict = false
+	// Percentage of the requests of a container which may be retried over the window, 0 disables the budget
+	defaultRetryBudgetPercent = 0
+	// Rolling window of the retry budget
+	defaultRetryBudgetWindow = time.Minute
+	// Size in MB after which nothing more is written to the dead-letter file, 0 means no limit
+	defaultDeadLetterMaxSizeMB = 100
+	// Write the messages which could not be written to the dead-letter file to stderr
+	defaultLastResortStderr = false
+	// How long SIGUSR1 waits for the loggers to flush their buffers, 0 disables the flush
+	defaultSignalFlushTimeout = 10 * time.Second
+	// Log the beginning of a few dropped messages at debug level
+	defaultDropSample = false
+	// Docker socket used to look up the network and the labels of containers, empty disables the lookups
+	defaultDockerSocket = ""
+	// How long to wait for the enrichment service
+	defaultEnrichTimeout = 2 * time.Second
+	// Number of messages queued for each sink which does not queue on its own
+	defaultSinkQueueSize = 1000
+	// Datagram socket of journald, for splunk-journald-copy
+	defaultJournaldSocket = "/run/systemd/journal/socket"
+	// Service account token of the plugin for the kubernetes auth of Vault
+	defaultTokenVaultJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// Minimum free space (in MB) for writing local json logs, 0 disables the check
+	defaultLocalMinFreeMB = 0
+	// How often creating a local json log which failed is retried
+	defaultCacheRetryInterval = 30 * time.Second
+	// Size of the local json files of all the containers, 0 means no limit
+	defaultCacheTotalMaxBytes = 0
+	// First wait before creating a splunk logger which failed is retried,
+	// doubled up to forwardingRetryMaxInterval
+	defaultForwardingRetryInterval = 5 * time.Second
+	// How often the HEC endpoints are probed for /healthz, 0 disables probing
+	defaultHealthInterval = 10 * time.Second
+	// Maximum percentage of events dropped over the last minute for /healthz
+	defaultHealthMaxDropPercent = 1
+	// Fraction of mutex contention events sampled while profiling is enabled
+	defaultPprofMutexFraction = 10
+	// Nanoseconds spent blocked per sampled blocking event while profiling is enabled
+	defaultPprofBlockRate = 10000
 )
 
 const (
 	envVarPostMessagesFrequency        = "SPLUNK_LOGGING_DRIVER_POST_MESSAGES_FREQUENCY"
 	envVarPostMessagesBatchSize        = "SPLUNK_LOGGING_DRIVER_POST_MESSAGES_BATCH_SIZE"
+	envVarPostMessagesMaxBytes         = "SPLUNK_LOGGING_DRIVER_POST_MESSAGES_MAX_BYTES"
 	envVarBufferMaximum                = "SPLUNK_LOGGING_DRIVER_BUFFER_MAX"
 	envVarStreamChannelSize            = "SPLUNK_LOGGING_DRIVER_CHANNEL_SIZE"
 	envVarPartialMsgBufferHoldDuration = "SPLUNK_LOGGING_DRIVER_TEMP_MESSAGES_HOLD_DURATION"
 	envVarPartialMsgBufferMaximum      = "SPLUNK_LOGGING_DRIVER_TEMP_MESSAGES_BUFFER_SIZE"
 	envVarReadFifoErrorRetryNumber     = "SPLUNK_LOGGING_DRIVER_FIFO_ERROR_RETRY_TIME"
+	envVarProcessPanicRetryNumber      = "SPLUNK_LOGGING_DRIVER_PROCESS_PANIC_RETRY_TIME"
+	envVarAdminSocket                  = "SPLUNK_LOGGING_DRIVER_ADMIN_SOCKET"
+	envVarAdminToken                   = "SPLUNK_LOGGING_DRIVER_ADMIN_TOKEN"
+	envVarDebugLogLines                = "SPLUNK_LOGGING_DRIVER_DEBUG_LOG_LINES"
+	envVarDebugTTL                     = "SPLUNK_LOGGING_DRIVER_DEBUG_TTL"
+	envVarInternalLogFormat            = "SPLUNK_INTERNAL_LOG_FORMAT"
+	envVarSelfLogFile                  = "SPLUNK_SELF_LOG_FILE"
+	envVarSelfLogMaxSizeMB             = "SPLUNK_SELF_LOG_MAX_SIZE_MB"
+	envVarSelfLogMaxFiles              = "SPLUNK_SELF_LOG_MAX_FILES"
+	envVarEnrichTimeout                = "SPLUNK_LOGGING_DRIVER_ENRICH_TIMEOUT"
+	envVarDockerSocket                 = "SPLUNK_DOCKER_SOCKET"
+	envVarDropSample                   = "SPLUNK_DROP_SAMPLE"
+	envVarDefaultsFile                 = "SPLUNK_DEFAULTS_FILE"
+	envVarLabelOptionsStrict           = "SPLUNK_LABEL_OPTIONS_STRICT"
+	envVarSignalFlushTimeout           = "SPLUNK_LOGGING_DRIVER_SIGNAL_FLUSH_TIMEOUT"
+	envVarRetryBudgetPercent           = "SPLUNK_LOGGING_DRIVER_RETRY_BUDGET_PERCENT"
+	envVarRetryBudgetWindow            = "SPLUNK_LOGGING_DRIVER_RETRY_BUDGET_WINDOW"
+	envVarDeadLetterFile               = "SPLUNK_DEAD_LETTER_FILE"
+	envVarDeadLetterMaxSizeMB          = "SPLUNK_DEAD_LETTER_MAX_SIZE_MB"
+	envVarLastResortStderr             = "SPLUNK_LAST_RESORT_STDERR"
+	envVarSkipVerifyIndex              = "SPLUNK_SKIP_VERIFY_INDEX"
+	envVarDefaultTag                   = "SPLUNK_LOGGING_DRIVER_DEFAULT_TAG"
+	envVarLocalMinFreeMB               = "SPLUNK_LOGGING_DRIVER_LOCAL_MIN_FREE_MB"
+	envVarCacheRetryInterval           = "SPLUNK_LOGGING_DRIVER_CACHE_RETRY_INTERVAL"
+	envVarCacheTotalMaxBytes           = "SPLUNK_CACHE_TOTAL_MAX_BYTES"
+	envVarForwardingRetryInterval      = "SPLUNK_LOGGING_DRIVER_FORWARDING_RETRY_INTERVAL"
+	envVarSinkQueueSize                = "SPLUNK_LOGGING_DRIVER_SINK_QUEUE_SIZE"
+	envVarJournaldSocket               = "SPLUNK_JOURNALD_SOCKET"
+	envVarTokenVaultJWTFile            = "SPLUNK_TOKEN_VAULT_JWT_FILE"
+	envVarTokenVaultSecretIDFile       = "SPLUNK_TOKEN_VAULT_SECRET_ID_FILE"
+	envVarMetricsAddr                  = "SPLUNK_METRICS_ADDR"
+	envVarMetricsMaxContainers         = "SPLUNK_METRICS_MAX_CONTAINERS"
+	envVarMetricsLatencyBuckets        = "SPLUNK_METRICS_LATENCY_BUCKETS"
+	envVarMetricsRetryBuckets          = "SPLUNK_METRICS_RETRY_BUCKETS"
+	envVarStatsInterval                = "SPLUNK_STATS_INTERVAL"
+	envVarSendErrorLogInterval         = "SPLUNK_LOGGING_DRIVER_SEND_ERROR_LOG_INTERVAL"
+	envVarHeartbeatInterval            = "SPLUNK_LOGGING_DRIVER_HEARTBEAT_INTERVAL"
+	envVarLifecycleEvents              = "SPLUNK_LIFECYCLE_EVENTS"
+	envVarLifecycleEventsIndex         = "SPLUNK_LIFECYCLE_EVENTS_INDEX"
+	envVarAlertConsecutiveFailures     = "SPLUNK_LOGGING_DRIVER_ALERT_CONSECUTIVE_FAILURES"
+	envVarAlertFailurePercent          = "SPLUNK_LOGGING_DRIVER_ALERT_FAILURE_PERCENT"
+	envVarAlertWindow                  = "SPLUNK_LOGGING_DRIVER_ALERT_WINDOW"
+	envVarAlertMinInterval             = "SPLUNK_LOGGING_DRIVER_ALERT_MIN_INTERVAL"
+	envVarTelemetry                    = "SPLUNK_TELEMETRY"
+	envVarTelemetryURL                 = "SPLUNK_TELEMETRY_URL"
+	envVarTelemetryToken               = "SPLUNK_TELEMETRY_TOKEN"
+	envVarTelemetryIndex               = "SPLUNK_TELEMETRY_INDEX"
+	envVarTelemetryIncludeHost         = "SPLUNK_TELEMETRY_INCLUDE_HOST"
+	envVarSenderWorkers                = "SPLUNK_LOGGING_DRIVER_SENDER_WORKERS"
+	envVarSenderWorkersMin             = "SPLUNK_LOGGING_DRIVER_SENDER_WORKERS_MIN"
+	envVarSenderWorkersMax             = "SPLUNK_LOGGING_DRIVER_SENDER_WORKERS_MAX"
+	envVarMaxInflight                  = "SPLUNK_LOGGING_DRIVER_MAX_INFLIGHT"
+	envVarRequestTimeout               = "SPLUNK_LOGGING_DRIVER_REQUEST_TIMEOUT"
+	envVarHealthInterval               = "SPLUNK_LOGGING_DRIVER_HEALTH_INTERVAL"
+	envVarHealthMaxDropPercent         = "SPLUNK_LOGGING_DRIVER_HEALTH_MAX_DROP_PERCENT"
+	envVarPprofAddr                    = "SPLUNK_PPROF_ADDR"
+	envVarPprofMutexFraction           = "SPLUNK_PPROF_MUTEX_FRACTION"
+	envVarPprofBlockRate               = "SPLUNK_PPROF_BLOCK_RATE"
 )
 
 type splunkLoggerInterface interface {
@@ -95,11 +347,79 @@ type splunkLoggerInterface interface {
 type splunkLogger struct {
 	hec         *hecClient
 	nullMessage *splunkMessage
+	containerID string
+
+	// Per-event ID for downstream deduplication. seq is incremented once
+	// per logged message, so retries of the same batch keep their IDs.
+	eventID bool
+	seq     uint64
+
+	// per-stream numbers of the container, nil unless splunk-sequence
+	sequence *streamSequence
+
+	// fields extracted from access log lines, nil unless splunk-access-log-format
+	accessLog *accessLogFormat
+
+	// nest the original Docker log entry fields under "docker"
+	includeEnvelope bool
+
+	// JSON lines sent as metric events, nil unless splunk-metrics-mode
+	metricsMode *metricsMapping
+
+	// sent when the log stream ends, nil when disabled
+	exitEvent *containerExitEvent
+
+	// nil unless the labels are refreshed, labelsVersion is the version of
+	// the attributes applied to the events
+	labels        *labelRefresher
+	labelsVersion uint64
+
+	// state of the dropped events summary, sent in the background by the stats
+	// reporter
+	drops *dropSummary
+	// nil when heartbeats are disabled
+	heartbeats *heartbeat
+	// nil when lifecycle events are disabled
+	lifecycle *lifecycleEvent
+
+	// *bandwidthLimiter of the events queued, nil unless
+	// splunk-bandwidth-limit, replaced live when it comes from the defaults
+	// file
+	bandwidth atomic.Value
+
+	// maximum number of indexed fields of an event, 0 when not limited
+	maxFields int
+	// warns once about the events with too many fields
+	maxFieldsWarning sync.Once
+
+	// buffered messages are posted once the stream is quiet for this long,
+	// for example when the container is paused, 0 disables it
+	flushOnIdle time.Duration
+	// buffered messages are posted once the oldest one is this old, even
+	// below the batch size, 0 disables it
+	maxEventAge time.Duration
+	// buffered messages are sent in the order of their timestamps, and held
+	// this long after they are read for older ones, 0 disables it
+	preserveOrder time.Duration
+	// messages whose timestamp was corrected are dropped, with
+	// splunk-invalid-time-policy=drop
+	invalidTimeDrop bool
+
+	// []*routingRule, replaced live when they come from the defaults file
+	routingRules atomic.Value
+	// index of the events by their sourcetype, after routing
+	indexBySourceType map[string]string
+	channels          *channelDeriver
+
+	// number of messages held by the worker, for queue depth metrics
+	buffered int64
 
 	// For synchronization between background worker and logger.
 	// We use channel to send messages to worker go routine.
 	// All other variables for blocking Close call before we flush all messages to HEC
-	stream     chan *splunkMessage
+	stream chan *splunkMessage
+	// closed by the worker once the messages buffered before are posted
+	flushes    chan chan struct{}
 	lock       sync.RWMutex
 	closed     bool
 	closedCond *sync.Cond
@@ -119,16 +439,40 @@ type splunkLoggerRaw struct {
 	*splunkLogger
 
 	prefix []byte
+	tag    string
 }
 
 type splunkMessage struct {
-	Event      interface{} `json:"event"`
-	Time       string      `json:"time"`
-	Host       string      `json:"host"`
-	Source     string      `json:"source,omitempty"`
-	SourceType string      `json:"sourcetype,omitempty"`
-	Index      string      `json:"index,omitempty"`
-	Entity     string      `json:"entity,omitempty"`
+	Event      interface{}       `json:"event"`
+	Time       string            `json:"time"`
+	Host       string            `json:"host"`
+	Source     string            `json:"source,omitempty"`
+	SourceType string            `json:"sourcetype,omitempty"`
+	Index      string            `json:"index,omitempty"`
+	Entity     string            `json:"entity,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+
+	// HEC request channel, sent as a header
+	channel string
+	// JSON encoding, kept once the message is measured for a size capped batch
+	encoded []byte
+	// values of a metric event, nil for other events
+	metrics map[string]float64
+	// when the plugin read the message, with splunk-add-buffer-latency or
+	// splunk-preserve-order
+	readAt int64
+	// timestamp of the message, with splunk-preserve-order
+	orderNano int64
+	// already exported over OTLP, when HEC failed with splunk-backend=both
+	exported bool
+	// already posted to HEC, when a later channel run of its batch failed
+	posted bool
+	// timestamp of a message read from the container, with
+	// splunk-delivery-latency-field
+	timeNano int64
+	// the timestamp was zero or skewed and replaced with the time the
+	// message was read
+	timeCorrected bool
 }
 
 type splunkMessageEvent struct {
@@ -136,11 +480,43 @@ type splunkMessageEvent struct {
 	Source string            `json:"source"`
 	Tag    string            `json:"tag,omitempty"`
 	Attrs  map[string]string `json:"attrs,omitempty"`
+	Docker *dockerEnvelope   `json:"docker,omitempty"`
+}
+
+// containerExitEvent is sent when the log stream of a container ends
+type containerExitEvent struct {
+	EventType     string            `json:"event_type"`
+	Reason        string            `json:"reason"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image"`
+	Tag           string            `json:"tag,omitempty"`
+	Attrs         map[string]string `json:"attrs,omitempty"`
+}
+
+// dockerEnvelope holds the fields of the log entry received from Docker
+type dockerEnvelope struct {
+	Source  string `json:"source"`
+	Partial bool   `json:"partial"`
+	Time    string `json:"time"`
 }
 
+const (
+	logSinkHEC           = "hec"
+	logSinkUnixSocket    = "unixsocket"
+	logSinkElasticsearch = "elasticsearch"
+)
+
+const (
+	splunkCompressionNone = "none"
+	splunkCompressionGzip = "gzip"
+	splunkCompressionZstd = "zstd"
+)
+
 const (
 	splunkFormatRaw    = "raw"
 	splunkFormatJSON   = "json"
+	splunkFormatMetric = "metric"
 	splunkFormatInline = "inline"
 )
 
@@ -148,48 +524,102 @@ const (
 New Splunk Logger
 */
 func New(info logger.Info) (logger.Logger, error) {
+	return NewWithClient(info, nil)
+}
+
+/*
+NewWithClient creates a Splunk Logger posting to HEC with client, for example
+a client with a stub transport in tests. The TLS options don't apply to a
+given client. A nil client gets one built from the options, as with New.
+*/
+func NewWithClient(info logger.Info, client *http.Client) (logger.Logger, error) {
 	hostname, err := info.Hostname()
 	if err != nil {
 		return nil, fmt.Errorf("%s: cannot access hostname to set source field", driverName)
 	}
 
-	// Parse and validate Splunk URL
-	splunkURL, err := parseURL(info)
+	// Events are posted to HEC, unless they are written to the socket of a
+	// local forwarder or posted to Elasticsearch, which need neither URL nor
+	// token
+	var socket *socketSink
+	var elasticsearch *elasticsearchSink
+	switch sink := info.Config[logSinkKey]; sink {
+	case "", logSinkHEC:
+	case logSinkUnixSocket:
+		socketPath, ok := info.Config[logSinkSocketKey]
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is expected with %s=%s", driverName, logSinkSocketKey, logSinkKey, logSinkUnixSocket)
+		}
+		socket = newSocketSink(socketPath)
+	case logSinkElasticsearch:
+		elasticsearch, err = newElasticsearchSink(info, hostname)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown sink specified %s, supported sinks are hec, unixsocket and elasticsearch", sink)
+	}
+
+	// Events are posted to HEC by default, but we allow user to export them
+	// over OTLP instead or as well
+	backend, err := parseBackend(info.Config)
 	if err != nil {
 		return nil, err
 	}
-
-	// Splunk Token is required parameter
-	splunkToken, ok := info.Config[splunkTokenKey]
-	if !ok {
-		return nil, fmt.Errorf("%s: %s is expected", driverName, splunkTokenKey)
+	var otlp *otlpExporter
+	if backend != splunkBackendHEC {
+		if socket != nil || elasticsearch != nil {
+			return nil, fmt.Errorf("%s: %s=%s is not supported with %s=%s", driverName, splunkBackendKey, backend, logSinkKey, info.Config[logSinkKey])
+		}
+		otlp, err = newOTLPExporter(info, hostname)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	tlsConfig := &tls.Config{}
-
-	// Splunk is using autogenerated certificates by default,
-	// allow users to trust them with skipping verification
-	if insecureSkipVerifyStr, ok := info.Config[splunkInsecureSkipVerifyKey]; ok {
-		insecureSkipVerify, err := strconv.ParseBool(insecureSkipVerifyStr)
+	splunkURL := &url.URL{Scheme: "unix", Path: info.Config[logSinkSocketKey]}
+	splunkToken := ""
+	var vault *vaultTokenSource
+	if backend == splunkBackendOTLP {
+		// the URL only identifies the destination in logs and metrics
+		splunkURL, _ = url.Parse(otlp.url)
+	} else if elasticsearch != nil {
+		splunkURL, _ = url.Parse(elasticsearch.url)
+	} else if socket == nil {
+		// Parse and validate Splunk URL
+		splunkURL, err = parseURL(info)
 		if err != nil {
 			return nil, err
 		}
-		tlsConfig.InsecureSkipVerify = insecureSkipVerify
-	}
+		if u, err := url.Parse(info.Config[splunkURLKey]); err == nil && splunkURLCollectorPath(u) != "" {
+			driverLog.WithField("id", info.ContainerID).WithField("url", splunkURL.Scheme+"://"+splunkURL.Host).Warn("Removed the collector path from " + splunkURLKey + ", the plugin appends " + splunkURLPathKey + " itself")
+		}
 
-	// If path to the root certificate is provided - load it
-	if caPath, ok := info.Config[splunkCAPathKey]; ok {
-		caCert, err := ioutil.ReadFile(caPath)
+		// Splunk Token is required parameter, unless it is read from Vault
+		vaultConfig, err := parseVaultTokenConfig(info.Config)
 		if err != nil {
 			return nil, err
 		}
-		caPool := x509.NewCertPool()
-		caPool.AppendCertsFromPEM(caCert)
-		tlsConfig.RootCAs = caPool
+		if vaultConfig != nil {
+			if vault, err = newVaultTokenSource(vaultConfig); err != nil {
+				return nil, err
+			}
+			// Vault may be down only for a while
+			if _, err := vault.get(); err != nil {
+				return nil, &splunkUnavailableError{err}
+			}
+		} else {
+			var ok bool
+			splunkToken, ok = info.Config[splunkTokenKey]
+			if !ok {
+				return nil, fmt.Errorf("%s: %s is expected", driverName, splunkTokenKey)
+			}
+		}
 	}
 
-	if caName, ok := info.Config[splunkCANameKey]; ok {
-		tlsConfig.ServerName = caName
+	tlsConfig, err := newTLSConfig(info.Config, hecTLSOptions)
+	if err != nil {
+		return nil, err
 	}
 
 	gzipCompression := false
@@ -200,6 +630,22 @@ func New(info logger.Info) (logger.Logger, error) {
 		}
 	}
 
+	// splunk-compression takes precedence over splunk-gzip
+	zstdCompression := false
+	if compression, ok := info.Config[splunkCompressionKey]; ok {
+		switch compression {
+		case splunkCompressionNone:
+			gzipCompression = false
+		case splunkCompressionGzip:
+			gzipCompression = true
+		case splunkCompressionZstd:
+			gzipCompression = false
+			zstdCompression = true
+		default:
+			return nil, fmt.Errorf("unknown compression specified %s, supported compressions are none, gzip and zstd", compression)
+		}
+	}
+
 	gzipCompressionLevel := gzip.DefaultCompression
 	if gzipCompressionLevelStr, ok := info.Config[splunkGzipCompressionLevelKey]; ok {
 		var err error
@@ -215,11 +661,15 @@ func New(info logger.Info) (logger.Logger, error) {
 		}
 	}
 
-	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
-	}
-	client := &http.Client{
-		Transport: transport,
+	// only the transport the logger creates is closed with it
+	var transport *http.Transport
+	if client == nil {
+		transport = &http.Transport{
+			TLSClientConfig: tlsConfig,
+		}
+		client = &http.Client{
+			Transport: transport,
+		}
 	}
 
 	source := info.Config[splunkSourceKey]
@@ -231,12 +681,115 @@ func New(info logger.Info) (logger.Logger, error) {
 		Source:     source,
 		SourceType: sourceType,
 		Index:      index,
+		Fields:     enrichFields(info),
+	}
+
+	if includeNetworkStr, ok := info.Config[splunkIncludeNetworkKey]; ok {
+		includeNetwork, err := strconv.ParseBool(includeNetworkStr)
+		if err != nil {
+			return nil, err
+		}
+		if includeNetwork {
+			for key, value := range networkFields(info) {
+				if nullMessage.Fields == nil {
+					nullMessage.Fields = make(map[string]string)
+				}
+				nullMessage.Fields[key] = value
+			}
+		}
+	}
+
+	if includeResourcesStr, ok := info.Config[splunkIncludeResourcesKey]; ok {
+		includeResources, err := strconv.ParseBool(includeResourcesStr)
+		if err != nil {
+			return nil, err
+		}
+		if includeResources {
+			for key, value := range resourceFields(info) {
+				if nullMessage.Fields == nil {
+					nullMessage.Fields = make(map[string]string)
+				}
+				nullMessage.Fields[key] = value
+			}
+		}
+	}
+
+	// By default every field is sent, but we allow user to bound the indexed
+	// fields, they are capped when the events are queued
+	var maxFields int
+	if maxFieldsStr, ok := info.Config[splunkMaxFieldsKey]; ok {
+		maxFields, err = strconv.Atoi(maxFieldsStr)
+		if err != nil {
+			return nil, err
+		}
+		if maxFields < 0 {
+			return nil, fmt.Errorf("%s: %s must not be negative", driverName, splunkMaxFieldsKey)
+		}
+	}
+
+	// Tag the events with the options they were sent with, to audit rollouts
+	if configHashStr, ok := info.Config[splunkConfigHashKey]; ok {
+		withConfigHash, err := strconv.ParseBool(configHashStr)
+		if err != nil {
+			return nil, err
+		}
+		if withConfigHash {
+			if nullMessage.Fields == nil {
+				nullMessage.Fields = make(map[string]string)
+			}
+			nullMessage.Fields[configHashField] = configHash(info.Config)
+		}
+	}
+
+	// Tag the events with the digest of the image, to correlate them with the
+	// supply chain
+	if imageDigestStr, ok := info.Config[splunkIncludeImageDigestKey]; ok {
+		withImageDigest, err := strconv.ParseBool(imageDigestStr)
+		if err != nil {
+			return nil, err
+		}
+		if digest := imageDigest(info); withImageDigest && digest != "" {
+			if nullMessage.Fields == nil {
+				nullMessage.Fields = make(map[string]string)
+			}
+			nullMessage.Fields[imageDigestField] = digest
+		}
 	}
 
-	// Allow user to remove tag from the messages by setting tag to empty string
+	// Without local json log, docker logs searches the events by container ID
+	if searchesContainer(info.Config) {
+		if nullMessage.Fields == nil {
+			nullMessage.Fields = make(map[string]string)
+		}
+		nullMessage.Fields[searchContainerIDField] = info.ContainerID
+	}
+
+	// By default the events name the plugin as their log driver, but we allow
+	// user to change the name, or to remove it with an empty name
+	logDriver := defaultLogDriver
+	if logDriverStr, ok := info.Config[splunkLogDriverKey]; ok {
+		logDriver = logDriverStr
+	}
+	if logDriver != "" {
+		if nullMessage.Fields == nil {
+			nullMessage.Fields = make(map[string]string)
+		}
+		nullMessage.Fields[logDriverField] = logDriver
+	}
+
+	// Allow user to remove tag from the messages by setting tag to empty string,
+	// without tag the plugin default applies, Docker's {{.ID}} unless changed.
+	// An empty plugin default omits the tag.
 	tag := ""
-	if tagTemplate, ok := info.Config[tagKey]; !ok || tagTemplate != "" {
-		tag, err = loggerutils.ParseLogTag(info, loggerutils.DefaultTemplate)
+	tagTemplate, ok := info.Config[tagKey]
+	if !ok {
+		tagTemplate = getAdvancedOptionString(envVarDefaultTag, loggerutils.DefaultTemplate)
+	}
+	if tagTemplate != "" {
+		// ParseLogTag reads the template from the options
+		tagInfo := info
+		tagInfo.Config = map[string]string{tagKey: tagTemplate}
+		tag, err = loggerutils.ParseLogTag(tagInfo, loggerutils.DefaultTemplate)
 		if err != nil {
 			return nil, err
 		}
@@ -251,9 +804,144 @@ func New(info logger.Info) (logger.Logger, error) {
 		postMessagesFrequency = getAdvancedOptionDuration(envVarPostMessagesFrequency, defaultPostMessagesFrequency)
 		postMessagesBatchSize = getAdvancedOptionInt(envVarPostMessagesBatchSize, defaultPostMessagesBatchSize)
 		bufferMaximum         = getAdvancedOptionInt(envVarBufferMaximum, defaultBufferMaximum)
+		postMessagesMaxBytes  = getAdvancedOptionInt(envVarPostMessagesMaxBytes, defaultPostMessagesMaxBytes)
 		streamChannelSize     = getAdvancedOptionInt(envVarStreamChannelSize, defaultStreamChannelSize)
 	)
 
+	routingRules, err := parseRoutingRules(info.Config[splunkRoutingRulesKey])
+	if err != nil {
+		return nil, err
+	}
+
+	indexBySourceType, err := parseSourceTypeIndexMap(info.Config[splunkSourceTypeIndexMapKey])
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := newChannelDeriver(info)
+	if err != nil {
+		return nil, err
+	}
+
+	// By default we don't add event ids, but we allow user to enable that
+	eventID := false
+	if eventIDStr, ok := info.Config[splunkEventIDKey]; ok {
+		eventID, err = strconv.ParseBool(eventIDStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default we don't include the docker envelope, but we allow user to enable that
+	includeEnvelope := false
+	if includeEnvelopeStr, ok := info.Config[splunkIncludeDockerEnvelopeKey]; ok {
+		includeEnvelope, err = strconv.ParseBool(includeEnvelopeStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default we don't send container exit events, but we allow user to enable that
+	var exitEvent *containerExitEvent
+	if exitEventStr, ok := info.Config[splunkExitEventKey]; ok {
+		enabled, err := strconv.ParseBool(exitEventStr)
+		if err != nil {
+			return nil, err
+		}
+		if enabled {
+			exitEvent = &containerExitEvent{
+				EventType:     "container_exited",
+				ContainerID:   info.ContainerID,
+				ContainerName: info.Name(),
+				Image:         info.ContainerImageName,
+				Tag:           tag,
+				Attrs:         attrs,
+			}
+		}
+	}
+
+	// Heartbeats default to the plugin setting, but we allow user to change that
+	heartbeatInterval := getAdvancedOptionDuration(envVarHeartbeatInterval, defaultHeartbeatInterval)
+	if heartbeatIntervalStr, ok := info.Config[splunkHeartbeatIntervalKey]; ok {
+		heartbeatInterval, err = time.ParseDuration(heartbeatIntervalStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default we wait for the batch size or the post frequency, but we allow user to flush sooner
+	var flushOnIdle time.Duration
+	if flushOnIdleStr, ok := info.Config[splunkFlushOnIdleKey]; ok {
+		flushOnIdle, err = time.ParseDuration(flushOnIdleStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default events are sent as read, but we allow user to add how long they were buffered
+	addBufferLatency := false
+	if addBufferLatencyStr, ok := info.Config[splunkAddBufferLatencyKey]; ok {
+		addBufferLatency, err = strconv.ParseBool(addBufferLatencyStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default events are sent as read, but we allow user to add how long they were in the plugin
+	deliveryLatencyField := info.Config[splunkDeliveryLatencyFieldKey]
+
+	// By default the labels are resolved once, but we allow user to refresh them
+	var labelsRefresh time.Duration
+	if labelsRefreshStr, ok := info.Config[splunkLabelsRefreshKey]; ok {
+		labelsRefresh, err = time.ParseDuration(labelsRefreshStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default lines are sent as events, but we allow user to send metric lines as metric events
+	metricsMode, err := parseMetricsMode(info)
+	if err != nil {
+		return nil, err
+	}
+
+	// By default lines are not parsed, but we allow user to extract the fields of access logs
+	accessLog, err := parseAccessLogFormat(info.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// By default buffered messages have no maximum age, but we allow user to bound the latency
+	var maxEventAge time.Duration
+	if maxEventAgeStr, ok := info.Config[splunkMaxEventAgeKey]; ok {
+		maxEventAge, err = time.ParseDuration(maxEventAgeStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default events with a corrected timestamp are sent, but we allow user to drop them
+	invalidTimeDrop, err := parseInvalidTimePolicy(info.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// By default events are queued as fast as they are read, but we allow user to limit their bytes per second
+	bandwidthLimit, err := parseBandwidthLimit(info.Config)
+	if err != nil {
+		return nil, err
+	}
+	bandwidthPolicy, err := parseBandwidthPolicy(info.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// By default messages are sent in the order they are read, but we allow user to order them by timestamp
+	preserveOrder, err := parsePreserveOrder(info.Config)
+	if err != nil {
+		return nil, err
+	}
+
 	logger := &splunkLogger{
 		hec: &hecClient{
 			client:                client,
@@ -261,15 +949,50 @@ func New(info logger.Info) (logger.Logger, error) {
 			url:                   splunkURL.String(),
 			healthCheckURL:        composeHealthCheckURL(splunkURL),
 			auth:                  "Splunk " + splunkToken,
+			vault:                 vault,
 			gzipCompression:       gzipCompression,
 			gzipCompressionLevel:  gzipCompressionLevel,
+			zstdCompression:       zstdCompression,
 			postMessagesFrequency: postMessagesFrequency,
 			postMessagesBatchSize: postMessagesBatchSize,
 			bufferMaximum:         bufferMaximum,
+			postMessagesMaxBytes:  postMessagesMaxBytes,
+			pool:                  senderWorkers,
+			shardKey:              info.ContainerID,
+			socket:                socket,
+			elasticsearch:         elasticsearch,
+			otlp:                  otlp,
+			backend:               backend,
+			monitor:               newDeliveryMonitor(info),
+			dropSamples:           newDropSampler(splunkToken),
+			addBufferLatency:      addBufferLatency,
+			deliveryLatencyField:  deliveryLatencyField,
+			requestTimeout:        getAdvancedOptionDuration(envVarRequestTimeout, defaultRequestTimeout),
+			retryBudget: newRetryBudget(getAdvancedOptionInt(envVarRetryBudgetPercent, defaultRetryBudgetPercent),
+				getAdvancedOptionDuration(envVarRetryBudgetWindow, defaultRetryBudgetWindow)),
 		},
-		nullMessage: nullMessage,
-		stream:      make(chan *splunkMessage, streamChannelSize),
+		nullMessage:       nullMessage,
+		containerID:       info.ContainerID,
+		eventID:           eventID,
+		includeEnvelope:   includeEnvelope,
+		metricsMode:       metricsMode,
+		accessLog:         accessLog,
+		exitEvent:         exitEvent,
+		drops:             newDropSummary(info, tag),
+		heartbeats:        newHeartbeat(info, tag, heartbeatInterval),
+		lifecycle:         newLifecycleEvent(info),
+		maxFields:         maxFields,
+		flushOnIdle:       flushOnIdle,
+		maxEventAge:       maxEventAge,
+		preserveOrder:     preserveOrder,
+		invalidTimeDrop:   invalidTimeDrop,
+		indexBySourceType: indexBySourceType,
+		channels:          channels,
+		stream:            make(chan *splunkMessage, streamChannelSize),
+		flushes:           make(chan chan struct{}),
 	}
+	logger.setRoutingRules(routingRules)
+	logger.setBandwidthLimit(bandwidthLimit, bandwidthPolicy)
 
 	// By default we don't verify connection, but we allow user to enable that
 	verifyConnection := false
@@ -280,12 +1003,36 @@ func New(info logger.Info) (logger.Logger, error) {
 			return nil, err
 		}
 	}
+	// By default the connection is verified once, but we allow user to retry for a while
+	var verifyTimeout time.Duration
+	if verifyTimeoutStr, ok := info.Config[splunkVerifyTimeoutKey]; ok {
+		verifyTimeout, err = time.ParseDuration(verifyTimeoutStr)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if verifyConnection {
-		err = logger.hec.verifySplunkConnection(logger)
+		err = logger.hec.verifyConnectionWithin(logger, verifyTimeout)
+		if err != nil {
+			return nil, &splunkUnavailableError{err}
+		}
+	}
+
+	// By default we don't verify the index, but we allow user to enable that
+	verifyIndex := false
+	if verifyIndexStr, ok := info.Config[splunkVerifyIndexKey]; ok {
+		verifyIndex, err = strconv.ParseBool(verifyIndexStr)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if verifyIndex && socket == nil && elasticsearch == nil && backend != splunkBackendOTLP {
+		if getAdvancedOptionBool(envVarSkipVerifyIndex, defaultSkipVerifyIndex) {
+			driverLog.WithField("id", info.ContainerID).Info("Skipping the index verification")
+		} else if err := logger.verifyIndex(info.Config[splunkVerifyIndexAPIKey]); err != nil {
+			return nil, &splunkUnavailableError{err}
+		}
+	}
 
 	var splunkFormat string
 	if splunkFormatParsed, ok := info.Config[splunkFormatKey]; ok {
@@ -293,8 +1040,9 @@ func New(info logger.Info) (logger.Logger, error) {
 		case splunkFormatInline:
 		case splunkFormatJSON:
 		case splunkFormatRaw:
+		case splunkFormatMetric:
 		default:
-			return nil, fmt.Errorf("unknown format specified %s, supported formats are inline, json and raw", splunkFormat)
+			return nil, fmt.Errorf("unknown format specified %s, supported formats are inline, json, raw and metric", splunkFormat)
 		}
 		splunkFormat = splunkFormatParsed
 	} else {
@@ -319,56 +1067,220 @@ func New(info logger.Info) (logger.Logger, error) {
 
 		loggerWrapper = &splunkLoggerJSON{&splunkLoggerInline{logger, nullEvent}}
 	case splunkFormatRaw:
-		var prefix bytes.Buffer
-		if tag != "" {
-			prefix.WriteString(tag)
-			prefix.WriteString(" ")
-		}
-		for key, value := range attrs {
-			prefix.WriteString(key)
-			prefix.WriteString("=")
-			prefix.WriteString(value)
-			prefix.WriteString(" ")
+		if includeEnvelope {
+			return nil, fmt.Errorf("%s: %s is not supported with the raw format", driverName, splunkIncludeDockerEnvelopeKey)
 		}
-
-		loggerWrapper = &splunkLoggerRaw{logger, prefix.Bytes()}
+		loggerWrapper = &splunkLoggerRaw{logger, rawPrefix(tag, attrs), tag}
+	case splunkFormatMetric:
+		loggerWrapper = &splunkLoggerMetric{logger, attrs}
 	default:
 		return nil, fmt.Errorf("unexpected format %s", splunkFormat)
 	}
 
+	logger.hec.alert = logger.sendDeliveryAlert
+	c := &containerMetrics{
+		id:         info.ContainerID,
+		queueDepth: logger.queueDepth,
+		// the worker starts dropping events once its buffer reaches the maximum
+		queueCapacity: bufferMaximum,
+		reportDrops:   logger.reportDrops,
+	}
+	if logger.heartbeats != nil {
+		c.heartbeat = logger.sendHeartbeat
+	}
+	logger.hec.metrics = metrics.add(c)
+	if socket == nil && elasticsearch == nil && backend != splunkBackendOTLP {
+		health.register(logger.hec)
+	}
+	logger.labels = newLabelRefresher(info, attrs, labelsRefresh)
 	go loggerWrapper.worker()
 
 	return loggerWrapper, nil
 }
 
+// supportedLogOpts are the options accepted by ValidateLogOpt
+var supportedLogOpts = []string{
+	splunkURLKey,
+	splunkURLPathKey,
+	splunkTokenKey,
+	splunkSourceKey,
+	splunkSourceTypeKey,
+	splunkIndexKey,
+	splunkCAPathKey,
+	splunkCANameKey,
+	splunkInsecureSkipVerifyKey,
+	splunkPinnedCertSHA256Key,
+	splunkPinTypeKey,
+	splunkTLSMinVersionKey,
+	splunkTLSCiphersKey,
+	splunkFormatKey,
+	splunkVerifyConnectionKey,
+	splunkVerifyTimeoutKey,
+	splunkVerifyIndexKey,
+	splunkVerifyIndexAPIKey,
+	splunkGzipCompressionKey,
+	splunkGzipCompressionLevelKey,
+	splunkCompressionKey,
+	splunkEventIDKey,
+	splunkImageAllowlistKey,
+	splunkEnrichURLKey,
+	splunkEnrichRedactKey,
+	splunkIncludeNetworkKey,
+	splunkIncludeResourcesKey,
+	splunkMaxFieldsKey,
+	splunkConfigHashKey,
+	splunkIncludeImageDigestKey,
+	splunkRoutingRulesKey,
+	splunkSourceTypeIndexMapKey,
+	splunkChannelFromKey,
+	splunkExitEventKey,
+	splunkDropSummaryIndexKey,
+	splunkDropSummarySourceTypeKey,
+	splunkHeartbeatIntervalKey,
+	splunkPartialTimeoutKey,
+	splunkFlushOnIdleKey,
+	splunkMaxEventAgeKey,
+	splunkLocalCompressKey,
+	splunkLocalMaxAgeKey,
+	splunkLocalJSONKey,
+	splunkSearchURLKey,
+	splunkSearchTokenKey,
+	splunkSearchCAPathKey,
+	splunkSearchCANameKey,
+	splunkSearchInsecureSkipVerifyKey,
+	splunkInputGzipKey,
+	splunkNormalizeNewlinesKey,
+	splunkStripANSIKey,
+	splunkDisabledKey,
+	splunkStrictOptsKey,
+	splunkJournaldCopyKey,
+	splunkAddBufferLatencyKey,
+	splunkSyslogURLKey,
+	splunkSyslogFacilityKey,
+	splunkSyslogTagKey,
+	splunkSyslogMatchKey,
+	splunkSyslogCAPathKey,
+	splunkSyslogCANameKey,
+	splunkSyslogInsecureSkipVerifyKey,
+	splunkSyslogCertKey,
+	splunkSyslogKeyKey,
+	splunkBackendKey,
+	splunkOTLPEndpointKey,
+	splunkOTLPHeadersKey,
+	splunkOTLPCAPathKey,
+	splunkOTLPCANameKey,
+	splunkOTLPInsecureSkipVerifyKey,
+	splunkOTLPCertKey,
+	splunkOTLPKeyKey,
+	splunkLabelsRefreshKey,
+	splunkMetricsModeKey,
+	splunkMetricsMappingKey,
+	splunkMetricsIndexKey,
+	splunkSequenceKey,
+	splunkAccessLogFormatKey,
+	splunkDeliveryLatencyFieldKey,
+	splunkMaxTimeSkewKey,
+	splunkInvalidTimePolicyKey,
+	splunkTokenVaultAddrKey,
+	splunkTokenVaultPathKey,
+	splunkTokenVaultRoleKey,
+	splunkTokenVaultAuthKey,
+	splunkTokenVaultFieldKey,
+	splunkTokenVaultCAPathKey,
+	splunkLogDriverKey,
+	splunkCacheRequiredKey,
+	splunkForwardingRequiredKey,
+	splunkDegradedBackfillMaxKey,
+	splunkBandwidthLimitKey,
+	splunkBandwidthPolicyKey,
+	splunkPreserveOrderKey,
+	splunkPreserveOrderWindowKey,
+	logSinkKey,
+	logSinkSocketKey,
+	logSinkElasticsearchURLKey,
+	logSinkElasticsearchIndexKey,
+	logSinkElasticsearchFieldsKey,
+	logSinkElasticsearchAPIKeyKey,
+	logSinkElasticsearchCAPathKey,
+	logSinkElasticsearchInsecureSkipVerifyKey,
+	splunkIncludeDockerEnvelopeKey,
+	envKey,
+	envRegexKey,
+	labelsKey,
+	tagKey,
+	localMaxSizeKey,
+	localMaxFileKey,
+}
+
 /*
-ValidateLogOpt validates the arguments passed in to the plugin
+ValidateLogOpt validates the arguments passed in to the plugin. Every
+unknown option and invalid value is reported in a single error. Unknown
+splunk-* options are only logged when splunk-strict-opts is false.
 */
 func ValidateLogOpt(cfg map[string]string) error {
+	keys := make([]string, 0, len(cfg))
 	for key := range cfg {
-		switch key {
-		case splunkURLKey:
-		case splunkURLPathKey:
-		case splunkTokenKey:
-		case splunkSourceKey:
-		case splunkSourceTypeKey:
-		case splunkIndexKey:
-		case splunkCAPathKey:
-		case splunkCANameKey:
-		case splunkInsecureSkipVerifyKey:
-		case splunkFormatKey:
-		case splunkVerifyConnectionKey:
-		case splunkGzipCompressionKey:
-		case splunkGzipCompressionLevelKey:
-		case envKey:
-		case envRegexKey:
-		case labelsKey:
-		case tagKey:
-		default:
-			return fmt.Errorf("unknown log opt '%s' for %s log driver", key, driverName)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	unknown := false
+	for _, key := range keys {
+		if ignoredLogOpt(key, cfg) {
+			driverLog.WithError(unknownLogOptError(key)).Warn("Ignoring unknown option, " + splunkStrictOptsKey + " is false")
+			continue
+		}
+		if !isSupportedLogOpt(key) {
+			problems = append(problems, unknownLogOptError(key).Error())
+			unknown = true
+			continue
+		}
+		if check, ok := optionChecks[key]; ok {
+			if _, err := check(cfg[key], cfg); err != nil {
+				value := cfg[key]
+				if secretOptions[key] {
+					value = redactSecret(value)
+				}
+				problems = append(problems, fmt.Sprintf("invalid value '%s' for %s: %v", value, key, err))
+			}
 		}
 	}
-	return nil
+	if len(problems) == 0 {
+		return nil
+	}
+	if unknown {
+		problems = append(problems, "supported options are "+strings.Join(supportedLogOpts, ", "))
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// ignoredLogOpt() returns true for an unknown splunk-* option, which is
+// only ignored for compatibility when splunk-strict-opts is false
+func ignoredLogOpt(key string, cfg map[string]string) bool {
+	if isSupportedLogOpt(key) || !strings.HasPrefix(key, "splunk-") {
+		return false
+	}
+	strict, err := strconv.ParseBool(cfg[splunkStrictOptsKey])
+	return err == nil && !strict
+}
+
+func isSupportedLogOpt(key string) bool {
+	for _, supported := range supportedLogOpts {
+		if key == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownLogOptError() suggests the closest supported option, if any is
+// close enough to be a typo
+func unknownLogOptError(key string) error {
+	if suggestion := closestLogOpt(key); suggestion != "" {
+		return fmt.Errorf("unknown log opt '%s' for %s log driver, did you mean '%s'?", key, driverName, suggestion)
+	}
+	return fmt.Errorf("unknown log opt '%s' for %s log driver", key, driverName)
 }
 
 func parseURL(info logger.Info) (*url.URL, error) {
@@ -382,6 +1294,22 @@ func parseURL(info logger.Info) (*url.URL, error) {
 		return nil, fmt.Errorf("%s: failed to parse %s as url value in %s", driverName, splunkURLStr, splunkURLKey)
 	}
 
+	if splunkURL.IsAbs() && splunkURL.Opaque == "" && splunkURL.Scheme != "http" && splunkURL.Scheme != "https" {
+		return nil, fmt.Errorf("%s: unsupported scheme %s in %s, expected http or https", driverName, splunkURL.Scheme, splunkURLKey)
+	}
+	if splunkURL.User != nil {
+		return nil, fmt.Errorf("%s: %s must not contain credentials, the token is set with %s", driverName, splunkURLKey, splunkTokenKey)
+	}
+	if port := splunkURL.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("%s: invalid port %s in %s", driverName, port, splunkURLKey)
+		}
+	}
+	// the collector path is appended by the plugin, see splunk-url-path
+	if collectorPath := splunkURLCollectorPath(splunkURL); collectorPath != "" {
+		splunkURL.Path = ""
+	}
+
 	if !urlutil.IsURL(splunkURLStr) ||
 		!splunkURL.IsAbs() ||
 		(splunkURL.Path != "" && splunkURL.Path != "/") ||
@@ -404,6 +1332,26 @@ func parseURL(info logger.Info) (*url.URL, error) {
 	return splunkURL, nil
 }
 
+// collectorPaths are the HEC endpoints users often append to splunk-url
+var collectorPaths = []string{
+	"/services/collector",
+	"/services/collector/event",
+	"/services/collector/event/1.0",
+	"/services/collector/raw",
+	"/services/collector/raw/1.0",
+}
+
+// splunkURLCollectorPath() returns the HEC endpoint path of the URL, if any
+func splunkURLCollectorPath(splunkURL *url.URL) string {
+	path := strings.TrimSuffix(splunkURL.Path, "/")
+	for _, collectorPath := range collectorPaths {
+		if path == collectorPath {
+			return splunkURL.Path
+		}
+	}
+	return ""
+}
+
 /*
  parseURL() makes sure that the URL is the format of: scheme://dns_name_or_ip:port
 */
@@ -418,7 +1366,27 @@ func getAdvancedOptionDuration(envName string, defaultValue time.Duration) time.
 	}
 	parsedValue, err := time.ParseDuration(valueStr)
 	if err != nil {
-		logrus.Error(fmt.Sprintf("Failed to parse value of %s as duration. Using default %v. %v", envName, defaultValue, err))
+		senderLog.WithField("env", envName).WithField("default", defaultValue).WithError(err).Error("Failed to parse value as duration, using default")
+		return defaultValue
+	}
+	return parsedValue
+}
+
+func getAdvancedOptionString(envName string, defaultValue string) string {
+	if valueStr, ok := os.LookupEnv(envName); ok {
+		return valueStr
+	}
+	return defaultValue
+}
+
+func getAdvancedOptionBool(envName string, defaultValue bool) bool {
+	valueStr := os.Getenv(envName)
+	if valueStr == "" {
+		return defaultValue
+	}
+	parsedValue, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		senderLog.WithField("env", envName).WithField("default", defaultValue).WithError(err).Error("Failed to parse value as boolean, using default")
 		return defaultValue
 	}
 	return parsedValue
@@ -431,7 +1399,7 @@ func getAdvancedOptionInt(envName string, defaultValue int) int {
 	}
 	parsedValue, err := strconv.ParseInt(valueStr, 10, 32)
 	if err != nil {
-		logrus.Error(fmt.Sprintf("Failed to parse value of %s as integer. Using default %d. %v", envName, defaultValue, err))
+		senderLog.WithField("env", envName).WithField("default", defaultValue).WithError(err).Error("Failed to parse value as integer, using default")
 		return defaultValue
 	}
 	return int(parsedValue)
@@ -440,11 +1408,16 @@ func getAdvancedOptionInt(envName string, defaultValue int) int {
 // Log() takes in a log message reference and put it into a queue: stream
 // stream is used by the HEC workers
 func (l *splunkLoggerInline) Log(msg *logger.Message) error {
+	l.refreshAttrs()
+	if metric, err := l.logMetricLine(msg, l.nullEvent.Attrs); metric {
+		return err
+	}
 	message := l.createSplunkMessage(msg)
 
 	event := *l.nullEvent
 	event.Line = string(msg.Line)
 	event.Source = msg.Source
+	event.Docker = l.dockerEnvelope(msg)
 
 	message.Event = &event
 	logger.PutMessage(msg)
@@ -452,6 +1425,10 @@ func (l *splunkLoggerInline) Log(msg *logger.Message) error {
 }
 
 func (l *splunkLoggerJSON) Log(msg *logger.Message) error {
+	l.refreshAttrs()
+	if metric, err := l.logMetricLine(msg, l.nullEvent.Attrs); metric {
+		return err
+	}
 	message := l.createSplunkMessage(msg)
 	event := *l.nullEvent
 
@@ -463,13 +1440,26 @@ func (l *splunkLoggerJSON) Log(msg *logger.Message) error {
 	}
 
 	event.Source = msg.Source
+	event.Docker = l.dockerEnvelope(msg)
 
 	message.Event = &event
 	logger.PutMessage(msg)
 	return l.queueMessageAsync(message)
 }
 
+// refreshAttrs() applies the attributes of refreshed labels to the next events
+func (l *splunkLoggerInline) refreshAttrs() {
+	if attrs, ok := l.refreshedAttrs(); ok {
+		nullEvent := *l.nullEvent
+		nullEvent.Attrs = attrs
+		l.nullEvent = &nullEvent
+	}
+}
+
 func (l *splunkLoggerRaw) Log(msg *logger.Message) error {
+	if attrs, ok := l.refreshedAttrs(); ok {
+		l.prefix = rawPrefix(l.tag, attrs)
+	}
 	message := l.createSplunkMessage(msg)
 
 	message.Event = string(append(l.prefix, msg.Line...))
@@ -477,16 +1467,134 @@ func (l *splunkLoggerRaw) Log(msg *logger.Message) error {
 	return l.queueMessageAsync(message)
 }
 
+// rawPrefix() returns the tag and the attributes written before the lines of
+// raw events
+func rawPrefix(tag string, attrs map[string]string) []byte {
+	var prefix bytes.Buffer
+	if tag != "" {
+		prefix.WriteString(tag)
+		prefix.WriteString(" ")
+	}
+	for key, value := range attrs {
+		prefix.WriteString(key)
+		prefix.WriteString("=")
+		prefix.WriteString(value)
+		prefix.WriteString(" ")
+	}
+	return prefix.Bytes()
+}
+
+// dockerEnvelope() returns the Docker log entry fields of the message when
+// splunk-include-docker-envelope is enabled
+func (l *splunkLogger) dockerEnvelope(msg *logger.Message) *dockerEnvelope {
+	if !l.includeEnvelope {
+		return nil
+	}
+	return &dockerEnvelope{
+		Source:  msg.Source,
+		Partial: msg.Partial,
+		Time:    msg.Timestamp.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// logContainerExit() queues a container_exited event with the reason the log
+// stream ended, when splunk-exit-event is enabled
+func (l *splunkLogger) logContainerExit(reason string) error {
+	l.refreshedAttrs()
+	if l.exitEvent == nil {
+		return nil
+	}
+	msg := &logger.Message{Timestamp: time.Now()}
+	message := l.createSplunkMessage(msg)
+	event := *l.exitEvent
+	event.Reason = reason
+	message.Event = &event
+	return l.queueMessageAsync(message)
+}
+
 func (l *splunkLogger) queueMessageAsync(message *splunkMessage) error {
+	if l.dropInvalidTime(message) {
+		return nil
+	}
+	applyTransformers(message)
+	l.capFields(message)
+	if !l.limitBandwidth(message) {
+		return nil
+	}
 	l.lock.RLock()
 	defer l.lock.RUnlock()
 	if l.closedCond != nil {
 		return fmt.Errorf("%s: driver is closed", driverName)
 	}
 	l.stream <- message
+	l.hec.metrics.addReceived(1)
 	return nil
 }
 
+// tryQueueMessage() is queueMessageAsync() for events which are not worth
+// waiting for, it returns false when the queue is full
+func (l *splunkLogger) tryQueueMessage(message *splunkMessage) bool {
+	applyTransformers(message)
+	l.capFields(message)
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.closedCond != nil {
+		return false
+	}
+	select {
+	case l.stream <- message:
+		l.hec.metrics.addReceived(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// capFields() applies splunk-max-fields to the final fields of the message,
+// keeping room for the latency fields set when it is sent
+func (l *splunkLogger) capFields(message *splunkMessage) {
+	if l.maxFields == 0 {
+		return
+	}
+	limit := l.maxFields
+	if l.hec.addBufferLatency && message.readAt != 0 {
+		limit--
+	}
+	if l.hec.deliveryLatencyField != "" && message.timeNano != 0 {
+		limit--
+	}
+	var dropped int
+	message.Fields, dropped = capFields(message.Fields, limit)
+	if dropped == 0 {
+		return
+	}
+	message.encoded = nil
+	l.hec.metrics.addFieldsDropped(dropped)
+	l.maxFieldsWarning.Do(func() {
+		driverLog.WithField("id", l.containerID).WithField("max", l.maxFields).WithField("dropped", dropped).Warn("Too many fields, dropping the extra fields")
+	})
+}
+
+func (l *splunkLogger) containerMetrics() *containerMetrics {
+	return l.hec.metrics
+}
+
+// rules() returns the routing rules of the logger
+func (l *splunkLogger) rules() []*routingRule {
+	rules, _ := l.routingRules.Load().([]*routingRule)
+	return rules
+}
+
+// setRoutingRules() replaces the routing rules of a running logger
+func (l *splunkLogger) setRoutingRules(rules []*routingRule) {
+	l.routingRules.Store(rules)
+}
+
+// queueDepth() returns the number of messages waiting to be sent
+func (l *splunkLogger) queueDepth() int {
+	return len(l.stream) + int(atomic.LoadInt64(&l.buffered))
+}
+
 /*
 main function that handles the log stream processing
 Do a HEC POST when
@@ -495,32 +1603,108 @@ Do a HEC POST when
 */
 func (l *splunkLogger) worker() {
 	var messages []*splunkMessage
+	// when the oldest buffered message was added, or the last post of the
+	// buffer failed
+	var oldest time.Time
+	// all posts the messages held by splunk-preserve-order as well
+	post := func(all bool) {
+		ready, held := l.orderMessages(messages, all, time.Now())
+		messages = append(l.hec.postMessages(ready, false), held...)
+		oldest = time.Now()
+	}
 	for {
 		timer := time.NewTicker(l.hec.postMessagesFrequency)
+		// the idle timer restarts with every message, like the post frequency ticker
+		var idle <-chan time.Time
+		if l.flushOnIdle > 0 && len(messages) > 0 {
+			idle = time.After(l.flushOnIdle)
+		}
+		var expired <-chan time.Time
+		if l.maxEventAge > 0 && len(messages) > 0 {
+			expired = time.After(time.Until(oldest.Add(l.maxEventAge)))
+		}
 		select {
 		case message, open := <-l.stream:
 			// if the stream channel is closed, post the remaining messages in the buffer
 			if !open {
-				logrus.Debugf("stream is closed with %d events", len(messages))
+				senderLog.WithField("id", l.containerID).WithField("count", len(messages)).Debug("Stream is closed")
+				messages, _ = l.orderMessages(messages, true, time.Now())
 				l.hec.postMessages(messages, true)
+				for i, rule := range l.rules() {
+					senderLog.WithField("id", l.containerID).WithField("rule", i).WithField("matched", atomic.LoadUint64(&rule.matched)).Debug("Routing rule statistics")
+				}
+				metrics.unregister(l.hec.metrics)
+				health.unregister(l.hec)
+				l.hec.socket.close()
+				l.hec.elasticsearch.close()
+				l.hec.otlp.close()
+				l.labels.close()
 				l.lock.Lock()
 				defer l.lock.Unlock()
-				l.hec.transport.CloseIdleConnections()
+				if l.hec.transport != nil {
+					l.hec.transport.CloseIdleConnections()
+				}
 				l.closed = true
 				l.closedCond.Signal()
 				return
 			}
+			if len(messages) == 0 {
+				oldest = time.Now()
+			}
 			messages = append(messages, message)
 			// Only sending when we get exactly to the batch size,
 			// This also helps not to fire postMessages on every new message,
 			// when previous try failed.
 			if len(messages)%l.hec.postMessagesBatchSize == 0 {
-				messages = l.hec.postMessages(messages, false)
+				post(false)
+			} else if l.maxEventAge > 0 && time.Since(oldest) >= l.maxEventAge {
+				senderLog.WithField("id", l.containerID).WithField("count", len(messages)).Debug("Messages reached their maximum age")
+				post(false)
 			}
 		case <-timer.C:
-			logrus.Debugf("messages buffer timeout, sending %d events", len(messages))
-			messages = l.hec.postMessages(messages, false)
+			senderLog.WithField("id", l.containerID).WithField("count", len(messages)).Debug("Messages buffer timeout")
+			post(false)
+		case <-idle:
+			senderLog.WithField("id", l.containerID).WithField("count", len(messages)).Debug("Stream is idle, flushing messages")
+			post(false)
+		case <-expired:
+			senderLog.WithField("id", l.containerID).WithField("count", len(messages)).Debug("Messages reached their maximum age")
+			post(false)
+		case flushed := <-l.flushes:
+			// the messages still in the stream are queued after the flush
+			senderLog.WithField("id", l.containerID).WithField("count", len(messages)).Debug("Flush requested")
+			post(true)
+			close(flushed)
 		}
+		atomic.StoreInt64(&l.buffered, int64(len(messages)))
+	}
+}
+
+// flush() waits up to timeout for the worker to post the messages it
+// buffers. It returns false on timeout.
+func (l *splunkLogger) flush(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	flushed := make(chan struct{})
+
+	l.lock.RLock()
+	if l.closedCond != nil {
+		// closing posts everything anyway
+		l.lock.RUnlock()
+		return true
+	}
+	select {
+	case l.flushes <- flushed:
+		l.lock.RUnlock()
+	case <-timer.C:
+		l.lock.RUnlock()
+		return false
+	}
+	select {
+	case <-flushed:
+		return true
+	case <-timer.C:
+		return false
 	}
 }
 
@@ -544,5 +1728,65 @@ func (l *splunkLogger) Name() string {
 func (l *splunkLogger) createSplunkMessage(msg *logger.Message) *splunkMessage {
 	message := *l.nullMessage
 	message.Time = fmt.Sprintf("%f", float64(msg.Timestamp.UnixNano())/float64(time.Second))
+	message.channel = l.channels.channel(msg)
+	if l.hec.addBufferLatency || l.preserveOrder > 0 {
+		message.readAt = time.Now().UnixNano()
+	}
+	if l.preserveOrder > 0 {
+		message.orderNano = msg.Timestamp.UnixNano()
+	}
+	if original, ok := msg.Attrs[originalTimeField]; ok {
+		setField(&message, originalTimeField, original)
+		message.timeCorrected = true
+	}
+	if l.hec.deliveryLatencyField != "" && msg.Source != "" {
+		message.timeNano = msg.Timestamp.UnixNano()
+	}
+	if l.eventID {
+		seq := atomic.AddUint64(&l.seq, 1)
+		message.Fields = make(map[string]string, len(l.nullMessage.Fields)+2)
+		for key, value := range l.nullMessage.Fields {
+			message.Fields[key] = value
+		}
+		message.Fields["event_id"] = computeEventID(l.containerID, msg.Timestamp.UnixNano(), seq)
+		message.Fields["seq"] = strconv.FormatUint(seq, 10)
+	}
+	// the per-stream number replaces the one of the event id
+	l.addSequence(&message, msg.Source)
+	if msg.Partial {
+		// the reassembly was flushed before its last fragment arrived
+		setField(&message, partialIncompleteField, "true")
+	}
+	l.addAccessLogFields(&message, msg.Line)
+	if rules := l.rules(); len(rules) > 0 && routeMessage(rules, &message, msg.Line) {
+		l.hec.metrics.addRouted(1)
+	}
+	// an index set by a routing rule takes precedence
+	if index, ok := l.indexBySourceType[message.SourceType]; ok && message.Index == l.nullMessage.Index {
+		message.Index = index
+	}
 	return &message
 }
+
+// setField() sets an indexed field on a copy of the message fields, which
+// may be shared with the null message
+func setField(message *splunkMessage, key string, value string) {
+	fields := make(map[string]string, len(message.Fields)+1)
+	for k, v := range message.Fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	message.Fields = fields
+}
+
+// computeEventID() returns a compact hash of the container id, the message
+// timestamp and the per-container sequence number
+func computeEventID(containerID string, timeNano int64, seq uint64) string {
+	h := fnv.New64a()
+	h.Write([]byte(containerID))
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(timeNano))
+	binary.BigEndian.PutUint64(b[8:], seq)
+	h.Write(b[:])
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	// indexed field by which the events of a container without local json
+	// log are searched
+	searchContainerIDField = "container_id"
+	// search export endpoint of the Splunk management port
+	searchExportPath = "/services/search/jobs/export"
+	// longest line of the search export, an event with its metadata
+	searchMaxLineSize = 16 * 1024 * 1024
+)
+
+var searchTLSOptions = tlsOptions{
+	caPath:             splunkSearchCAPathKey,
+	caName:             splunkSearchCANameKey,
+	insecureSkipVerify: splunkSearchInsecureSkipVerifyKey,
+}
+
+// parseLocalJSON() returns whether the lines of a forwarded container are
+// written to a local json log
+func parseLocalJSON(config map[string]string) (bool, error) {
+	localJSONStr, ok := config[splunkLocalJSONKey]
+	if !ok {
+		return true, nil
+	}
+	return strconv.ParseBool(localJSONStr)
+}
+
+// searchesContainer() returns whether docker logs of the container searches
+// its events in Splunk, they are then sent with the container ID
+func searchesContainer(config map[string]string) bool {
+	localJSON, err := parseLocalJSON(config)
+	return err == nil && !localJSON && config[splunkSearchURLKey] != ""
+}
+
+func parseSearchURL(value string) (string, error) {
+	searchURL, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to parse %s as url value in %s", driverName, value, splunkSearchURLKey)
+	}
+	if searchURL.Scheme != "http" && searchURL.Scheme != "https" {
+		return "", fmt.Errorf("%s: unsupported scheme %s in %s, expected http or https", driverName, searchURL.Scheme, splunkSearchURLKey)
+	}
+	if searchURL.User != nil {
+		return "", fmt.Errorf("%s: %s must not contain credentials, the token is set with %s", driverName, splunkSearchURLKey, splunkSearchTokenKey)
+	}
+	if searchURL.Host == "" || searchURL.RawQuery != "" || searchURL.Fragment != "" {
+		return "", fmt.Errorf("%s: expected format scheme://dns_name_or_ip:port for %s", driverName, splunkSearchURLKey)
+	}
+	return strings.TrimSuffix(searchURL.String(), "/"), nil
+}
+
+// splunkSearchReader serves docker logs for a container without local json
+// log, by searching its events in Splunk. It logs nothing itself.
+type splunkSearchReader struct {
+	containerID string
+	url         string
+	token       string
+	client      *http.Client
+}
+
+// newSplunkSearchReader() returns nil when splunk-search-url is not set
+func newSplunkSearchReader(info logger.Info) (*splunkSearchReader, error) {
+	endpoint, ok := info.Config[splunkSearchURLKey]
+	if !ok {
+		return nil, nil
+	}
+	searchURL, err := parseSearchURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(info.Config, searchTLSOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &splunkSearchReader{
+		containerID: info.ContainerID,
+		url:         searchURL + searchExportPath,
+		token:       info.Config[splunkSearchTokenKey],
+		client:      &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+func (r *splunkSearchReader) Log(msg *logger.Message) error {
+	logger.PutMessage(msg)
+	return nil
+}
+
+func (r *splunkSearchReader) Name() string {
+	return driverName
+}
+
+func (r *splunkSearchReader) Close() error {
+	return nil
+}
+
+// query() returns the search of the events of the container, oldest first
+func (r *splunkSearchReader) query(config logger.ReadConfig) string {
+	query := fmt.Sprintf("search index=* %s::%s", searchContainerIDField, strconv.Quote(r.containerID))
+	if config.Tail > 0 {
+		// the events are searched newest first
+		query += " | head " + strconv.Itoa(config.Tail)
+	}
+	return query + " | reverse | fields _time, _raw"
+}
+
+// ReadLogs() sends the events of the container found in Splunk. Following
+// the logs is not supported, the watcher is closed after the last event.
+func (r *splunkSearchReader) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	watcher := logger.NewLogWatcher()
+	if config.Follow {
+		driverLog.WithField("id", r.containerID).Debug("Following logs is not supported without local json log")
+	}
+	go func() {
+		defer close(watcher.Msg)
+		if err := r.search(config, watcher); err != nil {
+			watcher.Err <- err
+		}
+	}()
+	return watcher
+}
+
+// searchResult is a line of the search export in JSON
+type searchResult struct {
+	Preview bool `json:"preview"`
+	Result  *struct {
+		Time string `json:"_time"`
+		Raw  string `json:"_raw"`
+	} `json:"result"`
+}
+
+func (r *splunkSearchReader) search(config logger.ReadConfig, watcher *logger.LogWatcher) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-watcher.WatchClose():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	form := url.Values{
+		"search":      {r.query(config)},
+		"output_mode": {"json"},
+	}
+	if !config.Since.IsZero() {
+		form.Set("earliest_time", strconv.FormatInt(config.Since.Unix(), 10))
+	}
+	req, err := http.NewRequest(http.MethodPost, r.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	res, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, hecErrorBodyLimit))
+		return fmt.Errorf("%s: failed to search the logs in Splunk - %s - %s", driverName, res.Status, body)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(nil, searchMaxLineSize)
+	for scanner.Scan() {
+		var result searchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return err
+		}
+		if result.Preview || result.Result == nil {
+			continue
+		}
+		msg := searchMessage(result.Result.Raw, result.Result.Time)
+		select {
+		case watcher.Msg <- msg:
+		case <-watcher.WatchClose():
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// searchMessage() returns the line of a searched event, from the line and
+// source of the inline and json formats, or the whole event for the raw
+// format. The line ends with a newline, as in a local json log.
+func searchMessage(raw string, timestamp string) *logger.Message {
+	msg := logger.NewMessage()
+	msg.Timestamp, _ = time.Parse(time.RFC3339Nano, timestamp)
+	var event struct {
+		Line   json.RawMessage `json:"line"`
+		Source string          `json:"source"`
+	}
+	line := []byte(raw)
+	if err := json.Unmarshal(line, &event); err == nil && event.Line != nil {
+		var str string
+		if json.Unmarshal(event.Line, &str) == nil {
+			line = []byte(str)
+		} else {
+			line = event.Line
+		}
+		msg.Source = event.Source
+	}
+	msg.Line = append(line, '\n')
+	return msg
+}
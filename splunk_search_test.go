@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	protoio "github.com/gogo/protobuf/io"
+)
+
+func TestReadLogsFromSplunkSearch(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	var searched, auth string
+	search := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != searchExportPath {
+			http.NotFound(w, r)
+			return
+		}
+		searched, auth = r.FormValue("search"), r.Header.Get("Authorization")
+		fmt.Fprintln(w, `{"preview":true,"result":{"_time":"2018-01-01T00:00:00.000+00:00","_raw":"preview"}}`)
+		fmt.Fprintln(w, `{"preview":false,"result":{"_time":"2018-01-01T00:00:01.000+00:00","_raw":"{\"line\":\"first\",\"source\":\"stdout\"}"}}`)
+		fmt.Fprintln(w, `{"preview":false,"result":{"_time":"2018-01-01T00:00:02.000+00:00","_raw":"{\"line\":{\"a\":1},\"source\":\"stderr\"}"}}`)
+		fmt.Fprintln(w, `{"preview":false,"result":{"_time":"2018-01-01T00:00:03.000+00:00","_raw":"raw line"}}`)
+	}))
+	defer search.Close()
+
+	dir, err := ioutil.TempDir("", "splunk-driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:         hec.URL(),
+			splunkTokenKey:       hec.token,
+			splunkLocalJSONKey:   "false",
+			splunkSearchURLKey:   search.URL,
+			splunkSearchTokenKey: "searchtoken",
+		},
+		ContainerID: "containeriid",
+	}
+	d := newDriver()
+	file := startTestLogging(t, d, dir, info)
+	defer d.StopLogging(file)
+
+	if _, err := os.Stat(filepath.Join(dir, info.ContainerID+".json")); !os.IsNotExist(err) {
+		t.Fatalf("Expected no local json log, got %v", err)
+	}
+
+	r, err := d.ReadLogs(info, logger.ReadConfig{Tail: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	dec := protoio.NewUint32DelimitedReader(r, binary.BigEndian, 1e6)
+	var lines []string
+	for {
+		var entry logdriver.LogEntry
+		if err := dec.ReadMsg(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("%d %s %q", entry.TimeNano/1e9, entry.Source, entry.Line))
+	}
+	expected := []string{
+		`1514764801 stdout "first\n"`,
+		`1514764802 stderr "{\"a\":1}\n"`,
+		`1514764803  "raw line\n"`,
+	}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the lines %q, got %q", expected, lines)
+	}
+	if searched != `search index=* container_id::"containeriid" | head 3 | reverse | fields _time, _raw` {
+		t.Fatalf("Unexpected search %q", searched)
+	}
+	if auth != "Bearer searchtoken" {
+		t.Fatalf("Unexpected authorization %q", auth)
+	}
+}
+
+func TestSearchContainerIDField(t *testing.T) {
+	for _, test := range []struct {
+		config   map[string]string
+		expected string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{splunkLocalJSONKey: "false"}, ""},
+		{map[string]string{splunkLocalJSONKey: "false", splunkSearchURLKey: "https://splunk.example.com:8089"}, "containeriid"},
+	} {
+		stub := &stubTransport{}
+		test.config[splunkURLKey] = "https://splunk.example.com:8088"
+		test.config[splunkTokenKey] = "00000000-0000-0000-0000-000000000000"
+		l, err := NewWithClient(logger.Info{Config: test.config, ContainerID: "containeriid"}, &http.Client{Transport: stub})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Log(&logger.Message{Line: []byte("message"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stub.mu.Lock()
+		if len(stub.messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(stub.messages))
+		}
+		containerID := stub.messages[0].Fields[searchContainerIDField]
+		stub.mu.Unlock()
+		if containerID != test.expected {
+			t.Fatalf("Expected the %s field %q with %v, got %q", searchContainerIDField, test.expected, test.config, containerID)
+		}
+	}
+}
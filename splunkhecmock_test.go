@@ -24,7 +24,11 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func (message *splunkMessage) EventAsString() (string, error) {
@@ -52,8 +56,10 @@ type HTTPEventCollectorMock struct {
 
 	connectionVerified bool
 	gzipEnabled        *bool
+	zstdEnabled        bool
 	messages           []*splunkMessage
 	numOfRequests      int
+	channels           []string
 }
 
 func NewHTTPEventCollectorMock(t *testing.T) *HTTPEventCollectorMock {
@@ -115,6 +121,8 @@ func (hec *HTTPEventCollectorMock) ServeHTTP(writer http.ResponseWriter, request
 			hec.test.Error("Authorization header is invalid.")
 		}
 
+		hec.channels = append(hec.channels, request.Header.Get("X-Splunk-Request-Channel"))
+
 		gzipEnabled := false
 		if contentEncoding, ok := request.Header["Content-Encoding"]; ok && contentEncoding[0] == "gzip" {
 			gzipEnabled = true
@@ -135,6 +143,14 @@ func (hec *HTTPEventCollectorMock) ServeHTTP(writer http.ResponseWriter, request
 				hec.test.Fatal(err)
 			}
 			reader = gzipReader
+		} else if request.Header.Get("Content-Encoding") == "zstd" {
+			hec.zstdEnabled = true
+			zstdReader, err := zstd.NewReader(request.Body)
+			if err != nil {
+				hec.test.Fatal(err)
+			}
+			defer zstdReader.Close()
+			reader = zstdReader
 		} else {
 			reader = request.Body
 		}
@@ -170,4 +186,40 @@ func (hec *HTTPEventCollectorMock) ServeHTTP(writer http.ResponseWriter, request
 		hec.test.Errorf("Unexpected HTTP method %s", http.MethodOptions)
 		writer.WriteHeader(http.StatusBadRequest)
 	}
-}
\ No newline at end of file
+}
+
+// stubTransport answers every request like HEC without a server and keeps
+// the requests and events it received, for loggers created with
+// NewWithClient
+type stubTransport struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	messages []*splunkMessage
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var messages []*splunkMessage
+	if req.Body != nil {
+		defer req.Body.Close()
+		for dec := json.NewDecoder(req.Body); ; {
+			var message splunkMessage
+			if err := dec.Decode(&message); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			messages = append(messages, &message)
+		}
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.messages = append(s.messages, messages...)
+	s.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(`{"text":"Success","code":0}`)),
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// stateDumpDir is where SIGUSR1 writes the state dumps
+var stateDumpDir = selfLogDir
+
+// stateDump is a point-in-time snapshot of the plugin, written on SIGUSR1
+// to debug a wedged plugin without restarting it
+type stateDump struct {
+	Time             time.Time         `json:"time"`
+	LogLevel         string            `json:"log_level"`
+	ForwardingPaused bool              `json:"forwarding_paused"`
+	Containers       []containerState  `json:"containers"`
+	Endpoints        []endpointState   `json:"endpoints"`
+	Health           healthReport      `json:"health"`
+	Counters         map[string]uint64 `json:"counters"`
+}
+
+// dumpState() writes the state of the plugin followed by the stacks of
+// every goroutine to a new file of dir, and returns its path. The driver
+// lock is only held while the loggers are listed. Secrets and the
+// splunk-enrich-redact patterns are redacted.
+func (d *driver) dumpState(dir string) (string, error) {
+	now := time.Now()
+	dump := stateDump{
+		Time:             now,
+		LogLevel:         logLevel.level().String(),
+		ForwardingPaused: forwarding.paused(),
+		Containers:       d.containerStates(),
+		Endpoints:        health.endpointStates(),
+		Health:           health.report(),
+		Counters: map[string]uint64{
+			"events_in":                atomic.LoadUint64(&metrics.totals.received),
+			"events_out":               atomic.LoadUint64(&metrics.totals.sent),
+			"bytes_sent":               atomic.LoadUint64(&metrics.totals.bytesSent),
+			"dropped":                  atomic.LoadUint64(&metrics.totals.dropped),
+			"retried":                  atomic.LoadUint64(&metrics.totals.retried),
+			"routed":                   atomic.LoadUint64(&metrics.totals.routed),
+			"processor_panics":         atomic.LoadUint64(&metrics.processorPanics),
+			"fields_dropped":           atomic.LoadUint64(&metrics.fieldsDropped),
+			"journald_failures":        atomic.LoadUint64(&metrics.journaldFailures),
+			"syslog_failures":          atomic.LoadUint64(&metrics.syslogFailures),
+			"metric_lines_malformed":   atomic.LoadUint64(&metrics.metricLinesMalformed),
+			"delivery_latency_clamped": atomic.LoadUint64(&metrics.deliveryLatencyClamped),
+			"timestamps_corrected":     atomic.LoadUint64(&metrics.timestampsCorrected),
+			"forwarding_degraded":      atomic.LoadUint64(&metrics.forwardingDegraded),
+			"forwarding_recovered":     atomic.LoadUint64(&metrics.forwardingRecovered),
+			"cache_degraded":           atomic.LoadUint64(&metrics.cacheDegraded),
+			"events_backfilled":        atomic.LoadUint64(&metrics.eventsBackfilled),
+			"bandwidth_dropped":        atomic.LoadUint64(&metrics.bandwidthDropped),
+		},
+	}
+	for i := range dump.Containers {
+		state := &dump.Containers[i]
+		if _, ok := state.Options[splunkEnrichRedactKey]; ok {
+			state.Options[splunkEnrichRedactKey] = "<redacted>"
+		}
+		if option, ok := state.EffectiveOptions[splunkEnrichRedactKey]; ok {
+			// shared with the logger, replaced by a copy
+			effective := make(map[string]resolvedOption, len(state.EffectiveOptions))
+			for key, value := range state.EffectiveOptions {
+				effective[key] = value
+			}
+			effective[splunkEnrichRedactKey] = resolvedOption{"<redacted>", option.Source}
+			state.EffectiveOptions = effective
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("splunk-logging-plugin-dump-%s.txt", now.UTC().Format("20060102T150405.000000000Z")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&dump); err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString("\ngoroutine stacks:\n\n"); err != nil {
+		return "", err
+	}
+	if err := pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
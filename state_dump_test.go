@@ -0,0 +1,83 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestDumpState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const token = "00000000-0000-0000-0000-000000001234"
+	d := newDriver()
+	config := map[string]string{
+		splunkURLKey:          "https://splunk.example.com:8088",
+		splunkTokenKey:        token,
+		splunkEnrichRedactKey: "secret_field",
+	}
+	d.logs["/run/docker/logging/fifo"] = &logPair{
+		info:    logger.Info{ContainerID: "containeriid", Config: config},
+		options: resolveOptions(config, nil),
+	}
+
+	path, err := d.dumpState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != dir {
+		t.Fatalf("Expected the dump in %s, got %s", dir, path)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump stateDump
+	if err := json.NewDecoder(bytes.NewReader(content)).Decode(&dump); err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Containers) != 1 || dump.Containers[0].File != "/run/docker/logging/fifo" || dump.Containers[0].ID != "containeriid" {
+		t.Fatalf("Expected the container in the dump, got %+v", dump.Containers)
+	}
+	if _, ok := dump.Counters["events_in"]; !ok {
+		t.Fatalf("Expected the counters in the dump, got %v", dump.Counters)
+	}
+	if !strings.Contains(string(content), "goroutine stacks:") || !strings.Contains(string(content), "dumpState") {
+		t.Fatal("Expected the goroutine stacks in the dump")
+	}
+	for _, secret := range []string{token, "secret_field"} {
+		if strings.Contains(string(content), secret) {
+			t.Fatalf("Expected %s to be redacted from the dump", secret)
+		}
+	}
+	// the logger keeps its options
+	if d.logs["/run/docker/logging/fifo"].options[splunkEnrichRedactKey].Value != "secret_field" {
+		t.Fatal("Expected the options of the logger to be left unchanged")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Number of containers listed in the statistics line
+const statsTopContainers = 3
+
+// statsReporter periodically logs a digest of the plugin counters. Counters
+// are monotonic, the reporter logs the difference since the previous report.
+type statsReporter struct {
+	metrics        *pluginMetrics
+	last           eventCounters
+	lastBusyPaused uint64
+	lastContainers map[*containerMetrics]uint64
+}
+
+func newStatsReporter(m *pluginMetrics) *statsReporter {
+	return &statsReporter{
+		metrics:        m,
+		lastContainers: make(map[*containerMetrics]uint64),
+	}
+}
+
+func startStatsReporter(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	r := newStatsReporter(metrics)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			driverLog.WithFields(r.report()).Info("Plugin statistics")
+			r.reportDrops(time.Now())
+		}
+	}()
+}
+
+// report() returns the statistics since the previous call
+func (r *statsReporter) report() logrus.Fields {
+	var current eventCounters
+	current.received = atomic.LoadUint64(&r.metrics.totals.received)
+	current.sent = atomic.LoadUint64(&r.metrics.totals.sent)
+	current.dropped = atomic.LoadUint64(&r.metrics.totals.dropped)
+	current.retried = atomic.LoadUint64(&r.metrics.totals.retried)
+	current.bytesSent = atomic.LoadUint64(&r.metrics.totals.bytesSent)
+	current.routed = atomic.LoadUint64(&r.metrics.totals.routed)
+
+	type containerVolume struct {
+		id     string
+		events uint64
+	}
+	containers := r.metrics.snapshot()
+	volumes := make([]containerVolume, 0, len(containers))
+	lastContainers := make(map[*containerMetrics]uint64, len(containers))
+	for _, c := range containers {
+		received := atomic.LoadUint64(&c.received)
+		lastContainers[c] = received
+		volumes = append(volumes, containerVolume{c.id, received - r.lastContainers[c]})
+	}
+	sort.SliceStable(volumes, func(i, j int) bool {
+		return volumes[i].events > volumes[j].events
+	})
+	var top []string
+	for i := 0; i < len(volumes) && i < statsTopContainers && volumes[i].events > 0; i++ {
+		top = append(top, fmt.Sprintf("%s=%d", volumes[i].id, volumes[i].events))
+	}
+
+	fields := logrus.Fields{
+		"events_in":         current.received - r.last.received,
+		"events_out":        current.sent - r.last.sent,
+		"bytes_sent":        current.bytesSent - r.last.bytesSent,
+		"dropped":           current.dropped - r.last.dropped,
+		"retried":           current.retried - r.last.retried,
+		"routed":            current.routed - r.last.routed,
+		"open_loggers":      len(containers),
+		"top_containers":    strings.Join(top, ","),
+		"log_level":         logLevel.level().String(),
+		"forwarding_paused": forwarding.paused(),
+		"paused_containers": atomic.LoadInt64(&pausedContainers),
+		"cache_degraded":    atomic.LoadInt64(&cacheDegradedContainers),
+	}
+	latency := r.metrics.requestLatency.summarize()
+	for name, value := range map[string]float64{"p50": latency.p50, "p95": latency.p95, "p99": latency.p99, "max": latency.max} {
+		fields["hec_latency_"+name] = time.Duration(value * float64(time.Second)).Round(time.Millisecond).String()
+	}
+	retries := r.metrics.batchRetries.summarize()
+	for name, value := range map[string]float64{"p50": retries.p50, "p95": retries.p95, "p99": retries.p99, "max": retries.max} {
+		fields["batch_retries_"+name] = value
+	}
+	busyPaused := atomic.LoadUint64(&r.metrics.busyPausedNanos)
+	fields["busy_paused_seconds"] = time.Duration(busyPaused - r.lastBusyPaused).Seconds()
+	r.lastBusyPaused = busyPaused
+	r.last = current
+	r.lastContainers = lastContainers
+	return fields
+}
+
+// startHeartbeats() checks every heartbeatCheckInterval which containers are
+// due to send a heartbeat
+func startHeartbeats() {
+	r := newStatsReporter(metrics)
+	go func() {
+		ticker := time.NewTicker(heartbeatCheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			r.sendHeartbeats(now)
+		}
+	}()
+}
+
+func (r *statsReporter) sendHeartbeats(now time.Time) {
+	for _, c := range r.metrics.snapshot() {
+		if c.heartbeat != nil {
+			c.heartbeat(now)
+		}
+	}
+}
+
+// reportDrops() lets every container send its dropped events summary. They
+// are sent in the background, so an unreachable HEC does not hold the
+// statistics of the next intervals.
+func (r *statsReporter) reportDrops(now time.Time) {
+	for _, c := range r.metrics.snapshot() {
+		if c.reportDrops != nil {
+			go c.reportDrops(now)
+		}
+	}
+}
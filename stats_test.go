@@ -0,0 +1,102 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsReporter(t *testing.T) {
+	m := newPluginMetrics()
+	c1 := m.register("container1", func() int { return 0 })
+	c2 := m.register("container2", func() int { return 0 })
+	c3 := m.register("container3", func() int { return 0 })
+	c4 := m.register("container4", func() int { return 0 })
+	c1.addReceived(10)
+	c2.addReceived(40)
+	c3.addReceived(20)
+	c4.addReceived(5)
+	c1.addSent(10, 1000)
+	c2.addDropped(dropReasonRetryExhausted, 3)
+	c2.addRetried(2)
+	m.requestLatency.observe(0.02)
+	m.requestLatency.observe(0.3)
+	m.batchRetries.observe(0)
+	m.batchRetries.observe(3)
+	m.busyPausedNanos = uint64(1500 * time.Millisecond)
+
+	r := newStatsReporter(m)
+	fields := r.report()
+	if fields["events_in"] != uint64(75) ||
+		fields["events_out"] != uint64(10) ||
+		fields["bytes_sent"] != uint64(1000) ||
+		fields["dropped"] != uint64(3) ||
+		fields["retried"] != uint64(2) ||
+		fields["open_loggers"] != 4 ||
+		fields["top_containers"] != "container2=40,container3=20,container1=10" {
+		t.Fatalf("Unexpected statistics %v", fields)
+	}
+	if fields["hec_latency_max"] != "300ms" || fields["batch_retries_max"] != float64(3) || fields["batch_retries_p50"] != float64(0) ||
+		fields["busy_paused_seconds"] != 1.5 {
+		t.Fatalf("Unexpected latency and retries statistics %v", fields)
+	}
+
+	// second report only contains what happened since the first one
+	c4.addReceived(7)
+	c1.addReceived(1)
+	m.unregister(c3)
+	fields = r.report()
+	if fields["events_in"] != uint64(8) ||
+		fields["events_out"] != uint64(0) ||
+		fields["dropped"] != uint64(0) ||
+		fields["open_loggers"] != 3 ||
+		fields["top_containers"] != "container4=7,container1=1" ||
+		fields["hec_latency_p99"] != "0s" {
+		t.Fatalf("Unexpected statistics %v", fields)
+	}
+}
+
+func TestStatsReporterDropsNotBlocking(t *testing.T) {
+	m := newPluginMetrics()
+	c := m.register("container1", func() int { return 0 })
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	c.reportDrops = func(now time.Time) {
+		started <- struct{}{}
+		// HEC does not answer
+		<-release
+	}
+
+	r := newStatsReporter(m)
+	done := make(chan struct{})
+	go func() {
+		r.reportDrops(time.Now())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the drop summaries not to block the stats reporter")
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the drop summary to be sent")
+	}
+}
@@ -0,0 +1,395 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/loggerutils"
+)
+
+const (
+	// Messages queued for the syslog server of a container
+	syslogQueueSize = 1000
+	// How long connecting and writing to the syslog server can take
+	syslogTimeout = 10 * time.Second
+	// Longest wait before connecting again to a syslog server which is down
+	syslogMaxBackoff = 30 * time.Second
+	// Structured data ID of the container metadata, 32473 is the enterprise
+	// number of RFC 5612 for documentation as the plugin has none registered
+	syslogSDID = "docker@32473"
+	// Facility of the messages by default, like the syslog log driver of Docker
+	defaultSyslogFacility = "daemon"
+)
+
+// syslogFacilities are the facility codes of RFC 5424 by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var (
+	errSyslogQueueFull = errors.New("syslog queue is full")
+	errSyslogBackoff   = errors.New("syslog server is down, waiting before connecting again")
+)
+
+var syslogTLSOptions = tlsOptions{
+	caPath:             splunkSyslogCAPathKey,
+	caName:             splunkSyslogCANameKey,
+	insecureSkipVerify: splunkSyslogInsecureSkipVerifyKey,
+	clientCert:         splunkSyslogCertKey,
+	clientKey:          splunkSyslogKeyKey,
+}
+
+// syslogMatch selects the events copied to syslog with the match syntax of
+// the routing rules
+type syslogMatch struct {
+	routingMatch
+
+	regex *regexp.Regexp
+}
+
+// syslogConfig is the syslog server of a container and how its messages
+// are formatted
+type syslogConfig struct {
+	network  string
+	address  string
+	tls      *tls.Config
+	facility int
+	appName  string
+	matches  []*syslogMatch
+}
+
+// parseSyslogURL() returns the network and the address of a syslog URL,
+// udp://, tcp:// or tls://. The port defaults to 514, or 6514 for TLS.
+func parseSyslogURL(value string) (string, string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: failed to parse %s: %v", driverName, splunkSyslogURLKey, err)
+	}
+	port := "514"
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		port = "6514"
+	default:
+		return "", "", fmt.Errorf("%s: %s must be a udp://, tcp:// or tls:// URL, got %q", driverName, splunkSyslogURLKey, value)
+	}
+	if u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return "", "", fmt.Errorf("%s: %s must be <scheme>://<host>[:<port>], got %q", driverName, splunkSyslogURLKey, value)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+func parseSyslogFacility(value string) (int, error) {
+	facility, ok := syslogFacilities[value]
+	if !ok {
+		return 0, fmt.Errorf("%s: unknown %s %q", driverName, splunkSyslogFacilityKey, value)
+	}
+	return facility, nil
+}
+
+// parseSyslogMatches() validates and compiles the matches of
+// splunk-syslog-match, a JSON list of routing rule matches
+func parseSyslogMatches(value string) ([]*syslogMatch, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var matches []*syslogMatch
+	if err := json.Unmarshal([]byte(value), &matches); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse %s: %v", driverName, splunkSyslogMatchKey, err)
+	}
+	for i, match := range matches {
+		if match == nil || (match.Regex == "") == (match.Field == "") {
+			return nil, fmt.Errorf("%s: match %d of %s must match either a regex or a field", driverName, i, splunkSyslogMatchKey)
+		}
+		if match.Regex != "" {
+			regex, err := regexp.Compile(match.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s: match %d of %s has invalid regex: %v", driverName, i, splunkSyslogMatchKey, err)
+			}
+			match.regex = regex
+		}
+	}
+	return matches, nil
+}
+
+// parseSyslogConfig() returns the syslog server of the container, nil
+// without splunk-syslog-url
+func parseSyslogConfig(info logger.Info) (*syslogConfig, error) {
+	syslogURL, ok := info.Config[splunkSyslogURLKey]
+	if !ok {
+		return nil, nil
+	}
+	network, address, err := parseSyslogURL(syslogURL)
+	if err != nil {
+		return nil, err
+	}
+	config := &syslogConfig{network: network, address: address}
+	if network == "tls" {
+		if config.tls, err = newTLSConfig(info.Config, syslogTLSOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	facility := defaultSyslogFacility
+	if facilityStr, ok := info.Config[splunkSyslogFacilityKey]; ok {
+		facility = facilityStr
+	}
+	if config.facility, err = parseSyslogFacility(facility); err != nil {
+		return nil, err
+	}
+
+	// ParseLogTag reads the template from the tag option
+	tagInfo := info
+	tagInfo.Config = map[string]string{tagKey: info.Config[splunkSyslogTagKey]}
+	appName, err := loggerutils.ParseLogTag(tagInfo, loggerutils.DefaultTemplate)
+	if err != nil {
+		return nil, err
+	}
+	config.appName = syslogHeaderField(appName, 48)
+
+	if config.matches, err = parseSyslogMatches(info.Config[splunkSyslogMatchKey]); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// syslogLogger copies the messages of a container, or those matching
+// splunk-syslog-match, to a syslog server as RFC 5424 messages. Like the
+// journal copy it never blocks nor fails the container: the messages are
+// queued and sent by its own goroutine, and the messages which cannot be
+// queued or sent are only counted.
+type syslogLogger struct {
+	config   *syslogConfig
+	info     logger.Info
+	hostname string
+	// structured data with the container metadata, the same for every message
+	data string
+
+	queue chan []byte
+	done  chan struct{}
+
+	// guards the queue against being closed while a message is queued
+	mu     sync.RWMutex
+	closed bool
+	// an outage is logged once, until a message is sent again
+	reported int32
+
+	// connection to the server, only used by run()
+	conn     net.Conn
+	backoff  time.Duration
+	nextDial time.Time
+}
+
+func newSyslogLogger(config *syslogConfig, info logger.Info) *syslogLogger {
+	hostname, _ := info.Hostname()
+	s := &syslogLogger{
+		config:   config,
+		info:     info,
+		hostname: syslogHeaderField(hostname, 255),
+		data: fmt.Sprintf(`[%s container_id="%s" container_name="%s" image_name="%s" image_id="%s"]`, syslogSDID,
+			syslogParamValue(info.ContainerID), syslogParamValue(info.Name()),
+			syslogParamValue(info.ImageName()), syslogParamValue(info.ImageFullID())),
+		queue: make(chan []byte, syslogQueueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Log() queues the syslog message of a matching message, or drops it when
+// the queue is full
+func (s *syslogLogger) Log(msg *logger.Message) error {
+	if !s.matches(msg.Line) {
+		return nil
+	}
+	entry := s.format(msg)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil
+	}
+	select {
+	case s.queue <- entry:
+	default:
+		s.failed(errSyslogQueueFull)
+	}
+	return nil
+}
+
+// matches() returns true when the line matches one of the matches, or when
+// there are none
+func (s *syslogLogger) matches(line []byte) bool {
+	if len(s.config.matches) == 0 {
+		return true
+	}
+	var parsed map[string]interface{}
+	parsedLine := false
+	for _, match := range s.config.matches {
+		if match.regex != nil {
+			if match.regex.Match(line) {
+				return true
+			}
+			continue
+		}
+		if !parsedLine {
+			json.Unmarshal(line, &parsed)
+			parsedLine = true
+		}
+		if fieldEquals(parsed, nil, match.Field, match.Equals) {
+			return true
+		}
+	}
+	return false
+}
+
+// format() returns the RFC 5424 message, with the severity detected from
+// the line
+func (s *syslogLogger) format(msg *logger.Message) []byte {
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	priority := s.config.facility*8 + messageSeverity(msg.Source, msg.Line)
+	header := fmt.Sprintf("<%d>1 %s %s %s - - %s ", priority, timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.config.appName, s.data)
+	return append([]byte(header), msg.Line...)
+}
+
+func (s *syslogLogger) run() {
+	defer close(s.done)
+	for entry := range s.queue {
+		if err := s.send(entry); err != nil {
+			s.failed(err)
+			continue
+		}
+		if atomic.CompareAndSwapInt32(&s.reported, 1, 0) {
+			driverLog.WithField("id", s.info.ContainerID).WithField("address", s.config.address).Info("Copying messages to the syslog server again")
+		}
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// send() writes a message, connecting first when needed. Messages are
+// framed by their length on TCP and TLS. After a failed connection, the
+// messages are dropped until a backoff elapses.
+func (s *syslogLogger) send(entry []byte) error {
+	if s.conn == nil {
+		if time.Now().Before(s.nextDial) {
+			return errSyslogBackoff
+		}
+		conn, err := s.dial()
+		if err != nil {
+			if s.backoff *= 2; s.backoff == 0 {
+				s.backoff = time.Second
+			} else if s.backoff > syslogMaxBackoff {
+				s.backoff = syslogMaxBackoff
+			}
+			s.nextDial = time.Now().Add(s.backoff)
+			return err
+		}
+		s.conn, s.backoff = conn, 0
+	}
+	if s.config.network != "udp" {
+		entry = append([]byte(fmt.Sprintf("%d ", len(entry))), entry...)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(entry); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *syslogLogger) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if s.config.network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.config.address, s.config.tls)
+	}
+	return dialer.Dial(s.config.network, s.config.address)
+}
+
+// failed() counts a message which did not make it to the syslog server
+func (s *syslogLogger) failed(err error) {
+	atomic.AddUint64(&metrics.syslogFailures, 1)
+	if atomic.CompareAndSwapInt32(&s.reported, 0, 1) {
+		driverLog.WithField("id", s.info.ContainerID).WithField("address", s.config.address).WithError(err).Warn("Cannot copy messages to the syslog server, the failures are counted by splunk_logging_syslog_failures_total")
+	}
+}
+
+func (s *syslogLogger) Name() string {
+	return "syslog"
+}
+
+// Close() waits for the queued messages to be sent
+func (s *syslogLogger) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+// syslogHeaderField() makes a header field of RFC 5424 out of value: up to
+// max printable ASCII characters, "-" when empty
+func syslogHeaderField(value string, max int) string {
+	field := []byte(value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	for i, c := range field {
+		if c < 33 || c > 126 {
+			field[i] = '_'
+		}
+	}
+	if len(field) == 0 {
+		return "-"
+	}
+	return string(field)
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogParamValue() escapes a structured data parameter value
+func syslogParamValue(value string) string {
+	return syslogParamEscaper.Replace(value)
+}
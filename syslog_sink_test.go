@@ -0,0 +1,196 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// readSyslogFrame() reads an octet counted message from a syslog stream
+func readSyslogFrame(t *testing.T, conn net.Conn, r *bufio.Reader) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		t.Fatal(err)
+	}
+	return string(frame)
+}
+
+func TestSyslogCopy(t *testing.T) {
+	// the test certificate of httptest, for 127.0.0.1
+	https := httptest.NewTLSServer(http.NotFoundHandler())
+	https.Close()
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: https.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scheme   string
+		listener func() (net.Listener, error)
+		config   map[string]string
+	}{
+		{"tcp", func() (net.Listener, error) { return net.Listen("tcp", "127.0.0.1:0") }, nil},
+		{"tls", func() (net.Listener, error) {
+			return tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: https.TLS.Certificates})
+		}, map[string]string{splunkSyslogCAPathKey: caPath}},
+	}
+	for _, test := range tests {
+		listener, err := test.listener()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		info := logger.Info{
+			Config: map[string]string{
+				splunkSyslogURLKey:      test.scheme + "://" + listener.Addr().String(),
+				splunkSyslogFacilityKey: "auth",
+				splunkSyslogMatchKey:    `[{"regex": "login"}, {"field": "category", "equals": "security"}]`,
+			},
+			ContainerID:        "0123456789abcdef",
+			ContainerName:      "/web",
+			ContainerImageName: `registry.example.com/web:"1"`,
+		}
+		for key, value := range test.config {
+			info.Config[key] = value
+		}
+		config, err := parseSyslogConfig(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		syslogl := newSyslogLogger(config, info)
+		for _, msg := range []*logger.Message{
+			{Line: []byte("ERROR login failed for root"), Source: "stdout", Timestamp: time.Unix(1528000000, 123456000)},
+			{Line: []byte("hello"), Source: "stdout", Timestamp: time.Now()},
+			{Line: []byte(`{"category": "security", "user": "root"}`), Source: "stderr", Timestamp: time.Now()},
+		} {
+			if err := syslogl.Log(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bufio.NewReader(conn)
+		hostname, _ := os.Hostname()
+		// auth is facility 4, errors are severity 3
+		expected := "<35>1 2018-06-03T04:26:40.123456Z " + hostname + ` 0123456789ab - - [docker@32473 container_id="0123456789abcdef" container_name="web" image_name="registry.example.com/web:\"1\"" image_id=""] ERROR login failed for root`
+		if frame := readSyslogFrame(t, conn, r); frame != expected {
+			t.Fatalf("Unexpected syslog message over %s:\n%s\nexpected:\n%s", test.scheme, frame, expected)
+		}
+		// the line without a match is skipped
+		if frame := readSyslogFrame(t, conn, r); !strings.HasPrefix(frame, "<35>1 ") || !strings.HasSuffix(frame, `{"category": "security", "user": "root"}`) {
+			t.Fatalf("Unexpected syslog message over %s: %s", test.scheme, frame)
+		}
+		syslogl.Close()
+		conn.Close()
+	}
+}
+
+func TestSyslogOutage(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing listens anymore
+	listener.Close()
+
+	info := logger.Info{
+		Config:      map[string]string{splunkSyslogURLKey: "tcp://" + listener.Addr().String()},
+		ContainerID: "containeriid",
+	}
+	config, err := parseSyslogConfig(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := atomic.LoadUint64(&metrics.syslogFailures)
+	syslogl := newSyslogLogger(config, info)
+	start := time.Now()
+	for i := 0; i < 2*syslogQueueSize; i++ {
+		if err := syslogl.Log(&logger.Message{Line: []byte("hello"), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected logging not to wait for the syslog server, took %s", elapsed)
+	}
+	syslogl.Close()
+	if atomic.LoadUint64(&metrics.syslogFailures)-failures != 2*syslogQueueSize {
+		t.Fatalf("Expected every message to be counted as failed, got %d", atomic.LoadUint64(&metrics.syslogFailures)-failures)
+	}
+}
+
+func TestParseSyslogConfig(t *testing.T) {
+	tests := []struct {
+		config  map[string]string
+		network string
+		address string
+		valid   bool
+	}{
+		{map[string]string{splunkSyslogURLKey: "udp://syslog.example.com"}, "udp", "syslog.example.com:514", true},
+		{map[string]string{splunkSyslogURLKey: "tls://syslog.example.com"}, "tls", "syslog.example.com:6514", true},
+		{map[string]string{splunkSyslogURLKey: "tcp://10.0.0.1:1514"}, "tcp", "10.0.0.1:1514", true},
+		{map[string]string{splunkSyslogURLKey: "http://syslog.example.com"}, "", "", false},
+		{map[string]string{splunkSyslogURLKey: "tcp://"}, "", "", false},
+		{map[string]string{splunkSyslogURLKey: "udp://syslog.example.com", splunkSyslogFacilityKey: "local9"}, "", "", false},
+		{map[string]string{splunkSyslogURLKey: "udp://syslog.example.com", splunkSyslogMatchKey: `[{"regex": "a", "field": "b"}]`}, "", "", false},
+		{map[string]string{splunkSyslogURLKey: "tls://syslog.example.com", splunkSyslogCertKey: "client.pem"}, "", "", false},
+	}
+	for _, test := range tests {
+		config, err := parseSyslogConfig(logger.Info{Config: test.config, ContainerID: strings.Repeat("a", 64)})
+		if (err == nil) != test.valid {
+			t.Fatalf("Unexpected validity of %v: %v", test.config, err)
+		}
+		if test.valid && (config.network != test.network || config.address != test.address || config.facility != syslogFacilities[defaultSyslogFacility]) {
+			t.Fatalf("Unexpected syslog config for %v: %+v", test.config, config)
+		}
+	}
+	if config, err := parseSyslogConfig(logger.Info{Config: map[string]string{}}); config != nil || err != nil {
+		t.Fatalf("Expected no syslog server without %s, got %+v %v", splunkSyslogURLKey, config, err)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	// How often the telemetry event is sent after the one at startup
+	telemetryInterval = 24 * time.Hour
+	// How long sending the telemetry event can take
+	telemetryTimeout = 5 * time.Second
+	// Index of the telemetry event
+	defaultTelemetryIndex = "_introspection"
+)
+
+// Set at build time with -ldflags "-X main.pluginVersion=..."
+var pluginVersion = "dev"
+
+// telemetryEvent describes the plug-in and counts the features used by the
+// open loggers. It never has container data, and only has the host name
+// when SPLUNK_TELEMETRY_INCLUDE_HOST is enabled.
+type telemetryEvent struct {
+	EventType     string            `json:"event_type"`
+	PluginVersion string            `json:"plugin_version"`
+	GoVersion     string            `json:"go_version"`
+	OS            string            `json:"os"`
+	Arch          string            `json:"arch"`
+	Host          string            `json:"host,omitempty"`
+	Containers    int               `json:"containers"`
+	Features      telemetryFeatures `json:"features"`
+}
+
+type telemetryFeatures struct {
+	Formats          map[string]int `json:"formats"`
+	Compression      map[string]int `json:"compression"`
+	LogSinks         map[string]int `json:"log_sinks"`
+	VerifyConnection int            `json:"verify_connection"`
+	EventID          int            `json:"event_id"`
+}
+
+type telemetry struct {
+	hec         *hecClient
+	index       string
+	includeHost bool
+	driver      *driver
+}
+
+// newTelemetry() returns nil unless SPLUNK_TELEMETRY is enabled and
+// SPLUNK_TELEMETRY_URL is valid
+func newTelemetry(d *driver) *telemetry {
+	if !getAdvancedOptionBool(envVarTelemetry, false) {
+		return nil
+	}
+	splunkURL, err := parseURL(logger.Info{Config: map[string]string{splunkURLKey: os.Getenv(envVarTelemetryURL)}})
+	if err != nil {
+		driverLog.WithError(err).Warn("Telemetry is disabled")
+		return nil
+	}
+	return &telemetry{
+		hec: &hecClient{
+			client:                &http.Client{Timeout: telemetryTimeout},
+			url:                   splunkURL.String(),
+			auth:                  "Splunk " + os.Getenv(envVarTelemetryToken),
+			postMessagesBatchSize: 1,
+		},
+		index:       getAdvancedOptionString(envVarTelemetryIndex, defaultTelemetryIndex),
+		includeHost: getAdvancedOptionBool(envVarTelemetryIncludeHost, false),
+		driver:      d,
+	}
+}
+
+func (t *telemetry) start() {
+	go func() {
+		ticker := time.NewTicker(telemetryInterval)
+		defer ticker.Stop()
+		for {
+			t.send(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// send() posts the telemetry event once, failures are only logged
+func (t *telemetry) send(now time.Time) {
+	message := &splunkMessage{
+		Event: t.event(),
+		Time:  strconv.FormatInt(now.Unix(), 10),
+		Index: t.index,
+	}
+	if err := t.hec.tryPostMessages([]*splunkMessage{message}); err != nil {
+		driverLog.WithError(err).Debug("Failed to send telemetry")
+	}
+}
+
+func (t *telemetry) event() *telemetryEvent {
+	event := &telemetryEvent{
+		EventType:     "plugin_telemetry",
+		PluginVersion: pluginVersion,
+		GoVersion:     runtime.Version(),
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		Features: telemetryFeatures{
+			Formats:     make(map[string]int),
+			Compression: make(map[string]int),
+			LogSinks:    make(map[string]int),
+		},
+	}
+	if t.includeHost {
+		event.Host, _ = os.Hostname()
+	}
+
+	t.driver.mu.Lock()
+	defer t.driver.mu.Unlock()
+	for _, lf := range t.driver.logs {
+		if lf.splunkl == nil {
+			continue
+		}
+		config := lf.info.Config
+		event.Containers++
+		event.Features.Formats[configValue(config, splunkFormatKey, splunkFormatInline)]++
+		event.Features.Compression[compressionName(config)]++
+		event.Features.LogSinks[configValue(config, logSinkKey, "hec")]++
+		if enabled, _ := strconv.ParseBool(config[splunkVerifyConnectionKey]); enabled {
+			event.Features.VerifyConnection++
+		}
+		if enabled, _ := strconv.ParseBool(config[splunkEventIDKey]); enabled {
+			event.Features.EventID++
+		}
+	}
+	return event
+}
+
+func configValue(config map[string]string, key string, defaultValue string) string {
+	if value, ok := config[key]; ok {
+		return value
+	}
+	return defaultValue
+}
+
+// compressionName() resolves splunk-compression and splunk-gzip the way New() does
+func compressionName(config map[string]string) string {
+	if compression, ok := config[splunkCompressionKey]; ok {
+		return compression
+	}
+	if gzip, _ := strconv.ParseBool(config[splunkGzipCompressionKey]); gzip {
+		return splunkCompressionGzip
+	}
+	return splunkCompressionNone
+}
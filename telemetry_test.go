@@ -0,0 +1,115 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestTelemetry(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	os.Setenv(envVarTelemetry, "true")
+	os.Setenv(envVarTelemetryURL, hec.URL())
+	os.Setenv(envVarTelemetryToken, hec.token)
+	defer func() {
+		os.Setenv(envVarTelemetry, "")
+		os.Setenv(envVarTelemetryURL, "")
+		os.Setenv(envVarTelemetryToken, "")
+		os.Setenv(envVarTelemetryIncludeHost, "")
+	}()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:             hec.URL(),
+			splunkTokenKey:           "00000000-0000-0000-0000-000000000000",
+			splunkFormatKey:          splunkFormatJSON,
+			splunkGzipCompressionKey: "true",
+		},
+		ContainerID:   "containeriid",
+		ContainerName: "/container_name",
+	}
+	d := newDriver()
+	d.logs["file"] = &logPair{splunkl: &panickingLogger{}, info: info}
+
+	telemetry := newTelemetry(d)
+	if telemetry == nil {
+		t.Fatal("Expected telemetry to be enabled")
+	}
+	telemetry.send(time.Now())
+
+	os.Setenv(envVarTelemetryIncludeHost, "true")
+	newTelemetry(d).send(time.Now())
+
+	if len(hec.messages) != 2 {
+		t.Fatalf("Expected # of messages %d, got %d", 2, len(hec.messages))
+	}
+	if hec.messages[0].Index != defaultTelemetryIndex {
+		t.Fatalf("Expected index %s, got %s", defaultTelemetryIndex, hec.messages[0].Index)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(hec.messages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{info.ContainerID, "container_name", info.Config[splunkTokenKey], hec.token, hostname} {
+		if strings.Contains(string(payload), secret) {
+			t.Fatalf("Telemetry payload %s contains %s", payload, secret)
+		}
+	}
+
+	event, err := hec.messages[0].EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, _ := event["features"].(map[string]interface{})
+	formats, _ := features["formats"].(map[string]interface{})
+	compression, _ := features["compression"].(map[string]interface{})
+	if event["event_type"] != "plugin_telemetry" ||
+		event["plugin_version"] != pluginVersion ||
+		event["containers"] != float64(1) ||
+		formats[splunkFormatJSON] != float64(1) ||
+		compression[splunkCompressionGzip] != float64(1) {
+		t.Fatalf("Unexpected telemetry event %v", event)
+	}
+
+	event, err = hec.messages[1].EventAsMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["host"] != hostname {
+		t.Fatalf("Expected the host name when explicitly allowed, got %v", event)
+	}
+}
+
+func TestTelemetryDisabled(t *testing.T) {
+	if newTelemetry(newDriver()) != nil {
+		t.Fatal("Expected telemetry to be disabled by default")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+// Indexed field with the timestamp given by the runtime to an event whose
+// timestamp was corrected, in seconds like the time of the event
+const originalTimeField = "original_time"
+
+// Policies of splunk-invalid-time-policy for the entries whose timestamp is
+// corrected
+const (
+	invalidTimePolicyCorrect = "correct"
+	invalidTimePolicyDrop    = "drop"
+)
+
+// parseInvalidTimePolicy() returns whether the entries whose timestamp is
+// corrected are dropped instead of sent to Splunk
+func parseInvalidTimePolicy(config map[string]string) (bool, error) {
+	switch policy := config[splunkInvalidTimePolicyKey]; policy {
+	case "", invalidTimePolicyCorrect:
+		return false, nil
+	case invalidTimePolicyDrop:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s: unknown %s %q, supported values are %s and %s", driverName, splunkInvalidTimePolicyKey, policy, invalidTimePolicyCorrect, invalidTimePolicyDrop)
+	}
+}
+
+// parseMaxTimeSkew() returns how far from now the timestamp of an entry can
+// be before it is corrected, 0 by default means only zero timestamps are
+// corrected
+func parseMaxTimeSkew(config map[string]string) (time.Duration, error) {
+	skewStr, ok := config[splunkMaxTimeSkewKey]
+	if !ok {
+		return 0, nil
+	}
+	skew, err := time.ParseDuration(skewStr)
+	if err != nil {
+		return 0, err
+	}
+	if skew < 0 {
+		return 0, fmt.Errorf("%s: %s must not be negative", driverName, splunkMaxTimeSkewKey)
+	}
+	return skew, nil
+}
+
+// correctTime() replaces a zero timestamp, or one further from now than the
+// maximum skew, with now. It returns the original timestamp when it was
+// replaced, an empty string else. Entries stamped in 1970 would otherwise
+// land in buckets which are never searched.
+func (mg messageProcessor) correctTime(entry *logdriver.LogEntry, now time.Time, containerID string) string {
+	if entry.TimeNano != 0 {
+		skew := now.Sub(time.Unix(0, entry.TimeNano))
+		if mg.maxTimeSkew == 0 || (skew <= mg.maxTimeSkew && skew >= -mg.maxTimeSkew) {
+			return ""
+		}
+	}
+	original := fmt.Sprintf("%f", float64(entry.TimeNano)/float64(time.Second))
+	processorLog.WithField("id", containerID).WithField("timeNano", entry.TimeNano).Debug("Replacing the timestamp of an entry with the current time")
+	atomic.AddUint64(&metrics.timestampsCorrected, 1)
+	entry.TimeNano = now.UnixNano()
+	return original
+}
+
+// dropInvalidTime() returns true when the message had its timestamp
+// corrected and splunk-invalid-time-policy drops it. The local log still
+// has the line, with the corrected timestamp.
+func (l *splunkLogger) dropInvalidTime(message *splunkMessage) bool {
+	if !l.invalidTimeDrop || !message.timeCorrected {
+		return false
+	}
+	l.hec.metrics.addReceived(1)
+	l.hec.metrics.addDropped(dropReasonInvalidTime, 1)
+	l.hec.sampleDropped(dropReasonInvalidTime, []*splunkMessage{message})
+	return true
+}
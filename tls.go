@@ -0,0 +1,191 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TLS versions of splunk-tls-min-version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsOptions names the options configuring TLS for a destination, an empty
+// name is not configurable
+type tlsOptions struct {
+	caPath             string
+	caName             string
+	insecureSkipVerify string
+	clientCert         string
+	clientKey          string
+	minVersion         string
+	ciphers            string
+	pinnedCerts        string
+	pinType            string
+}
+
+var hecTLSOptions = tlsOptions{
+	caPath:             splunkCAPathKey,
+	caName:             splunkCANameKey,
+	insecureSkipVerify: splunkInsecureSkipVerifyKey,
+	minVersion:         splunkTLSMinVersionKey,
+	ciphers:            splunkTLSCiphersKey,
+	pinnedCerts:        splunkPinnedCertSHA256Key,
+	pinType:            splunkPinTypeKey,
+}
+
+// newTLSConfig() builds the TLS configuration of a destination from the
+// options of the container
+func newTLSConfig(config map[string]string, options tlsOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	// Splunk is using autogenerated certificates by default,
+	// allow users to trust them with skipping verification
+	if insecureSkipVerifyStr, ok := config[options.insecureSkipVerify]; ok && options.insecureSkipVerify != "" {
+		insecureSkipVerify, err := strconv.ParseBool(insecureSkipVerifyStr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.InsecureSkipVerify = insecureSkipVerify
+	}
+
+	// If path to the root certificate is provided - load it
+	if caPath, ok := config[options.caPath]; ok && options.caPath != "" {
+		caCert, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		caPool := x509.NewCertPool()
+		caPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = caPool
+	}
+
+	if caName, ok := config[options.caName]; ok && options.caName != "" {
+		tlsConfig.ServerName = caName
+	}
+
+	if minVersion, ok := config[options.minVersion]; ok && options.minVersion != "" {
+		version, err := parseTLSVersion(minVersion, options.minVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if ciphers, ok := config[options.ciphers]; ok && options.ciphers != "" {
+		suites, err := parseTLSCiphers(ciphers, options.ciphers)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = suites
+	}
+
+	// the server certificate is verified, then checked against the pins
+	if pinned := config[options.pinnedCerts]; pinned != "" && options.pinnedCerts != "" {
+		// the pins check the verified chain, there is none without verification
+		if tlsConfig.InsecureSkipVerify {
+			return nil, fmt.Errorf("%s: %s and %s=true must not be set together", driverName, options.pinnedCerts, options.insecureSkipVerify)
+		}
+		pins, err := parsePins(pinned, options.pinnedCerts)
+		if err != nil {
+			return nil, err
+		}
+		pinType := pinTypeCert
+		if pinTypeStr, ok := config[options.pinType]; ok && options.pinType != "" {
+			if pinType, err = parsePinType(pinTypeStr, options.pinType); err != nil {
+				return nil, err
+			}
+		}
+		tlsConfig.VerifyPeerCertificate = verifyPins(pins, pinType)
+	}
+
+	// a client certificate needs both its certificate and its key
+	certPath, key := config[options.clientCert], config[options.clientKey]
+	if (certPath == "") != (key == "") {
+		return nil, fmt.Errorf("%s: %s and %s must be set together", driverName, options.clientCert, options.clientKey)
+	}
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// parseTLSVersion() returns the TLS version of a version number such as 1.2
+func parseTLSVersion(value string, key string) (uint16, error) {
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("%s: unknown TLS version %s in %s, supported versions are 1.0, 1.1, 1.2 and 1.3", driverName, value, key)
+	}
+	return version, nil
+}
+
+// parseTLSCiphers() returns the cipher suites of a comma separated list of
+// their names, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. The suites of
+// TLS 1.3 are not configurable.
+func parseTLSCiphers(value string, key string) ([]uint16, error) {
+	suites := make(map[string]*tls.CipherSuite)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite
+	}
+	var ids []uint16
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		suite, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown cipher suite %s in %s, supported suites are %s", driverName, name, key, strings.Join(tlsCipherNames(), ", "))
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("%s: cipher suite %s in %s is only used by TLS 1.3, whose suites are not configurable", driverName, name, key)
+		}
+		ids = append(ids, suite.ID)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s: %s has no cipher suite", driverName, key)
+	}
+	return ids, nil
+}
+
+// tlsCipherNames() returns the names of the secure cipher suites which are
+// configurable
+func tlsCipherNames() []string {
+	var names []string
+	for _, suite := range tls.CipherSuites() {
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			continue
+		}
+		names = append(names, suite.Name)
+	}
+	sort.Strings(names)
+	return names
+}
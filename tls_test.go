@@ -0,0 +1,82 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLSMinVersionAndCiphers(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}
+	// the refused handshakes are expected
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		config  map[string]string
+		refused bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{splunkTLSMinVersionKey: "1.2"}, false},
+		{map[string]string{splunkTLSMinVersionKey: "1.3"}, true},
+		{map[string]string{splunkTLSCiphersKey: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, false},
+		{map[string]string{splunkTLSCiphersKey: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, true},
+	}
+	for _, test := range tests {
+		test.config[splunkInsecureSkipVerifyKey] = "true"
+		if err := ValidateLogOpt(test.config); err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig, err := newTLSConfig(test.config, hecTLSOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := &http.Transport{TLSClientConfig: tlsConfig}
+		res, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		transport.CloseIdleConnections()
+		if refused := err != nil; refused != test.refused {
+			t.Fatalf("Expected the connection with %v to be refused: %v, got %v", test.config, test.refused, err)
+		}
+	}
+}
+
+func TestValidateTLSOptions(t *testing.T) {
+	for _, config := range []map[string]string{
+		{splunkTLSMinVersionKey: "1.4"},
+		{splunkTLSMinVersionKey: "TLS1.2"},
+		{splunkTLSCiphersKey: "TLS_RSA_WITH_RC5"},
+		{splunkTLSCiphersKey: "TLS_AES_128_GCM_SHA256"},
+		{splunkTLSCiphersKey: ","},
+	} {
+		if err := ValidateLogOpt(config); err == nil {
+			t.Fatalf("Expected %v to be invalid", config)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// transformer modifies an event before it is queued for HEC. Fields of the
+// message may be shared with other events, use setField() to change them.
+type transformer func(message *splunkMessage)
+
+// transformers are applied in order to every event logged by a container.
+// Custom builds register theirs from the init() function of their own file
+// in this package, without changing the logging loop.
+var transformers []transformer
+
+func registerTransformer(t transformer) {
+	transformers = append(transformers, t)
+}
+
+func applyTransformers(message *splunkMessage) {
+	for _, t := range transformers {
+		t(message)
+	}
+}
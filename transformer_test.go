@@ -0,0 +1,72 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestTransformers(t *testing.T) {
+	registered := transformers
+	defer func() {
+		transformers = registered
+	}()
+	registerTransformer(func(message *splunkMessage) {
+		setField(message, "team", "payments")
+	})
+	registerTransformer(func(message *splunkMessage) {
+		// runs after the first one
+		setField(message, "owner", message.Fields["team"]+"-oncall")
+	})
+
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	info := logger.Info{
+		Config: map[string]string{
+			splunkURLKey:   hec.URL(),
+			splunkTokenKey: hec.token,
+		},
+		ContainerID: "containeriid",
+	}
+	loggerDriver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one", "two", "three"} {
+		if err := loggerDriver.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = loggerDriver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hec.messages) != 3 {
+		t.Fatalf("Expected # of messages %d, got %d", 3, len(hec.messages))
+	}
+	for _, message := range hec.messages {
+		if message.Fields["team"] != "payments" || message.Fields["owner"] != "payments-oncall" {
+			t.Fatalf("Expected the transformers to run in order, got fields %v", message.Fields)
+		}
+	}
+}
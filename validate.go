@@ -0,0 +1,559 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/loggerutils"
+)
+
+// Result of the validation of an option
+const (
+	validationOK      = "ok"
+	validationWarning = "warning"
+	validationError   = "error"
+)
+
+type optionValidation struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type validationReport struct {
+	Valid   bool                        `json:"valid"`
+	Options map[string]optionValidation `json:"options"`
+}
+
+// optionChecks parse the value of an option the way the logger does. A
+// check returns a warning for values which work but are likely mistakes.
+var optionChecks = map[string]func(value string, cfg map[string]string) (string, error){
+	splunkURLKey: func(value string, cfg map[string]string) (string, error) {
+		splunkURL, err := parseURL(logger.Info{Config: cfg})
+		if err != nil {
+			return "", err
+		}
+		if u, err := url.Parse(value); err == nil && splunkURLCollectorPath(u) != "" {
+			return "the collector path is removed, the plug-in uses " + splunkURL.String(), nil
+		}
+		return "", nil
+	},
+	splunkInsecureSkipVerifyKey: func(value string, cfg map[string]string) (string, error) {
+		skip, err := strconv.ParseBool(value)
+		if skip {
+			return "server certificates are not verified", err
+		}
+		return "", err
+	},
+	splunkPinnedCertSHA256Key: func(value string, cfg map[string]string) (string, error) {
+		if _, err := parsePins(value, splunkPinnedCertSHA256Key); err != nil {
+			return "", err
+		}
+		if skip, _ := strconv.ParseBool(cfg[splunkInsecureSkipVerifyKey]); skip {
+			return "", fmt.Errorf("%s: %s and %s=true must not be set together", driverName, splunkPinnedCertSHA256Key, splunkInsecureSkipVerifyKey)
+		}
+		return "", nil
+	},
+	splunkPinTypeKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parsePinType(value, splunkPinTypeKey)
+		return "", err
+	},
+	splunkTLSMinVersionKey: func(value string, cfg map[string]string) (string, error) {
+		version, err := parseTLSVersion(value, splunkTLSMinVersionKey)
+		if err == nil && version < tls.VersionTLS12 {
+			return "TLS versions before 1.2 are deprecated", nil
+		}
+		return "", err
+	},
+	splunkTLSCiphersKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := parseTLSCiphers(value, splunkTLSCiphersKey); err != nil {
+			return "", err
+		}
+		if cfg[splunkTLSMinVersionKey] == "1.3" {
+			return "the cipher suites are not used as TLS 1.3 suites are not configurable", nil
+		}
+		return "", nil
+	},
+	splunkCAPathKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := ioutil.ReadFile(value); err != nil {
+			return "the file is read by the plug-in when the container starts: " + err.Error(), nil
+		}
+		return "", nil
+	},
+	splunkFormatKey: func(value string, cfg map[string]string) (string, error) {
+		switch value {
+		case splunkFormatInline, splunkFormatJSON, splunkFormatRaw, splunkFormatMetric:
+			return "", nil
+		}
+		return "", fmt.Errorf("unknown format specified %s, supported formats are inline, json, raw and metric", value)
+	},
+	splunkVerifyConnectionKey: checkBool,
+	splunkVerifyTimeoutKey: func(value string, cfg map[string]string) (string, error) {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return "", err
+		}
+		if timeout < 0 {
+			return "", fmt.Errorf("%s: %s must not be negative", driverName, splunkVerifyTimeoutKey)
+		}
+		if verify, _ := strconv.ParseBool(cfg[splunkVerifyConnectionKey]); !verify {
+			return "the connection is only verified with " + splunkVerifyConnectionKey, nil
+		}
+		return "", nil
+	},
+	splunkVerifyIndexKey:      checkBool,
+	splunkGzipCompressionKey:  checkBool,
+	splunkInputGzipKey:        checkBool,
+	splunkDisabledKey:         checkBool,
+	splunkStrictOptsKey:       checkBool,
+	splunkJournaldCopyKey:     checkBool,
+	splunkAddBufferLatencyKey: checkBool,
+	splunkDeliveryLatencyFieldKey: func(value string, cfg map[string]string) (string, error) {
+		if value == "" {
+			return "", fmt.Errorf("%s: %s must not be empty", driverName, splunkDeliveryLatencyFieldKey)
+		}
+		return "", nil
+	},
+	splunkSyslogURLKey: func(value string, cfg map[string]string) (string, error) {
+		_, _, err := parseSyslogURL(value)
+		return "", err
+	},
+	splunkSyslogFacilityKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseSyslogFacility(value)
+		return "", err
+	},
+	splunkSyslogTagKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := loggerutils.ParseLogTag(logger.Info{Config: map[string]string{tagKey: value}}, loggerutils.DefaultTemplate)
+		return "", err
+	},
+	splunkSyslogMatchKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseSyslogMatches(value)
+		return "", err
+	},
+	splunkSyslogCAPathKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := ioutil.ReadFile(value); err != nil {
+			return "the file is read by the plug-in when the container starts: " + err.Error(), nil
+		}
+		return "", nil
+	},
+	splunkSyslogInsecureSkipVerifyKey: func(value string, cfg map[string]string) (string, error) {
+		skip, err := strconv.ParseBool(value)
+		if skip {
+			return "syslog server certificates are not verified", err
+		}
+		return "", err
+	},
+	splunkSyslogCertKey: checkSyslogClientCert,
+	splunkSyslogKeyKey:  checkSyslogClientCert,
+	splunkBackendKey: func(value string, cfg map[string]string) (string, error) {
+		backend, err := parseBackend(cfg)
+		if err == nil && backend != splunkBackendHEC && cfg[logSinkKey] == logSinkUnixSocket {
+			err = fmt.Errorf("%s: %s=%s is not supported with %s=%s", driverName, splunkBackendKey, backend, logSinkKey, logSinkUnixSocket)
+		}
+		return "", err
+	},
+	splunkOTLPEndpointKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseOTLPEndpoint(value)
+		return "", err
+	},
+	splunkOTLPHeadersKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseOTLPHeaders(value)
+		return "", err
+	},
+	splunkOTLPCAPathKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := ioutil.ReadFile(value); err != nil {
+			return "the file is read by the plug-in when the container starts: " + err.Error(), nil
+		}
+		return "", nil
+	},
+	splunkOTLPInsecureSkipVerifyKey: func(value string, cfg map[string]string) (string, error) {
+		skip, err := strconv.ParseBool(value)
+		if skip {
+			return "collector certificates are not verified", err
+		}
+		return "", err
+	},
+	splunkOTLPCertKey: checkOTLPClientCert,
+	splunkOTLPKeyKey:  checkOTLPClientCert,
+	splunkLabelsRefreshKey: func(value string, cfg map[string]string) (string, error) {
+		refresh, err := time.ParseDuration(value)
+		if err == nil && refresh > 0 && getAdvancedOptionString(envVarDockerSocket, defaultDockerSocket) == "" {
+			return envVarDockerSocket + " is not set, the labels are not refreshed", nil
+		}
+		return "", err
+	},
+	splunkMetricsModeKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseMetricsMode(logger.Info{Config: cfg})
+		return "", err
+	},
+	splunkMetricsMappingKey: func(value string, cfg map[string]string) (string, error) {
+		_, _, err := parseMetricsMapping(value)
+		return "", err
+	},
+	splunkAccessLogFormatKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseAccessLogFormat(cfg)
+		return "", err
+	},
+	splunkEventIDKey:               checkBool,
+	splunkSequenceKey:              checkBool,
+	splunkIncludeNetworkKey:        checkBool,
+	splunkIncludeResourcesKey:      checkBool,
+	splunkConfigHashKey:            checkBool,
+	splunkIncludeImageDigestKey:    checkBool,
+	splunkIncludeDockerEnvelopeKey: checkBool,
+	splunkExitEventKey:             checkBool,
+	splunkLocalCompressKey:         checkBool,
+	splunkNormalizeNewlinesKey:     checkBool,
+	splunkStripANSIKey:             checkBool,
+	splunkCacheRequiredKey:         checkBool,
+	splunkForwardingRequiredKey:    checkBool,
+	splunkMaxTimeSkewKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseMaxTimeSkew(cfg)
+		return "", err
+	},
+	splunkInvalidTimePolicyKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseInvalidTimePolicy(cfg)
+		return "", err
+	},
+	splunkLocalMaxAgeKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseLocalMaxAge(cfg)
+		return "", err
+	},
+	splunkLocalJSONKey: func(value string, cfg map[string]string) (string, error) {
+		localJSON, err := parseLocalJSON(cfg)
+		if err != nil {
+			return "", err
+		}
+		if !localJSON && cfg[splunkSearchURLKey] == "" {
+			return fmt.Sprintf("docker logs is not supported without %s", splunkSearchURLKey), nil
+		}
+		return "", nil
+	},
+	splunkSearchURLKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseSearchURL(value)
+		return "", err
+	},
+	splunkTokenVaultAddrKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseVaultTokenConfig(cfg)
+		if err == nil && cfg[splunkTokenKey] != "" {
+			return fmt.Sprintf("%s is ignored, the token is read from Vault", splunkTokenKey), nil
+		}
+		return "", err
+	},
+	splunkTokenVaultAuthKey: func(value string, cfg map[string]string) (string, error) {
+		if value != vaultAuthKubernetes && value != vaultAuthAppRole {
+			return "", fmt.Errorf("%s: unknown %s %s, supported methods are %s and %s", driverName, splunkTokenVaultAuthKey, value, vaultAuthKubernetes, vaultAuthAppRole)
+		}
+		if value == vaultAuthAppRole && getAdvancedOptionString(envVarTokenVaultSecretIDFile, "") == "" {
+			return fmt.Sprintf("%s is not set, the plug-in cannot log in to Vault", envVarTokenVaultSecretIDFile), nil
+		}
+		return "", nil
+	},
+	splunkTokenVaultCAPathKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := ioutil.ReadFile(value); err != nil {
+			return "the file is read by the plug-in when the container starts: " + err.Error(), nil
+		}
+		return "", nil
+	},
+	splunkSearchCAPathKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := ioutil.ReadFile(value); err != nil {
+			return "the file is read by the plug-in when the container starts: " + err.Error(), nil
+		}
+		return "", nil
+	},
+	splunkSearchInsecureSkipVerifyKey: func(value string, cfg map[string]string) (string, error) {
+		skip, err := strconv.ParseBool(value)
+		if skip {
+			return "search head certificates are not verified", err
+		}
+		return "", err
+	},
+	splunkGzipCompressionLevelKey: func(value string, cfg map[string]string) (string, error) {
+		level, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return "", err
+		}
+		if level < gzip.DefaultCompression || level > gzip.BestCompression {
+			return "", fmt.Errorf("not supported level '%s' for %s (supported values between %d and %d)",
+				value, splunkGzipCompressionLevelKey, gzip.DefaultCompression, gzip.BestCompression)
+		}
+		if _, ok := cfg[splunkCompressionKey]; !ok && cfg[splunkGzipCompressionKey] != "true" {
+			return "gzip compression is not enabled", nil
+		}
+		return "", nil
+	},
+	splunkCompressionKey: func(value string, cfg map[string]string) (string, error) {
+		switch value {
+		case splunkCompressionNone, splunkCompressionGzip, splunkCompressionZstd:
+			if _, ok := cfg[splunkGzipCompressionKey]; ok {
+				return splunkCompressionKey + " takes precedence over " + splunkGzipCompressionKey, nil
+			}
+			return "", nil
+		}
+		return "", fmt.Errorf("unknown compression specified %s, supported compressions are none, gzip and zstd", value)
+	},
+	splunkImageAllowlistKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := imageAllowed(value, "")
+		return "", err
+	},
+	splunkRoutingRulesKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseRoutingRules(value)
+		return "", err
+	},
+	splunkSourceTypeIndexMapKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseSourceTypeIndexMap(value)
+		return "", err
+	},
+	splunkChannelFromKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := newChannelDeriver(logger.Info{Config: cfg})
+		return "", err
+	},
+	splunkMaxFieldsKey: func(value string, cfg map[string]string) (string, error) {
+		maxFields, err := strconv.Atoi(value)
+		if err == nil && maxFields < 0 {
+			err = fmt.Errorf("%s: %s must not be negative", driverName, splunkMaxFieldsKey)
+		}
+		return "", err
+	},
+	splunkDegradedBackfillMaxKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseDegradedBackfillMax(cfg)
+		return "", err
+	},
+	splunkBandwidthLimitKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseBandwidthLimit(cfg)
+		return "", err
+	},
+	splunkBandwidthPolicyKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := parseBandwidthPolicy(cfg); err != nil {
+			return "", err
+		}
+		if _, ok := cfg[splunkBandwidthLimitKey]; !ok {
+			return splunkBandwidthLimitKey + " is not set", nil
+		}
+		return "", nil
+	},
+	splunkPreserveOrderKey: checkBool,
+	splunkPreserveOrderWindowKey: func(value string, cfg map[string]string) (string, error) {
+		if _, err := time.ParseDuration(value); err != nil {
+			return "", err
+		}
+		if _, err := parsePreserveOrder(cfg); err != nil {
+			return "", err
+		}
+		if cfg[splunkPreserveOrderKey] != "true" {
+			return splunkPreserveOrderKey + " is not enabled", nil
+		}
+		return "", nil
+	},
+	splunkHeartbeatIntervalKey: checkDuration,
+	splunkFlushOnIdleKey:       checkDuration,
+	splunkMaxEventAgeKey:       checkDuration,
+	splunkPartialTimeoutKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parsePartialTimeout(cfg)
+		return "", err
+	},
+	logSinkKey: func(value string, cfg map[string]string) (string, error) {
+		switch value {
+		case logSinkHEC:
+			return "", nil
+		case logSinkUnixSocket:
+			if _, ok := cfg[logSinkSocketKey]; !ok {
+				return "", fmt.Errorf("%s: %s is expected with %s=%s", driverName, logSinkSocketKey, logSinkKey, logSinkUnixSocket)
+			}
+			return "", nil
+		case logSinkElasticsearch:
+			if _, ok := cfg[logSinkElasticsearchURLKey]; !ok {
+				return "", fmt.Errorf("%s: %s is expected with %s=%s", driverName, logSinkElasticsearchURLKey, logSinkKey, logSinkElasticsearch)
+			}
+			return "", nil
+		}
+		return "", fmt.Errorf("unknown sink specified %s, supported sinks are hec, unixsocket and elasticsearch", value)
+	},
+	logSinkElasticsearchURLKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseElasticsearchURL(value)
+		return "", err
+	},
+	logSinkElasticsearchIndexKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseElasticsearchIndex(logger.Info{Config: cfg})
+		return "", err
+	},
+	logSinkElasticsearchFieldsKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := parseElasticsearchFields(cfg)
+		return "", err
+	},
+	logSinkElasticsearchInsecureSkipVerifyKey: checkBool,
+	envRegexKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := regexp.Compile(value)
+		return "", err
+	},
+	tagKey: func(value string, cfg map[string]string) (string, error) {
+		_, err := loggerutils.ParseLogTag(logger.Info{Config: cfg}, loggerutils.DefaultTemplate)
+		return "", err
+	},
+	localMaxFileKey: func(value string, cfg map[string]string) (string, error) {
+		_, _, err := parseLocalCompress(cfg)
+		return "", err
+	},
+}
+
+func checkBool(value string, cfg map[string]string) (string, error) {
+	_, err := strconv.ParseBool(value)
+	return "", err
+}
+
+// checkSyslogClientCert() checks the syslog client certificate and key are
+// set together
+func checkSyslogClientCert(value string, cfg map[string]string) (string, error) {
+	if cfg[splunkSyslogCertKey] == "" || cfg[splunkSyslogKeyKey] == "" {
+		return "", fmt.Errorf("%s: %s and %s must be set together", driverName, splunkSyslogCertKey, splunkSyslogKeyKey)
+	}
+	return "", nil
+}
+
+// checkOTLPClientCert() checks the OTLP client certificate and key are set
+// together
+func checkOTLPClientCert(value string, cfg map[string]string) (string, error) {
+	if cfg[splunkOTLPCertKey] == "" || cfg[splunkOTLPKeyKey] == "" {
+		return "", fmt.Errorf("%s: %s and %s must be set together", driverName, splunkOTLPCertKey, splunkOTLPKeyKey)
+	}
+	return "", nil
+}
+
+func checkDuration(value string, cfg map[string]string) (string, error) {
+	_, err := time.ParseDuration(value)
+	return "", err
+}
+
+// closestLogOpt() returns the supported option nearest to key by edit
+// distance, or "" when none is within a few edits
+func closestLogOpt(key string) string {
+	closest, best := "", len(key)/4+2
+	for _, supported := range supportedLogOpts {
+		if d := editDistance(key, supported); d < best {
+			closest, best = supported, d
+		}
+	}
+	return closest
+}
+
+// editDistance() is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// validateOptions() checks a set of log-opts without starting a logger.
+// With connect, the connection to HEC is verified like
+// splunk-verify-connection does.
+func validateOptions(cfg map[string]string, connect bool) *validationReport {
+	report := &validationReport{Valid: true, Options: make(map[string]optionValidation, len(cfg))}
+	set := func(key string, warning string, err error) {
+		result := optionValidation{Status: validationOK}
+		if err != nil {
+			result = optionValidation{Status: validationError, Message: err.Error()}
+			report.Valid = false
+		} else if warning != "" {
+			result = optionValidation{Status: validationWarning, Message: warning}
+		}
+		report.Options[key] = result
+	}
+
+	for key, value := range cfg {
+		if ignoredLogOpt(key, cfg) {
+			set(key, unknownLogOptError(key).Error()+", the option is ignored", nil)
+			continue
+		}
+		if !isSupportedLogOpt(key) {
+			set(key, "", unknownLogOptError(key))
+			continue
+		}
+		var warning string
+		var err error
+		if check, ok := optionChecks[key]; ok {
+			warning, err = check(value, cfg)
+		}
+		set(key, warning, err)
+	}
+
+	if backend := cfg[splunkBackendKey]; backend == splunkBackendOTLP || backend == splunkBackendBoth {
+		if _, ok := cfg[splunkOTLPEndpointKey]; !ok {
+			set(splunkOTLPEndpointKey, "", fmt.Errorf("%s: %s is expected with %s=%s", driverName, splunkOTLPEndpointKey, splunkBackendKey, backend))
+		}
+	}
+	if cfg[logSinkKey] != logSinkUnixSocket && cfg[splunkBackendKey] != splunkBackendOTLP {
+		for _, key := range []string{splunkURLKey, splunkTokenKey} {
+			if _, ok := cfg[key]; !ok && (key != splunkTokenKey || cfg[splunkTokenVaultAddrKey] == "") {
+				set(key, "", fmt.Errorf("%s: %s is expected", driverName, key))
+			}
+		}
+		if result := report.Options[splunkURLKey]; connect && result.Status != validationError {
+			set(splunkURLKey, "", verifyOptionsConnection(cfg))
+		}
+	}
+	return report
+}
+
+// verifyOptionsConnection() checks the HEC health endpoint with the TLS
+// settings of the options
+func verifyOptionsConnection(cfg map[string]string) error {
+	splunkURL, err := parseURL(logger.Info{Config: cfg})
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := newTLSConfig(cfg, hecTLSOptions)
+	if err != nil {
+		return err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	defer transport.CloseIdleConnections()
+	hec := &hecClient{
+		client:         &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		healthCheckURL: composeHealthCheckURL(splunkURL),
+	}
+	return hec.verifySplunkConnection(context.Background(), nil)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	hec := NewHTTPEventCollectorMock(t)
+	go hec.Serve()
+	defer hec.Close()
+
+	admin := newAdminServer(newDriver(), newLogRingBuffer(1), "secret")
+	validate := func(query string, options map[string]string) *validationReport {
+		body, _ := json.Marshal(options)
+		req := httptest.NewRequest(http.MethodPost, "/validate"+query, strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected status %d: %s", w.Code, w.Body.String())
+		}
+		var report validationReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return &report
+	}
+
+	report := validate("", map[string]string{
+		splunkURLKey:                hec.URL(),
+		splunkTokenKey:              hec.token,
+		splunkInsecureSkipVerifyKey: "true",
+		splunkFormatKey:             "xml",
+		splunkRoutingRulesKey:       `[{"match": "(", "index": "errors"}]`,
+		envRegexKey:                 "^APP_",
+		tagKey:                      "{{.Name}",
+		"splunk-idnex":              "main",
+	})
+	if report.Valid {
+		t.Fatal("Expected the options to be invalid")
+	}
+	for key, status := range map[string]string{
+		splunkURLKey:                validationOK,
+		splunkTokenKey:              validationOK,
+		splunkInsecureSkipVerifyKey: validationWarning,
+		splunkFormatKey:             validationError,
+		splunkRoutingRulesKey:       validationError,
+		envRegexKey:                 validationOK,
+		tagKey:                      validationError,
+		"splunk-idnex":              validationError,
+	} {
+		if result := report.Options[key]; result.Status != status {
+			t.Fatalf("Expected %s to be %s, got %v", key, status, result)
+		}
+	}
+	if !strings.Contains(report.Options[splunkFormatKey].Message, "unknown format") {
+		t.Fatalf("Expected the error message, got %v", report.Options[splunkFormatKey])
+	}
+
+	report = validate("?connect=true", map[string]string{splunkURLKey: hec.URL(), splunkTokenKey: hec.token})
+	if !report.Valid || !hec.connectionVerified {
+		t.Fatalf("Expected the connection to be verified, got %v", report)
+	}
+
+	report = validate("", map[string]string{splunkIndexKey: "main"})
+	if report.Valid || report.Options[splunkURLKey].Status != validationError || report.Options[splunkTokenKey].Status != validationError {
+		t.Fatalf("Expected the missing options to be reported, got %v", report)
+	}
+	if len(admin.driver.containerStates()) != 0 {
+		t.Fatal("Expected validation not to start any logger")
+	}
+}
@@ -0,0 +1,296 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault auth methods of splunk-token-vault-auth
+const (
+	vaultAuthKubernetes = "kubernetes"
+	vaultAuthAppRole    = "approle"
+)
+
+const (
+	// Field of the secret holding the HEC token by default
+	defaultVaultTokenField = "token"
+	// How long a token is cached when neither the secret nor the Vault
+	// login have a lease
+	defaultVaultTokenTTL = time.Hour
+	// Timeout of every request to Vault
+	vaultRequestTimeout = 10 * time.Second
+)
+
+// vaultTokenConfig is where the HEC token of a container is read in Vault
+type vaultTokenConfig struct {
+	addr   string
+	path   string
+	role   string
+	auth   string
+	field  string
+	caPath string
+}
+
+// parseVaultTokenConfig() returns nil when splunk-token-vault-addr is not set
+func parseVaultTokenConfig(config map[string]string) (*vaultTokenConfig, error) {
+	addr := config[splunkTokenVaultAddrKey]
+	if addr == "" {
+		return nil, nil
+	}
+	vaultURL, err := url.Parse(addr)
+	if err != nil || (vaultURL.Scheme != "http" && vaultURL.Scheme != "https") || vaultURL.Host == "" {
+		return nil, fmt.Errorf("%s: expected format scheme://dns_name_or_ip:port for %s", driverName, splunkTokenVaultAddrKey)
+	}
+	v := &vaultTokenConfig{
+		addr:   strings.TrimSuffix(addr, "/"),
+		path:   strings.Trim(config[splunkTokenVaultPathKey], "/"),
+		role:   config[splunkTokenVaultRoleKey],
+		auth:   config[splunkTokenVaultAuthKey],
+		field:  config[splunkTokenVaultFieldKey],
+		caPath: config[splunkTokenVaultCAPathKey],
+	}
+	if v.path == "" {
+		return nil, fmt.Errorf("%s: %s is expected with %s", driverName, splunkTokenVaultPathKey, splunkTokenVaultAddrKey)
+	}
+	if v.role == "" {
+		return nil, fmt.Errorf("%s: %s is expected with %s", driverName, splunkTokenVaultRoleKey, splunkTokenVaultAddrKey)
+	}
+	if v.auth == "" {
+		v.auth = vaultAuthKubernetes
+	}
+	if v.auth != vaultAuthKubernetes && v.auth != vaultAuthAppRole {
+		return nil, fmt.Errorf("%s: unknown %s %s, supported methods are %s and %s", driverName, splunkTokenVaultAuthKey, v.auth, vaultAuthKubernetes, vaultAuthAppRole)
+	}
+	if v.field == "" {
+		v.field = defaultVaultTokenField
+	}
+	return v, nil
+}
+
+// vaultTokenSource caches a HEC token read from Vault. The token is read
+// again once two thirds of its lease elapsed, and until it expires the
+// cached token is used when Vault is unavailable.
+type vaultTokenSource struct {
+	config vaultTokenConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+	expiresAt time.Time
+}
+
+// vaultTokens are shared by the containers reading the same secret
+var vaultTokens = struct {
+	sync.Mutex
+	sources map[vaultTokenConfig]*vaultTokenSource
+}{sources: make(map[vaultTokenConfig]*vaultTokenSource)}
+
+// newVaultTokenSource() returns the token source of the secret of config
+func newVaultTokenSource(config *vaultTokenConfig) (*vaultTokenSource, error) {
+	vaultTokens.Lock()
+	defer vaultTokens.Unlock()
+	if source, ok := vaultTokens.sources[*config]; ok {
+		return source, nil
+	}
+	tlsConfig, err := newTLSConfig(vaultTLSConfig(config), tlsOptions{caPath: splunkTokenVaultCAPathKey})
+	if err != nil {
+		return nil, err
+	}
+	source := &vaultTokenSource{
+		config: *config,
+		client: &http.Client{
+			Timeout:   vaultRequestTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}
+	vaultTokens.sources[*config] = source
+	return source, nil
+}
+
+func vaultTLSConfig(config *vaultTokenConfig) map[string]string {
+	if config.caPath == "" {
+		return nil
+	}
+	return map[string]string{splunkTokenVaultCAPathKey: config.caPath}
+}
+
+// get() returns the cached token, read from Vault first when it is due
+func (s *vaultTokenSource) get() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.token != "" && now.Before(s.refreshAt) {
+		return s.token, nil
+	}
+	if err := s.fetch(now); err != nil {
+		if s.token != "" && now.Before(s.expiresAt) {
+			driverLog.WithField("vault", s.config.addr).WithError(err).Warn("Cannot read the HEC token from Vault, using the cached token until it expires")
+			return s.token, nil
+		}
+		return "", err
+	}
+	return s.token, nil
+}
+
+// refetch() reads the token from Vault again after HEC rejected used. It
+// returns the token to retry with, and false when there is none.
+func (s *vaultTokenSource) refetch(used string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != used {
+		// another sender already refetched it
+		return s.token, s.token != ""
+	}
+	if err := s.fetch(time.Now()); err != nil {
+		driverLog.WithField("vault", s.config.addr).WithError(err).Warn("Cannot read the HEC token from Vault after HEC rejected it")
+		return "", false
+	}
+	if s.token == used {
+		return "", false
+	}
+	driverLog.WithField("vault", s.config.addr).WithField("path", s.config.path).Info("HEC rejected the token, read a new one from Vault")
+	return s.token, true
+}
+
+// vaultResponse is the part of the Vault responses used by the plugin
+type vaultResponse struct {
+	LeaseDuration int             `json:"lease_duration"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// fetch() logs in to Vault and reads the token, with s.mu held
+func (s *vaultTokenSource) fetch(now time.Time) error {
+	login, err := s.login()
+	if err != nil {
+		return err
+	}
+	var secret vaultResponse
+	if err := s.request(http.MethodGet, "/v1/"+s.config.path, login.Auth.ClientToken, nil, &secret); err != nil {
+		return err
+	}
+	token, err := secretField(secret.Data, s.config.field)
+	if err != nil {
+		return fmt.Errorf("%s: cannot read the HEC token from Vault path %s: %v", driverName, s.config.path, err)
+	}
+
+	ttl := time.Duration(secret.LeaseDuration) * time.Second
+	if ttl <= 0 {
+		ttl = time.Duration(login.Auth.LeaseDuration) * time.Second
+	}
+	if ttl <= 0 {
+		ttl = defaultVaultTokenTTL
+	}
+	s.token, s.refreshAt, s.expiresAt = token, now.Add(ttl*2/3), now.Add(ttl)
+	return nil
+}
+
+// login() authenticates to Vault with the Kubernetes service account of
+// the plugin, or with the AppRole of the role and the secret ID of the
+// plugin environment
+func (s *vaultTokenSource) login() (*vaultResponse, error) {
+	body := map[string]string{}
+	switch s.config.auth {
+	case vaultAuthKubernetes:
+		jwt, err := ioutil.ReadFile(getAdvancedOptionString(envVarTokenVaultJWTFile, defaultTokenVaultJWTFile))
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot read the service account token for Vault: %v", driverName, err)
+		}
+		body["role"], body["jwt"] = s.config.role, strings.TrimSpace(string(jwt))
+	case vaultAuthAppRole:
+		secretID, err := ioutil.ReadFile(getAdvancedOptionString(envVarTokenVaultSecretIDFile, ""))
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot read the AppRole secret ID for Vault: %v", driverName, err)
+		}
+		body["role_id"], body["secret_id"] = s.config.role, strings.TrimSpace(string(secretID))
+	}
+	var login vaultResponse
+	if err := s.request(http.MethodPost, "/v1/auth/"+s.config.auth+"/login", "", body, &login); err != nil {
+		return nil, err
+	}
+	if login.Auth == nil || login.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("%s: Vault login with %s returned no token", driverName, s.config.auth)
+	}
+	return &login, nil
+}
+
+func (s *vaultTokenSource) request(method string, path string, vaultToken string, body interface{}, response *vaultResponse) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, s.config.addr+path, reader)
+	if err != nil {
+		return err
+	}
+	if vaultToken != "" {
+		req.Header.Set("X-Vault-Token", vaultToken)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(response); err != nil && res.StatusCode == http.StatusOK {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		// the errors of Vault don't hold secrets
+		return fmt.Errorf("%s: Vault %s %s failed - %s - %s", driverName, method, path, res.Status, strings.Join(response.Errors, ", "))
+	}
+	return nil
+}
+
+// secretField() returns a string field of the data of a KV secret, in
+// version 1 or 2
+func secretField(data json.RawMessage, field string) (string, error) {
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &secret); err == nil && secret.Data != nil {
+		// version 2 nests the data with its metadata
+		data, _ = json.Marshal(secret.Data)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	value, ok := fields[field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("no %s field", field)
+	}
+	return value, nil
+}
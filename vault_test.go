@@ -0,0 +1,170 @@
+/*
+ * Copyright 2018 Splunk, Inc..
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestVaultToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "splunk-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretIDFile := filepath.Join(dir, "secret-id")
+	if err := ioutil.WriteFile(secretIDFile, []byte("secretid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(envVarTokenVaultSecretIDFile, secretIDFile)
+	defer os.Setenv(envVarTokenVaultSecretIDFile, "")
+
+	var (
+		mu      sync.Mutex
+		current = "first"
+		reads   int
+		events  []string
+	)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			var login map[string]string
+			json.NewDecoder(r.Body).Decode(&login)
+			if login["role_id"] != "logging" || login["secret_id"] != "secretid" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"errors":["invalid role or secret ID"]}`)
+				return
+			}
+			fmt.Fprint(w, `{"auth":{"client_token":"vaulttoken","lease_duration":3600}}`)
+		case "/v1/secret/data/splunk":
+			if r.Header.Get("X-Vault-Token") != "vaulttoken" {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"errors":["permission denied"]}`)
+				return
+			}
+			reads++
+			fmt.Fprintf(w, `{"lease_duration":0,"data":{"data":{"hec":%q},"metadata":{"version":1}}}`, current)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+	hec := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Splunk "+current {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"text":"Invalid token","code":4}`)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		events = append(events, strings.TrimSpace(string(body)))
+	}))
+	defer hec.Close()
+
+	config := map[string]string{
+		splunkURLKey:             hec.URL,
+		splunkTokenVaultAddrKey:  vault.URL,
+		splunkTokenVaultPathKey:  "secret/data/splunk",
+		splunkTokenVaultRoleKey:  "logging",
+		splunkTokenVaultAuthKey:  vaultAuthAppRole,
+		splunkTokenVaultFieldKey: "hec",
+	}
+	if err := ValidateLogOpt(config); err != nil {
+		t.Fatal(err)
+	}
+	log := func(line string) {
+		l, err := New(logger.Info{Config: config, ContainerID: "containeriid"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log("first line")
+
+	// the token is rotated, HEC rejects the cached one
+	mu.Lock()
+	current = "second"
+	mu.Unlock()
+	log("second line")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if reads != 2 {
+		t.Fatalf("Expected the token to be read from Vault twice, got %d", reads)
+	}
+	if len(events) != 2 || !strings.Contains(events[0], "first line") || !strings.Contains(events[1], "second line") {
+		t.Fatalf("Expected both lines to be sent, got %q", events)
+	}
+}
+
+func TestVaultUnavailable(t *testing.T) {
+	vault := httptest.NewServer(http.NotFoundHandler())
+	vault.Close()
+
+	_, err := New(logger.Info{
+		Config: map[string]string{
+			splunkURLKey:            "https://splunk.example.com:8088",
+			splunkTokenVaultAddrKey: vault.URL,
+			splunkTokenVaultPathKey: "secret/data/unavailable",
+			splunkTokenVaultRoleKey: "logging",
+		},
+		ContainerID: "containeriid",
+	})
+	if _, ok := err.(*splunkUnavailableError); !ok {
+		t.Fatalf("Expected Splunk to be unavailable while Vault is, got %v", err)
+	}
+}
+
+func TestParseVaultTokenConfig(t *testing.T) {
+	for _, config := range []map[string]string{
+		{splunkTokenVaultAddrKey: "vault:8200", splunkTokenVaultPathKey: "secret/splunk", splunkTokenVaultRoleKey: "logging"},
+		{splunkTokenVaultAddrKey: "https://vault:8200", splunkTokenVaultRoleKey: "logging"},
+		{splunkTokenVaultAddrKey: "https://vault:8200", splunkTokenVaultPathKey: "secret/splunk"},
+		{splunkTokenVaultAddrKey: "https://vault:8200", splunkTokenVaultPathKey: "secret/splunk", splunkTokenVaultRoleKey: "logging", splunkTokenVaultAuthKey: "userpass"},
+	} {
+		if _, err := parseVaultTokenConfig(config); err == nil {
+			t.Fatalf("Expected an error for %v", config)
+		}
+	}
+	v, err := parseVaultTokenConfig(map[string]string{splunkTokenVaultAddrKey: "https://vault:8200/", splunkTokenVaultPathKey: "/secret/splunk", splunkTokenVaultRoleKey: "logging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.addr != "https://vault:8200" || v.path != "secret/splunk" || v.auth != vaultAuthKubernetes || v.field != defaultVaultTokenField {
+		t.Fatalf("Unexpected configuration %+v", v)
+	}
+}